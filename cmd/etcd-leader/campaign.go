@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// transitionLine is a change of leadership printed by campaign.
+type transitionLine struct {
+	Time   time.Time `json:"time"`
+	ID     string    `json:"id"`
+	Leader bool      `json:"leader"`
+	Term   int       `json:"term,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+func campaign(flags *flag.FlagSet, out *output) func() int {
+	newClient := clientFlags(flags)
+	key := flags.String("key", "", "election key to campaign for")
+	ttl := flags.Duration("ttl", 10*time.Second, "leader key TTL")
+	renewInterval := flags.Duration("renew-interval", 0, "mean delay between renewals (default a quarter of -ttl)")
+	id := flags.String("id", "", "candidate id (default the client certificate's identity, else the hostname)")
+	candidates := flags.Int("candidates", 1, "candidates to run, as id-0, id-1, ... when more than one")
+	historyFile := flags.String("history-file", "", "keep the changes of leader in this file across restarts")
+	historyMax := flags.Int("history-max", 1000, "changes of leader -history-file retains")
+	return func() int {
+		if *key == "" {
+			fmt.Fprintln(os.Stderr, "campaign: -key is required")
+			return 2
+		}
+		if *candidates < 1 {
+			fmt.Fprintln(os.Stderr, "campaign: -candidates must be at least 1")
+			return 2
+		}
+		client, err := newClient()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "campaign: %s\n", err.Error())
+			return 1
+		}
+		// the file's defaults, with -ttl and -renew-interval taking
+		// precedence when given
+		config, _, err := fileDefaults(flags)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "campaign: %s\n", err.Error())
+			return 2
+		}
+		set := setFlags(flags)
+		if set["ttl"] || config.TTL == 0 {
+			config.TTL = *ttl
+		}
+		if set["renew-interval"] || config.RenewInterval == 0 {
+			config.RenewInterval = *renewInterval
+		}
+		if *historyFile != "" {
+			store, err := election.NewFileHistoryStore(*historyFile, election.HistoryRetention{MaxEntries: *historyMax})
+			if err == nil {
+				err = election.SetHistoryStore(store)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "campaign: %s\n", err.Error())
+				return 1
+			}
+		}
+		if *id == "" && client.Identity() == "" {
+			*id, _ = os.Hostname()
+		}
+		var mu sync.Mutex
+		print := func(line transitionLine) {
+			mu.Lock()
+			defer mu.Unlock()
+			if out.json() {
+				json.NewEncoder(os.Stdout).Encode(line)
+			} else if line.Leader {
+				fmt.Printf("%s  %s  elected, term %d\n", line.Time.Format(time.RFC3339), line.ID, line.Term)
+			} else {
+				fmt.Printf("%s  %s  demoted: %s\n", line.Time.Format(time.RFC3339), line.ID, line.Reason)
+			}
+		}
+		var electors []*election.Elector
+		for i := 0; i < *candidates; i++ {
+			name := *id
+			if *candidates > 1 {
+				name = fmt.Sprintf("%s-%d", *id, i)
+			}
+			c, err := election.New(client, *key, name, config)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "campaign: %s\n", err.Error())
+				return 2
+			}
+			c.OnElected(func(term int) {
+				print(transitionLine{Time: time.Now(), ID: name, Leader: true, Term: term})
+			})
+			c.OnDemoted(func(reason error) {
+				print(transitionLine{Time: time.Now(), ID: name, Reason: reason.Error()})
+			})
+			electors = append(electors, c)
+		}
+		for _, c := range electors {
+			c.Start()
+		}
+		// leave cleanly on SIGINT and SIGTERM, so that followers take over
+		// at once instead of once the lock expires
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		<-interrupt
+		ctx, cancel := context.WithTimeout(context.Background(), config.TTL)
+		defer cancel()
+		for _, c := range electors {
+			if err := c.Close(ctx); err != nil {
+				fmt.Fprintf(os.Stderr, "campaign: %s\n", err.Error())
+			}
+		}
+		return 0
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// checkConfig validates a configuration file and probes the cluster it points
+// at, so that mistakes surface before the daemon is started in production.
+// It fails if any finding is an error.
+func checkConfig(flags *flag.FlagSet, out *output) func() int {
+	path := flags.String("config", "", "configuration file to check")
+	readOnly := flags.Bool("read-only", false, "skip the write permission probe")
+	return func() int {
+		if *path == "" {
+			fmt.Fprintln(os.Stderr, "check-config: -config is required")
+			return 2
+		}
+		config, err := loadConfig(*path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "check-config: %s\n", err.Error())
+			return 2
+		}
+		client := config.client()
+		client.SetReadOnly(*readOnly)
+		findings := election.Check(context.Background(), client, config.manager())
+		out.write(findings, func(w io.Writer) {
+			fmt.Fprintf(w, "level\tcheck\telection\tfinding\n")
+			for _, f := range findings {
+				shard := f.Election
+				if shard == "" {
+					shard = "-"
+				}
+				message := f.Message
+				if f.Fix != "" {
+					message += " (" + f.Fix + ")"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Level, f.Check, shard, message)
+			}
+		})
+		for _, f := range findings {
+			if f.Level == election.FindingError {
+				return 1
+			}
+		}
+		return 0
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// command is a subcommand of the binary. setup registers the command's flags
+// and returns the function running it once they are parsed.
+type command struct {
+	name    string
+	summary string
+	setup   func(flags *flag.FlagSet, out *output) func() int
+}
+
+func commandTable() []command {
+	return []command{
+		{"campaign", "campaign for an election and print its changes of leader", campaign},
+		{"soak", "run a candidate/shard matrix and check election invariants", soak},
+		{"loadgen", "emulate election traffic against a cluster and report request rates", loadgen},
+		{"debug-bundle", "collect election state into a tarball for bug reports", debugBundle},
+		{"watchdog", "watch elections and send alerts", watchdog},
+		{"inspect", "audit elections on demand and report inconsistencies between their keys", inspect},
+		{"check-config", "lint a config file and the cluster it points at", checkConfig},
+		{"compat", "run election checks against every etcd release of the test matrix", compat},
+		{"completion", "print a shell completion script for bash, zsh or fish", completion},
+	}
+}
+
+// runCommand runs the subcommand named by args[0] and returns its exit code,
+// or false if there is no such command.
+func runCommand(args []string) (int, bool) {
+	for _, cmd := range commandTable() {
+		if cmd.name != args[0] {
+			continue
+		}
+		flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		out := outputFlag(flags)
+		run := cmd.setup(flags, out)
+		if err := flags.Parse(args[1:]); err != nil {
+			return 2, true
+		}
+		if err := fromEnv(flags, os.LookupEnv); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+			return 2, true
+		}
+		if err := out.validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+			return 2, true
+		}
+		return run(), true
+	}
+	return 0, false
+}
+
+// envPrefix starts the environment variables standing in for flags.
+const envPrefix = "ETCD_LEADER_"
+
+// envName returns the environment variable of a flag: -renew-interval is
+// ETCD_LEADER_RENEW_INTERVAL.
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+}
+
+// fromEnv sets the flags not given explicitly from the environment, so that
+// flags override the environment, which overrides the configuration file.
+// Aliases share a value, and are left alone when either is given.
+func fromEnv(flags *flag.FlagSet, lookup func(string) (string, bool)) error {
+	set := make(map[flag.Value]bool)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Value] = true
+	})
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := lookup(envName(f.Name))
+		if !ok || set[f.Value] || err != nil {
+			return
+		}
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid %s %q: %s", envName(f.Name), value, setErr.Error())
+		}
+		set[f.Value] = true
+	})
+	return err
+}
+
+// output renders a command's result as JSON or as an aligned table.
+type output struct {
+	format string
+}
+
+func outputFlag(flags *flag.FlagSet) *output {
+	out := &output{}
+	flags.StringVar(&out.format, "output", "table", "output format: table or json")
+	return out
+}
+
+func (o *output) validate() error {
+	if o.format != "table" && o.format != "json" {
+		return fmt.Errorf("unknown output format %q", o.format)
+	}
+	return nil
+}
+
+func (o *output) json() bool {
+	return o.format == "json"
+}
+
+// write prints v as JSON, or calls table with a tab-separated writer.
+func (o *output) write(v interface{}, table func(w io.Writer)) {
+	if o.json() {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(v)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	table(w)
+	w.Flush()
+}
+
+// commandFlags returns the flag names and usages of every command.
+func commandFlags() map[string][]*flag.Flag {
+	all := make(map[string][]*flag.Flag)
+	for _, cmd := range commandTable() {
+		flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		cmd.setup(flags, outputFlag(flags))
+		flags.VisitAll(func(f *flag.Flag) {
+			all[cmd.name] = append(all[cmd.name], f)
+		})
+	}
+	return all
+}
+
+func completion(flags *flag.FlagSet, out *output) func() int {
+	return func() int {
+		shell := flags.Arg(0)
+		var script string
+		switch shell {
+		case "bash":
+			script = bashCompletion()
+		case "zsh":
+			script = "autoload -U +X bashcompinit && bashcompinit\n" + bashCompletion()
+		case "fish":
+			script = fishCompletion()
+		default:
+			fmt.Fprintln(os.Stderr, "usage: etcd-leader completion bash|zsh|fish")
+			return 2
+		}
+		if out.json() {
+			out.write(map[string]string{"shell": shell, "script": script}, nil)
+		} else {
+			fmt.Print(script)
+		}
+		return 0
+	}
+}
+
+func bashCompletion() string {
+	var b strings.Builder
+	names := []string{}
+	for _, cmd := range commandTable() {
+		names = append(names, cmd.name)
+	}
+	b.WriteString("_etcd_leader() {\n")
+	b.WriteString("  local cur=${COMP_WORDS[COMP_CWORD]}\n")
+	b.WriteString("  if [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+	fmt.Fprintf(&b, "    COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	b.WriteString("    return\n  fi\n")
+	b.WriteString("  case ${COMP_WORDS[1]} in\n")
+	all := commandFlags()
+	for _, name := range names {
+		var words []string
+		for _, f := range all[name] {
+			words = append(words, "--"+f.Name)
+		}
+		if name == "completion" {
+			words = append(words, "bash", "zsh", "fish")
+		}
+		sort.Strings(words)
+		fmt.Fprintf(&b, "    %s) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", name, strings.Join(words, " "))
+	}
+	b.WriteString("  esac\n}\n")
+	b.WriteString("complete -F _etcd_leader etcd-leader\n")
+	return b.String()
+}
+
+func fishCompletion() string {
+	var b strings.Builder
+	all := commandFlags()
+	for _, cmd := range commandTable() {
+		fmt.Fprintf(&b, "complete -c etcd-leader -f -n __fish_use_subcommand -a %s -d %q\n", cmd.name, cmd.summary)
+		for _, f := range all[cmd.name] {
+			fmt.Fprintf(&b, "complete -c etcd-leader -n '__fish_seen_subcommand_from %s' -l %s -d %q\n", cmd.name, f.Name, f.Usage)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+	"github.com/jeeyoungk/etcd-leader/etcdtest"
+)
+
+// compatResult is the outcome of one behaviour check against one release.
+type compatResult struct {
+	Target string `json:"target"`
+	Check  string `json:"check"`
+	// Result is "pass", "fail" or "skip".
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// compatCheck is a behaviour elections rely on. It is skipped on releases
+// without every feature it needs.
+type compatCheck struct {
+	name  string
+	needs []etcdtest.Feature
+	run   func(ctx context.Context, client *election.EtcdClient, target etcdtest.Target) error
+}
+
+// compatTTL is the lock TTL of the checks; renewals are ttl/4 apart on
+// average, which every release keeps comfortably.
+const compatTTL = 2 * time.Second
+
+var compatChecks = []compatCheck{
+	{"negotiate", nil, func(ctx context.Context, client *election.EtcdClient, target etcdtest.Target) error {
+		want := election.BackendV3
+		if target.Has(etcdtest.FeatureV2) {
+			want = election.BackendV2
+		}
+		got, err := election.Negotiate(ctx, client, election.BackendAuto)
+		if err == nil && got != want {
+			err = fmt.Errorf("negotiated %s, want %s", got, want)
+		}
+		return err
+	}},
+	{"campaign", nil, func(ctx context.Context, client *election.EtcdClient, target etcdtest.Target) error {
+		return holdLock(ctx, client, "compat-campaign", election.ElectionConfig{TTL: compatTTL})
+	}},
+	{"refresh", []etcdtest.Feature{etcdtest.FeatureRefresh}, func(ctx context.Context, client *election.EtcdClient, target etcdtest.Target) error {
+		return holdLock(ctx, client, "compat-refresh", election.ElectionConfig{TTL: compatTTL, Refresh: true})
+	}},
+	{"failover", nil, func(ctx context.Context, client *election.EtcdClient, target etcdtest.Target) error {
+		config := election.ElectionConfig{TTL: compatTTL}
+		a, err := election.New(client, "compat-failover", "a", config)
+		if err != nil {
+			return err
+		}
+		b, _ := election.New(client, "compat-failover", "b", config)
+		defer b.Stop()
+		if _, err := a.Campaign(ctx); err != nil {
+			a.Stop()
+			return err
+		}
+		b.Start()
+		// let b settle into watching the lock
+		time.Sleep(compatTTL / 2)
+		start := time.Now()
+		if err := a.Stop(); err != nil {
+			return err
+		}
+		if _, err := b.Campaign(ctx); err != nil {
+			return err
+		}
+		if took := time.Since(start); took > compatTTL {
+			return fmt.Errorf("b took over after %s, expected well within the %s ttl", took.Round(time.Millisecond), compatTTL)
+		}
+		return nil
+	}},
+	{"observe", nil, func(ctx context.Context, client *election.EtcdClient, target etcdtest.Target) error {
+		config := election.ElectionConfig{TTL: compatTTL}
+		a, err := election.New(client, "compat-observe", "a", config)
+		if err != nil {
+			return err
+		}
+		observer, _ := election.New(client, "compat-observe", "observer", config)
+		events := observer.Observe(ctx)
+		if _, err := a.Campaign(ctx); err != nil {
+			a.Stop()
+			return err
+		}
+		a.Stop()
+		var seen []string
+		for event := range events {
+			seen = append(seen, event.Leader.ID)
+			if event.Leader.ID == "" && len(seen) > 1 && seen[len(seen)-2] == "a" {
+				return nil
+			}
+		}
+		return fmt.Errorf("observed leaders %q, want a followed by none", seen)
+	}},
+}
+
+// holdLock campaigns with config and checks that the lock is still held three
+// TTLs later.
+func holdLock(ctx context.Context, client *election.EtcdClient, key string, config election.ElectionConfig) error {
+	e, err := election.New(client, key, "a", config)
+	if err != nil {
+		return err
+	}
+	defer e.Stop()
+	if _, err := e.Campaign(ctx); err != nil {
+		return err
+	}
+	time.Sleep(3 * config.TTL)
+	if !e.IsLeader() {
+		return fmt.Errorf("lost the lock within 3 ttls (phase %s)", e.Phase())
+	}
+	return nil
+}
+
+// compat starts every release of the etcd compatibility matrix in turn and
+// runs the behaviour checks against it, since TTL, refresh and watch
+// semantics differ between releases. It fails if any check fails.
+func compat(flags *flag.FlagSet, out *output) func() int {
+	targets := flags.String("targets", "", "comma-separated targets to run (default all, or $ETCDTEST_TARGETS)")
+	timeout := flags.Duration("timeout", 30*time.Second, "time allowed for each check")
+	return func() int {
+		election.SetLogLevel(election.LevelWarn)
+		if *targets != "" {
+			os.Setenv("ETCDTEST_TARGETS", *targets)
+		}
+		var results []compatResult
+		failed := false
+		for _, target := range etcdtest.Targets() {
+			etcd, err := etcdtest.StartTarget(target)
+			if err != nil {
+				results = append(results, compatResult{Target: target.Name, Check: "start", Result: "skip", Detail: err.Error()})
+				continue
+			}
+			for _, check := range compatChecks {
+				result := compatResult{Target: target.Name, Check: check.name, Result: "pass"}
+				if missing := missingFeatures(target, check.needs); missing != "" {
+					result.Result, result.Detail = "skip", "needs "+missing
+				} else {
+					client := election.NewEtcdClient(etcd.URL)
+					if _, err := election.Negotiate(context.Background(), client, election.BackendAuto); err != nil && check.name != "negotiate" {
+						result.Result, result.Detail = "fail", err.Error()
+					} else {
+						ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+						if err := check.run(ctx, client, target); err != nil {
+							result.Result, result.Detail = "fail", err.Error()
+						}
+						cancel()
+					}
+				}
+				failed = failed || result.Result == "fail"
+				results = append(results, result)
+			}
+			etcd.Close()
+		}
+		out.write(results, func(w io.Writer) {
+			fmt.Fprintf(w, "target\tcheck\tresult\tdetail\n")
+			for _, r := range results {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Target, r.Check, r.Result, r.Detail)
+			}
+		})
+		if failed {
+			return 1
+		}
+		return 0
+	}
+}
+
+func missingFeatures(target etcdtest.Target, needs []etcdtest.Feature) string {
+	var missing []string
+	for _, feature := range needs {
+		if !target.Has(feature) {
+			missing = append(missing, string(feature))
+		}
+	}
+	return strings.Join(missing, ", ")
+}
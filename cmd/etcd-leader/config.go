@@ -0,0 +1,291 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// fileConfig is the configuration file of the binary, in JSON, or in YAML or
+// TOML when its name ends in .yaml, .yml or .toml. Endpoints, if set, replaces
+// Endpoint with members of one cluster to fail over between.
+type fileConfig struct {
+	Endpoint      string                  `json:"endpoint"`
+	Endpoints     []string                `json:"endpoints"`
+	RoundRobin    bool                    `json:"round_robin"`
+	KeysPath      string                  `json:"keys_path"`
+	Backend       string                  `json:"backend"`
+	V3Path        string                  `json:"v3_path"`
+	AllowedPrefix string                  `json:"allowed_prefix"`
+	Namespace     string                  `json:"namespace"`
+	Concurrency   int                     `json:"concurrency"`
+	MaxElections  int                     `json:"max_elections"`
+	Defaults      fileElection            `json:"defaults"`
+	Elections     map[string]fileElection `json:"elections"`
+
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	Username           string `json:"username"`
+	Password           string `json:"password"`
+
+	// RequestTimeout bounds every request but watches (see
+	// -request-timeout).
+	RequestTimeout duration `json:"request_timeout"`
+	// LogLevel is debug, info, warn or error, and WireLog redacted or full
+	// (see -wire-log).
+	LogLevel string `json:"log_level"`
+	WireLog  string `json:"wire_log"`
+	// loaded from the files above by loadConfig
+	tls *tls.Config
+}
+
+// fileElection is an election of a fileConfig. Durations are strings such as
+// "10s". Fields whose zero value is a setting of its own are pointers, so
+// that an override can set them to false or 0 where the defaults do not.
+type fileElection struct {
+	TTL              duration           `json:"ttl"`
+	Backoff          duration           `json:"backoff"`
+	RenewInterval    duration           `json:"renew_interval"`
+	Retry            fileRetry          `json:"retry"`
+	Heartbeat        *duration          `json:"heartbeat"`
+	TakeoverGrace    *float64           `json:"takeover_grace"`
+	Refresh          *bool              `json:"refresh"`
+	Critical         *bool              `json:"critical"`
+	ClearBroadcast   *bool              `json:"clear_broadcast"`
+	BroadcastTTL     *duration          `json:"broadcast_ttl"`
+	LeaseGuard       *bool              `json:"lease_guard"`
+	Strict           *bool              `json:"strict"`
+	Fair             *bool              `json:"fair"`
+	Capabilities     []string           `json:"capabilities"`
+	Weights          map[string]float64 `json:"weights"`
+	WeightDelay      duration           `json:"weight_delay"`
+	Priority         *int               `json:"priority"`
+	PriorityDelay    *duration          `json:"priority_delay"`
+	Preempt          *bool              `json:"preempt"`
+	BroadcastFailure string             `json:"broadcast_failure"`
+	Layout           string             `json:"layout"`
+	CompatLayout     *string            `json:"compat_layout"`
+	ObserverSkew     *duration          `json:"observer_skew"`
+	Fingerprint      *bool              `json:"fingerprint"`
+	ExclusiveID      *bool              `json:"exclusive_id"`
+	Gates            []string           `json:"gates"`
+	Throttle         fileThrottle       `json:"throttle"`
+	Metadata         map[string]string  `json:"metadata"`
+}
+
+// fileRetry is the retry policy of a fileElection.
+type fileRetry struct {
+	Initial    duration `json:"initial"`
+	Max        duration `json:"max"`
+	MaxElapsed duration `json:"max_elapsed"`
+	Jitter     float64  `json:"jitter"`
+}
+
+func (r fileRetry) policy() election.RetryPolicy {
+	return election.RetryPolicy{
+		Initial:    time.Duration(r.Initial),
+		Max:        time.Duration(r.Max),
+		MaxElapsed: time.Duration(r.MaxElapsed),
+		Jitter:     r.Jitter,
+	}
+}
+
+// fileThrottle is the throttle policy of a fileElection.
+type fileThrottle struct {
+	Key    string   `json:"key"`
+	Budget int      `json:"budget"`
+	Window duration `json:"window"`
+}
+
+func (t fileThrottle) policy() election.ThrottlePolicy {
+	return election.ThrottlePolicy{Key: t.Key, Budget: t.Budget, Window: time.Duration(t.Window)}
+}
+
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"10s\": %s", err.Error())
+	}
+	parsed, err := time.ParseDuration(s)
+	*d = duration(parsed)
+	return err
+}
+
+// loadConfig reads the configuration file at path. Fields it does not set
+// keep the defaults of the binary's flags.
+func loadConfig(path string) (*fileConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = configJSON(path, data); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	config := &fileConfig{Endpoint: "http://127.0.0.1:4001", KeysPath: election.DefaultKeysPath, V3Path: election.DefaultV3Path, Concurrency: 8}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	switch config.Backend {
+	case "", election.BackendAuto, election.BackendV2, election.BackendV3:
+	default:
+		return nil, fmt.Errorf("%s: unknown backend %q", path, config.Backend)
+	}
+	if config.LogLevel != "" {
+		if _, err := parseLevel(config.LogLevel); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+	}
+	switch config.WireLog {
+	case "", "redacted", "full":
+	default:
+		return nil, fmt.Errorf("%s: unknown wire_log %q, want redacted or full", path, config.WireLog)
+	}
+	for _, endpoint := range config.Endpoints {
+		if _, err := url.Parse(endpoint); err != nil {
+			return nil, fmt.Errorf("%s: endpoint: %s", path, err.Error())
+		}
+	}
+	files := election.TLSFiles{CA: config.CAFile, Cert: config.CertFile, Key: config.KeyFile, InsecureSkipVerify: config.InsecureSkipVerify}
+	if files != (election.TLSFiles{}) {
+		if config.tls, err = files.Config(); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+	}
+	return config, nil
+}
+
+// configJSON returns the configuration file data as JSON, decoding it from
+// YAML or TOML according to the extension of path.
+func configJSON(path string, data []byte) ([]byte, error) {
+	var decoded interface{}
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoded, err = decodeYAML(data)
+	case ".toml":
+		decoded, err = decodeTOML(data)
+	default:
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(decoded)
+}
+
+func (e fileElection) election() election.ElectionConfig {
+	// the pointer fields set to their zero value
+	var zero election.ZeroFields
+	flag := func(p *bool, field election.ZeroFields) bool {
+		if p != nil && !*p {
+			zero |= field
+		}
+		return p != nil && *p
+	}
+	span := func(p *duration, field election.ZeroFields) time.Duration {
+		if p == nil {
+			return 0
+		} else if *p == 0 {
+			zero |= field
+		}
+		return time.Duration(*p)
+	}
+	var grace float64
+	if e.TakeoverGrace != nil {
+		if grace = *e.TakeoverGrace; grace == 0 {
+			zero |= election.ZeroTakeoverGrace
+		}
+	}
+	var priority int
+	if e.Priority != nil {
+		if priority = *e.Priority; priority == 0 {
+			zero |= election.ZeroPriority
+		}
+	}
+	var compat election.Layout
+	if e.CompatLayout != nil {
+		if compat = election.Layout(*e.CompatLayout); compat == "" {
+			zero |= election.ZeroCompatLayout
+		}
+	}
+	config := election.ElectionConfig{
+		TTL:              time.Duration(e.TTL),
+		Backoff:          time.Duration(e.Backoff),
+		RenewInterval:    time.Duration(e.RenewInterval),
+		Retry:            e.Retry.policy(),
+		Heartbeat:        span(e.Heartbeat, election.ZeroHeartbeat),
+		TakeoverGrace:    grace,
+		Refresh:          flag(e.Refresh, election.ZeroRefresh),
+		Critical:         flag(e.Critical, election.ZeroCritical),
+		ClearBroadcast:   flag(e.ClearBroadcast, election.ZeroClearBroadcast),
+		BroadcastTTL:     span(e.BroadcastTTL, election.ZeroBroadcastTTL),
+		LeaseGuard:       flag(e.LeaseGuard, election.ZeroLeaseGuard),
+		Strict:           flag(e.Strict, election.ZeroStrict),
+		Fair:             flag(e.Fair, election.ZeroFair),
+		Capabilities:     e.Capabilities,
+		Weights:          e.Weights,
+		WeightDelay:      time.Duration(e.WeightDelay),
+		Priority:         priority,
+		PriorityDelay:    span(e.PriorityDelay, election.ZeroPriorityDelay),
+		Preempt:          flag(e.Preempt, election.ZeroPreempt),
+		BroadcastFailure: election.BroadcastPolicy(e.BroadcastFailure),
+		Layout:           election.Layout(e.Layout),
+		CompatLayout:     compat,
+		ObserverSkew:     span(e.ObserverSkew, election.ZeroObserverSkew),
+		Fingerprint:      flag(e.Fingerprint, election.ZeroFingerprint),
+		ExclusiveID:      flag(e.ExclusiveID, election.ZeroExclusiveID),
+		Gates:            e.Gates,
+		Throttle:         e.Throttle.policy(),
+		Metadata:         e.Metadata,
+	}
+	config.Zero = zero
+	return config
+}
+
+// manager returns the manager configuration described by the file.
+func (c *fileConfig) manager() election.ManagerConfig {
+	config := election.ManagerConfig{
+		Concurrency:  c.Concurrency,
+		MaxElections: c.MaxElections,
+		Defaults:     c.Defaults.election(),
+		Elections:    make(map[string]election.ElectionConfig, len(c.Elections)),
+	}
+	for shard, e := range c.Elections {
+		config.Elections[shard] = e.election()
+	}
+	return config
+}
+
+// client returns a client of the endpoint described by the file.
+func (c *fileConfig) client() *election.EtcdClient {
+	client := election.NewEtcdClient(c.Endpoint)
+	if len(c.Endpoints) > 0 {
+		// validated by loadConfig
+		client.SetEndpoints(c.Endpoints)
+		client.SetRoundRobin(c.RoundRobin)
+	}
+	if c.tls != nil {
+		client.SetTLS(c.tls)
+	}
+	client.SetCredentials(c.Username, c.Password)
+	client.SetAllowedPrefix(c.AllowedPrefix)
+	client.SetNamespace(c.Namespace)
+	client.SetKeysPath(c.KeysPath)
+	client.SetRequestTimeout(time.Duration(c.RequestTimeout))
+	// auto is left to Check, which negotiates it
+	if c.Backend == election.BackendV3 {
+		client.SetV3Path(c.V3Path)
+	}
+	return client
+}
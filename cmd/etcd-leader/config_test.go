@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestConfigOverrides checks that an election of the configuration file can
+// turn off, or set to 0, what the defaults set, and inherits what it leaves
+// out.
+func TestConfigOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{
+		"defaults": {"ttl": "10s", "refresh": true, "fair": true, "priority": 5, "heartbeat": "2s"},
+		"elections": {
+			"off": {"refresh": false, "fair": false, "priority": 0, "heartbeat": "0s"},
+			"inherit": {}
+		}
+	}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := file.manager()
+
+	off := config.Election("off")
+	if off.Refresh || off.Fair || off.Priority != 0 || off.Heartbeat != 0 {
+		t.Errorf("override got refresh %t, fair %t, priority %d, heartbeat %s; want all off", off.Refresh, off.Fair, off.Priority, off.Heartbeat)
+	}
+	inherit := config.Election("inherit")
+	if !inherit.Refresh || !inherit.Fair || inherit.Priority != 5 || inherit.Heartbeat != 2*time.Second {
+		t.Errorf("override got refresh %t, fair %t, priority %d, heartbeat %s; want the defaults", inherit.Refresh, inherit.Fair, inherit.Priority, inherit.Heartbeat)
+	}
+}
+
+// TestConfigFormats loads the same configuration from JSON, YAML and TOML.
+func TestConfigFormats(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.json": `{
+			"endpoints": ["http://a:4001", "http://b:4001"],
+			"concurrency": 2,
+			"defaults": {"ttl": "10s", "gates": ["ready"], "weights": {"ssd": 2}},
+			"elections": {"orders/eu": {"priority": 3, "refresh": false}}
+		}`,
+		"config.yaml": `endpoints:
+  - http://a:4001
+  - http://b:4001
+concurrency: 2
+defaults:
+  ttl: 10s
+  gates: [ready]
+  weights: {ssd: 2}
+elections:
+  orders/eu:
+    priority: 3
+    refresh: false
+`,
+		"config.toml": `endpoints = ["http://a:4001", "http://b:4001"]
+concurrency = 2
+
+[defaults]
+ttl = "10s"
+gates = ["ready"]
+weights = { ssd = 2 }
+
+[elections."orders/eu"]
+priority = 3
+refresh = false
+`,
+	}
+	var want *fileConfig
+	for _, name := range []string{"config.json", "config.yaml", "config.toml"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(files[name]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := loadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want == nil {
+			want = config
+		} else if !reflect.DeepEqual(config.manager(), want.manager()) || !reflect.DeepEqual(config.Endpoints, want.Endpoints) {
+			t.Errorf("%s loaded %+v, want %+v as from config.json", name, config.manager(), want.manager())
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+func debugBundle(flags *flag.FlagSet, out *output) func() int {
+	newClient := clientFlags(flags)
+	keys := flags.String("keys", "", "comma-separated election keys to include")
+	layout := flags.String("layout", string(election.LayoutFlat), "key layout of the elections: flat or dir")
+	path := flags.String("out", "", "output file (default etcd-leader-<time>.tar.gz)")
+	return func() int {
+		if *path == "" {
+			*path = fmt.Sprintf("etcd-leader-%s.tar.gz", time.Now().Format("20060102-150405"))
+		}
+		var names []string
+		if *keys != "" {
+			names = strings.Split(*keys, ",")
+		}
+		config := make(map[string]string)
+		flags.VisitAll(func(f *flag.Flag) {
+			config[f.Name] = f.Value.String()
+		})
+
+		file, err := os.Create(*path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "debug-bundle: %s\n", err.Error())
+			return 1
+		}
+		defer file.Close()
+		// a bundle is most useful when things are broken, so collect it
+		// even if the endpoint does not look usable
+		client, err := newClient()
+		if client == nil {
+			fmt.Fprintf(os.Stderr, "debug-bundle: %s\n", err.Error())
+			return 1
+		} else if err != nil {
+			config["negotiation-error"] = err.Error()
+		}
+		client.SetReadOnly(true)
+		if err := election.WriteBundle(context.Background(), file, client, names, election.Layout(*layout), config); err != nil {
+			fmt.Fprintf(os.Stderr, "debug-bundle: %s\n", err.Error())
+			return 1
+		}
+		out.write(map[string]string{"bundle": *path}, func(w io.Writer) {
+			fmt.Fprintf(w, "bundle\t%s\n", *path)
+		})
+		return 0
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jeeyoungk/etcd-leader/etcdtest"
+	"github.com/jeeyoungk/etcd-leader/leadertest"
+)
+
+// exitHooks run before the process exits through exit().
+var exitHooks []func()
+
+func exit(code int) {
+	for i := len(exitHooks) - 1; i >= 0; i-- {
+		exitHooks[i]()
+	}
+	os.Exit(code)
+}
+
+// startDev starts a single-node etcd for development and returns its client
+// URL and a function stopping it. It runs the etcd binary found in PATH, or
+// serves an in-memory keys API in-process when there is none.
+func startDev() (string, func(), error) {
+	etcd, err := etcdtest.StartProcess(etcdtest.Options{})
+	if err == etcdtest.ErrNoBinary {
+		server := leadertest.Start()
+		fmt.Fprintf(os.Stderr, "dev: no etcd in PATH, serving an in-memory keys API at %s\n", server.URL)
+		return server.URL, server.Close, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	fmt.Fprintf(os.Stderr, "dev: started etcd at %s\n", etcd.URL)
+	return etcd.URL, func() { etcd.Close() }, nil
+}
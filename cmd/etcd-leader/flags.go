@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// clientFlags registers the flags shared by every command that talks to etcd
+// and returns a function building the client once flags are parsed. With
+// -config, the settings of the configuration file stand in for the flags not
+// given explicitly. The client is returned even when backend negotiation
+// fails, but not when the -dev etcd cannot be started.
+func clientFlags(flags *flag.FlagSet) func() (*election.EtcdClient, error) {
+	configPath := flags.String("config", "", "configuration file (JSON, YAML or TOML) with the client settings; flags given explicitly override it")
+	endpoint := flags.String("endpoint", "http://127.0.0.1:4001", "etcd endpoint, or comma-separated endpoints of one cluster to fail over between")
+	flags.StringVar(endpoint, "endpoints", *endpoint, "alias of -endpoint")
+	roundRobin := flags.Bool("round-robin", false, "spread requests across all of -endpoint instead of failing over")
+	backend := flags.String("backend", election.BackendAuto, "etcd API to use: auto, v2 or v3")
+	dev := flags.Bool("dev", false, "start a local single-node etcd and use it instead of -endpoint")
+	prefix := flags.String("allowed-prefix", "", "reject requests on keys outside this prefix")
+	namespace := flags.String("namespace", "", "prepend this prefix to every key sent to the v3 API, like clientv3.Namespace")
+	keysPath := flags.String("keys-path", election.DefaultKeysPath, "path of the keys API below the endpoint")
+	v3Path := flags.String("v3-path", election.DefaultV3Path, "path of the v3 gateway below the endpoint")
+	requestTimeout := flags.Duration("request-timeout", 0, "bound every etcd request but watches; zero leaves them unbounded")
+	var files election.TLSFiles
+	flags.StringVar(&files.CA, "ca-file", "", "PEM bundle verifying the certificate of https:// endpoints")
+	flags.StringVar(&files.Cert, "cert-file", "", "PEM client certificate presented to etcd")
+	flags.StringVar(&files.Key, "key-file", "", "PEM key of -cert-file")
+	flags.BoolVar(&files.InsecureSkipVerify, "insecure-skip-verify", false, "accept any certificate etcd presents")
+	user := flags.String("user", "", "authenticate as name:password, or as name with the password in $ETCD_PASSWORD")
+	record := flags.String("record", "", "append every keys API request and response to this file, for replay in tests")
+	wireLog := flags.String("wire-log", "", "log every etcd request and response at debug level: redacted, or full to show values")
+	logLevel := flags.String("log-level", "", "level of the election log: debug, info, warn or error")
+	return func() (*election.EtcdClient, error) {
+		if *configPath != "" {
+			config, err := loadConfig(*configPath)
+			if err != nil {
+				return nil, err
+			}
+			set := setFlags(flags)
+			fromFile := func(value string, names ...string) {
+				for _, name := range names {
+					if set[name] {
+						return
+					}
+				}
+				if value != "" {
+					flags.Set(names[0], value)
+				}
+			}
+			endpoints := config.Endpoint
+			if len(config.Endpoints) > 0 {
+				endpoints = strings.Join(config.Endpoints, ",")
+			}
+			fromFile(endpoints, "endpoint", "endpoints")
+			fromFile(strconv.FormatBool(config.RoundRobin), "round-robin")
+			fromFile(config.Backend, "backend")
+			fromFile(config.AllowedPrefix, "allowed-prefix")
+			fromFile(config.Namespace, "namespace")
+			fromFile(config.KeysPath, "keys-path")
+			fromFile(config.V3Path, "v3-path")
+			if config.RequestTimeout != 0 {
+				fromFile(time.Duration(config.RequestTimeout).String(), "request-timeout")
+			}
+			fromFile(config.CAFile, "ca-file")
+			fromFile(config.CertFile, "cert-file")
+			fromFile(config.KeyFile, "key-file")
+			fromFile(strconv.FormatBool(config.InsecureSkipVerify), "insecure-skip-verify")
+			if config.Username != "" {
+				fromFile(config.Username+":"+config.Password, "user")
+			}
+			fromFile(config.WireLog, "wire-log")
+			fromFile(config.LogLevel, "log-level")
+		}
+		if *dev {
+			url, stop, err := startDev()
+			if err != nil {
+				return nil, err
+			}
+			exitHooks = append(exitHooks, stop)
+			*endpoint = url
+		}
+		endpoints := strings.Split(*endpoint, ",")
+		client := election.NewEtcdClient(endpoints[0])
+		if err := client.SetEndpoints(endpoints); err != nil {
+			return nil, err
+		}
+		client.SetRoundRobin(*roundRobin)
+		if files != (election.TLSFiles{}) {
+			config, err := files.Config()
+			if err != nil {
+				return nil, err
+			}
+			client.SetTLS(config)
+		}
+		if *user != "" {
+			name, password := *user, os.Getenv("ETCD_PASSWORD")
+			if i := strings.Index(name, ":"); i >= 0 {
+				name, password = name[:i], name[i+1:]
+			}
+			client.SetCredentials(name, password)
+		}
+		client.SetAllowedPrefix(*prefix)
+		client.SetNamespace(*namespace)
+		client.SetKeysPath(*keysPath)
+		client.SetRequestTimeout(*requestTimeout)
+		switch *wireLog {
+		case "":
+		case "redacted":
+			client.SetWireLog(election.RedactValues)
+		case "full":
+			client.SetWireLog(election.ShowValues)
+		default:
+			return nil, fmt.Errorf("unknown -wire-log %q, want redacted or full", *wireLog)
+		}
+		if *wireLog != "" {
+			election.SetLogLevel(election.LevelDebug)
+		}
+		if *logLevel != "" {
+			level, err := parseLevel(*logLevel)
+			if err != nil {
+				return nil, err
+			}
+			election.SetLogLevel(level)
+		}
+		if *record != "" {
+			file, err := os.OpenFile(*record, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				return nil, err
+			}
+			exitHooks = append(exitHooks, func() { file.Close() })
+			client.Record(file)
+		}
+		backend, err := election.Negotiate(context.Background(), client, *backend)
+		if backend == election.BackendV3 {
+			client.SetV3Path(*v3Path)
+		}
+		return client, err
+	}
+}
+
+// setFlags returns the names of the flags given explicitly.
+func setFlags(flags *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// fileDefaults returns the election defaults of the configuration file named
+// by -config, if any.
+func fileDefaults(flags *flag.FlagSet) (election.ElectionConfig, bool, error) {
+	path := flags.Lookup("config").Value.String()
+	if path == "" {
+		return election.ElectionConfig{}, false, nil
+	}
+	config, err := loadConfig(path)
+	if err != nil {
+		return election.ElectionConfig{}, false, err
+	}
+	return config.Defaults.election(), true, nil
+}
+
+func parseLevel(name string) (election.Level, error) {
+	switch name {
+	case "debug":
+		return election.LevelDebug, nil
+	case "info":
+		return election.LevelInfo, nil
+	case "warn":
+		return election.LevelWarn, nil
+	case "error":
+		return election.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q, want debug, info, warn or error", name)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// inspect audits elections once and prints what it found, or with -listen
+// serves the same audit at /inspect?key=<election> for incident triage. It
+// fails if any finding is an error.
+func inspect(flags *flag.FlagSet, out *output) func() int {
+	newClient := clientFlags(flags)
+	keys := flags.String("keys", "", "comma-separated election keys to inspect")
+	layout := flags.String("layout", string(election.LayoutFlat), "key layout of the elections: flat or dir")
+	historyDir := flags.String("history-dir", "", "etcd directory the candidates persist their changes of leader to, to check against")
+	listen := flags.String("listen", "", "serve the audit at /inspect on this address instead of running it once")
+	return func() int {
+		if *keys == "" && *listen == "" {
+			fmt.Fprintln(os.Stderr, "inspect: -keys or -listen is required")
+			return 2
+		}
+		client, err := newClient()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "inspect: %s\n", err.Error())
+			return 1
+		}
+		client.SetReadOnly(true)
+		if *historyDir != "" {
+			if err := election.SetHistoryStore(election.NewEtcdHistoryStore(client, *historyDir, election.HistoryRetention{})); err != nil {
+				fmt.Fprintf(os.Stderr, "inspect: %s\n", err.Error())
+				return 1
+			}
+		}
+		if *listen != "" {
+			mux := http.NewServeMux()
+			mux.Handle("/inspect", election.InspectHandler(client, election.Layout(*layout)))
+			if err := http.ListenAndServe(*listen, mux); err != nil {
+				fmt.Fprintf(os.Stderr, "inspect: %s\n", err.Error())
+				return 1
+			}
+			return 0
+		}
+		var reports []*election.Inspection
+		for _, key := range strings.Split(*keys, ",") {
+			reports = append(reports, election.Inspect(context.Background(), client, key, election.Layout(*layout)))
+		}
+		out.write(reports, func(w io.Writer) {
+			fmt.Fprintf(w, "level\tcheck\telection\tfinding\n")
+			for _, report := range reports {
+				for _, f := range report.Findings {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Level, f.Check, f.Election, f.Message)
+				}
+			}
+		})
+		for _, report := range reports {
+			if report.Failed() {
+				return 1
+			}
+		}
+		return 0
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// loadgenReport summarizes the request mix a loadgen run pushed through etcd.
+type loadgenReport struct {
+	Duration   time.Duration `json:"duration"`
+	Candidates int           `json:"candidates"`
+	Shards     int           `json:"shards"`
+	Requests   int64         `json:"requests"`
+	Rate       float64       `json:"rate"`
+	Operations []loadgenOp   `json:"operations"`
+}
+
+// loadgenOp is the traffic of one operation and outcome, summed over shards.
+type loadgenOp struct {
+	Op          string        `json:"op"`
+	Outcome     string        `json:"outcome"`
+	Count       int64         `json:"count"`
+	Rate        float64       `json:"rate"`
+	MeanLatency time.Duration `json:"mean_latency"`
+}
+
+func (r *loadgenReport) table(w io.Writer) {
+	fmt.Fprintf(w, "duration\t%s\n", r.Duration)
+	fmt.Fprintf(w, "candidates x shards\t%d x %d\n", r.Candidates, r.Shards)
+	fmt.Fprintf(w, "requests\t%d (%.1f/s)\n", r.Requests, r.Rate)
+	fmt.Fprintf(w, "\nop\toutcome\tcount\trate\tmean latency\n")
+	for _, op := range r.Operations {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.1f/s\t%s\n", op.Op, op.Outcome, op.Count, op.Rate, op.MeanLatency)
+	}
+}
+
+// loadgen runs real election loops for a candidate/shard matrix, so that the
+// GET/PUT mix and the compare failures at expiry match production traffic,
+// and reports the request rates and latencies the cluster sustained.
+func loadgen(flags *flag.FlagSet, out *output) func() int {
+	newClient := clientFlags(flags)
+	duration := flags.Duration("duration", time.Minute, "how long to run")
+	candidates := flags.Int("candidates", 3, "candidates per shard")
+	shards := flags.Int("shards", 100, "number of shards")
+	ttl := flags.Duration("ttl", 10*time.Second, "leader key TTL")
+	chaos := flags.Float64("chaos", 0.05, "probability of a stalled leader per renewal, which forces expiries")
+	concurrency := flags.Int("concurrency", 8, "first campaigns in flight per candidate")
+	return func() int {
+		election.SetLogLevel(election.LevelWarn)
+		client, err := newClient()
+		if err == nil {
+			err = client.Warm(context.Background(), 4)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "loadgen: %s\n", err.Error())
+			return 1
+		}
+		// every shard folds into one label; the report sums over shards
+		client.SetMetrics(election.NewMetrics(0))
+
+		run := rand.Int31()
+		keys := make([]string, *shards)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("loadgen-%d-%d", run, i)
+		}
+		start := time.Now()
+		managers := make([]*election.Manager, *candidates)
+		for i := range managers {
+			managers[i] = election.NewManager(client, fmt.Sprintf("%d", i), *ttl, *concurrency)
+			managers[i].SetChaos(float32(*chaos))
+			managers[i].Start(keys)
+		}
+		time.Sleep(*duration)
+		samples := client.Metrics().Snapshot()
+		elapsed := time.Since(start)
+
+		// leave no locks behind on the target cluster
+		ctx, cancel := context.WithTimeout(context.Background(), 3**ttl)
+		defer cancel()
+		var closing sync.WaitGroup
+		for _, manager := range managers {
+			closing.Add(1)
+			go func(manager *election.Manager) {
+				defer closing.Done()
+				if _, err := manager.Close(ctx); err != nil {
+					fmt.Fprintf(os.Stderr, "loadgen: %s\n", err.Error())
+				}
+			}(manager)
+		}
+		closing.Wait()
+
+		report := &loadgenReport{Duration: elapsed, Candidates: *candidates, Shards: *shards}
+		for _, sample := range samples {
+			report.Requests += sample.Count
+			report.Operations = append(report.Operations, loadgenOp{
+				Op:          sample.Op,
+				Outcome:     sample.Outcome,
+				Count:       sample.Count,
+				Rate:        float64(sample.Count) / elapsed.Seconds(),
+				MeanLatency: sample.Latency / time.Duration(sample.Count),
+			})
+		}
+		sort.Slice(report.Operations, func(i, j int) bool {
+			return report.Operations[i].Count > report.Operations[j].Count
+		})
+		report.Rate = float64(report.Requests) / elapsed.Seconds()
+		out.write(report, report.table)
+		return 0
+	}
+}
@@ -0,0 +1,34 @@
+// Command etcd-leader bundles the tools for operating leader elections run
+// with the election package. The demo of the package lives in examples.
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func main() {
+	rand.Seed(time.Now().Unix())
+	if len(os.Args) > 1 {
+		if code, ok := runCommand(os.Args[1:]); ok {
+			exit(code)
+		}
+	}
+	usage()
+	exit(2)
+}
+
+// usage lists the commands on stderr.
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: etcd-leader <command> [flags]")
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, cmd := range commandTable() {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	w.Flush()
+	fmt.Fprintln(os.Stderr, "\nflags not given fall back to the environment as ETCD_LEADER_<FLAG>, e.g. ETCD_LEADER_RENEW_INTERVAL for -renew-interval")
+	fmt.Fprintln(os.Stderr, "to see the election package at work: go run ./examples -list")
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// soakReport summarizes the invariants observed during a soak run.
+type soakReport struct {
+	Duration         time.Duration `json:"duration"`
+	Candidates       int           `json:"candidates"`
+	Shards           int           `json:"shards"`
+	Samples          int64         `json:"samples"`
+	MaxLeaderlessGap time.Duration `json:"max_leaderless_gap"`
+	DoubleLeaders    int           `json:"double_leaders"`
+	Transitions      int           `json:"transitions"`
+	Passed           bool          `json:"passed"`
+}
+
+func (r *soakReport) table(w io.Writer) {
+	fmt.Fprintf(w, "duration\t%s\n", r.Duration)
+	fmt.Fprintf(w, "candidates x shards\t%d x %d\n", r.Candidates, r.Shards)
+	fmt.Fprintf(w, "samples\t%d\n", r.Samples)
+	fmt.Fprintf(w, "max leaderless gap\t%s\n", r.MaxLeaderlessGap)
+	fmt.Fprintf(w, "double-leader events\t%d\n", r.DoubleLeaders)
+	fmt.Fprintf(w, "leadership changes\t%d\n", r.Transitions)
+	fmt.Fprintf(w, "passed\t%t\n", r.Passed)
+}
+
+// shardWatch tracks the invariants of a single shard between samples.
+type shardWatch struct {
+	leader          string
+	leaderlessSince time.Time
+	double          bool
+}
+
+// soak runs a candidate/shard matrix against a cluster and checks that no
+// shard ever has two leaders (safety) and that no shard stays leaderless for
+// longer than -max-gap (liveness).
+func soak(flags *flag.FlagSet, out *output) func() int {
+	newClient := clientFlags(flags)
+	duration := flags.Duration("duration", time.Hour, "how long to run")
+	candidates := flags.Int("candidates", 5, "candidates per shard")
+	shards := flags.Int("shards", 10, "number of shards")
+	ttl := flags.Duration("ttl", time.Second, "leader key TTL")
+	chaos := flags.Float64("chaos", 0, "probability of a stalled leader per renewal")
+	maxGap := flags.Duration("max-gap", 0, "leaderless gap that fails the run (default 5x ttl)")
+	verbose := flags.Bool("verbose", false, "log election events")
+	annotateURL := flags.String("annotate-url", "", "Grafana /api/annotations URL to post transitions to")
+	annotateToken := flags.String("annotate-token", "", "bearer token for -annotate-url")
+	auditFile := flags.String("audit-file", "", "append every write to this file")
+	metricsAddr := flags.String("metrics-addr", "", "serve Prometheus metrics of the candidates at /metrics on this address")
+	return func() int {
+		if *maxGap == 0 {
+			*maxGap = 5 * *ttl
+		}
+		if !*verbose {
+			election.SetLogLevel(election.LevelWarn)
+		}
+
+		client, err := newClient()
+		if err == nil {
+			err = client.Warm(context.Background(), 4)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "soak: %s\n", err.Error())
+			return 1
+		}
+		if *auditFile != "" {
+			sink, err := election.NewFileAuditSink(*auditFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "soak: %s\n", err.Error())
+				return 1
+			}
+			defer sink.Close()
+			client.SetAuditSink(sink)
+		}
+		run := rand.Int31()
+		keys := make([]string, *shards)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("soak-%d-%d", run, i)
+		}
+		var registry election.Registry
+		if *metricsAddr != "" {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", &registry)
+			go func() {
+				if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+					fmt.Fprintf(os.Stderr, "soak: metrics: %s\n", err.Error())
+				}
+			}()
+		}
+		managers := make([]*election.Manager, *candidates)
+		for i := range managers {
+			managers[i] = election.NewManager(client, fmt.Sprintf("%d", i), *ttl, 8)
+			managers[i].SetChaos(float32(*chaos))
+			if *annotateURL != "" {
+				annotator := &election.Annotator{URL: *annotateURL, Token: *annotateToken, Tags: []string{"soak"}}
+				managers[i].OnTransition(annotator.Annotate)
+			}
+			registry.Register(managers[i])
+			managers[i].Start(keys)
+		}
+
+		report := &soakReport{Candidates: *candidates, Shards: *shards}
+		watches := make([]shardWatch, *shards)
+		start := time.Now()
+		for i := range watches {
+			watches[i].leaderlessSince = start
+		}
+		ticker := time.NewTicker(*ttl / 10)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if now.Sub(start) >= *duration {
+				break
+			}
+			report.Samples++
+			for i, key := range keys {
+				watch := &watches[i]
+				leaders := []string{}
+				for _, manager := range managers {
+					if manager.IsLeader(key) {
+						leaders = append(leaders, manager.ID())
+					}
+				}
+				switch {
+				case len(leaders) == 0:
+					if watch.leaderlessSince.IsZero() {
+						watch.leaderlessSince = now
+					}
+				default:
+					if !watch.leaderlessSince.IsZero() {
+						if gap := now.Sub(watch.leaderlessSince); gap > report.MaxLeaderlessGap {
+							report.MaxLeaderlessGap = gap
+						}
+						watch.leaderlessSince = time.Time{}
+					}
+					if leaders[0] != watch.leader {
+						if watch.leader != "" {
+							report.Transitions++
+						}
+						watch.leader = leaders[0]
+					}
+				}
+				if len(leaders) > 1 && !watch.double {
+					report.DoubleLeaders++
+					fmt.Fprintf(os.Stderr, "soak: %s has leaders %v\n", key, leaders)
+				}
+				watch.double = len(leaders) > 1
+			}
+		}
+		end := time.Now()
+		for i := range watches {
+			if since := watches[i].leaderlessSince; !since.IsZero() && end.Sub(since) > report.MaxLeaderlessGap {
+				report.MaxLeaderlessGap = end.Sub(since)
+			}
+		}
+		report.Duration = end.Sub(start)
+		report.Passed = report.DoubleLeaders == 0 && report.MaxLeaderlessGap <= *maxGap
+		out.write(report, report.table)
+		if !report.Passed {
+			return 1
+		}
+		return 0
+	}
+}
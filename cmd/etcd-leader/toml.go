@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// decodeTOML decodes a TOML document; see yaml.go for what it covers.
+func decodeTOML(data []byte) (map[string]interface{}, error) {
+	root := make(map[string]interface{})
+	table := root
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		n := i + 1
+		text := strings.TrimSpace(stripComment(lines[i]))
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "[[") {
+			return nil, fmt.Errorf("line %d: arrays of tables are not supported", n)
+		}
+		if text[0] == '[' {
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("line %d: unterminated table header", n)
+			}
+			path, err := tomlKey(text[1 : len(text)-1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", n, err.Error())
+			}
+			if table, err = tomlTable(root, path); err != nil {
+				return nil, fmt.Errorf("line %d: %s", n, err.Error())
+			}
+			continue
+		}
+		eq := tomlEquals(text)
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		path, err := tomlKey(text[:eq])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err.Error())
+		}
+		raw := strings.TrimSpace(text[eq+1:])
+		// arrays may continue over the following lines
+		for strings.HasPrefix(raw, "[") && !balanced(raw) && i+1 < len(lines) {
+			i++
+			raw += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+		value, err := tomlValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err.Error())
+		}
+		if err := tomlSet(table, path, value); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err.Error())
+		}
+	}
+	return root, nil
+}
+
+// tomlEquals returns the index of the = separating key and value, or -1.
+func tomlEquals(text string) int {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '"', '\'':
+			end := quoteEnd(text[i:])
+			if end < 0 {
+				return -1
+			}
+			i += end
+		case '=':
+			return i
+		}
+	}
+	return -1
+}
+
+// tomlKey parses a dotted key of bare and quoted parts.
+func tomlKey(text string) ([]string, error) {
+	var path []string
+	text = strings.TrimSpace(text)
+	for text != "" {
+		var part string
+		if text[0] == '"' || text[0] == '\'' {
+			end := quoteEnd(text)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated key %s", text)
+			}
+			value, err := tomlValue(text[:end+1])
+			if err != nil {
+				return nil, err
+			}
+			part, text = value.(string), text[end+1:]
+		} else {
+			end := strings.IndexByte(text, '.')
+			if end < 0 {
+				end = len(text)
+			}
+			part, text = strings.TrimSpace(text[:end]), text[end:]
+			for _, c := range part {
+				if !(c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+					return nil, fmt.Errorf("invalid bare key %q", part)
+				}
+			}
+			if part == "" {
+				return nil, fmt.Errorf("empty key")
+			}
+		}
+		path = append(path, part)
+		text = strings.TrimSpace(text)
+		if text == "" {
+			break
+		}
+		if text[0] != '.' {
+			return nil, fmt.Errorf("expected . in key at %s", text)
+		}
+		text = strings.TrimSpace(text[1:])
+		if text == "" {
+			return nil, fmt.Errorf("key ends with a dot")
+		}
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("empty key")
+	}
+	return path, nil
+}
+
+// tomlTable returns the table at path, creating the tables on the way.
+func tomlTable(root map[string]interface{}, path []string) (map[string]interface{}, error) {
+	table := root
+	for _, part := range path {
+		switch next := table[part].(type) {
+		case nil:
+			created := make(map[string]interface{})
+			table[part] = created
+			table = created
+		case map[string]interface{}:
+			table = next
+		default:
+			return nil, fmt.Errorf("key %q is not a table", part)
+		}
+	}
+	return table, nil
+}
+
+func tomlSet(table map[string]interface{}, path []string, value interface{}) error {
+	parent, err := tomlTable(table, path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+	key := path[len(path)-1]
+	if _, dup := parent[key]; dup {
+		return fmt.Errorf("duplicate key %q", key)
+	}
+	parent[key] = value
+	return nil
+}
+
+func tomlValue(text string) (interface{}, error) {
+	if text == "" {
+		return nil, fmt.Errorf("missing value")
+	}
+	switch text[0] {
+	case '"':
+		if strings.HasPrefix(text, `"""`) {
+			return nil, fmt.Errorf("multi-line strings are not supported")
+		}
+		if quoteEnd(text) != len(text)-1 {
+			return nil, fmt.Errorf("malformed string %s", text)
+		}
+		return strconv.Unquote(text)
+	case '\'':
+		// literal strings have no escapes
+		end := strings.IndexByte(text[1:], '\'')
+		if strings.HasPrefix(text, "'''") || end != len(text)-2 {
+			return nil, fmt.Errorf("malformed literal string %s", text)
+		}
+		return text[1 : len(text)-1], nil
+	case '[':
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("unterminated array %s", text)
+		}
+		items, err := splitFlow(text[1 : len(text)-1])
+		if err != nil {
+			return nil, err
+		}
+		array := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			value, err := tomlValue(item)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		return array, nil
+	case '{':
+		if !strings.HasSuffix(text, "}") {
+			return nil, fmt.Errorf("unterminated inline table %s", text)
+		}
+		items, err := splitFlow(text[1 : len(text)-1])
+		if err != nil {
+			return nil, err
+		}
+		table := make(map[string]interface{}, len(items))
+		for _, item := range items {
+			eq := tomlEquals(item)
+			if eq < 0 {
+				return nil, fmt.Errorf("expected key = value in %s", text)
+			}
+			path, err := tomlKey(item[:eq])
+			if err != nil {
+				return nil, err
+			}
+			value, err := tomlValue(strings.TrimSpace(item[eq+1:]))
+			if err != nil {
+				return nil, err
+			}
+			if err := tomlSet(table, path, value); err != nil {
+				return nil, err
+			}
+		}
+		return table, nil
+	}
+	switch text {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	number := strings.Replace(text, "_", "", -1)
+	if n, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil && strings.ContainsAny(number[:1], "+-.0123456789") {
+		return f, nil
+	}
+	return nil, fmt.Errorf("unsupported value %s", text)
+}
+
+// balanced reports whether the brackets of text outside strings are closed.
+func balanced(text string) bool {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '"', '\'':
+			end := quoteEnd(text[i:])
+			if end < 0 {
+				return false
+			}
+			i += end
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		}
+	}
+	return depth == 0
+}
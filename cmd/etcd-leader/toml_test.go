@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeTOML(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		doc  string
+		want map[string]interface{}
+	}{
+		{"empty", "", object{}},
+		{"scalars", "s = \"text\"\ni = 42\nneg = -3\nhex = 0x1f\nbig = 1_000\nf = 0.5\nexp = 1e3\nb = true\nc = false",
+			object{"s": "text", "i": int64(42), "neg": int64(-3), "hex": int64(31), "big": int64(1000), "f": 0.5, "exp": 1000.0, "b": true, "c": false}},
+		{"comments", "# header\na = 1 # trailing\nb = \"# kept\" # dropped\nc = 'x#y'",
+			object{"a": int64(1), "b": "# kept", "c": "x#y"}},
+		{"basic strings", `a = "tab\there"` + "\n" + `b = "quote \" and \\ backslash"` + "\n" + `c = "\u00e9"`,
+			object{"a": "tab\there", "b": `quote " and \ backslash`, "c": "é"}},
+		{"literal strings", `path = 'C:\dir\file'`, object{"path": `C:\dir\file`}},
+		{"tables", "endpoint = \"http://e:4001\"\n[defaults]\nttl = \"10s\"\n[defaults.retry]\nmax = \"1m\"\n[elections.a]\nfair = true",
+			object{"endpoint": "http://e:4001", "defaults": object{"ttl": "10s", "retry": object{"max": "1m"}}, "elections": object{"a": object{"fair": true}}}},
+		{"quoted table keys", "[elections.\"orders/eu\"]\npriority = 3\n[elections.'a.b']\npriority = 1",
+			object{"elections": object{"orders/eu": object{"priority": int64(3)}, "a.b": object{"priority": int64(1)}}}},
+		{"dotted keys", "defaults.ttl = \"10s\"\ndefaults . retry.max = \"1m\"",
+			object{"defaults": object{"ttl": "10s", "retry": object{"max": "1m"}}}},
+		{"arrays", "gates = [\"a\", 'b', 3]\nnested = [[1, 2], [\"x, y\"]]\nnone = []\ntrailing = [1, 2,]",
+			object{"gates": list{"a", "b", int64(3)}, "nested": list{list{int64(1), int64(2)}, list{"x, y"}}, "none": list{}, "trailing": list{int64(1), int64(2)}}},
+		{"multi-line array", "gates = [\n  \"a\", # first\n  \"b\",\n]\nnext = 1",
+			object{"gates": list{"a", "b"}, "next": int64(1)}},
+		{"inline tables", "weights = {ssd = 2, gpu = 0.5}\nretry = { max = \"1m\", nested.x = 1 }",
+			object{"weights": object{"ssd": int64(2), "gpu": 0.5}, "retry": object{"max": "1m", "nested": object{"x": int64(1)}}}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := decodeTOML([]byte(test.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("decoded %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeTOMLErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{"missing equals", "a = 1\nb", "line 2: expected key = value"},
+		{"missing value", "a =", "line 1: missing value"},
+		{"bare string", "a = text", "line 1: unsupported value text"},
+		{"invalid bare key", "a b = 1", `line 1: invalid bare key "a b"`},
+		{"trailing dot", "a. = 1", "line 1: key ends with a dot"},
+		{"duplicate key", "a = 1\n\na = 2", `line 3: duplicate key "a"`},
+		{"duplicate in a table", "[t]\na = 1\n[t]\na = 2", `line 4: duplicate key "a"`},
+		{"value as table", "a = 1\n[a]", `line 2: key "a" is not a table`},
+		{"unterminated header", "[defaults", "line 1: unterminated table header"},
+		{"array of tables", "[[elections]]", "line 1: arrays of tables are not supported"},
+		{"unterminated string", "a = \"open", "line 1: malformed string"},
+		{"multi-line string", `a = """text"""`, "line 1: multi-line strings are not supported"},
+		{"multi-line literal", "a = '''text'''", "line 1: malformed literal string"},
+		{"unterminated array", "a = [1, 2\nb = 3", "line 1: unterminated array"},
+		{"empty array item", "a = [1,, 2]", "line 1: empty item"},
+		{"inline table without equals", "a = {b}", "line 1: expected key = value"},
+		{"date", "a = 1979-05-27", "line 1: unsupported value 1979-05-27"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeTOML([]byte(test.doc))
+			if err == nil {
+				t.Fatalf("decoded without error, want %q", test.want)
+			}
+			if !strings.HasPrefix(err.Error(), test.want) {
+				t.Fatalf("error %q, want %q", err, test.want)
+			}
+		})
+	}
+}
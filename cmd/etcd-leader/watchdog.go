@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// jsonSink writes alerts as JSON lines to stdout.
+type jsonSink struct{}
+
+func (jsonSink) Send(alert election.Alert) error {
+	return json.NewEncoder(os.Stdout).Encode(alert)
+}
+
+// filePlaybook is the file of watchdog -playbook, in JSON, or in YAML or TOML
+// like the configuration file. Remedies are keyed by a name of the
+// operator's choosing, and added in the order of their names.
+type filePlaybook struct {
+	Remedies map[string]fileRemedy `json:"remedies"`
+}
+
+type fileRemedy struct {
+	Condition string   `json:"condition"`
+	After     duration `json:"after"`
+	Command   []string `json:"command"`
+	URL       string   `json:"url"`
+	Cooldown  duration `json:"cooldown"`
+	Timeout   duration `json:"timeout"`
+}
+
+// loadPlaybook adds the remedies of the playbook file at path to dog.
+func loadPlaybook(path string, dog *election.Watchdog) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if data, err = configJSON(path, data); err != nil {
+		return fmt.Errorf("%s: %s", path, err.Error())
+	}
+	var playbook filePlaybook
+	if err := json.Unmarshal(data, &playbook); err != nil {
+		return fmt.Errorf("%s: %s", path, err.Error())
+	}
+	names := make([]string, 0, len(playbook.Remedies))
+	for name := range playbook.Remedies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := playbook.Remedies[name]
+		err := dog.AddRemedy(election.Remedy{
+			Condition: r.Condition,
+			After:     time.Duration(r.After),
+			Command:   r.Command,
+			URL:       r.URL,
+			Cooldown:  time.Duration(r.Cooldown),
+			Timeout:   time.Duration(r.Timeout),
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %s: %s", path, name, err.Error())
+		}
+	}
+	return nil
+}
+
+func watchdog(flags *flag.FlagSet, out *output) func() int {
+	newClient := clientFlags(flags)
+	keys := flags.String("keys", "", "comma-separated election keys to watch")
+	layout := flags.String("layout", string(election.LayoutFlat), "key layout of the elections: flat or dir")
+	interval := flags.Duration("interval", time.Second, "check interval")
+	leaderlessAfter := flags.Duration("leaderless-after", 10*time.Second, "leaderless duration that raises an alert")
+	webhook := flags.String("webhook", "", "generic webhook URL")
+	slack := flags.String("slack", "", "Slack incoming webhook URL")
+	pagerduty := flags.String("pagerduty-key", "", "PagerDuty Events API v2 routing key")
+	text := flags.String("template", "", "alert message template (text/template over Alert)")
+	hmacKeyFile := flags.String("hmac-key-file", "", "file with the shared HMAC key leader values must be signed with")
+	heartbeat := flags.Bool("heartbeat", false, "alert when a leader holds the lock without a heartbeat key")
+	demote := flags.Bool("demote", false, "delete the leader key when a demotion condition holds (requires -audit-file)")
+	ttl := flags.Duration("ttl", 10*time.Second, "lock TTL of the watched elections, for -demote-heartbeat")
+	demoteHeartbeat := flags.Float64("demote-heartbeat", 3, "demote after the heartbeat is absent for this many TTLs (0 disables)")
+	healthKey := flags.String("demote-health-key", "", "leader metadata entry with a health URL to probe (empty disables)")
+	healthFailures := flags.Int("demote-health-failures", 3, "consecutive failed health probes that demote a leader")
+	auditFile := flags.String("audit-file", "", "append every write to this file")
+	playbook := flags.String("playbook", "", "file of recovery actions to run on leaderless, double leader or etcd unreachable conditions")
+	return func() int {
+		if *keys == "" {
+			fmt.Fprintln(os.Stderr, "watchdog: -keys is required")
+			return 2
+		}
+		if *demote && *auditFile == "" {
+			fmt.Fprintln(os.Stderr, "watchdog: -demote requires -audit-file")
+			return 2
+		}
+		sinks := []election.AlertSink{election.LogSink{}}
+		if out.json() {
+			sinks = []election.AlertSink{jsonSink{}}
+		}
+		if *webhook != "" {
+			sinks = append(sinks, &election.WebhookSink{URL: *webhook})
+		}
+		if *slack != "" {
+			sinks = append(sinks, &election.SlackSink{URL: *slack})
+		}
+		if *pagerduty != "" {
+			host, _ := os.Hostname()
+			sinks = append(sinks, &election.PagerDutySink{RoutingKey: *pagerduty, Source: host})
+		}
+		alerts, err := election.NewAlerter(*text, sinks...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "watchdog: %s\n", err.Error())
+			return 2
+		}
+		client, err := newClient()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "watchdog: %s\n", err.Error())
+			return 1
+		}
+		dog := election.NewWatchdog(client, strings.Split(*keys, ","), *interval, *leaderlessAfter, alerts)
+		dog.SetLayout(election.Layout(*layout))
+		dog.SetHeartbeat(*heartbeat)
+		if *playbook != "" {
+			if err := loadPlaybook(*playbook, dog); err != nil {
+				fmt.Fprintf(os.Stderr, "watchdog: %s\n", err.Error())
+				return 2
+			}
+		}
+		if *demote {
+			sink, err := election.NewFileAuditSink(*auditFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "watchdog: %s\n", err.Error())
+				return 1
+			}
+			defer sink.Close()
+			client.SetAuditSink(sink)
+			dog.SetDemotionPolicy(&election.DemotionPolicy{
+				TTL:             *ttl,
+				HeartbeatAbsent: *demoteHeartbeat,
+				HealthKey:       *healthKey,
+				HealthFailures:  *healthFailures,
+			})
+		} else {
+			client.SetReadOnly(true)
+		}
+		if *hmacKeyFile != "" {
+			key, err := ioutil.ReadFile(*hmacKeyFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "watchdog: %s\n", err.Error())
+				return 2
+			}
+			dog.SetVerifier(election.HMACKey(bytes.TrimSpace(key)))
+		}
+		dog.Run(context.Background())
+		return 0
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The configuration file may be written in YAML or TOML as well as in JSON.
+// Both are decoded into the values encoding/json produces, and re-encoded as
+// JSON for loadConfig, so that every format shares fileConfig's field names
+// and validation. The decoders cover what a configuration file needs rather
+// than the whole of either language: YAML block mappings and sequences, flow
+// collections and scalars, without anchors, tags or block scalars; TOML
+// tables, dotted keys, arrays and inline tables, without arrays of tables,
+// dates or multi-line strings.
+
+// yamlLine is a line of a YAML document without its indentation and comment.
+type yamlLine struct {
+	n      int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// decodeYAML decodes a single YAML document.
+func decodeYAML(data []byte) (interface{}, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(string(data), "\n") {
+		text := strings.TrimLeft(raw, " ")
+		indent := len(raw) - len(text)
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs cannot indent YAML", i+1)
+		}
+		text = strings.TrimRight(stripComment(text), " \t\r")
+		if text == "" || (indent == 0 && text == "---") {
+			continue
+		}
+		if indent == 0 && text == "..." {
+			break
+		}
+		p.lines = append(p.lines, yamlLine{i + 1, indent, text})
+	}
+	if len(p.lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	value, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.errorf(p.lines[p.pos], "unexpected indentation")
+	}
+	return value, nil
+}
+
+func (p *yamlParser) errorf(line yamlLine, format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", line.n, fmt.Sprintf(format, args...))
+}
+
+func isItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// block parses the mapping or sequence starting at the current line.
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	if isItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) mapping(indent int) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, p.errorf(line, "unexpected indentation")
+		}
+		key, rest, ok, err := splitYAMLKey(line.text)
+		if err != nil {
+			return nil, p.errorf(line, "%s", err.Error())
+		} else if !ok {
+			return nil, p.errorf(line, "expected key: value")
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf(line, "duplicate key %q", key)
+		}
+		p.pos++
+		value, err := p.value(line, indent, rest, true)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+func (p *yamlParser) sequence(indent int) ([]interface{}, error) {
+	s := []interface{}{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent || (line.indent == indent && !isItem(line.text)) {
+			break
+		}
+		if line.indent > indent || !isItem(line.text) {
+			return nil, p.errorf(line, "unexpected indentation")
+		}
+		rest := strings.TrimLeft(line.text[1:], " ")
+		if _, _, ok, _ := splitYAMLKey(rest); ok || isItem(rest) {
+			// a mapping or sequence starting on the item's line, whose
+			// other entries line up with its first
+			p.lines[p.pos] = yamlLine{line.n, line.indent + len(line.text) - len(rest), rest}
+			value, err := p.block(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, value)
+			continue
+		}
+		p.pos++
+		value, err := p.value(line, indent, rest, false)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, value)
+	}
+	return s, nil
+}
+
+// value parses what follows a key or a dash: a scalar or flow collection on
+// the same line, or else the block nested below it. The items of a sequence
+// under a key may line up with the key.
+func (p *yamlParser) value(line yamlLine, indent int, rest string, keyed bool) (interface{}, error) {
+	if rest != "" {
+		value, err := yamlScalar(rest)
+		if err != nil {
+			return nil, p.errorf(line, "%s", err.Error())
+		}
+		return value, nil
+	}
+	if p.pos < len(p.lines) {
+		next := p.lines[p.pos]
+		if next.indent > indent {
+			return p.block(next.indent)
+		}
+		if keyed && next.indent == indent && isItem(next.text) {
+			return p.sequence(indent)
+		}
+	}
+	return nil, nil
+}
+
+// splitYAMLKey splits "key: value" into its key and value, and reports false
+// for text that is not a mapping entry.
+func splitYAMLKey(text string) (string, string, bool, error) {
+	if text == "" || strings.ContainsAny(text[:1], "[{") {
+		return "", "", false, nil
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		end := quoteEnd(text)
+		if end < 0 {
+			return "", "", false, fmt.Errorf("unterminated string")
+		}
+		rest := text[end+1:]
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			return "", "", false, nil
+		}
+		key, err := yamlScalar(text[:end+1])
+		if err != nil {
+			return "", "", false, err
+		}
+		return fmt.Sprint(key), strings.TrimSpace(rest[1:]), true, nil
+	}
+	if strings.HasSuffix(text, ":") && !strings.Contains(text, ": ") {
+		return text[:len(text)-1], "", true, nil
+	}
+	i := strings.Index(text, ": ")
+	if i < 0 {
+		return "", "", false, nil
+	}
+	return text[:i], strings.TrimSpace(text[i+2:]), true, nil
+}
+
+// yamlScalar decodes a scalar or a flow collection.
+func yamlScalar(text string) (interface{}, error) {
+	switch text[0] {
+	case '"':
+		if quoteEnd(text) != len(text)-1 {
+			return nil, fmt.Errorf("malformed string %s", text)
+		}
+		return strconv.Unquote(text)
+	case '\'':
+		if quoteEnd(text) != len(text)-1 {
+			return nil, fmt.Errorf("malformed string %s", text)
+		}
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	case '[':
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("unterminated flow sequence %s", text)
+		}
+		items, err := splitFlow(text[1 : len(text)-1])
+		if err != nil {
+			return nil, err
+		}
+		s := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			value, err := yamlScalar(item)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, value)
+		}
+		return s, nil
+	case '{':
+		if !strings.HasSuffix(text, "}") {
+			return nil, fmt.Errorf("unterminated flow mapping %s", text)
+		}
+		items, err := splitFlow(text[1 : len(text)-1])
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, len(items))
+		for _, item := range items {
+			key, rest, ok, err := splitYAMLKey(item)
+			if err != nil {
+				return nil, err
+			} else if !ok {
+				return nil, fmt.Errorf("expected key: value in %s", text)
+			}
+			if m[key], err = yamlScalarOrNull(rest); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case '|', '>':
+		return nil, fmt.Errorf("block scalars are not supported")
+	case '&', '*', '!':
+		return nil, fmt.Errorf("anchors, aliases and tags are not supported")
+	}
+	switch text {
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	case "null", "Null", "NULL", "~":
+		return nil, nil
+	}
+	if strings.ContainsAny(text[:1], "+-.0123456789") {
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return n, nil
+		}
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f, nil
+		}
+	}
+	return text, nil
+}
+
+func yamlScalarOrNull(text string) (interface{}, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return yamlScalar(text)
+}
+
+// quoteEnd returns the index of the quote closing the string text starts
+// with, or -1. Double-quoted strings escape with a backslash, single-quoted
+// ones by doubling the quote.
+func quoteEnd(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] == quote && quote == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// stripComment removes a comment from a line of YAML or TOML: a # outside
+// quotes, at the start of the line or after a space.
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '"' || c == '\'':
+			end := quoteEnd(line[i:])
+			if end < 0 {
+				return line
+			}
+			i += end
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitFlow splits the items of a flow collection or TOML array at the
+// commas outside quotes and nested brackets, dropping a trailing comma.
+func splitFlow(text string) ([]string, error) {
+	var items []string
+	depth, start := 0, 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '"', '\'':
+			end := quoteEnd(text[i:])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string in %s", text)
+			}
+			i += end
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, strings.TrimSpace(text[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced brackets in %s", text)
+	}
+	if last := strings.TrimSpace(text[start:]); last != "" {
+		items = append(items, last)
+	}
+	for _, item := range items {
+		if item == "" {
+			return nil, fmt.Errorf("empty item in %s", text)
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type object = map[string]interface{}
+type list = []interface{}
+
+func TestDecodeYAML(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		doc  string
+		want interface{}
+	}{
+		{"empty", "", object{}},
+		{"only comments", "# nothing\n  # here\n", object{}},
+		{"scalars", "s: text\ni: 42\nneg: -3\nf: 0.5\nb: true\nB: False\nn: null\ntilde: ~\nempty:",
+			object{"s": "text", "i": int64(42), "neg": int64(-3), "f": 0.5, "b": true, "B": false, "n": nil, "tilde": nil, "empty": nil}},
+		{"durations stay strings", "ttl: 10s", object{"ttl": "10s"}},
+		{"comments", "# header\na: 1 # trailing\nb: x#y\nc: \"# kept\" # dropped\nd: '# kept'",
+			object{"a": int64(1), "b": "x#y", "c": "# kept", "d": "# kept"}},
+		{"double quotes", `a: "tab\there"` + "\n" + `b: "quote \" and \\ backslash"` + "\n" + `c: "\u00e9"` + "\n" + `d: "42"`,
+			object{"a": "tab\there", "b": `quote " and \ backslash`, "c": "é", "d": "42"}},
+		{"single quotes", `a: 'it''s'` + "\n" + `b: 'no \n escape'` + "\n" + `c: 'true'`,
+			object{"a": "it's", "b": `no \n escape`, "c": "true"}},
+		{"quoted keys", `"a b": 1` + "\n" + `'c: d': 2`, object{"a b": int64(1), "c: d": int64(2)}},
+		{"colon in value", "url: http://host:4001/v2", object{"url": "http://host:4001/v2"}},
+		{"nested mappings", "defaults:\n  ttl: 10s\n  retry:\n    max: 1m\nconcurrency: 4",
+			object{"defaults": object{"ttl": "10s", "retry": object{"max": "1m"}}, "concurrency": int64(4)}},
+		{"indented sequence", "gates:\n  - a\n  - b", object{"gates": list{"a", "b"}}},
+		{"sequence at key indent", "gates:\n- a\n- b\nnext: 1", object{"gates": list{"a", "b"}, "next": int64(1)}},
+		{"sequence of mappings", "items:\n  - name: a\n    ttl: 1\n  - name: b",
+			object{"items": list{object{"name": "a", "ttl": int64(1)}, object{"name": "b"}}}},
+		{"nested sequences", "- - a\n  - b\n- c", list{list{"a", "b"}, "c"}},
+		{"flow collections", "caps: [ssd, \"gpu, fast\", 3]\nweights: {ssd: 2, gpu: 0.5}\nnone: []",
+			object{"caps": list{"ssd", "gpu, fast", int64(3)}, "weights": object{"ssd": int64(2), "gpu": 0.5}, "none": list{}}},
+		{"nested flow", "a: [[1, 2], {b: [c]}]", object{"a": list{list{int64(1), int64(2)}, object{"b": list{"c"}}}}},
+		{"document markers", "---\na: 1\n...\nb: 2", object{"a": int64(1)}},
+		{"crlf", "a: 1\r\nb: 2\r\n", object{"a": int64(1), "b": int64(2)}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := decodeYAML([]byte(test.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("decoded %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeYAMLErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{"tab indentation", "a:\n\tb: 1", "line 2: tabs cannot indent YAML"},
+		{"over-indented key", "a: 1\n  b: 2", "line 2: unexpected indentation"},
+		{"dedent inside a block", "a:\n    b: 1\n  c: 2", "line 3: unexpected indentation"},
+		{"duplicate key", "a: 1\nb: 2\na: 3", `line 3: duplicate key "a"`},
+		{"not a mapping entry", "a: 1\njust text", "line 2: expected key: value"},
+		{"unterminated string", "a: 1\nb: \"open", "line 2: malformed string"},
+		{"unterminated quoted key", "\"a: 1", "line 1: unterminated string"},
+		{"unterminated flow sequence", "a: [1, 2", "line 1: unterminated flow sequence"},
+		{"unbalanced flow", "a: [[1], 2]]", "line 1: unbalanced brackets"},
+		{"empty flow item", "a: [1,, 2]", "line 1: empty item"},
+		{"flow mapping without colon", "a: {b}", "line 1: expected key: value"},
+		{"block scalar", "a: |\n  text", "line 1: block scalars are not supported"},
+		{"anchor", "a: &x 1", "line 1: anchors, aliases and tags are not supported"},
+		{"item in a mapping", "a: 1\n- b", "line 2: expected key: value"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeYAML([]byte(test.doc))
+			if err == nil {
+				t.Fatalf("decoded without error, want %q", test.want)
+			}
+			if !strings.HasPrefix(err.Error(), test.want) {
+				t.Fatalf("error %q, want %q", err, test.want)
+			}
+		})
+	}
+}
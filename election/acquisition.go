@@ -0,0 +1,218 @@
+package election
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Acquisition is how a candidate won the lock, as returned with its Lease.
+type Acquisition struct {
+	Time time.Time `json:"time"`
+	Key  string    `json:"key"`
+	ID   string    `json:"id"`
+	// Term is the term of the leadership; see Elector.Term.
+	Term int `json:"term"`
+	// FencingToken is the term qualified by the election key, for storage
+	// shared by several elections. Tokens of one election sort in the order
+	// of their terms.
+	FencingToken string `json:"fencing_token"`
+	// Latency is the time from the start of the campaign to the lock being
+	// won, including the takeover grace period.
+	Latency time.Duration `json:"latency"`
+	// Attempts is how many writes trying to create the lock the campaign
+	// took, including the winning one.
+	Attempts int `json:"attempts"`
+	// ContendedWith lists the candidates seen holding the lock during the
+	// campaign, in the order they were seen.
+	ContendedWith []string `json:"contended_with,omitempty"`
+	// Failures counts the attempts of the campaign that did not win the
+	// lock, by reason.
+	Failures map[AttemptFailure]int `json:"failures,omitempty"`
+}
+
+// AttemptFailure is why an attempt to take a free lock failed.
+type AttemptFailure string
+
+const (
+	// FailureLostRace is another candidate creating the lock between the
+	// read that found it free and the write.
+	FailureLostRace AttemptFailure = "lost_race"
+	// FailureKeyExists is the lock held in the compat layout by a
+	// candidate of the previous release (see ElectionConfig.CompatLayout).
+	FailureKeyExists AttemptFailure = "key_exists"
+	// FailureTakeoverConflict is another candidate announcing itself on
+	// the broadcast key after the lock was won.
+	FailureTakeoverConflict AttemptFailure = "takeover_conflict"
+	// FailureBroadcast is the broadcast key failing to be written, with
+	// BroadcastRetry and BroadcastResign.
+	FailureBroadcast AttemptFailure = "broadcast"
+	// FailureNetwork is a request that got no answer from etcd.
+	FailureNetwork AttemptFailure = "network"
+	// FailureAuth is etcd refusing the write to the candidate's
+	// credentials, or a read-only client.
+	FailureAuth AttemptFailure = "auth"
+	// FailureRateLimited is etcd answering with ErrRateLimited.
+	FailureRateLimited AttemptFailure = "rate_limited"
+	// FailureEtcd is any other error reported by etcd.
+	FailureEtcd AttemptFailure = "etcd_error"
+)
+
+// attemptFailure classifies a failed request of an acquisition attempt.
+func attemptFailure(err error) AttemptFailure {
+	switch {
+	case err == ErrReadOnly || errors.Is(err, ErrUnauthorized):
+		return FailureAuth
+	case err == ErrRateLimited:
+		return FailureRateLimited
+	case errors.Is(err, ErrNodeExist):
+		return FailureLostRace
+	}
+	var statusErr *StatusError
+	if answered(err) || errors.As(err, &statusErr) {
+		return FailureEtcd
+	}
+	return FailureNetwork
+}
+
+func fencingToken(key string, term int) string {
+	return fmt.Sprintf("%s:%020d", key, term)
+}
+
+func (a Acquisition) String() string {
+	s := fmt.Sprintf("term %d in %s, attempts %d", a.Term, a.Latency.Round(time.Millisecond), a.Attempts)
+	if len(a.ContendedWith) > 0 {
+		s += ", contended with " + strings.Join(a.ContendedWith, ", ")
+	}
+	if len(a.Failures) > 0 {
+		reasons := make([]string, 0, len(a.Failures))
+		for reason, n := range a.Failures {
+			reasons = append(reasons, fmt.Sprintf("%s %d", reason, n))
+		}
+		sort.Strings(reasons)
+		s += ", failed " + strings.Join(reasons, ", ")
+	}
+	return s
+}
+
+// campaign is what the election loop tracks of a campaign in progress.
+type campaign struct {
+	start      time.Time
+	attempts   int
+	contenders []string
+	failures   map[AttemptFailure]int
+	// the creation index of the contender key, with Fair (see enlist)
+	enlisted int
+	// when the candidate first deferred to a longer-waiting contender
+	// since it last saw the lock held
+	deferred time.Time
+}
+
+// contend records holder as seen holding the lock during the campaign.
+func (c *campaign) contend(holder string) {
+	for _, id := range c.contenders {
+		if id == holder {
+			return
+		}
+	}
+	c.contenders = append(c.contenders, holder)
+}
+
+// acquire records the lock won at index term, ending the campaign.
+func (s *State) acquire(term int) {
+	acquisition := Acquisition{
+		Time:          time.Now(),
+		Key:           s.key,
+		ID:            s.id,
+		Term:          term,
+		FencingToken:  fencingToken(s.key, term),
+		Latency:       time.Since(s.campaign.start),
+		Attempts:      s.campaign.attempts,
+		ContendedWith: s.campaign.contenders,
+		Failures:      s.campaign.failures,
+	}
+	atomic.StoreInt64(&s.term, int64(term))
+	s.acquisition.Store(acquisition)
+	s.eventStr(LevelInfo, evAcquired, acquisition.String())
+	if s.metrics != nil {
+		s.metrics.acquired(acquisition)
+	}
+}
+
+// attemptFailed records an attempt of the campaign failing for reason.
+func (s *State) attemptFailed(reason AttemptFailure, detail string) {
+	if s.campaign.failures == nil {
+		s.campaign.failures = make(map[AttemptFailure]int)
+	}
+	s.campaign.failures[reason]++
+	if detail != "" {
+		s.eventStr(LevelDebug, evAttemptFailed, string(reason)+": "+detail)
+	} else {
+		s.eventStr(LevelDebug, evAttemptFailed, string(reason))
+	}
+	if s.metrics != nil {
+		s.metrics.attemptFailed(s.key, reason)
+	}
+}
+
+// lastAcquisition returns how the lock was last won.
+func (s *State) lastAcquisition() Acquisition {
+	acquisition, _ := s.acquisition.Load().(Acquisition)
+	return acquisition
+}
+
+type acquisitionStat struct {
+	count     int64
+	contended int64
+	attempts  int64
+	latency   time.Duration
+}
+
+// AcquisitionSample is the lock acquisitions of one election.
+type AcquisitionSample struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+	// Contended counts the acquisitions whose campaign saw the lock held
+	// by another candidate.
+	Contended int64         `json:"contended"`
+	Attempts  int64         `json:"attempts"`
+	Latency   time.Duration `json:"latency"`
+}
+
+func (m *Metrics) acquired(a Acquisition) {
+	m.mu.Lock()
+	label := m.keyLabel(a.Key)
+	stat, ok := m.acquisitions[label]
+	if !ok {
+		stat = &acquisitionStat{}
+		m.acquisitions[label] = stat
+	}
+	stat.count++
+	if len(a.ContendedWith) > 0 {
+		stat.contended++
+	}
+	stat.attempts += int64(a.Attempts)
+	stat.latency += a.Latency
+	m.mu.Unlock()
+}
+
+// Acquisitions returns the lock acquisitions counted so far, ordered by key.
+func (m *Metrics) Acquisitions() []AcquisitionSample {
+	m.mu.Lock()
+	samples := make([]AcquisitionSample, 0, len(m.acquisitions))
+	for key, stat := range m.acquisitions {
+		samples = append(samples, AcquisitionSample{
+			Key:       key,
+			Count:     stat.count,
+			Contended: stat.contended,
+			Attempts:  stat.attempts,
+			Latency:   stat.latency,
+		})
+	}
+	m.mu.Unlock()
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Key < samples[j].Key })
+	return samples
+}
@@ -0,0 +1,170 @@
+package election
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Alert describes a condition detected on an election. Alerts with the same
+// Key are deduplicated until they are resolved.
+type Alert struct {
+	Key       string    `json:"key"`
+	Election  string    `json:"election"`
+	Condition string    `json:"condition"`
+	Detail    string    `json:"detail"`
+	Severity  string    `json:"severity"`
+	Resolved  bool      `json:"resolved"`
+	Time      time.Time `json:"time"`
+	Message   string    `json:"message"`
+}
+
+// AlertSink delivers alerts to an external system.
+type AlertSink interface {
+	Send(alert Alert) error
+}
+
+const defaultAlertTemplate = `{{if .Resolved}}RESOLVED {{end}}{{.Condition}} on {{.Election}}{{if .Detail}}: {{.Detail}}{{end}}`
+
+// Alerter renders alerts, suppresses duplicates of unresolved alerts, and fans
+// them out to every sink.
+type Alerter struct {
+	sinks    []AlertSink
+	template *template.Template
+
+	mu     sync.Mutex
+	active map[string]Alert
+}
+
+func NewAlerter(text string, sinks ...AlertSink) (*Alerter, error) {
+	if text == "" {
+		text = defaultAlertTemplate
+	}
+	tmpl, err := template.New("alert").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Alerter{sinks: sinks, template: tmpl, active: make(map[string]Alert)}, nil
+}
+
+// Fire sends alert unless an alert with the same key is already active.
+func (a *Alerter) Fire(alert Alert) {
+	a.mu.Lock()
+	if _, ok := a.active[alert.Key]; ok {
+		a.mu.Unlock()
+		return
+	}
+	a.active[alert.Key] = alert
+	a.mu.Unlock()
+	a.send(alert)
+}
+
+// Resolve sends a resolve notification for the active alert with key, if any.
+func (a *Alerter) Resolve(key string) {
+	a.mu.Lock()
+	alert, ok := a.active[key]
+	delete(a.active, key)
+	a.mu.Unlock()
+	if ok {
+		alert.Resolved = true
+		a.send(alert)
+	}
+}
+
+func (a *Alerter) send(alert Alert) {
+	alert.Time = time.Now()
+	if alert.Severity == "" {
+		alert.Severity = "critical"
+	}
+	var message bytes.Buffer
+	if err := a.template.Execute(&message, alert); err != nil {
+		alert.Message = fmt.Sprintf("%s on %s (template: %s)", alert.Condition, alert.Election, err.Error())
+	} else {
+		alert.Message = message.String()
+	}
+	for _, sink := range a.sinks {
+		if err := sink.Send(alert); err != nil {
+			std.eventStr(LevelWarn, alert.Election, evAlertFailed, err.Error())
+		}
+	}
+}
+
+// alertTimeout bounds the delivery of an alert to one sink, since alerts are
+// sent from the goroutines watching elections.
+const alertTimeout = 10 * time.Second
+
+var alertClient = &http.Client{Timeout: alertTimeout}
+
+func postJSON(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := alertClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// WebhookSink posts the alert as JSON to an arbitrary URL.
+type WebhookSink struct {
+	URL string
+}
+
+func (s *WebhookSink) Send(alert Alert) error {
+	return postJSON(s.URL, alert)
+}
+
+// SlackSink posts the rendered message to a Slack incoming webhook.
+type SlackSink struct {
+	URL string
+}
+
+func (s *SlackSink) Send(alert Alert) error {
+	return postJSON(s.URL, map[string]string{"text": alert.Message})
+}
+
+// PagerDutySink triggers and resolves incidents through the Events API v2.
+type PagerDutySink struct {
+	RoutingKey string
+	Source     string
+	URL        string // defaults to the public events endpoint
+}
+
+func (s *PagerDutySink) Send(alert Alert) error {
+	url := s.URL
+	if url == "" {
+		url = "https://events.pagerduty.com/v2/enqueue"
+	}
+	action := "trigger"
+	if alert.Resolved {
+		action = "resolve"
+	}
+	return postJSON(url, map[string]interface{}{
+		"routing_key":  s.RoutingKey,
+		"event_action": action,
+		"dedup_key":    alert.Key,
+		"payload": map[string]string{
+			"summary":  alert.Message,
+			"source":   s.Source,
+			"severity": alert.Severity,
+		},
+	})
+}
@@ -0,0 +1,118 @@
+package election_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// TestAlerterDedup fires an alert twice, resolves it and fires it again:
+// the duplicate must be suppressed, and the resolution sent once.
+func TestAlerterDedup(t *testing.T) {
+	sink := &alertLog{}
+	alerts, err := election.NewAlerter("", sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alert := election.Alert{Key: "leaderless/a", Election: "a", Condition: "leaderless", Detail: "no leader for 1m"}
+	alerts.Fire(alert)
+	alerts.Fire(alert)
+	alerts.Resolve("leaderless/a")
+	alerts.Resolve("leaderless/a")
+	alerts.Resolve("leaderless/b")
+	alerts.Fire(alert)
+
+	var messages []string
+	for _, sent := range sink.alerts {
+		messages = append(messages, sent.Message)
+	}
+	want := []string{"leaderless on a: no leader for 1m", "RESOLVED leaderless on a: no leader for 1m", "leaderless on a: no leader for 1m"}
+	if strings.Join(messages, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("sent %q, want %q", messages, want)
+	}
+	if sink.alerts[0].Severity != "critical" || sink.alerts[0].Time.IsZero() {
+		t.Fatalf("sent %+v, want a timed critical alert", sink.alerts[0])
+	}
+}
+
+// TestAlerterTemplate renders alerts with a template of the user's, and
+// falls back to a plain message when the template fails on an alert.
+func TestAlerterTemplate(t *testing.T) {
+	if _, err := election.NewAlerter("{{.Condition"); err == nil {
+		t.Fatal("accepted a malformed template")
+	}
+	sink := &alertLog{}
+	alerts, err := election.NewAlerter("[{{.Severity}}] {{.Election}} {{.Missing}}", sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts.Fire(election.Alert{Key: "k", Election: "a", Condition: "leaderless"})
+	if message := sink.alerts[0].Message; !strings.HasPrefix(message, "leaderless on a (template: ") {
+		t.Fatalf("message %q, want the fallback", message)
+	}
+
+	sink = &alertLog{}
+	if alerts, err = election.NewAlerter("[{{.Severity}}] {{.Election}}", sink); err != nil {
+		t.Fatal(err)
+	}
+	alerts.Fire(election.Alert{Key: "k", Election: "a", Severity: "warning"})
+	if message := sink.alerts[0].Message; message != "[warning] a" {
+		t.Fatalf("message %q, want [warning] a", message)
+	}
+}
+
+// TestAlertSinks delivers an alert and its resolution to the webhook, Slack
+// and PagerDuty sinks, and checks what each posts.
+func TestAlertSinks(t *testing.T) {
+	var mu sync.Mutex
+	posted := make(map[string][]map[string]interface{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		posted[r.URL.Path] = append(posted[r.URL.Path], body)
+		mu.Unlock()
+		if r.URL.Path == "/failing" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	alerts, err := election.NewAlerter("",
+		&election.WebhookSink{URL: server.URL + "/webhook"},
+		&election.SlackSink{URL: server.URL + "/slack"},
+		&election.PagerDutySink{RoutingKey: "routing", Source: "watchdog", URL: server.URL + "/pagerduty"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts.Fire(election.Alert{Key: "wedged/a", Election: "a", Condition: "wedged leader"})
+	alerts.Resolve("wedged/a")
+	failing := &election.WebhookSink{URL: server.URL + "/failing"}
+	if err := failing.Send(election.Alert{Key: "k"}); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("send to a failing webhook: %v, want its status", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if webhook := posted["/webhook"]; len(webhook) != 2 || webhook[0]["key"] != "wedged/a" || webhook[1]["resolved"] != true {
+		t.Errorf("webhook posted %v", webhook)
+	}
+	if slack := posted["/slack"]; len(slack) != 2 || slack[0]["text"] != "wedged leader on a" || slack[1]["text"] != "RESOLVED wedged leader on a" {
+		t.Errorf("slack posted %v", slack)
+	}
+	pagerduty := posted["/pagerduty"]
+	if len(pagerduty) != 2 || pagerduty[0]["event_action"] != "trigger" || pagerduty[1]["event_action"] != "resolve" {
+		t.Fatalf("pagerduty posted %v", pagerduty)
+	}
+	if pagerduty[0]["dedup_key"] != "wedged/a" || pagerduty[0]["routing_key"] != "routing" {
+		t.Errorf("pagerduty posted %v", pagerduty[0])
+	}
+}
@@ -0,0 +1,67 @@
+package election
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Annotator posts an annotation for every leadership transition to Grafana's
+// /api/annotations endpoint, or to any endpoint accepting the same payload.
+type Annotator struct {
+	URL   string
+	Token string // sent as a bearer token when set
+	Tags  []string
+}
+
+type annotation struct {
+	Time int64    `json:"time"`
+	Tags []string `json:"tags"`
+	Text string   `json:"text"`
+}
+
+// Annotate posts t in the background so that a slow dashboard never delays
+// the election loop.
+func (a *Annotator) Annotate(t Transition) {
+	go func() {
+		if err := a.post(t); err != nil {
+			std.eventStr(LevelWarn, t.ID, evAnnotateFailed, err.Error())
+		}
+	}()
+}
+
+func (a *Annotator) post(t Transition) error {
+	from, to := t.Leaders()
+	if from == "" {
+		from = "none"
+	}
+	if to == "" {
+		to = "unknown"
+	}
+	body, err := json.Marshal(annotation{
+		Time: t.Time.UnixNano() / 1e6,
+		Tags: append([]string{"etcd-leader", t.Key}, a.Tags...),
+		Text: fmt.Sprintf("%s: leader %s -> %s (%s)", t.Key, from, to, t.Reason),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", a.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", a.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry records one mutating request made by the client.
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Key       string    `json:"key"`
+	Condition string    `json:"condition,omitempty"`
+	Origin    string    `json:"origin,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Index     int       `json:"index,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// AuditSink receives an entry for every PUT and DELETE the client issues.
+type AuditSink interface {
+	Record(entry AuditEntry) error
+}
+
+// FileAuditSink appends entries as JSON lines to a file.
+type FileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditSink{file: file}, nil
+}
+
+func (s *FileAuditSink) Record(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+func (s *FileAuditSink) Close() error {
+	return s.file.Close()
+}
+
+// EtcdAuditSink appends entries as in-order keys under an etcd directory.
+// Its own writes are not audited.
+type EtcdAuditSink struct {
+	client  *EtcdClient
+	dir     string
+	timeout time.Duration
+}
+
+func NewEtcdAuditSink(client *EtcdClient, dir string) *EtcdAuditSink {
+	return &EtcdAuditSink{client: client, dir: dir, timeout: 10 * time.Second}
+}
+
+func (s *EtcdAuditSink) Record(entry AuditEntry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	_, err = s.client.append(ctx, s.dir, string(value))
+	return err
+}
+
+// SetAuditSink makes the client record every mutating request to sink. It
+// must be called before the client is shared between goroutines.
+func (c *EtcdClient) SetAuditSink(sink AuditSink) {
+	c.audit = sink
+}
+
+func (c *EtcdClient) record(method string, key string, option Option, resp *EtcdResponse, err error) {
+	if c.audit == nil {
+		return
+	}
+	entry := AuditEntry{
+		Time:      time.Now(),
+		Method:    method,
+		Key:       key,
+		Condition: option.condition(),
+		Origin:    option.origin,
+		Reason:    option.reason,
+		Outcome:   outcome(resp, err),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	} else if resp.Err() != nil {
+		entry.Error = resp.Err().Error()
+		entry.Index = resp.Index
+	} else {
+		entry.Index = resp.Node.ModifiedIndex
+	}
+	if err := c.audit.Record(entry); err != nil {
+		std.eventStr(LevelError, key, evAuditFailed, err.Error())
+	}
+}
+
+// condition describes the preconditions and TTL of a write.
+func (o Option) condition() string {
+	var parts []string
+	switch o.prevExist {
+	case 1:
+		parts = append(parts, "prevExist=true")
+	case -1:
+		parts = append(parts, "prevExist=false")
+	}
+	if o.prevIndex != 0 {
+		parts = append(parts, "prevIndex="+strconv.Itoa(o.prevIndex))
+	}
+	if o.prevValue != "" {
+		parts = append(parts, "prevValue="+o.prevValue)
+	}
+	if o.ttl != 0 {
+		parts = append(parts, "ttl="+o.ttl.String())
+	}
+	if o.refresh {
+		parts = append(parts, "refresh")
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,114 @@
+package election
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// credentials authenticate the client to a cluster with auth enabled: as
+// basic auth on every v2 request, and through a token on v3.
+type credentials struct {
+	username, password string
+
+	mu sync.Mutex
+	// the v3 token, fetched on first use and again once etcd rejects it
+	token string
+}
+
+// SetCredentials makes the client authenticate as username. The v2 keys API
+// gets the password with every request; the v3 gateway exchanges it for a
+// token, and for a new one whenever etcd answers 401 because the token
+// expired. It must be called before the client is shared between goroutines.
+func (c *EtcdClient) SetCredentials(username, password string) {
+	if username == "" {
+		c.credentials = nil
+		return
+	}
+	c.credentials = &credentials{username: username, password: password}
+}
+
+// Username returns the user the client authenticates as, or "".
+func (c *EtcdClient) Username() string {
+	if c.credentials == nil {
+		return ""
+	}
+	return c.credentials.username
+}
+
+// authenticate exchanges the credentials for a token.
+func (b *v3Backend) authenticate(ctx context.Context) (string, error) {
+	creds := b.client.credentials
+	data, _ := json.Marshal(map[string]string{"name": creds.username, "password": creds.password})
+	req, err := http.NewRequestWithContext(ctx, "POST", b.client.baseUrl+b.path+"/auth/authenticate", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := b.client.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var out struct {
+		Token string `json:"token"`
+		v3Error
+	}
+	json.Unmarshal(body, &out)
+	if resp.StatusCode != http.StatusOK || out.Token == "" {
+		return "", fmt.Errorf("etcd v3 authentication as %s: %s %s", creds.username, resp.Status, out.Message)
+	}
+	return out.Token, nil
+}
+
+// token returns the token to send, authenticating first if there is none.
+// stale is a token etcd just rejected.
+func (b *v3Backend) token(ctx context.Context, stale string) (string, error) {
+	creds := b.client.credentials
+	creds.mu.Lock()
+	defer creds.mu.Unlock()
+	if creds.token != "" && creds.token != stale {
+		return creds.token, nil
+	}
+	token, err := b.authenticate(ctx)
+	if err != nil {
+		return "", err
+	}
+	creds.token = token
+	return token, nil
+}
+
+// send sends req to the gateway with the client's token, if it has
+// credentials, and once more with a fresh token if etcd rejects it.
+func (b *v3Backend) send(req *http.Request) (*http.Response, error) {
+	if b.client.credentials == nil {
+		return b.client.client.Do(b.client.stats.trace(req))
+	}
+	token, err := b.token(req.Context(), "")
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", token)
+	resp, err := b.client.client.Do(b.client.stats.trace(req))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || req.GetBody == nil {
+		return resp, err
+	}
+	resp.Body.Close()
+	if token, err = b.token(req.Context(), token); err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	if retry.Body, err = req.GetBody(); err != nil {
+		return nil, err
+	}
+	retry.Header.Set("Authorization", token)
+	return b.client.client.Do(b.client.stats.trace(retry))
+}
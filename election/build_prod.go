@@ -0,0 +1,5 @@
+//go:build !dev
+
+package election
+
+const devBuild = false
@@ -0,0 +1,180 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Finding levels, from most to least severe.
+const (
+	FindingError   = "error"
+	FindingWarning = "warning"
+	FindingInfo    = "info"
+)
+
+// Finding is one problem, or one passed check, reported by Check.
+type Finding struct {
+	Level string `json:"level"`
+	Check string `json:"check"`
+	// Election is the shard the finding is about, or "" for the defaults
+	// and the cluster.
+	Election string `json:"election,omitempty"`
+	Message  string `json:"message"`
+	// Fix says what to change, if anything.
+	Fix string `json:"fix,omitempty"`
+}
+
+// maxRenewal is the longest pause of the election loop between two renewals,
+// as a fraction of the TTL (see pollInterval).
+const maxRenewal = 0.375
+
+// skewResolution is the smallest clock skew ClockSkew can tell from none.
+const skewResolution = time.Second
+
+// Check lints config before it is deployed: the settings of every election
+// against each other, and the cluster behind client for reachability, write
+// permissions under the client's allowed prefix, and clock skew. Findings
+// are ordered by severity.
+func Check(ctx context.Context, client *EtcdClient, config ManagerConfig) []Finding {
+	var findings []Finding
+	add := func(level, check, election, message, fix string) {
+		findings = append(findings, Finding{Level: level, Check: check, Election: election, Message: message, Fix: fix})
+	}
+
+	if err := config.Validate(); err != nil {
+		add(FindingError, "config", "", err.Error(), "")
+	}
+	if err := config.strict(client); err != nil {
+		add(FindingError, "strict", "", err.Error(), "fix the unsafe settings, or turn off strict")
+	}
+	shards := []string{""}
+	for shard := range config.Elections {
+		shards = append(shards, shard)
+	}
+	sort.Strings(shards[1:])
+	var shortest time.Duration
+	for _, shard := range shards {
+		election := config.Election(shard)
+		if shortest == 0 || election.TTL < shortest {
+			shortest = election.TTL
+		}
+		for _, f := range checkElection(election) {
+			f.Election = shard
+			findings = append(findings, f)
+		}
+	}
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		add(FindingError, "reachability", "", fmt.Sprintf("cannot reach etcd: %s", err.Error()), "check the endpoint URL and that etcd is listening on it")
+		return sortFindings(findings)
+	}
+	add(FindingInfo, "reachability", "", fmt.Sprintf("etcd %s is reachable", version.Server), "")
+	// an explicit v3 client is kept; anything else is negotiated
+	backend := client.Backend()
+	if backend != BackendV3 {
+		if backend, err = Negotiate(ctx, client, BackendAuto); err != nil {
+			add(FindingError, "backend", "", err.Error(), "")
+			return sortFindings(findings)
+		}
+	}
+	add(FindingInfo, "backend", "", fmt.Sprintf("using the %s API", backend), "")
+	if client.namespace != "" && backend != BackendV3 {
+		add(FindingWarning, "namespace", "", fmt.Sprintf("namespace %q is ignored by the %s API", client.namespace, backend), "use the v3 backend, or a keys path below a directory")
+	}
+
+	probe := fmt.Sprintf("%setcd-leader-check-%d", client.prefix, rand.Int63())
+	_, err = client.Put(ctx, probe, "check", Option{ttl: time.Second, prevExist: -1, origin: "check"})
+	switch {
+	case err == ErrReadOnly:
+		add(FindingInfo, "permissions", "", "the client is read-only; write permissions were not checked", "")
+	case errors.Is(err, ErrUnauthorized):
+		add(FindingError, "permissions", "", fmt.Sprintf("the credentials cannot write %s; candidates would only observe", probe), "grant the role read-write access to the key prefix")
+	case err != nil:
+		add(FindingError, "permissions", "", fmt.Sprintf("cannot write %s: %s", probe, err.Error()), "")
+	default:
+		add(FindingInfo, "permissions", "", fmt.Sprintf("can write under %q", client.prefix), "")
+		client.Delete(ctx, probe, "check", Option{origin: "check"})
+	}
+
+	skew, err := client.ClockSkew(ctx)
+	if err != nil {
+		add(FindingWarning, "clock", "", err.Error(), "")
+	} else {
+		level, fix := FindingInfo, ""
+		if abs(skew) > longer(shortest/2, skewResolution) {
+			level, fix = FindingError, "sync the clocks with NTP before running candidates"
+		} else if abs(skew) > longer(shortest/10, skewResolution) {
+			level, fix = FindingWarning, "sync the clocks with NTP"
+		}
+		add(level, "clock", "", fmt.Sprintf("local clock is %s off the etcd server (shortest ttl %s)", skew.Round(time.Millisecond), shortest), fix)
+	}
+	return sortFindings(findings)
+}
+
+// checkElection reports settings of one election that validate but are
+// unlikely to do what was meant.
+func checkElection(c ElectionConfig) []Finding {
+	var findings []Finding
+	add := func(level, check, message, fix string) {
+		findings = append(findings, Finding{Level: level, Check: check, Message: message, Fix: fix})
+	}
+	renewal := time.Duration(maxRenewal * float64(c.TTL))
+	if margin := c.TTL - renewal; margin < 2*time.Second {
+		add(FindingWarning, "ttl", fmt.Sprintf("renewals come up to %s apart, leaving %s for a slow request before the lock expires", renewal, margin), "use a ttl of at least 4s")
+	}
+	if c.Heartbeat != 0 && c.Heartbeat >= c.TTL {
+		add(FindingWarning, "heartbeat", fmt.Sprintf("heartbeat %s is not shorter than ttl %s, so a wedged leader is never seen before its lock expires", c.Heartbeat, c.TTL), "use a heartbeat of about half the ttl")
+	} else if c.Heartbeat != 0 && c.Heartbeat <= renewal {
+		add(FindingWarning, "heartbeat", fmt.Sprintf("heartbeat %s can expire between two renewals up to %s apart, raising false wedged-leader alerts", c.Heartbeat, renewal), "use a heartbeat of about half the ttl")
+	}
+	if c.BroadcastTTL != 0 && c.BroadcastTTL <= renewal {
+		add(FindingWarning, "broadcast-ttl", fmt.Sprintf("broadcast ttl %s can expire between two renewals up to %s apart, leaving the leader unannounced", c.BroadcastTTL, renewal), "use a broadcast ttl of at least the ttl")
+	}
+	if c.Backoff < c.TTL {
+		add(FindingWarning, "backoff", fmt.Sprintf("backoff %s is shorter than ttl %s, so a candidate that lost the lock races its successor right away", c.Backoff, c.TTL), "leave backoff unset for twice the ttl")
+	}
+	if c.TakeoverGrace > 0.5 {
+		add(FindingWarning, "takeover-grace", fmt.Sprintf("takeover grace %g keeps a new leader idle for over half its first lease", c.TakeoverGrace), "use 0.25 or less")
+	}
+	if c.Chaos > 0 {
+		add(FindingWarning, "chaos", fmt.Sprintf("chaos %g stalls real leaders on purpose", c.Chaos), "set chaos only for tests")
+	}
+	if c.ObserverSkew != 0 && c.Refresh {
+		add(FindingWarning, "observer-skew", fmt.Sprintf("observer skew %s has no effect with refresh, since refreshed expirations never reach observers", c.ObserverSkew), "turn off refresh or drop the skew")
+	}
+	if c.Preempt && c.CompatLayout != "" {
+		add(FindingWarning, "preempt", "leaders cannot hand the lock over during a layout upgrade, so preempt requests go unanswered", "turn off preempt until compat layout is cleared")
+	}
+	if c.CompatLayout != "" {
+		add(FindingInfo, "layout", fmt.Sprintf("writing both the %s and %s layouts", c.Layout, c.CompatLayout), "clear compat layout once every candidate runs this release")
+	}
+	return findings
+}
+
+var findingOrder = map[string]int{FindingError: 0, FindingWarning: 1, FindingInfo: 2}
+
+func sortFindings(findings []Finding) []Finding {
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findingOrder[findings[i].Level] < findingOrder[findings[j].Level]
+	})
+	return findings
+}
+
+func longer(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
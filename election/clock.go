@@ -0,0 +1,141 @@
+package election
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ClockSkew estimates how far the local clock is ahead of the etcd server's,
+// from the Date header of a /version request taken at the midpoint of the
+// round trip. The header has a resolution of one second, so the estimate is
+// off by up to that much plus half the round trip.
+func (c *EtcdClient) ClockSkew(ctx context.Context) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseUrl+"/version", nil)
+	if err != nil {
+		return 0, err
+	}
+	sent := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("/version: no usable Date header: %s", err.Error())
+	}
+	// Date is truncated to the second; its middle is the best guess
+	server := date.Add(500 * time.Millisecond)
+	local := sent.Add(received.Sub(sent) / 2)
+	return local.Sub(server), nil
+}
+
+// ClockPolicy decides what a manager does while its clock is skewed against
+// etcd's by more than the ClockCheck allows.
+type ClockPolicy string
+
+const (
+	// ClockWarn logs the skew and carries on.
+	ClockWarn ClockPolicy = "warn"
+	// ClockRefuse also stops acquiring locks until the skew is back within
+	// bounds. Leaderships already held are kept.
+	ClockRefuse ClockPolicy = "refuse"
+)
+
+// ClockCheck compares the local clock against etcd's when a manager starts
+// and every Interval after, since severe skew undermines the assumptions
+// applications make about lease expiry.
+type ClockCheck struct {
+	// MaxSkew is the tolerated skew as a fraction of an election's TTL.
+	// Skew under a second cannot be measured and is always tolerated.
+	MaxSkew  float64
+	Interval time.Duration
+	Policy   ClockPolicy
+}
+
+// clockGuard holds the last skew measured under a ClockCheck.
+type clockGuard struct {
+	check ClockCheck
+	start sync.Once
+	skew  int64 // accessed atomically, in nanoseconds
+}
+
+// tolerates reports whether the last measured skew is acceptable for an
+// election with the given TTL.
+func (g *clockGuard) tolerates(ttl time.Duration) bool {
+	skew := abs(time.Duration(atomic.LoadInt64(&g.skew)))
+	return skew <= longer(time.Duration(g.check.MaxSkew*float64(ttl)), skewResolution)
+}
+
+// refuses reports whether an election with the given TTL must not acquire
+// its lock now.
+func (g *clockGuard) refuses(ttl time.Duration) bool {
+	return g.check.Policy == ClockRefuse && !g.tolerates(ttl)
+}
+
+// measure updates the skew, and logs it if it is out of bounds for the
+// shortest TTL in use.
+func (g *clockGuard) measure(ctx context.Context, client *EtcdClient, id string, shortest time.Duration) {
+	skew, err := client.ClockSkew(ctx)
+	if err != nil {
+		std.error(id, err)
+		return
+	}
+	atomic.StoreInt64(&g.skew, int64(skew))
+	if !g.tolerates(shortest) {
+		std.eventStr(LevelWarn, id, evClockSkew, fmt.Sprintf("%s (ttl %s)", skew.Round(time.Millisecond), shortest))
+	}
+}
+
+// SetClockCheck enables the clock comparison of check. The next call to Start
+// measures the skew before campaigning, and starts the periodic checks.
+func (m *Manager) SetClockCheck(check ClockCheck) {
+	m.mu.Lock()
+	m.clock = &clockGuard{check: check}
+	m.mu.Unlock()
+}
+
+// watchClock measures the skew once before returning, then every interval
+// until the manager is closed.
+func (m *Manager) watchClock(guard *clockGuard) {
+	guard.start.Do(func() {
+		guard.measure(m.ctx, m.client, m.id, m.shortestTTL())
+		if guard.check.Interval <= 0 {
+			return
+		}
+		go func() {
+			ticker := time.NewTicker(guard.check.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-m.ctx.Done():
+					return
+				case <-ticker.C:
+					guard.measure(m.ctx, m.client, m.id, m.shortestTTL())
+				}
+			}
+		}()
+	})
+}
+
+// shortestTTL returns the shortest TTL among the manager's elections, or
+// among its configuration before any has started.
+func (m *Manager) shortestTTL() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	shortest := m.config.Election("").TTL
+	for _, state := range m.states {
+		if state.ttl < shortest {
+			shortest = state.ttl
+		}
+	}
+	return shortest
+}
@@ -0,0 +1,88 @@
+package election
+
+import (
+	"net/http"
+	"sync"
+)
+
+// validators remembers the last body served with an ETag or Last-Modified
+// validator for each GET URL, so that a proxy or gateway in front of etcd can
+// answer a repeated read with 304 Not Modified instead of the full body.
+type validators struct {
+	mu      sync.Mutex
+	entries map[string]validated
+}
+
+type validated struct {
+	etag         string
+	lastModified string
+	status       int
+	body         []byte
+}
+
+// prepare adds the validators stored for req, if any.
+func (v *validators) prepare(req *http.Request) {
+	v.mu.Lock()
+	entry, ok := v.entries[req.URL.String()]
+	v.mu.Unlock()
+	if !ok {
+		return
+	}
+	if entry.etag != "" {
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+	if entry.lastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.lastModified)
+	}
+}
+
+// resolve returns the status and body to use for resp: the stored ones if
+// the server answered 304, otherwise resp's own, which are remembered if
+// they come with a validator.
+func (v *validators) resolve(req *http.Request, resp *http.Response, body []byte) (int, []byte) {
+	key := req.URL.String()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if resp.StatusCode == http.StatusNotModified {
+		if entry, ok := v.entries[key]; ok {
+			return entry.status, entry.body
+		}
+		return resp.StatusCode, body
+	}
+	entry := validated{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		status:       resp.StatusCode,
+		body:         body,
+	}
+	if entry.etag == "" && entry.lastModified == "" {
+		delete(v.entries, key)
+	} else {
+		v.entries[key] = entry
+	}
+	return resp.StatusCode, body
+}
+
+// SetConditional makes plain GETs conditional on the validators of the
+// previous response to the same URL, for observers polling through a caching
+// gateway. etcd itself sends no validators, so this has no effect against a
+// bare cluster. It must be called before the client is shared between
+// goroutines.
+func (c *EtcdClient) SetConditional(enabled bool) {
+	if enabled {
+		c.validators = &validators{entries: make(map[string]validated)}
+	} else {
+		c.validators = nil
+	}
+}
+
+// SetCompression controls whether responses are requested with
+// Accept-Encoding: gzip, which the transport does by default. Large
+// observations compress well, but small ones are cheaper to read uncompressed
+// on a fast network. It must be called before the client is shared between
+// goroutines, and has no effect on a client without an HTTP transport.
+func (c *EtcdClient) SetCompression(enabled bool) {
+	if transport := c.transport(); transport != nil {
+		transport.DisableCompression = !enabled
+	}
+}
@@ -0,0 +1,395 @@
+package election
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ElectionConfig holds the settings of a single election. Zero fields are
+// inherited from the manager defaults, unless named by Zero.
+type ElectionConfig struct {
+	TTL time.Duration
+	// Backoff is how long to wait after losing leadership before campaigning
+	// again. Defaults to twice the TTL.
+	Backoff time.Duration
+	// RenewInterval is the mean delay between two polls of the lock, at
+	// which a leader renews it and a follower that does not watch it
+	// looks for it to be free; each delay is jittered by half of it either
+	// way. Defaults to a quarter of the TTL.
+	RenewInterval time.Duration
+	// Retry is how the candidate waits out failed requests to etcd.
+	Retry         RetryPolicy
+	TakeoverGrace float64
+	Chaos         float32
+	// Heartbeat is the TTL of a heartbeat key the leader refreshes
+	// alongside its lock. It should be well below TTL; zero disables it.
+	Heartbeat time.Duration
+	// Refresh renews the lock by resetting its TTL instead of rewriting
+	// the value. The full record written at acquisition stays readable,
+	// while renewals stop waking every watcher of the election.
+	Refresh bool
+	// ClearBroadcast deletes the broadcast key when the candidate resigns,
+	// so that those following the broadcast stop routing to it at once
+	// instead of once a successor announces itself.
+	ClearBroadcast bool
+	// BroadcastTTL expires the broadcast key this long after the leader
+	// last renewed it, so that it does not name a crashed leader forever;
+	// the leader then refreshes it on every renewal. Zero keeps it until a
+	// successor overwrites it.
+	BroadcastTTL time.Duration
+	// LeaseGuard makes IsLeader report false once TTL has passed since the
+	// last renewal request that succeeded was sent, even before the
+	// election loop finds out it lost the lock, so that a leader cut off
+	// from etcd stops acting as one by the time its lock expires.
+	LeaseGuard bool
+	// Strict refuses configurations known to be unsafe: see Unsafe.
+	Strict bool
+	// Fair makes candidates that find the lock free defer to the one that
+	// has been campaigning longest, so that one lucky candidate does not
+	// win every failover. It costs a contender key per candidate, and
+	// candidates poll the lock rather than watch it.
+	Fair bool
+	// Critical elections are campaigned for first when a manager starts;
+	// the others wait until every critical one has made its first attempt.
+	Critical bool
+	// BroadcastFailure decides what a new leader does when it cannot write
+	// the broadcast key. Defaults to BroadcastRetry.
+	BroadcastFailure BroadcastPolicy
+	// Layout names the keys of the election. Defaults to LayoutFlat.
+	Layout Layout
+	// CompatLayout is the layout of the previous release while a rolling
+	// upgrade changes Layout. The lock is then held in both layouts and
+	// the other keys are written to both, so old and new candidates never
+	// lead at the same time. Clear it in the release after.
+	CompatLayout Layout
+	// ObserverSkew makes observers treat the leader as gone this long
+	// before its lock expires in etcd, so that routing stops sending it
+	// requests it may not live to answer. Observers only learn the new
+	// expiration from renewals that rewrite the value, so the skew is
+	// ignored with Refresh.
+	ObserverSkew time.Duration
+	// Capabilities are labels of this candidate, such as "ssd", and Weights
+	// rates labels for the election. A candidate that finds the lock free
+	// waits before trying to take it for a part of WeightDelay that
+	// shrinks with the weight of its capabilities, so that the best
+	// equipped candidate alive usually wins. WeightDelay defaults to half
+	// the TTL; without weights, nobody waits.
+	Capabilities []string
+	Weights      map[string]float64
+	WeightDelay  time.Duration
+	// Priority ranks the candidate from 0 to MaxPriority, higher being
+	// preferred, and is announced along with its id. PriorityDelay enables
+	// priorities: a candidate that finds the lock free waits for the part
+	// of PriorityDelay by which its priority falls short of MaxPriority, on
+	// top of any weight delay, so that the highest-priority candidate alive
+	// usually wins. Without PriorityDelay, nobody waits.
+	Priority      int
+	PriorityDelay time.Duration
+	// Preempt makes a candidate that finds the lock held by one of lower
+	// priority ask the leader to hand it over, which the leader does with
+	// TransferTo at its next renewal. Leaders only honour the request with
+	// Preempt set themselves, so set it on every candidate of the election.
+	Preempt bool
+	// ExclusiveID tags the lock with a nonce of this process, and makes the
+	// candidate recognize only a lock with its nonce as its own rather than
+	// any lock naming its id. Two processes sharing an id, such as clones
+	// with the same configuration or client certificate, then cannot both
+	// act as leader: the other one reports ErrDuplicateID. A restarted
+	// candidate waits for its predecessor's lock to expire.
+	ExclusiveID bool
+	// Gates names gates registered with RegisterGate, all of which must be
+	// open for the candidate to try to take a free lock.
+	Gates []string
+	// Throttle limits how many candidates of a fleet attempt to take a
+	// free lock at once: see ThrottlePolicy.
+	Throttle ThrottlePolicy
+	// Fingerprint adds the entries of Fingerprint to the metadata, so that
+	// the leading process can be found from its announcement. Metadata
+	// set explicitly takes precedence.
+	Fingerprint bool
+	// Metadata is announced along with the leader id. Overrides add to and
+	// replace entries of the defaults rather than the whole map.
+	Metadata map[string]string
+	// Zero names the fields of an override that are meant to be zero, such
+	// as Refresh turned off, or Priority 0, where the defaults set them.
+	Zero ZeroFields
+}
+
+// ZeroFields is a set of the ElectionConfig fields whose zero value is a
+// setting of its own, such as false, rather than "unset".
+type ZeroFields uint32
+
+const (
+	ZeroRefresh ZeroFields = 1 << iota
+	ZeroCritical
+	ZeroClearBroadcast
+	ZeroLeaseGuard
+	ZeroStrict
+	ZeroFair
+	ZeroPreempt
+	ZeroFingerprint
+	ZeroExclusiveID
+	ZeroTakeoverGrace
+	ZeroChaos
+	ZeroHeartbeat
+	ZeroBroadcastTTL
+	ZeroObserverSkew
+	ZeroPriority
+	ZeroPriorityDelay
+	ZeroCompatLayout
+)
+
+// unset reports whether a zero field of c is to be taken from the defaults.
+func (c ElectionConfig) unset(field ZeroFields) bool {
+	return c.Zero&field == 0
+}
+
+// merge returns c with its zero fields taken from defaults, but for those
+// named by Zero.
+func (c ElectionConfig) merge(defaults ElectionConfig) ElectionConfig {
+	if c.TTL == 0 {
+		c.TTL = defaults.TTL
+	}
+	if c.Backoff == 0 {
+		c.Backoff = defaults.Backoff
+	}
+	if c.RenewInterval == 0 {
+		c.RenewInterval = defaults.RenewInterval
+	}
+	c.Retry = c.Retry.merge(defaults.Retry)
+	c.Throttle = c.Throttle.merge(defaults.Throttle)
+	if c.TakeoverGrace == 0 && c.unset(ZeroTakeoverGrace) {
+		c.TakeoverGrace = defaults.TakeoverGrace
+	}
+	if c.Chaos == 0 && c.unset(ZeroChaos) {
+		c.Chaos = defaults.Chaos
+	}
+	if c.Heartbeat == 0 && c.unset(ZeroHeartbeat) {
+		c.Heartbeat = defaults.Heartbeat
+	}
+	if !c.Refresh && c.unset(ZeroRefresh) {
+		c.Refresh = defaults.Refresh
+	}
+	if !c.Critical && c.unset(ZeroCritical) {
+		c.Critical = defaults.Critical
+	}
+	if !c.ClearBroadcast && c.unset(ZeroClearBroadcast) {
+		c.ClearBroadcast = defaults.ClearBroadcast
+	}
+	if c.BroadcastTTL == 0 && c.unset(ZeroBroadcastTTL) {
+		c.BroadcastTTL = defaults.BroadcastTTL
+	}
+	if !c.LeaseGuard && c.unset(ZeroLeaseGuard) {
+		c.LeaseGuard = defaults.LeaseGuard
+	}
+	if !c.Strict && c.unset(ZeroStrict) {
+		c.Strict = defaults.Strict
+	}
+	if !c.Fair && c.unset(ZeroFair) {
+		c.Fair = defaults.Fair
+	}
+	if c.BroadcastFailure == "" {
+		c.BroadcastFailure = defaults.BroadcastFailure
+	}
+	if c.Layout == "" {
+		c.Layout = defaults.Layout
+	}
+	if c.CompatLayout == "" && c.unset(ZeroCompatLayout) {
+		c.CompatLayout = defaults.CompatLayout
+	}
+	if c.ObserverSkew == 0 && c.unset(ZeroObserverSkew) {
+		c.ObserverSkew = defaults.ObserverSkew
+	}
+	if c.Capabilities == nil {
+		c.Capabilities = defaults.Capabilities
+	}
+	if c.Weights == nil {
+		c.Weights = defaults.Weights
+	}
+	if c.WeightDelay == 0 {
+		c.WeightDelay = defaults.WeightDelay
+	}
+	if c.Priority == 0 && c.unset(ZeroPriority) {
+		c.Priority = defaults.Priority
+	}
+	if c.PriorityDelay == 0 && c.unset(ZeroPriorityDelay) {
+		c.PriorityDelay = defaults.PriorityDelay
+	}
+	if !c.Preempt && c.unset(ZeroPreempt) {
+		c.Preempt = defaults.Preempt
+	}
+	if !c.Fingerprint && c.unset(ZeroFingerprint) {
+		c.Fingerprint = defaults.Fingerprint
+	}
+	if !c.ExclusiveID && c.unset(ZeroExclusiveID) {
+		c.ExclusiveID = defaults.ExclusiveID
+	}
+	if c.Gates == nil {
+		c.Gates = defaults.Gates
+	}
+	if len(defaults.Metadata) > 0 {
+		metadata := make(map[string]string, len(defaults.Metadata)+len(c.Metadata))
+		for name, value := range defaults.Metadata {
+			metadata[name] = value
+		}
+		for name, value := range c.Metadata {
+			metadata[name] = value
+		}
+		c.Metadata = metadata
+	}
+	return c
+}
+
+func (c ElectionConfig) validate() []string {
+	var problems []string
+	// the keys API takes TTLs in whole seconds
+	if c.TTL < time.Second {
+		problems = append(problems, fmt.Sprintf("ttl %s is shorter than 1s", c.TTL))
+	} else if c.TTL%time.Second != 0 {
+		problems = append(problems, fmt.Sprintf("ttl %s is not a whole number of seconds", c.TTL))
+	}
+	if c.Heartbeat != 0 && (c.Heartbeat < time.Second || c.Heartbeat%time.Second != 0) {
+		problems = append(problems, fmt.Sprintf("heartbeat %s is not a whole number of seconds", c.Heartbeat))
+	}
+	if c.BroadcastTTL != 0 && (c.BroadcastTTL < time.Second || c.BroadcastTTL%time.Second != 0) {
+		problems = append(problems, fmt.Sprintf("broadcast ttl %s is not a whole number of seconds", c.BroadcastTTL))
+	}
+	if c.ObserverSkew < 0 || (c.ObserverSkew != 0 && c.ObserverSkew >= c.TTL) {
+		problems = append(problems, fmt.Sprintf("observer skew %s is outside [0, ttl)", c.ObserverSkew))
+	}
+	if c.Backoff < 0 {
+		problems = append(problems, fmt.Sprintf("backoff %s is negative", c.Backoff))
+	}
+	if c.RenewInterval < 0 || (c.RenewInterval != 0 && c.RenewInterval >= c.TTL) {
+		problems = append(problems, fmt.Sprintf("renew interval %s is outside [0, ttl)", c.RenewInterval))
+	}
+	problems = append(problems, c.Retry.validate()...)
+	problems = append(problems, c.Throttle.validate()...)
+	for label, weight := range c.Weights {
+		if weight < 0 {
+			problems = append(problems, fmt.Sprintf("weight %g of %q is negative", weight, label))
+		}
+	}
+	if c.WeightDelay < 0 || (c.WeightDelay != 0 && c.WeightDelay >= c.TTL) {
+		problems = append(problems, fmt.Sprintf("weight delay %s is outside [0, ttl)", c.WeightDelay))
+	}
+	if c.Priority < 0 || c.Priority > MaxPriority {
+		problems = append(problems, fmt.Sprintf("priority %d is outside [0, %d]", c.Priority, MaxPriority))
+	}
+	if c.PriorityDelay < 0 || (c.PriorityDelay != 0 && c.PriorityDelay >= c.TTL) {
+		problems = append(problems, fmt.Sprintf("priority delay %s is outside [0, ttl)", c.PriorityDelay))
+	}
+	if _, err := lookupGates(c.Gates); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.TakeoverGrace < 0 || c.TakeoverGrace >= 1 {
+		problems = append(problems, fmt.Sprintf("takeover grace %g is outside [0, 1)", c.TakeoverGrace))
+	}
+	if c.Chaos < 0 || c.Chaos > 1 {
+		problems = append(problems, fmt.Sprintf("chaos %g is outside [0, 1]", c.Chaos))
+	}
+	switch c.BroadcastFailure {
+	case "", BroadcastRetry, BroadcastResign, BroadcastDegraded:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown broadcast failure policy %q", c.BroadcastFailure))
+	}
+	if !c.Layout.valid() {
+		problems = append(problems, fmt.Sprintf("unknown layout %q", c.Layout))
+	}
+	if !c.CompatLayout.valid() {
+		problems = append(problems, fmt.Sprintf("unknown compat layout %q", c.CompatLayout))
+	} else if c.CompatLayout != "" && c.CompatLayout == c.Layout {
+		problems = append(problems, fmt.Sprintf("compat layout %q is the layout itself", c.CompatLayout))
+	}
+	return problems
+}
+
+// hesitation is how long a candidate waits before trying to take a free lock:
+// all of the weight delay without any of the weighted capabilities, none
+// with all of them, plus its share of the priority delay.
+func (c ElectionConfig) hesitation() time.Duration {
+	wait := c.PriorityDelay * time.Duration(MaxPriority-c.Priority) / MaxPriority
+	var total, own float64
+	for _, weight := range c.Weights {
+		total += weight
+	}
+	if total == 0 {
+		return wait
+	}
+	seen := make(map[string]bool, len(c.Capabilities))
+	for _, label := range c.Capabilities {
+		if !seen[label] {
+			seen[label] = true
+			own += c.Weights[label]
+		}
+	}
+	delay := c.WeightDelay
+	if delay == 0 {
+		delay = c.TTL / 2
+	}
+	return wait + time.Duration(float64(delay)*(1-own/total))
+}
+
+// ManagerConfig is the configuration of a Manager: defaults shared by every
+// election, plus overrides for individual elections keyed by shard.
+type ManagerConfig struct {
+	Concurrency int
+	// MaxElections caps the elections a manager runs at once, and with
+	// them its goroutines, watches and timers; zero means no limit.
+	MaxElections int
+	Defaults     ElectionConfig
+	Elections    map[string]ElectionConfig
+}
+
+// Election returns the effective configuration of shard.
+func (c *ManagerConfig) Election(shard string) ElectionConfig {
+	config := c.Elections[shard].merge(c.Defaults)
+	if config.Backoff == 0 {
+		config.Backoff = 2 * config.TTL
+	}
+	config.Retry = config.Retry.merge(RetryPolicy{Initial: config.TTL / 4, Max: 2 * config.TTL, Jitter: 0.5})
+	config.Throttle = config.Throttle.merge(ThrottlePolicy{Window: time.Second})
+	if config.BroadcastFailure == "" {
+		config.BroadcastFailure = BroadcastRetry
+	}
+	if config.Layout == "" {
+		config.Layout = LayoutFlat
+	}
+	return config
+}
+
+// shards returns the shards with overrides, sorted.
+func (c *ManagerConfig) shards() []string {
+	shards := make([]string, 0, len(c.Elections))
+	for shard := range c.Elections {
+		shards = append(shards, shard)
+	}
+	sort.Strings(shards)
+	return shards
+}
+
+// Validate checks the defaults and every override as they would be applied,
+// reporting all problems at once.
+func (c *ManagerConfig) Validate() error {
+	var problems []string
+	if c.Concurrency < 1 {
+		problems = append(problems, fmt.Sprintf("concurrency %d is less than 1", c.Concurrency))
+	}
+	if c.MaxElections < 0 {
+		problems = append(problems, fmt.Sprintf("max elections %d is negative", c.MaxElections))
+	}
+	for _, problem := range c.Election("").validate() {
+		problems = append(problems, "defaults: "+problem)
+	}
+	for _, shard := range c.shards() {
+		for _, problem := range c.Election(shard).validate() {
+			problems = append(problems, shard+": "+problem)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid manager config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package election_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// TestManagerConfigOverrides merges overrides with the defaults both ways:
+// an override turns on what the defaults leave off, and with Zero turns off
+// what they turn on.
+func TestManagerConfigOverrides(t *testing.T) {
+	on := election.ElectionConfig{
+		TTL:           2 * time.Second,
+		Refresh:       true,
+		Critical:      true,
+		LeaseGuard:    true,
+		Fair:          true,
+		Preempt:       true,
+		ExclusiveID:   true,
+		Priority:      5,
+		PriorityDelay: time.Second,
+		TakeoverGrace: 0.5,
+		Chaos:         0.1,
+		Heartbeat:     time.Second,
+		CompatLayout:  election.LayoutDir,
+	}
+	for _, test := range []struct {
+		name     string
+		defaults election.ElectionConfig
+		override election.ElectionConfig
+		check    func(election.ElectionConfig) interface{}
+		want     interface{}
+	}{
+		{"refresh on", election.ElectionConfig{}, election.ElectionConfig{Refresh: true},
+			func(c election.ElectionConfig) interface{} { return c.Refresh }, true},
+		{"refresh inherited", on, election.ElectionConfig{},
+			func(c election.ElectionConfig) interface{} { return c.Refresh }, true},
+		{"refresh off", on, election.ElectionConfig{Zero: election.ZeroRefresh},
+			func(c election.ElectionConfig) interface{} { return c.Refresh }, false},
+		{"fair off", on, election.ElectionConfig{Zero: election.ZeroFair},
+			func(c election.ElectionConfig) interface{} { return c.Fair }, false},
+		{"lease guard off", on, election.ElectionConfig{Zero: election.ZeroLeaseGuard},
+			func(c election.ElectionConfig) interface{} { return c.LeaseGuard }, false},
+		{"critical off", on, election.ElectionConfig{Zero: election.ZeroCritical},
+			func(c election.ElectionConfig) interface{} { return c.Critical }, false},
+		{"preempt off", on, election.ElectionConfig{Zero: election.ZeroPreempt},
+			func(c election.ElectionConfig) interface{} { return c.Preempt }, false},
+		{"exclusive id off", on, election.ElectionConfig{Zero: election.ZeroExclusiveID},
+			func(c election.ElectionConfig) interface{} { return c.ExclusiveID }, false},
+		{"priority raised", on, election.ElectionConfig{Priority: 9},
+			func(c election.ElectionConfig) interface{} { return c.Priority }, 9},
+		{"priority 0", on, election.ElectionConfig{Zero: election.ZeroPriority},
+			func(c election.ElectionConfig) interface{} { return c.Priority }, 0},
+		{"takeover grace 0", on, election.ElectionConfig{Zero: election.ZeroTakeoverGrace},
+			func(c election.ElectionConfig) interface{} { return c.TakeoverGrace }, 0.0},
+		{"chaos 0", on, election.ElectionConfig{Zero: election.ZeroChaos},
+			func(c election.ElectionConfig) interface{} { return c.Chaos }, float32(0)},
+		{"heartbeat off", on, election.ElectionConfig{Zero: election.ZeroHeartbeat},
+			func(c election.ElectionConfig) interface{} { return c.Heartbeat }, time.Duration(0)},
+		{"compat layout off", on, election.ElectionConfig{Zero: election.ZeroCompatLayout},
+			func(c election.ElectionConfig) interface{} { return c.CompatLayout }, election.Layout("")},
+		{"zero ignores a set field", on, election.ElectionConfig{Priority: 3, Zero: election.ZeroPriority},
+			func(c election.ElectionConfig) interface{} { return c.Priority }, 3},
+		{"one field off keeps the others", on, election.ElectionConfig{Zero: election.ZeroRefresh},
+			func(c election.ElectionConfig) interface{} { return []bool{c.Fair, c.LeaseGuard, c.Preempt} }, []bool{true, true, true}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			config := election.ManagerConfig{
+				Concurrency: 1,
+				Defaults:    test.defaults,
+				Elections:   map[string]election.ElectionConfig{"shard": test.override},
+			}
+			if got := test.check(config.Election("shard")); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
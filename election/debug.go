@@ -0,0 +1,150 @@
+package election
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// exchange is a recorded etcd request and its response.
+type exchange struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	URL    string    `json:"url"`
+	Status int       `json:"status"`
+	Body   string    `json:"body"`
+}
+
+const maxExchangeBody = 4096
+
+func newExchange(req *http.Request, resp *http.Response, body []byte) exchange {
+	if len(body) > maxExchangeBody {
+		body = body[:maxExchangeBody]
+	}
+	return exchange{
+		Time:   time.Now(),
+		Method: req.Method,
+		URL:    redactURL(req.URL.String()),
+		Status: resp.StatusCode,
+		Body:   string(body),
+	}
+}
+
+// redactURL strips credentials embedded in a URL.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	u.User = url.User("REDACTED")
+	return u.String()
+}
+
+// redactConfig hides values of settings that look like credentials.
+func redactConfig(config map[string]string) map[string]string {
+	redacted := make(map[string]string, len(config))
+	for name, value := range config {
+		lower := strings.ToLower(name)
+		switch {
+		case strings.Contains(lower, "password"), strings.Contains(lower, "token"), strings.Contains(lower, "secret"):
+			redacted[name] = "REDACTED"
+		case strings.Contains(lower, "user"):
+			// name:password, as taken by the user flag
+			if i := strings.Index(value, ":"); i >= 0 {
+				value = value[:i+1] + "REDACTED"
+			}
+			redacted[name] = value
+		default:
+			redacted[name] = redactURL(value)
+		}
+	}
+	return redacted
+}
+
+// WriteBundle writes a gzipped tarball describing the election state of keys,
+// named as layout does, as seen through client, together with this process's
+// recent history.
+func WriteBundle(ctx context.Context, w io.Writer, client *EtcdClient, keys []string, layout Layout, config map[string]string) error {
+	state := make(map[string]interface{})
+	for _, key := range keys {
+		probe := &State{key: key, layout: layout}
+		for _, name := range []string{probe.leaderKey(), probe.broadcastKey()} {
+			if resp, err := client.Get(ctx, name, Option{}); err != nil && !answered(err) {
+				state[name] = err.Error()
+			} else {
+				state[name] = resp
+			}
+		}
+	}
+	metrics := map[string]interface{}{
+		"connections": client.ConnStats(),
+		"operations":  client.Metrics().Snapshot(),
+		"watch_lag":   client.Metrics().WatchLag(),
+	}
+	for _, path := range []string{"/version", "/v2/stats/self", "/v2/stats/store"} {
+		if raw, err := client.raw(ctx, path); err != nil {
+			metrics[path] = err.Error()
+		} else {
+			metrics[path] = raw
+		}
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	files := []struct {
+		name    string
+		content interface{}
+	}{
+		{"state.json", state},
+		{"history.json", history.snapshot()},
+		{"metrics.json", metrics},
+		{"config.json", redactConfig(config)},
+		{"responses.json", client.responses.snapshot()},
+	}
+	for _, file := range files {
+		data, err := json.MarshalIndent(file.content, "", "  ")
+		if err != nil {
+			return err
+		}
+		header := &tar.Header{Name: file.name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := archive.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// raw fetches a non-keys endpoint such as /version and returns its JSON body.
+func (c *EtcdClient) raw(ctx context.Context, path string) (json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseUrl+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("%s: %s", path, resp.Status)
+	}
+	return json.RawMessage(body), nil
+}
@@ -0,0 +1,194 @@
+package election
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Drainer stops serving leader-only traffic when leadership is lost: it
+// closes registered listeners, waits for in-flight connections and requests
+// to finish, and force-closes whatever is left once the deadline passes.
+type Drainer struct {
+	timeout time.Duration
+
+	mu        sync.Mutex
+	listeners []*drainListener
+	servers   []*http.Server
+	drained   bool
+}
+
+func NewDrainer(timeout time.Duration) *Drainer {
+	return &Drainer{timeout: timeout}
+}
+
+// Listener wraps l so that it is closed, and its accepted connections
+// drained, on Drain.
+func (d *Drainer) Listener(l net.Listener) net.Listener {
+	wrapped := &drainListener{Listener: l, conns: make(map[net.Conn]struct{}), idle: make(chan struct{})}
+	d.mu.Lock()
+	d.listeners = append(d.listeners, wrapped)
+	d.mu.Unlock()
+	return wrapped
+}
+
+// Server registers s to be shut down on Drain.
+func (d *Drainer) Server(s *http.Server) {
+	d.mu.Lock()
+	d.servers = append(d.servers, s)
+	d.mu.Unlock()
+}
+
+// OnTransition returns a Manager.OnTransition hook draining when this node
+// loses leadership of shard, and re-arming the drainer when it acquires it
+// again. Drained listeners and servers stay closed, so the application
+// registers new ones once it serves as leader again.
+func (d *Drainer) OnTransition(shard string) func(Transition) {
+	return func(t Transition) {
+		if t.Key != shard {
+			return
+		}
+		if t.Leader {
+			d.rearm()
+		} else {
+			go d.Drain()
+		}
+	}
+}
+
+// rearm lets the next Drain take effect again, on the listeners and servers
+// registered since the last one.
+func (d *Drainer) rearm() {
+	d.mu.Lock()
+	d.drained = false
+	d.mu.Unlock()
+}
+
+// Drain stops accepting connections and waits up to the drainer's timeout
+// for in-flight work. It returns context.DeadlineExceeded if connections had
+// to be closed forcibly. Only the first call after the drainer was created,
+// or re-armed by acquiring leadership, has any effect.
+func (d *Drainer) Drain() error {
+	d.mu.Lock()
+	if d.drained {
+		d.mu.Unlock()
+		return nil
+	}
+	d.drained = true
+	listeners, servers := d.listeners, d.servers
+	d.listeners, d.servers = nil, nil
+	d.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		first error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if first == nil {
+			first = err
+		}
+		mu.Unlock()
+	}
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s *http.Server) {
+			defer wg.Done()
+			if err := s.Shutdown(ctx); err != nil {
+				s.Close()
+				fail(err)
+			}
+		}(s)
+	}
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(l *drainListener) {
+			defer wg.Done()
+			if err := l.drain(ctx); err != nil {
+				fail(err)
+			}
+		}(l)
+	}
+	wg.Wait()
+	return first
+}
+
+type drainListener struct {
+	net.Listener
+
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	closing bool
+	idle    chan struct{}
+}
+
+func (l *drainListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closing {
+		conn.Close()
+		return nil, net.ErrClosed
+	}
+	tracked := &drainConn{Conn: conn, listener: l}
+	l.conns[tracked] = struct{}{}
+	return tracked, nil
+}
+
+func (l *drainListener) release(conn net.Conn) {
+	l.mu.Lock()
+	delete(l.conns, conn)
+	if l.closing && len(l.conns) == 0 {
+		select {
+		case <-l.idle:
+		default:
+			close(l.idle)
+		}
+	}
+	l.mu.Unlock()
+}
+
+func (l *drainListener) drain(ctx context.Context) error {
+	l.mu.Lock()
+	l.closing = true
+	if len(l.conns) == 0 {
+		close(l.idle)
+	}
+	l.mu.Unlock()
+	l.Listener.Close()
+	select {
+	case <-l.idle:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		conns := make([]net.Conn, 0, len(l.conns))
+		for conn := range l.conns {
+			conns = append(conns, conn)
+		}
+		l.mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		return ctx.Err()
+	}
+}
+
+type drainConn struct {
+	net.Conn
+	listener *drainListener
+	once     sync.Once
+}
+
+func (c *drainConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { c.listener.release(c) })
+	return err
+}
@@ -0,0 +1,121 @@
+package election_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// listen returns a drained listener on a free port, and a connection it
+// accepted.
+func listen(t *testing.T, drainer *election.Drainer) (net.Listener, net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	wrapped := drainer.Listener(l)
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	conn, err := wrapped.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wrapped, conn
+}
+
+// TestDrainerWaits drains a listener with a connection in flight: Drain
+// must close the listener at once, and return when the connection ends.
+func TestDrainerWaits(t *testing.T) {
+	drainer := election.NewDrainer(time.Second)
+	l, conn := listen(t, drainer)
+	drained := make(chan error, 1)
+	go func() { drained <- drainer.Drain() }()
+
+	time.Sleep(50 * time.Millisecond)
+	if _, err := l.Accept(); err == nil {
+		t.Fatal("accepted a connection while draining")
+	}
+	select {
+	case err := <-drained:
+		t.Fatalf("drained with a connection in flight: %v", err)
+	default:
+	}
+	conn.Close()
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("still draining after the connection ended")
+	}
+}
+
+// TestDrainerDeadline drains a connection that outlives the timeout: it must
+// be closed, and Drain report it.
+func TestDrainerDeadline(t *testing.T) {
+	drainer := election.NewDrainer(50 * time.Millisecond)
+	_, conn := listen(t, drainer)
+	if err := drainer.Drain(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("drain: %v, want DeadlineExceeded", err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("connection still open after the deadline")
+	}
+}
+
+// closed reports whether the listener l wraps stopped taking connections,
+// waiting up to a second for a Drain in the background.
+func closed(l net.Listener) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return true
+		}
+		conn.Close()
+	}
+	return false
+}
+
+// TestDrainerRearm loses, regains and loses leadership again through the
+// transition hook: a Drain in between must do nothing, and the second loss
+// drain what was registered since the first.
+func TestDrainerRearm(t *testing.T) {
+	drainer := election.NewDrainer(time.Second)
+	hook := drainer.OnTransition("shard")
+	first, conn := listen(t, drainer)
+	conn.Close()
+	hook(election.Transition{Key: "shard", Leader: false})
+	if !closed(first) {
+		t.Fatal("losing leadership did not drain")
+	}
+
+	second, conn := listen(t, drainer)
+	conn.Close()
+	if err := drainer.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	hook(election.Transition{Key: "other", Leader: true})
+	hook(election.Transition{Key: "other", Leader: false})
+	time.Sleep(50 * time.Millisecond)
+	if conn, err := net.Dial("tcp", second.Addr().String()); err != nil {
+		t.Fatal("drained again before leadership was acquired again")
+	} else {
+		conn.Close()
+	}
+
+	hook(election.Transition{Key: "shard", Leader: true})
+	hook(election.Transition{Key: "shard", Leader: false})
+	if !closed(second) {
+		t.Fatal("losing leadership again did not drain the listener registered since")
+	}
+}
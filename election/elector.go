@@ -0,0 +1,572 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoLeader is returned by Leader while an election has no leader, or
+// while its lock expires within the election's ObserverSkew.
+var ErrNoLeader = errors.New("election has no leader")
+
+// Interface is one candidate's view of a single election. Elector implements
+// it; consumers can depend on the interface and substitute a fake in their
+// own tests.
+//
+// Manager and Observer do not implement it. A Manager campaigns for many
+// shards at once, so it has no single lease to return from Campaign, and an
+// Observer never campaigns at all; code that only follows the leader can
+// depend on the Leader and Observe methods of an Elector instead.
+type Interface interface {
+	// Campaign joins the election and blocks until this candidate is
+	// leader or ctx is done.
+	Campaign(ctx context.Context) (*Lease, error)
+	// Leader returns the current leader of the election.
+	Leader(ctx context.Context) (Leader, error)
+	// Resign leaves the election, releasing the lock if it is held.
+	Resign(ctx context.Context) error
+	// Observe streams leader changes until ctx is done, starting with
+	// the current leader.
+	Observe(ctx context.Context) <-chan Event
+}
+
+// Leader is the announced leader of an election.
+type Leader struct {
+	ID       string
+	Metadata map[string]string
+	// the index at which the leader acquired the lock
+	Index int
+}
+
+// Event is a change of leader seen by Observe. Leader.ID is empty while the
+// election has no leader.
+type Event struct {
+	Time   time.Time
+	Key    string
+	Leader Leader
+	// Snapshot marks the first event of a subscription, which describes
+	// the state at the time of subscribing rather than a change.
+	Snapshot bool
+	// History marks a change replayed by ObserveHistory from before the
+	// subscription; Time is when it happened.
+	History bool
+}
+
+var _ Interface = (*Elector)(nil)
+
+// Elector campaigns for a single election on behalf of one candidate. It is
+// the entry point for applications embedding an election; Manager runs many
+// elections for one node.
+type Elector struct {
+	client *EtcdClient
+	state  *State
+
+	dropped int64 // accessed atomically
+
+	// mu guards everything below
+	mu      sync.Mutex
+	changed chan struct{}      // closed and replaced on every transition
+	cancel  context.CancelFunc // non-nil while campaigning
+	// closed once the election loop exits, and once the candidate stopped
+	// after it: a Start waits for idle, so that a loop Resign gave up
+	// waiting for never runs alongside the next one
+	done     chan struct{}
+	idle     chan struct{}
+	buffer   int
+	overflow Overflow
+	phased   []func(PhaseChange)
+	events   chan ElectionEvent // made by the first call to Events
+	elected  []func(term int)
+	demoted  []func(reason error)
+	errored  []func(err error)
+}
+
+// Overflow decides what Observe does with an event when the subscriber's
+// buffer is full. Whatever the policy, a slow subscriber only holds up its
+// own watch, never the election loop.
+type Overflow int
+
+const (
+	// OverflowBlock waits for the subscriber to make room.
+	OverflowBlock Overflow = iota
+	// OverflowDropOldest discards the oldest buffered event.
+	OverflowDropOldest
+	// OverflowCoalesce discards every buffered event, so the subscriber
+	// next sees the latest state.
+	OverflowCoalesce
+)
+
+// defaultEventBuffer is the buffer of Observe channels unless set otherwise.
+const defaultEventBuffer = 16
+
+// New returns an elector campaigning as id for the election of key, or as
+// the identity of the client's certificate if id is empty. Zero fields of
+// config take the same defaults as in a ManagerConfig.
+func New(client *EtcdClient, key string, id string, config ElectionConfig) (*Elector, error) {
+	if id == "" {
+		if id = client.Identity(); id == "" {
+			return nil, errors.New("no candidate id, and no client certificate to take it from")
+		}
+	}
+	manager := ManagerConfig{Concurrency: 1, Defaults: config}
+	if err := manager.Validate(); err != nil {
+		return nil, err
+	}
+	if err := manager.strict(client); err != nil {
+		return nil, err
+	}
+	state, err := newState(key, id, manager.Election(key), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &Elector{client: client, state: state, changed: make(chan struct{}), buffer: defaultEventBuffer}
+	state.onTransition = c.transition
+	state.onPhase = c.phase
+	state.onError = c.fail
+	state.onEvent = c.send
+	state.metrics = client.metrics
+	return c, nil
+}
+
+// SetLogger sends the log lines of the candidate to l instead of the
+// package's logger. It must be called before the candidate starts.
+func (c *Elector) SetLogger(l Logger) {
+	c.state.logger = l
+}
+
+// Clock is the time an election loop waits by. Tests substitute fake time,
+// such as leadertest's, to step an election through its polls, backoffs and
+// TTLs without waiting for them.
+type Clock interface {
+	Now() time.Time
+	// After returns a channel that receives once d has passed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// SetClock makes the candidate wait by clock instead of real time. It must be
+// called before the candidate starts.
+func (c *Elector) SetClock(clock Clock) {
+	c.state.waits = clock
+}
+
+// OnElected registers fn to be called from the election goroutine each time
+// the candidate becomes leader. term is the etcd index at which it acquired
+// the lock, which grows with every new leadership of the election. A panic in
+// fn is recovered and logged.
+func (c *Elector) OnElected(fn func(term int)) {
+	c.mu.Lock()
+	c.elected = append(c.elected, fn)
+	c.mu.Unlock()
+}
+
+// OnDemoted registers fn to be called each time the candidate stops being
+// leader, with ErrResigned or a *LostError. It is called from the election
+// goroutine, or from Resign for the lock Resign releases. A panic in fn is
+// recovered and logged.
+func (c *Elector) OnDemoted(fn func(reason error)) {
+	c.mu.Lock()
+	c.demoted = append(c.demoted, fn)
+	c.mu.Unlock()
+}
+
+// OnError registers fn to be called from the election goroutine with every
+// error the election runs into. The election retries on its own; fn is for
+// reporting. A panic in fn is recovered and logged.
+func (c *Elector) OnError(fn func(err error)) {
+	c.mu.Lock()
+	c.errored = append(c.errored, fn)
+	c.mu.Unlock()
+}
+
+// OnPhase registers fn to be called from the election goroutine after every
+// phase change of the candidate. A panic in fn is recovered and logged.
+func (c *Elector) OnPhase(fn func(PhaseChange)) {
+	c.mu.Lock()
+	c.phased = append(c.phased, fn)
+	c.mu.Unlock()
+}
+
+// Phase returns where the candidate currently stands in the election.
+func (c *Elector) Phase() Phase {
+	return c.state.phase()
+}
+
+func (c *Elector) phase(change PhaseChange) {
+	c.mu.Lock()
+	hooks := c.phased
+	c.mu.Unlock()
+	for _, hook := range hooks {
+		c.call(func() { hook(change) })
+	}
+}
+
+// call runs a hook of the application, logging a panic in it rather than
+// letting it take down the election goroutine.
+func (c *Elector) call(hook func()) {
+	if err := callHook(hook); err != nil {
+		c.state.eventStr(LevelError, evHookPanic, err.Error())
+	}
+}
+
+// SetEventBuffer sets the buffer of channels returned by Observe afterwards,
+// and what happens once one is full.
+func (c *Elector) SetEventBuffer(size int, overflow Overflow) {
+	c.mu.Lock()
+	c.buffer, c.overflow = size, overflow
+	c.mu.Unlock()
+}
+
+// Dropped returns how many events Observe discarded because of an overflow
+// policy.
+func (c *Elector) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+// Resources returns what the election loop and subscriptions of the
+// candidate hold.
+func (c *Elector) Resources() Resources {
+	return c.state.usage.snapshot()
+}
+
+func (c *Elector) transition(t Transition) {
+	c.mu.Lock()
+	close(c.changed)
+	c.changed = make(chan struct{})
+	elected, demoted := c.elected, c.demoted
+	c.mu.Unlock()
+	if t.Leader {
+		c.send(ElectionEvent{Type: EventElected, Time: t.Time, Key: t.Key, Leader: t.ID, Term: c.state.lastTerm()})
+		term := c.state.lastTerm()
+		for _, hook := range elected {
+			c.call(func() { hook(term) })
+		}
+		return
+	}
+	var reason error = ErrResigned
+	if t.Reason != reasonResigned {
+		reason = &LostError{Reason: t.Reason}
+	}
+	c.send(ElectionEvent{Type: EventLost, Time: t.Time, Key: t.Key, Term: c.state.lastTerm(), Err: reason})
+	for _, hook := range demoted {
+		c.call(func() { hook(reason) })
+	}
+}
+
+func (c *Elector) fail(err error) {
+	c.mu.Lock()
+	hooks := c.errored
+	c.mu.Unlock()
+	for _, hook := range hooks {
+		c.call(func() { hook(err) })
+	}
+}
+
+// Start campaigns in the background until Stop or Resign, and returns
+// immediately. Use IsLeader, Observe or Campaign to learn the outcome.
+func (c *Elector) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.cancel == nil && c.idle != nil {
+		idle := c.idle
+		select {
+		case <-idle:
+			c.idle = nil
+			continue
+		default:
+		}
+		// still stopping
+		c.mu.Unlock()
+		<-idle
+		c.mu.Lock()
+	}
+	if c.cancel != nil {
+		return
+	}
+	var ctx context.Context
+	ctx, c.cancel = context.WithCancel(context.Background())
+	c.done, c.idle = make(chan struct{}), make(chan struct{})
+	c.state.stop = ctx.Done()
+	c.state.failures = 0
+	atomic.StoreInt32(&c.state.exhausted, 0)
+	go c.run(ctx, c.done)
+}
+
+// Stop is Resign without a deadline.
+func (c *Elector) Stop() error {
+	return c.Resign(context.Background())
+}
+
+// IsLeader reports whether this candidate currently holds the lock; see
+// ElectionConfig.LeaseGuard.
+func (c *Elector) IsLeader() bool {
+	return c.state.guarded()
+}
+
+// State returns the candidate's standing in the election, for reading from
+// any goroutine.
+func (c *Elector) State() *State {
+	return c.state
+}
+
+// Term returns the term of the candidate's leadership, or 0 while it is not
+// leader. The term is the etcd index at which the lock was acquired, so it
+// grows with every new leadership of the election: a storage layer that
+// rejects writes carrying a lower term than it has seen fences off a stale
+// leader that has yet to notice it lost the lock.
+func (c *Elector) Term() int {
+	return c.state.currentTerm()
+}
+
+// Campaign starts campaigning if the candidate is not yet, and waits until it
+// is leader or ctx is done, or fails with ErrRetriesExhausted if it gives up
+// on etcd. The lease it returns tells how the lock was won: the term, the
+// campaign's latency and attempts, and the candidates it contended with.
+func (c *Elector) Campaign(ctx context.Context) (*Lease, error) {
+	c.Start()
+	for {
+		c.mu.Lock()
+		changed, done := c.changed, c.done
+		c.mu.Unlock()
+		if c.state.isLeader() {
+			return &Lease{state: c.state, client: c.client, acquisition: c.state.lastAcquisition()}, nil
+		}
+		if atomic.LoadInt32(&c.state.exhausted) == 1 {
+			return nil, ErrRetriesExhausted
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		case <-done:
+			// stopped, or gave up retrying
+		}
+	}
+}
+
+// run executes the election loop until ctx is done, or until the candidate
+// gives up retrying.
+func (c *Elector) run(ctx context.Context, done chan<- struct{}) {
+	defer close(done)
+	defer c.state.usage.hold(false)()
+	for c.state.next(ctx, c.client, loop(ctx, c.state, c.client), ctx.Done()) {
+	}
+}
+
+func (c *Elector) Leader(ctx context.Context) (Leader, error) {
+	info, err := currentLeader(ctx, c.state, c.client)
+	return info.Leader, err
+}
+
+func (c *Elector) leader(node Node) (Leader, error) {
+	info, err := c.state.leaderInfo(node)
+	return info.Leader, err
+}
+
+// Resign stops campaigning, waiting for an iteration in progress to finish,
+// and deletes the lock if this candidate holds it. Followers watching the
+// lock campaign as soon as it is gone, rather than once it would have
+// expired. If ctx is done before the iteration finishes, Resign returns ctx's
+// error and the lock is released once it does.
+func (c *Elector) Resign(ctx context.Context) error {
+	return c.resign(ctx, false)
+}
+
+// Close is Resign for a candidate going away, such as on SIGTERM: as leader,
+// it clears the broadcast key too whatever ClearBroadcast says, so that
+// those following the broadcast learn at once that the leader left. It also
+// deletes the keys the candidate keeps for itself, as Manager.Close does.
+func (c *Elector) Close(ctx context.Context) error {
+	return c.resign(ctx, true)
+}
+
+// halt stops the election loop and waits for it to exit. The caller then
+// owns the state until it calls release, which lets the next Start run. It
+// returns a nil release if the candidate was not campaigning, after waiting
+// for a stop in progress, and ctx's error if ctx is done first: the loop is
+// then left to exit on its own, after which finish runs, if set.
+func (c *Elector) halt(ctx context.Context, finish func()) (release func(), err error) {
+	c.mu.Lock()
+	cancel, done, idle := c.cancel, c.done, c.idle
+	c.cancel = nil
+	c.mu.Unlock()
+	if cancel == nil {
+		if idle != nil {
+			select {
+			case <-idle:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		return nil, nil
+	}
+	cancel()
+	select {
+	case <-done:
+		return func() { close(idle) }, nil
+	case <-ctx.Done():
+		go func() {
+			<-done
+			if finish != nil {
+				finish()
+			}
+			close(idle)
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Elector) resign(ctx context.Context, closing bool) error {
+	// a Resign that times out still releases the lock once the loop exits
+	release, err := c.halt(ctx, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.state.ttl)
+		defer cancel()
+		c.leave(ctx, closing)
+	})
+	if release == nil {
+		return err
+	}
+	defer release()
+	return c.leave(ctx, closing)
+}
+
+// leave releases the lock, or the contender key of a follower, after the
+// election loop stopped, and with closing the keys the candidate keeps for
+// itself.
+func (c *Elector) leave(ctx context.Context, closing bool) error {
+	defer c.state.setPhase(PhaseIdle, "stopped")
+	var err error
+	if c.state.isLeader() {
+		err = resign(ctx, c.state, c.client, closing || c.state.clearBroadcast)
+	} else {
+		withdraw(ctx, c.state, c.client)
+	}
+	if !closing {
+		return err
+	}
+	var report ShutdownReport
+	cleanUp(ctx, c.state, c.client, &report)
+	if err == nil && len(report.Failures) > 0 {
+		err = fmt.Errorf("%s: %s", report.Failures[0].Key, report.Failures[0].Error)
+	}
+	return err
+}
+
+func (c *Elector) Observe(ctx context.Context) <-chan Event {
+	return c.observe(ctx, nil)
+}
+
+// ObserveHistory is Observe for a subscriber joining late: the snapshot is
+// preceded by the changes of leader of the election this process recorded
+// since the given time, oldest first, so that a component started after a
+// failover can tell that leadership moved while it was down and reconcile.
+// The zero time replays all the history retained. Only changes made by this
+// process's own candidates are recorded, as the leader they elected, or no
+// leader when one was demoted; with SetHistoryStore, those of its earlier
+// runs too.
+func (c *Elector) ObserveHistory(ctx context.Context, since time.Time) <-chan Event {
+	var replay []Event
+	for _, entry := range history.snapshot() {
+		t, ok := entry.(Transition)
+		if !ok || t.Key != c.state.key || t.Time.Before(since) {
+			continue
+		}
+		event := Event{Time: t.Time, Key: t.Key, History: true}
+		if t.Leader {
+			event.Leader.ID = t.ID
+		}
+		if n := len(replay); n > 0 && replay[n-1].Leader.ID == event.Leader.ID {
+			continue
+		}
+		replay = append(replay, event)
+	}
+	return c.observe(ctx, replay)
+}
+
+// observe streams the replayed events, then the snapshot and the changes
+// that follow it.
+func (c *Elector) observe(ctx context.Context, replay []Event) <-chan Event {
+	c.mu.Lock()
+	events := make(chan Event, c.buffer)
+	overflow := c.overflow
+	c.mu.Unlock()
+	go func() {
+		defer close(events)
+		defer c.state.usage.hold(true)()
+		for _, event := range replay {
+			if !c.deliver(ctx, events, event, overflow) {
+				return
+			}
+		}
+		watch := newWatcher(ctx, c.client, c.state.watchKey())
+		last, first := "", true
+		for {
+			resp, err := watch.Next()
+			if err != nil && !answered(err) {
+				if ctx.Err() != nil {
+					return
+				}
+				c.state.eventStr(LevelError, evError, err.Error())
+				if !c.state.sleepUntil(c.state.pollInterval(), ctx.Done()) {
+					return
+				}
+				continue
+			}
+			event := Event{Time: time.Now(), Key: c.state.key, Snapshot: first}
+			if err == nil && resp.Node.Value != "" {
+				if event.Leader, err = c.leader(resp.Node); err != nil {
+					c.state.eventStr(LevelWarn, evUnverified, err.Error())
+					continue
+				}
+			}
+			if event.Leader.ID == last && !first {
+				continue
+			}
+			last, first = event.Leader.ID, false
+			if !c.deliver(ctx, events, event, overflow) {
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// deliver sends event to a subscriber according to overflow, and returns
+// false once ctx is done.
+func (c *Elector) deliver(ctx context.Context, events chan Event, event Event, overflow Overflow) bool {
+	if overflow == OverflowBlock || cap(events) == 0 {
+		select {
+		case events <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	if overflow == OverflowCoalesce {
+		c.drain(events, len(events))
+	}
+	for {
+		select {
+		case events <- event:
+			return true
+		default:
+		}
+		// full: make room, unless the subscriber just did
+		c.drain(events, 1)
+	}
+}
+
+// drain discards up to n buffered events.
+func (c *Elector) drain(events chan Event, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-events:
+			atomic.AddInt64(&c.dropped, 1)
+		default:
+			return
+		}
+	}
+}
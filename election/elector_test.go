@@ -0,0 +1,304 @@
+package election_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+	"github.com/jeeyoungk/etcd-leader/leadertest"
+)
+
+func init() {
+	election.SetLogLevel(election.LevelError)
+}
+
+func newElector(t *testing.T, server *leadertest.Server, key string, id string) *election.Elector {
+	t.Helper()
+	elector, err := election.New(election.NewEtcdClient(server.URL), key, id, election.ElectionConfig{TTL: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { elector.Stop() })
+	return elector
+}
+
+// TestElectorConcurrentUse campaigns, resigns and observes one elector from
+// many goroutines at once; run it with -race.
+func TestElectorConcurrentUse(t *testing.T) {
+	server := leadertest.NewServer(t)
+	elector := newElector(t, server, "concurrent", "a")
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				attempt, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+				elector.Campaign(attempt)
+				cancel()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				// a short deadline leaves loops behind for the next Start
+				resign, cancel := context.WithTimeout(ctx, time.Millisecond)
+				elector.Resign(resign)
+				cancel()
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range elector.Observe(ctx) {
+				elector.IsLeader()
+				elector.Phase()
+				elector.State().CurrentLeaderID()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := elector.Resign(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if leader := server.Leader("concurrent"); leader != "" {
+		t.Fatalf("lock held by %q after resigning", leader)
+	}
+}
+
+// TestElectorRestart resigns with an expired deadline and starts again at
+// once: the new loop must only run after the old one exited.
+func TestElectorRestart(t *testing.T) {
+	server := leadertest.NewServer(t)
+	elector := newElector(t, server, "restart", "a")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 20; i++ {
+		if _, err := elector.Campaign(ctx); err != nil {
+			t.Fatal(err)
+		}
+		expired, cancel := context.WithCancel(ctx)
+		cancel()
+		elector.Resign(expired)
+		elector.Start()
+	}
+	if _, err := elector.Campaign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !elector.IsLeader() {
+		t.Fatal("not leader after campaigning")
+	}
+}
+
+func TestElectorFailover(t *testing.T) {
+	server := leadertest.NewServer(t)
+	first := newElector(t, server, "failover", "first")
+	second := newElector(t, server, "failover", "second")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lease, err := first.Campaign(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Start()
+	if leader := server.LeaderFor(t, "failover"); leader != "first" {
+		t.Fatalf("leader is %q, want first", leader)
+	}
+	if err := first.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	next, err := second.Campaign(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Term() <= lease.Term() {
+		t.Fatalf("term %d does not follow %d", next.Term(), lease.Term())
+	}
+}
+
+// TestElectorFakeClock holds the lock across many TTLs of fake time: the
+// leader renews only as the clock is advanced, and keeps the lock.
+func TestElectorFakeClock(t *testing.T) {
+	server := leadertest.NewServer(t)
+	clock := server.FakeTime()
+	elector := newElector(t, server, "fake", "a")
+	elector.SetClock(clock)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := elector.Campaign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		// wait for the leader to sleep before waking it
+		for clock.Waiting() == 0 {
+			if ctx.Err() != nil {
+				t.Fatal("the leader never waited on the clock")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(400 * time.Millisecond)
+	}
+	if leader := server.Leader("fake"); leader != "a" {
+		t.Fatalf("leader is %q after 4s of fake time, want a", leader)
+	}
+	if !elector.IsLeader() {
+		t.Fatal("a lost the lock while renewing it")
+	}
+}
+
+// TestElectorHookPanic checks that panicking hooks neither crash the
+// election goroutine nor keep the candidate from leading.
+func TestElectorHookPanic(t *testing.T) {
+	server := leadertest.NewServer(t)
+	elector := newElector(t, server, "panic", "a")
+	elector.OnPhase(func(election.PhaseChange) { panic("phase") })
+	elector.OnElected(func(int) { panic("elected") })
+	elector.OnDemoted(func(error) { panic("demoted") })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := elector.Campaign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := elector.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := elector.Campaign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !elector.IsLeader() {
+		t.Fatal("not leader after campaigning again")
+	}
+}
+
+// TestElectorLockExpires checks that the lock is taken with the TTL, so that
+// it expires if its holder stops renewing it.
+func TestElectorLockExpires(t *testing.T) {
+	server := leadertest.NewServer(t)
+	clock := server.FakeTime()
+	elector := newElector(t, server, "expires", "a")
+	// with the clock never advanced, the lock is not renewed
+	elector.SetClock(clock)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := elector.Campaign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := election.NewEtcdClient(server.URL).Get(ctx, "expires-leader", election.Option{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Node.Expiration == nil {
+		t.Fatalf("lock %q was taken without a TTL", resp.Node.Value)
+	}
+}
+
+// TestElectorReclaimsOwnLock restarts a candidate while its lock, carrying
+// its metadata, is still in etcd: the new process must take the lock up as
+// leader rather than wait for it to expire.
+func TestElectorReclaimsOwnLock(t *testing.T) {
+	server := leadertest.NewServer(t)
+	client := election.NewEtcdClient(server.URL)
+	config := election.ElectionConfig{TTL: time.Second, Metadata: map[string]string{"addr": "10.0.0.1:80"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	before, err := election.New(client, "reclaim", "a", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := before.Campaign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(ctx, "reclaim-leader", election.Option{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before.Stop()
+	// the lock as the previous process left it, without a TTL so that it
+	// cannot expire under the test
+	seeded, err := client.Put(ctx, "reclaim-leader", resp.Node.Value, election.Option{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := election.New(election.NewEtcdClient(server.URL), "reclaim", "a", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { after.Stop() })
+	lease, err := after.Campaign(ctx)
+	if err != nil {
+		t.Fatalf("did not reclaim its own lock: %s (phase %s)", err, after.Phase())
+	}
+	if lease.Term() != seeded.Node.CreatedIndex {
+		t.Fatalf("term %d, want the lock's create index %d", lease.Term(), seeded.Node.CreatedIndex)
+	}
+	if !after.IsLeader() {
+		t.Fatal("not leader after reclaiming the lock")
+	}
+}
+
+// TestElectorStopQuietly stops a leader while its read of the lock is in
+// flight: the request cut short is no error to report.
+func TestElectorStopQuietly(t *testing.T) {
+	server := leadertest.NewServer(t)
+	var stall atomic.Bool
+	stalled, release := make(chan struct{}, 1), make(chan struct{})
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if stall.Load() && r.Method == http.MethodGet && r.URL.Query().Get("wait") == "" {
+			select {
+			case stalled <- struct{}{}:
+			default:
+			}
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		server.ServeHTTP(w, r)
+	}))
+	t.Cleanup(proxy.Close)
+	t.Cleanup(func() { close(release) })
+	elector, err := election.New(election.NewEtcdClient(proxy.URL), "quiet", "a", election.ElectionConfig{TTL: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errs []error
+	var mu sync.Mutex
+	elector.OnError(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := elector.Campaign(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	stall.Store(true)
+	select {
+	case <-stalled:
+	case <-ctx.Done():
+		t.Fatal("the leader did not read the lock")
+	}
+	elector.Stop()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) > 0 {
+		t.Fatalf("stopping reported %v", errs)
+	}
+}
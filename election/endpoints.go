@@ -0,0 +1,210 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// endpointCooldown is how long an endpoint that failed a request is passed
+// over, unless every endpoint failed.
+const endpointCooldown = 10 * time.Second
+
+// failover sends each request to one of several endpoints of the same etcd
+// cluster, moving on to the next one when an endpoint cannot be reached or
+// answers with a 5xx, so that the restart of one member does not fail every
+// election. Requests are built against the first endpoint; failover rewrites
+// them for the endpoint they are sent to.
+type failover struct {
+	next      *http.Transport
+	endpoints []*url.URL
+	// the endpoint that last answered, accessed atomically
+	current int32
+	// send each request to the endpoint after the previous request's,
+	// rather than sticking to the last one that answered
+	roundRobin bool
+	turn       uint32
+	// unix nanoseconds until which each endpoint is passed over, accessed
+	// atomically
+	down []int64
+}
+
+func newFailover(next *http.Transport, endpoints []string) (*failover, error) {
+	f := &failover{next: next, down: make([]int64, len(endpoints))}
+	for _, endpoint := range endpoints {
+		u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+		if err != nil {
+			return nil, err
+		}
+		f.endpoints = append(f.endpoints, u)
+	}
+	return f, nil
+}
+
+// first returns the endpoint to try first: the next one in turn, or the one
+// that last answered, unless it is cooling down.
+func (f *failover) first() int {
+	n := len(f.endpoints)
+	start := int(atomic.LoadInt32(&f.current))
+	if f.roundRobin {
+		start = int(atomic.AddUint32(&f.turn, 1) % uint32(n))
+	}
+	now := time.Now().UnixNano()
+	for i := 0; i < n; i++ {
+		if index := (start + i) % n; atomic.LoadInt64(&f.down[index]) < now {
+			return index
+		}
+	}
+	return start
+}
+
+func (f *failover) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := f.first()
+	var resp *http.Response
+	var err error
+	for i := 0; i < len(f.endpoints); i++ {
+		index := (start + i) % len(f.endpoints)
+		attempt := req
+		if i > 0 || index != 0 {
+			attempt = f.rewrite(req, index)
+		}
+		if i > 0 {
+			// the previous attempt consumed the body
+			if req.Body != nil {
+				if req.GetBody == nil {
+					break
+				}
+				body, bodyErr := req.GetBody()
+				if bodyErr != nil {
+					break
+				}
+				attempt.Body = body
+			}
+			if resp != nil {
+				io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		}
+		resp, err = f.next.RoundTrip(attempt)
+		if err == nil && resp.StatusCode < 500 {
+			atomic.StoreInt32(&f.current, int32(index))
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			// abandoned by the caller, not failed by the endpoint
+			break
+		}
+		atomic.StoreInt64(&f.down[index], time.Now().Add(endpointCooldown).UnixNano())
+		if len(f.endpoints) > 1 {
+			var reason string
+			if err != nil {
+				reason = err.Error()
+			} else {
+				reason = resp.Status
+			}
+			std.eventStr(LevelWarn, "", evFailover, f.endpoints[index].Host+": "+reason)
+		}
+	}
+	return resp, err
+}
+
+// rewrite returns req addressed to endpoint index instead of the first.
+func (f *failover) rewrite(req *http.Request, index int) *http.Request {
+	base, target := f.endpoints[0], f.endpoints[index]
+	clone := req.Clone(req.Context())
+	u := *req.URL
+	u.Scheme, u.Host = target.Scheme, target.Host
+	u.Path = target.Path + strings.TrimPrefix(req.URL.Path, base.Path)
+	if req.URL.RawPath != "" {
+		u.RawPath = target.Path + strings.TrimPrefix(req.URL.RawPath, base.Path)
+	}
+	clone.URL, clone.Host = &u, ""
+	return clone
+}
+
+// SetEndpoints makes the client send its requests to any of endpoints, all
+// members or gateways of the same cluster, in place of the base URL it was
+// created with. Requests go to the first endpoint that answers, and move on
+// to the next one when it cannot be reached or answers with a 5xx; an
+// endpoint that failed is passed over for a while. A client already warmed
+// with Warm opens as many connections to the new endpoints in the
+// background. It must be called before the client is shared between
+// goroutines.
+func (c *EtcdClient) SetEndpoints(endpoints []string) error {
+	if len(endpoints) == 0 {
+		return nil
+	}
+	if c.transport() == nil {
+		return errors.New("endpoints cannot be set on a client without an HTTP transport")
+	}
+	f, err := newFailover(c.transport(), endpoints)
+	if err != nil {
+		return err
+	}
+	c.baseUrl = strings.TrimSuffix(endpoints[0], "/")
+	c.client.Transport = f
+	if conns := atomic.LoadInt32(&c.warm); conns > 0 {
+		// the client was warmed for the endpoints it had before
+		go c.Warm(context.Background(), int(conns))
+	}
+	return nil
+}
+
+// endpoints returns the base URLs the client sends its requests to.
+func (c *EtcdClient) endpoints() []string {
+	f, ok := c.client.Transport.(*failover)
+	if !ok {
+		return []string{c.baseUrl}
+	}
+	endpoints := make([]string, len(f.endpoints))
+	for i, endpoint := range f.endpoints {
+		endpoints[i] = endpoint.String()
+	}
+	return endpoints
+}
+
+// SetRoundRobin spreads the requests of a client with several endpoints (see
+// SetEndpoints) across all of them, instead of sticking to the one that last
+// answered. It must be called after SetEndpoints, and before the client is
+// shared between goroutines.
+func (c *EtcdClient) SetRoundRobin(enabled bool) {
+	if f, ok := c.client.Transport.(*failover); ok {
+		f.roundRobin = enabled
+	}
+}
+
+// Endpoint returns the endpoint that answered the client's last request.
+func (c *EtcdClient) Endpoint() string {
+	f, ok := c.client.Transport.(*failover)
+	if !ok {
+		return c.baseUrl
+	}
+	return f.endpoints[atomic.LoadInt32(&f.current)].String()
+}
+
+// transport returns the transport requests are sent with, below failover,
+// or nil if the client sends them some other way, as the client of a
+// Replayer does.
+func (c *EtcdClient) transport() *http.Transport {
+	if f, ok := c.client.Transport.(*failover); ok {
+		return f.next
+	}
+	transport, _ := c.client.Transport.(*http.Transport)
+	return transport
+}
+
+// setTransport replaces the transport requests are sent with, keeping
+// failover between endpoints.
+func (c *EtcdClient) setTransport(transport *http.Transport) {
+	if f, ok := c.client.Transport.(*failover); ok {
+		f.next = transport
+		return
+	}
+	c.client.Transport = transport
+}
@@ -0,0 +1,165 @@
+package election
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// EtcdError is an error reported by etcd in the body of a keys API response.
+type EtcdError struct {
+	Code    int
+	Message string
+	// Cause is the key, or for compare failures the comparison
+	// ("[expected != actual]"), that the error refers to.
+	Cause string
+	// Index is the etcd index at the time of the error.
+	Index int
+}
+
+func (e *EtcdError) Error() string {
+	return fmt.Sprintf("etcd error %d: %s (cause: %s, index: %d)", e.Code, e.Message, e.Cause, e.Index)
+}
+
+// Is makes errors.Is match an EtcdError against the sentinel of its code,
+// such as ErrKeyNotFound for error code 100.
+func (e *EtcdError) Is(target error) bool {
+	sentinel, ok := codeErrors[e.Code]
+	return ok && sentinel == target
+}
+
+// Error codes of the etcd v2 keys API, as in EtcdResponse.ErrorCode. The v3
+// backend translates its failures to the same codes.
+const (
+	codeKeyNotFound      = 100
+	codeTestFailed       = 101
+	codeNotFile          = 102
+	codeNotDir           = 104
+	codeNodeExist        = 105
+	codeRootReadOnly     = 107
+	codeDirNotEmpty      = 108
+	codeUnauthorized     = 110
+	codePrevValueMissing = 201
+	codeTTLNaN           = 202
+	codeIndexNaN         = 203
+	codeInvalidField     = 209
+	codeInvalidForm      = 210
+	codeRaftInternal     = 300
+	codeLeaderElect      = 301
+	codeWatcherCleared   = 400
+	codeIndexCleared     = 401
+)
+
+// Sentinels of the etcd error codes, for matching the error of
+// EtcdResponse.Err with errors.Is.
+var (
+	ErrKeyNotFound       = errors.New("key not found")
+	ErrTestFailed        = errors.New("compare failed")
+	ErrNotFile           = errors.New("not a file")
+	ErrNotDir            = errors.New("not a directory")
+	ErrNodeExist         = errors.New("key already exists")
+	ErrRootReadOnly      = errors.New("root is read only")
+	ErrDirNotEmpty       = errors.New("directory not empty")
+	ErrUnauthorized      = errors.New("the request requires user authentication")
+	ErrPrevValueMissing  = errors.New("prevValue is required")
+	ErrTTLNaN            = errors.New("the given TTL is not a number")
+	ErrIndexNaN          = errors.New("the given index is not a number")
+	ErrInvalidField      = errors.New("invalid field")
+	ErrInvalidForm       = errors.New("invalid POST form")
+	ErrRaftInternal      = errors.New("raft internal error")
+	ErrLeaderElect       = errors.New("etcd is electing its leader")
+	ErrWatcherCleared    = errors.New("watcher is cleared due to etcd recovery")
+	ErrEventIndexCleared = errors.New("the event in the requested index is outdated and cleared")
+)
+
+var codeErrors = map[int]error{
+	codeKeyNotFound:      ErrKeyNotFound,
+	codeTestFailed:       ErrTestFailed,
+	codeNotFile:          ErrNotFile,
+	codeNotDir:           ErrNotDir,
+	codeNodeExist:        ErrNodeExist,
+	codeRootReadOnly:     ErrRootReadOnly,
+	codeDirNotEmpty:      ErrDirNotEmpty,
+	codeUnauthorized:     ErrUnauthorized,
+	codePrevValueMissing: ErrPrevValueMissing,
+	codeTTLNaN:           ErrTTLNaN,
+	codeIndexNaN:         ErrIndexNaN,
+	codeInvalidField:     ErrInvalidField,
+	codeInvalidForm:      ErrInvalidForm,
+	codeRaftInternal:     ErrRaftInternal,
+	codeLeaderElect:      ErrLeaderElect,
+	codeWatcherCleared:   ErrWatcherCleared,
+	codeIndexCleared:     ErrEventIndexCleared,
+}
+
+// StatusError is returned for a response that fails with an HTTP status but
+// carries no etcd error code, as from a proxy in front of etcd, so that it is
+// not taken for success.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("etcd answered %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("etcd answered %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// ErrResigned is the reason OnDemoted gives after the candidate resigned.
+var ErrResigned = errors.New("resigned")
+
+// ErrDuplicateID is reported through OnError when a candidate with
+// ElectionConfig.ExclusiveID finds the lock held under its id by another
+// process, such as a clone started with the same configuration or
+// certificate.
+var ErrDuplicateID = errors.New("lock held by another process running under the same id")
+
+// ErrRetriesExhausted is reported through OnError, and returned by Campaign,
+// when a candidate leaves the election after failing for longer than
+// RetryPolicy.MaxElapsed.
+var ErrRetriesExhausted = errors.New("gave up after etcd failed past the retry policy's max elapsed time")
+
+// ErrRateLimited is returned for requests etcd refuses with 429 Too Many
+// Requests, as the v3 gateway does when the server is overloaded.
+var ErrRateLimited = errors.New("etcd is rate limiting requests")
+
+// LostError is the reason OnDemoted gives after the candidate failed to renew
+// the lock, usually because it expired during a stall and was taken over.
+type LostError struct {
+	Reason string
+}
+
+func (e *LostError) Error() string {
+	return "lost the lock: " + e.Reason
+}
+
+// Err returns the etcd error carried by the response, or nil. The error is
+// an *EtcdError, which errors.Is matches against the sentinel of its code:
+//
+//	if _, err := client.Get(ctx, key, Option{}); errors.Is(err, ErrKeyNotFound) { ... }
+//
+// Get, Put and Delete return it as their error, along with the response.
+func (r *EtcdResponse) Err() error {
+	if r.ErrorCode == 0 {
+		return nil
+	}
+	return &EtcdError{Code: r.ErrorCode, Message: r.Message, Cause: r.Cause, Index: r.Index}
+}
+
+// checked returns the outcome of a request: err if it got no answer, and
+// otherwise the error the response carries, if any.
+func checked(resp *EtcdResponse, err error) (*EtcdResponse, error) {
+	if err == nil && resp != nil {
+		err = resp.Err()
+	}
+	return resp, err
+}
+
+// answered reports whether err is an error etcd answered a request with, as
+// opposed to a request that got no answer.
+func answered(err error) bool {
+	var etcdErr *EtcdError
+	return errors.As(err, &etcdErr)
+}
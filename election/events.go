@@ -0,0 +1,133 @@
+package election
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// EventType tells what an ElectionEvent reports.
+type EventType int
+
+const (
+	// EventElected is sent when the candidate becomes leader.
+	EventElected EventType = iota
+	// EventLost is sent when the candidate stops being leader, whether it
+	// resigned or lost the lock.
+	EventLost
+	// EventLeaderChanged is sent when the candidate sees a different
+	// leader than before, including none.
+	EventLeaderChanged
+	// EventRenewFailed is sent when the candidate could not renew the lock
+	// it holds.
+	EventRenewFailed
+)
+
+var eventTypeNames = map[EventType]string{
+	EventElected:       "elected",
+	EventLost:          "lost",
+	EventLeaderChanged: "leader_changed",
+	EventRenewFailed:   "renew_failed",
+}
+
+func (t EventType) String() string {
+	return eventTypeNames[t]
+}
+
+// ElectionEvent is a leadership event of one candidate, sent by
+// Elector.Events.
+type ElectionEvent struct {
+	Type EventType
+	Time time.Time
+	Key  string
+	// Leader is the id of the leader after the event, or "" if there is
+	// none or it is unknown.
+	Leader string
+	// Term is the term of the leadership gained or lost, for EventElected
+	// and EventLost.
+	Term int
+	// Err is why the lock was lost, for EventLost, or why it could not be
+	// renewed, for EventRenewFailed.
+	Err error
+}
+
+func (s *State) lastTerm() int {
+	return int(atomic.LoadInt64(&s.term))
+}
+
+// currentTerm returns the term of the candidate's leadership, or 0 if it is
+// not leader.
+func (s *State) currentTerm() int {
+	if !s.isLeader() {
+		return 0
+	}
+	return s.lastTerm()
+}
+
+// emit sends an event of the election loop to the candidate's events, if any
+// are wanted.
+func (s *State) emit(event ElectionEvent) {
+	if s.onEvent == nil {
+		return
+	}
+	event.Time = time.Now()
+	event.Key = s.key
+	s.onEvent(event)
+}
+
+// see records the holder of the lock as last read by the election loop.
+func (s *State) see(holder string) {
+	if holder == s.CurrentLeaderID() {
+		return
+	}
+	s.seen.Store(holder)
+	s.emit(ElectionEvent{Type: EventLeaderChanged, Leader: holder})
+}
+
+// renewFailed reports a renewal that failed with err, or with the error of
+// resp.
+func (s *State) renewFailed(err error) {
+	if s.metrics != nil {
+		s.metrics.renewed(s.key, false)
+	}
+	s.emit(ElectionEvent{Type: EventRenewFailed, Leader: s.id, Term: s.lastTerm(), Err: err})
+}
+
+// Events returns a channel of the candidate's leadership events, created on
+// the first call and shared by later ones. Events are sent from the election
+// goroutine without ever blocking it: once the channel's buffer (see
+// SetEventBuffer) is full, the oldest event is dropped and counted in
+// Dropped.
+func (c *Elector) Events() <-chan ElectionEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.events == nil {
+		size := c.buffer
+		if size < 1 {
+			size = 1
+		}
+		c.events = make(chan ElectionEvent, size)
+	}
+	return c.events
+}
+
+func (c *Elector) send(event ElectionEvent) {
+	c.mu.Lock()
+	events := c.events
+	c.mu.Unlock()
+	if events == nil {
+		return
+	}
+	for {
+		select {
+		case events <- event:
+			return
+		default:
+		}
+		// full: make room, unless the consumer just did
+		select {
+		case <-events:
+			atomic.AddInt64(&c.dropped, 1)
+		default:
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// With ElectionConfig.Fair, every campaigning candidate keeps a contender key
+// under the election's contenders directory, created at its first attempt of
+// the campaign and renewed with the TTL of the lock on every poll. The key's
+// creation index orders the contenders. When the lock is free, a candidate
+// that finds a contender that has waited longer defers to it for up to a TTL,
+// so the lock goes to the candidate that waited longest rather than to the
+// one that happened to poll first. A contender that crashed drops out once
+// its key expires; one that is alive but fails to take the lock within the
+// TTL is passed over.
+
+// contenderKey is the contender key of the candidate.
+func (s *State) contenderKey() string {
+	return s.layout.key(s.key, "contenders") + "/" + s.id
+}
+
+// contenderValue is the value of the contender key, the start of the
+// campaign. It tells this campaign's key from one left by an earlier
+// incarnation of the candidate under the same id.
+func (s *State) contenderValue() string {
+	return s.campaign.start.UTC().Format(time.RFC3339Nano)
+}
+
+// enlist creates the candidate's contender key at the first attempt of a
+// campaign and renews it afterwards. A key that expired while the candidate
+// stalled is created again, at the back of the queue.
+func enlist(ctx context.Context, state *State, client *EtcdClient) error {
+	key, value := state.contenderKey(), state.contenderValue()
+	if state.campaign.enlisted != 0 {
+		_, err := client.Put(ctx, key, value, Option{ttl: state.ttl, prevExist: 1, origin: "enlist"})
+		if !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+	}
+	resp, err := client.Put(ctx, key, value, Option{ttl: state.ttl, origin: "enlist"})
+	if err != nil {
+		return err
+	}
+	state.campaign.enlisted = resp.Node.CreatedIndex
+	return nil
+}
+
+// ahead returns a contender that has waited longer than the candidate, or ""
+// if there is none.
+func ahead(ctx context.Context, state *State, client *EtcdClient) (string, error) {
+	if state.campaign.enlisted == 0 {
+		return "", nil
+	}
+	resp, err := client.list(ctx, state.layout.key(state.key, "contenders"))
+	if errors.Is(err, ErrKeyNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	first, index := "", state.campaign.enlisted
+	for _, node := range resp.Node.Nodes {
+		if node.CreatedIndex < index {
+			first, index = node.Key[strings.LastIndex(node.Key, "/")+1:], node.CreatedIndex
+		}
+	}
+	return first, nil
+}
+
+// yields reports whether a candidate that found the lock free defers to a
+// longer-waiting contender this time round.
+func yields(ctx context.Context, state *State, client *EtcdClient) bool {
+	contender, err := ahead(ctx, state, client)
+	if err != nil {
+		state.fail(err)
+		return false
+	}
+	if contender == "" {
+		return false
+	}
+	if state.campaign.deferred.IsZero() {
+		state.campaign.deferred = time.Now()
+	} else if time.Since(state.campaign.deferred) >= state.ttl {
+		return false
+	}
+	state.eventStr(LevelDebug, evDeferred, contender)
+	return true
+}
+
+// withdraw deletes the candidate's contender key, once it won the lock or
+// stopped campaigning, unless it was since written by another campaign.
+func withdraw(ctx context.Context, state *State, client *EtcdClient) {
+	if state.campaign.enlisted == 0 {
+		return
+	}
+	state.campaign.enlisted = 0
+	if _, err := discard(ctx, client, state.contenderKey(), state.contenderValue(), "withdraw"); err != nil {
+		state.fail(err)
+	}
+}
@@ -0,0 +1,125 @@
+package election
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	fingerprintOnce sync.Once
+	fingerprinted   map[string]string
+)
+
+// Fingerprint returns metadata identifying the running process, as announced
+// with ElectionConfig.Fingerprint: its host, addresses, pid, container and
+// Kubernetes pod where it runs in one, and build version. Entries that cannot
+// be determined are left out. It is computed once per process.
+func Fingerprint() map[string]string {
+	fingerprintOnce.Do(func() {
+		fingerprinted = fingerprint()
+	})
+	copy := make(map[string]string, len(fingerprinted))
+	for name, value := range fingerprinted {
+		copy[name] = value
+	}
+	return copy
+}
+
+func fingerprint() map[string]string {
+	metadata := map[string]string{"pid": strconv.Itoa(os.Getpid())}
+	set := func(name, value string) {
+		if value != "" {
+			metadata[name] = value
+		}
+	}
+	hostname, _ := os.Hostname()
+	set("hostname", hostname)
+	set("ips", strings.Join(addresses(), ","))
+	set("container_id", containerID())
+	// POD_NAME and POD_NAMESPACE are the conventional downward API names
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		pod := os.Getenv("POD_NAME")
+		if pod == "" {
+			pod = hostname
+		}
+		set("pod", pod)
+		namespace := os.Getenv("POD_NAMESPACE")
+		if namespace == "" {
+			data, _ := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+			namespace = strings.TrimSpace(string(data))
+		}
+		set("namespace", namespace)
+	}
+	set("version", buildVersion())
+	return metadata
+}
+
+// addresses returns the global unicast addresses of the host.
+func addresses() []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []string
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.IsGlobalUnicast() {
+			ips = append(ips, ipnet.IP.String())
+		}
+	}
+	return ips
+}
+
+var (
+	// cgroup v1 paths end in the container id, as in /docker/<id> or
+	// /kubepods/.../<id>
+	cgroupIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+	// under cgroup v2 the runtime's bind mounts of /etc files give it away
+	mountIDPattern = regexp.MustCompile(`/containers/([0-9a-f]{64})/\S+ /etc/(?:hostname|hosts|resolv\.conf) `)
+)
+
+// containerID returns the id of the container the process runs in, or "".
+func containerID() string {
+	if data, err := ioutil.ReadFile("/proc/self/cgroup"); err == nil {
+		if id := cgroupIDPattern.Find(data); id != nil {
+			return string(id)
+		}
+	}
+	if data, err := ioutil.ReadFile("/proc/self/mountinfo"); err == nil {
+		if match := mountIDPattern.FindSubmatch(data); match != nil {
+			return string(match[1])
+		}
+	}
+	return ""
+}
+
+// buildVersion returns the version of the main module, and the VCS revision
+// it was built from when known.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	version := info.Main.Version
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && setting.Value != "" {
+			revision := setting.Value
+			if len(revision) > 12 {
+				revision = revision[:12]
+			}
+			if version == "" || version == "(devel)" {
+				return revision
+			}
+			return version + "+" + revision
+		}
+	}
+	if version == "(devel)" {
+		return ""
+	}
+	return version
+}
@@ -0,0 +1,127 @@
+package election
+
+import (
+	"sort"
+	"sync"
+)
+
+// LeaderSource answers whether this process leads the election of a key.
+// Manager implements it; an Elector is adapted by Flags.BindElector.
+type LeaderSource interface {
+	IsLeader(key string) bool
+}
+
+var _ LeaderSource = (*Manager)(nil)
+
+// Flags exposes leadership as boolean feature flags, for gating code paths
+// on it with existing flag tooling: a flag bound to an election is on while
+// this process leads it. Resolutions carry the reasons and error codes of
+// OpenFeature, and Evaluate takes its flattened evaluation context, so an
+// OpenFeature provider is a thin wrapper around Evaluate. The zero value is
+// ready to use.
+type Flags struct {
+	mu    sync.RWMutex
+	flags map[string]flagBinding
+}
+
+type flagBinding struct {
+	source LeaderSource
+	// "" takes the key from the evaluation context
+	key string
+}
+
+// Flag resolution reasons and error codes, as named by OpenFeature.
+const (
+	ReasonTargetingMatch = "TARGETING_MATCH"
+	ReasonError          = "ERROR"
+
+	ErrorFlagNotFound   = "FLAG_NOT_FOUND"
+	ErrorInvalidContext = "INVALID_CONTEXT"
+)
+
+// FlagKeyAttribute is the evaluation context attribute naming the election of
+// a flag bound without a key.
+const FlagKeyAttribute = "key"
+
+// FlagResolution is the outcome of evaluating a flag.
+type FlagResolution struct {
+	Value bool
+	// Variant is "leader" or "follower", or "" on error.
+	Variant string
+	Reason  string
+	// ErrorCode is set when Reason is ReasonError, in which case Value is
+	// the default passed to Evaluate.
+	ErrorCode string
+	// Key is the election the flag resolved against.
+	Key string
+}
+
+// Bind makes flag report whether source leads the election of key. With an
+// empty key, every evaluation names the election in its context under
+// FlagKeyAttribute, so one flag covers all shards of a manager. Binding a
+// flag again replaces it.
+func (f *Flags) Bind(flag string, source LeaderSource, key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.flags == nil {
+		f.flags = make(map[string]flagBinding)
+	}
+	f.flags[flag] = flagBinding{source: source, key: key}
+}
+
+// BindElector makes flag report whether the elector is leader.
+func (f *Flags) BindElector(flag string, c *Elector) {
+	f.Bind(flag, electorSource{c}, c.state.key)
+}
+
+// Unbind removes flag.
+func (f *Flags) Unbind(flag string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.flags, flag)
+}
+
+// Names returns the bound flags, sorted.
+func (f *Flags) Names() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	names := make([]string, 0, len(f.flags))
+	for name := range f.flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Evaluate resolves flag in the evaluation context evalCtx, which may be nil
+// for flags bound to a key. An unknown flag, or one bound without a key
+// evaluated without a string FlagKeyAttribute, resolves to fallback.
+func (f *Flags) Evaluate(flag string, fallback bool, evalCtx map[string]interface{}) FlagResolution {
+	f.mu.RLock()
+	binding, ok := f.flags[flag]
+	f.mu.RUnlock()
+	if !ok {
+		return FlagResolution{Value: fallback, Reason: ReasonError, ErrorCode: ErrorFlagNotFound}
+	}
+	key := binding.key
+	if key == "" {
+		key, _ = evalCtx[FlagKeyAttribute].(string)
+		if key == "" {
+			return FlagResolution{Value: fallback, Reason: ReasonError, ErrorCode: ErrorInvalidContext}
+		}
+	}
+	resolution := FlagResolution{Variant: "follower", Reason: ReasonTargetingMatch, Key: key}
+	if binding.source.IsLeader(key) {
+		resolution.Value, resolution.Variant = true, "leader"
+	}
+	return resolution
+}
+
+// Enabled reports whether flag is on, or fallback if it cannot be resolved.
+func (f *Flags) Enabled(flag string, fallback bool) bool {
+	return f.Evaluate(flag, fallback, nil).Value
+}
+
+type electorSource struct{ c *Elector }
+
+func (s electorSource) IsLeader(string) bool { return s.c.IsLeader() }
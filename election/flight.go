@@ -0,0 +1,56 @@
+package election
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// flights collapses concurrent plain GETs of the same key into one request,
+// so that many components asking for the leader at once cost etcd a single
+// read. A caller may be answered by a read that began shortly before its
+// call, which is no weaker than the non-quorum reads the keys API serves.
+type flights struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done chan struct{}
+	resp *EtcdResponse
+	err  error
+}
+
+// do returns the result of fetch for key, sharing it with every caller that
+// asks for key while the first fetch is in flight. Each caller stops waiting
+// when its own ctx is done; the fetch itself runs to completion for the
+// others.
+func (f *flights) do(ctx context.Context, key string, stats *connStats, fetch func() (*EtcdResponse, error)) (*EtcdResponse, error) {
+	f.mu.Lock()
+	call, ok := f.calls[key]
+	if !ok {
+		call = &flight{done: make(chan struct{})}
+		f.calls[key] = call
+		go func() {
+			call.resp, call.err = fetch()
+			f.mu.Lock()
+			delete(f.calls, key)
+			f.mu.Unlock()
+			close(call.done)
+		}()
+	} else {
+		atomic.AddInt64(&stats.shared, 1)
+	}
+	f.mu.Unlock()
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+	// callers get their own copy of the response
+	resp := *call.resp
+	return &resp, nil
+}
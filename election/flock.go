@@ -0,0 +1,61 @@
+package election
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// errLocked is returned by lockLocal when another process holds the lock.
+var errLocked = errors.New("held by another process on this host")
+
+// localLock is an exclusive file lock that keeps processes on the same host
+// from campaigning for the same election with the same id, e.g. while an old
+// and a new release overlap during a deploy. Both would write identical
+// values, so each would take the other's lock for its own.
+type localLock struct {
+	file *os.File
+}
+
+// lockLocal takes the lock of key under dir without blocking.
+func lockLocal(dir string, key string) (*localLock, error) {
+	path := filepath.Join(dir, url.PathEscape(key)+".lock")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := flock(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	// record the holder for whoever finds the lock taken
+	file.Truncate(0)
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+	return &localLock{file: file}, nil
+}
+
+// waitLocal takes the local lock of the candidate's election, polling while
+// another process holds it, until stop is closed.
+func waitLocal(state *State, dir string, stop <-chan struct{}) (*localLock, error) {
+	waiting := false
+	for {
+		lock, err := lockLocal(dir, state.key)
+		if err != errLocked {
+			return lock, err
+		}
+		if !waiting {
+			state.eventStr(LevelInfo, evLocalLock, state.key)
+			waiting = true
+		}
+		if !state.sleepUntil(state.pollInterval(), stop) {
+			return nil, ErrClosed
+		}
+	}
+}
+
+func (l *localLock) release() error {
+	// closing the file drops the lock
+	return l.file.Close()
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package election
+
+import (
+	"errors"
+	"os"
+)
+
+func flock(file *os.File) error {
+	return errors.New("local election locks are not supported on this platform")
+}
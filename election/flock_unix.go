@@ -0,0 +1,16 @@
+//go:build unix
+
+package election
+
+import (
+	"os"
+	"syscall"
+)
+
+func flock(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errLocked
+	}
+	return err
+}
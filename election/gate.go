@@ -0,0 +1,88 @@
+package election
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// A Gate decides whether a candidate is eligible to lead the election of key,
+// for workload-specific conditions such as a replica whose replication lag
+// is too high to take over. It returns nil to let the candidate take a free
+// lock, or an error telling why not. A gate that panics counts as closed.
+//
+// Gates are consulted before every acquisition attempt, from the election
+// goroutine, so they should answer quickly; ctx is cancelled when the
+// candidate stops. A gated candidate keeps watching the election and tries
+// again at its next poll. Gates do not affect a leader, which may step down
+// with Resign.
+type Gate func(ctx context.Context, key string, id string) error
+
+var (
+	gatesMu sync.RWMutex
+	gates   = make(map[string]Gate)
+)
+
+// RegisterGate makes gate available to elections under name, for
+// ElectionConfig.Gates. It panics if name is empty or already registered, or
+// if gate is nil; it is meant to be called from an init function.
+func RegisterGate(name string, gate Gate) {
+	gatesMu.Lock()
+	defer gatesMu.Unlock()
+	if name == "" || gate == nil {
+		panic("election: RegisterGate needs a name and a gate")
+	}
+	if _, dup := gates[name]; dup {
+		panic("election: RegisterGate called twice for gate " + name)
+	}
+	gates[name] = gate
+}
+
+// Gates returns the names of the registered gates, sorted.
+func Gates() []string {
+	gatesMu.RLock()
+	defer gatesMu.RUnlock()
+	names := make([]string, 0, len(gates))
+	for name := range gates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// namedGate is a gate of an election, as looked up from the registry.
+type namedGate struct {
+	name string
+	gate Gate
+}
+
+// lookupGates returns the registered gates of names, in order.
+func lookupGates(names []string) ([]namedGate, error) {
+	gatesMu.RLock()
+	defer gatesMu.RUnlock()
+	found := make([]namedGate, 0, len(names))
+	for _, name := range names {
+		gate, ok := gates[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown gate %q", name)
+		}
+		found = append(found, namedGate{name, gate})
+	}
+	return found, nil
+}
+
+// gated returns why the first closed gate of the candidate keeps it from
+// taking the lock, or nil if every gate is open.
+func (s *State) gated(ctx context.Context) error {
+	for _, g := range s.gates {
+		var err error
+		if panicked := callHook(func() { err = g.gate(ctx, s.key, s.id) }); panicked != nil {
+			err = panicked
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", g.name, err.Error())
+		}
+	}
+	return nil
+}
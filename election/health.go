@@ -0,0 +1,75 @@
+package election
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// LeaderStatus is what an observer knows about the current leader of an
+// election. Exists reflects the lock in etcd; Responsive additionally
+// requires the leader's advertised health endpoint to answer.
+type LeaderStatus struct {
+	Leader     string
+	Exists     bool
+	Responsive bool
+	// the outcome of the last health probe, empty if it succeeded or the
+	// leader advertises no endpoint
+	Error   string
+	Checked time.Time
+	// Expires is when the lock expires unless renewed, if known.
+	Expires time.Time
+}
+
+// healthProbe checks the health URL leaders advertise under a metadata key.
+type healthProbe struct {
+	key    string
+	client *http.Client
+}
+
+func newHealthProbe(key string, timeout time.Duration) *healthProbe {
+	return &healthProbe{key: key, client: &http.Client{Timeout: timeout}}
+}
+
+// check requests the health URL in metadata. A leader that advertises none
+// is considered responsive.
+func (p *healthProbe) check(metadata map[string]string) error {
+	url := metadata[p.key]
+	if url == "" {
+		return nil
+	}
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+// setStatus publishes the observer's view of the leader.
+func (s *State) setStatus(status LeaderStatus) {
+	s.status.Store(status)
+}
+
+// leaderStatus returns the observer's view of the leader, and false if this
+// candidate is not observing.
+func (s *State) leaderStatus() (LeaderStatus, bool) {
+	status, ok := s.status.Load().(LeaderStatus)
+	if ok && status.Exists && s.expiring(status.Expires) {
+		status.Exists, status.Responsive = false, false
+		status.Error = "lock expires within the observer skew"
+	}
+	return status, ok
+}
+
+// expiring reports whether a lock expiring at expires is to be treated as
+// gone already under the election's ObserverSkew.
+func (s *State) expiring(expires time.Time) bool {
+	if s.observerSkew == 0 || s.refresh || expires.IsZero() {
+		return false
+	}
+	return time.Now().After(expires.Add(-s.observerSkew))
+}
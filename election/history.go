@@ -0,0 +1,73 @@
+package election
+
+import (
+	"sync"
+	"time"
+)
+
+// Transition is a leadership change observed by this process.
+type Transition struct {
+	Time     time.Time `json:"time"`
+	Key      string    `json:"key"`
+	ID       string    `json:"id"`
+	Leader   bool      `json:"leader"`
+	Previous string    `json:"previous,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// Leaders returns the leader before and after the transition; either may be
+// empty when unknown.
+func (t Transition) Leaders() (from string, to string) {
+	if t.Leader {
+		return t.Previous, t.ID
+	}
+	return t.ID, ""
+}
+
+// ring keeps the most recent entries of an append-only log.
+type ring struct {
+	mu      sync.Mutex
+	entries []interface{}
+	next    int
+	full    bool
+}
+
+func newRing(size int) *ring {
+	return &ring{entries: make([]interface{}, size)}
+}
+
+func (r *ring) add(entry interface{}) {
+	r.mu.Lock()
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+	r.mu.Unlock()
+}
+
+// snapshot returns the retained entries, oldest first.
+func (r *ring) snapshot() []interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]interface{}{}, r.entries[:r.next]...)
+	}
+	return append(append([]interface{}{}, r.entries[r.next:]...), r.entries[:r.next]...)
+}
+
+// prepend adds entries, oldest first, ahead of those already retained.
+func (r *ring) prepend(entries []interface{}) {
+	current := r.snapshot()
+	r.mu.Lock()
+	r.next, r.full = 0, false
+	for i := range r.entries {
+		r.entries[i] = nil
+	}
+	r.mu.Unlock()
+	for _, entry := range append(entries, current...) {
+		r.add(entry)
+	}
+}
+
+var history = newRing(256)
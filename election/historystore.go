@@ -0,0 +1,289 @@
+package election
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HistoryStore persists the leadership transitions of this process, so that
+// the timeline of an election survives restarts for post-incident analysis.
+// Stores enforce their HistoryRetention as they go.
+type HistoryStore interface {
+	Append(t Transition) error
+	// Load returns the retained transitions, oldest first.
+	Load() ([]Transition, error)
+}
+
+// HistoryRetention bounds what a HistoryStore keeps; zero fields keep
+// everything.
+type HistoryRetention struct {
+	MaxEntries int
+	MaxAge     time.Duration
+}
+
+// keep returns the transitions of entries, oldest first, that the retention
+// still allows at now.
+func (r HistoryRetention) keep(entries []Transition, now time.Time) []Transition {
+	if r.MaxAge > 0 {
+		i := sort.Search(len(entries), func(i int) bool {
+			return now.Sub(entries[i].Time) <= r.MaxAge
+		})
+		entries = entries[i:]
+	}
+	if r.MaxEntries > 0 && len(entries) > r.MaxEntries {
+		entries = entries[len(entries)-r.MaxEntries:]
+	}
+	return entries
+}
+
+// FileHistoryStore appends transitions as JSON lines to a local file, which
+// it compacts to what the retention allows when opened and whenever it grows
+// half as large again.
+type FileHistoryStore struct {
+	mu        sync.Mutex
+	path      string
+	retention HistoryRetention
+	file      *os.File
+	entries   []Transition
+}
+
+func NewFileHistoryStore(path string, retention HistoryRetention) (*FileHistoryStore, error) {
+	s := &FileHistoryStore{path: path, retention: retention}
+	if err := s.read(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// read loads the file's transitions, skipping a torn last line.
+func (s *FileHistoryStore) read() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var t Transition
+		if json.Unmarshal(scanner.Bytes(), &t) == nil {
+			s.entries = append(s.entries, t)
+		}
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the file with the retained transitions, replacing it
+// atomically, and reopens it for appending.
+func (s *FileHistoryStore) compact() error {
+	s.entries = s.retention.keep(s.entries, time.Now())
+	tmp := s.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, t := range s.entries {
+		line, _ := json.Marshal(t)
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0600)
+	return err
+}
+
+func (s *FileHistoryStore) Append(t Transition) error {
+	line, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.entries = append(s.entries, t)
+	if max := s.retention.MaxEntries; max > 0 && len(s.entries) > max+max/2 {
+		return s.compact()
+	}
+	return nil
+}
+
+func (s *FileHistoryStore) Load() ([]Transition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Transition{}, s.retention.keep(s.entries, time.Now())...), nil
+}
+
+func (s *FileHistoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// EtcdHistoryStore appends transitions as in-order keys under an etcd
+// directory, deleting those the retention no longer allows after every
+// append. Its writes are audited like any other.
+type EtcdHistoryStore struct {
+	client    *EtcdClient
+	dir       string
+	retention HistoryRetention
+	timeout   time.Duration
+}
+
+func NewEtcdHistoryStore(client *EtcdClient, dir string, retention HistoryRetention) *EtcdHistoryStore {
+	return &EtcdHistoryStore{client: client, dir: dir, retention: retention, timeout: 10 * time.Second}
+}
+
+func (s *EtcdHistoryStore) Append(t Transition) error {
+	value, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	if _, err := s.client.append(ctx, s.dir, string(value)); err != nil {
+		return err
+	}
+	if s.retention == (HistoryRetention{}) {
+		return nil
+	}
+	nodes, entries, err := s.list(ctx)
+	if err != nil {
+		return err
+	}
+	expired := len(entries) - len(s.retention.keep(entries, time.Now()))
+	for _, node := range nodes[:expired] {
+		key := s.dir + "/" + node.Key[strings.LastIndex(node.Key, "/")+1:]
+		_, err := s.client.Delete(ctx, key, "", Option{prevIndex: node.ModifiedIndex, origin: "history"})
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *EtcdHistoryStore) Load() ([]Transition, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	_, entries, err := s.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.retention.keep(entries, time.Now()), nil
+}
+
+// list returns the keys of the directory holding a transition, with their
+// transitions, oldest first.
+func (s *EtcdHistoryStore) list(ctx context.Context) ([]Node, []Transition, error) {
+	resp, err := s.client.list(ctx, s.dir)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	all := resp.Node.Nodes
+	sort.Slice(all, func(i, j int) bool { return all[i].CreatedIndex < all[j].CreatedIndex })
+	var nodes []Node
+	var entries []Transition
+	for _, node := range all {
+		var t Transition
+		if json.Unmarshal([]byte(node.Value), &t) == nil {
+			nodes = append(nodes, node)
+			entries = append(entries, t)
+		}
+	}
+	return nodes, entries, nil
+}
+
+// persisted hands the transitions of the process to the store set with
+// SetHistoryStore, from a goroutine of its own so that a slow store never
+// holds up an election.
+var persisted struct {
+	mu    sync.Mutex
+	store HistoryStore
+	queue chan Transition
+}
+
+// SetHistoryStore persists the process's transitions to store from now on,
+// and loads those it retains into the history that ObserveHistory replays
+// and debug bundles include. Call it before campaigning; nil stops
+// persisting. Transitions that would queue up behind more than 256 others
+// are dropped and logged.
+func SetHistoryStore(store HistoryStore) error {
+	var loaded []Transition
+	if store != nil {
+		var err error
+		if loaded, err = store.Load(); err != nil {
+			return err
+		}
+	}
+	persisted.mu.Lock()
+	defer persisted.mu.Unlock()
+	persisted.store = store
+	if store == nil {
+		return nil
+	}
+	entries := make([]interface{}, 0, len(loaded))
+	for _, t := range loaded {
+		entries = append(entries, t)
+	}
+	history.prepend(entries)
+	if persisted.queue == nil {
+		persisted.queue = make(chan Transition, 256)
+		go persist(persisted.queue)
+	}
+	return nil
+}
+
+// persist appends the queued transitions to the current store.
+func persist(queue <-chan Transition) {
+	for t := range queue {
+		persisted.mu.Lock()
+		store := persisted.store
+		persisted.mu.Unlock()
+		if store == nil {
+			continue
+		}
+		if err := store.Append(t); err != nil {
+			std.eventStr(LevelError, t.Key, evHistoryFailed, err.Error())
+		}
+	}
+}
+
+// persistTransition queues t for the store, if there is one.
+func persistTransition(t Transition) {
+	persisted.mu.Lock()
+	defer persisted.mu.Unlock()
+	if persisted.store == nil {
+		return
+	}
+	select {
+	case persisted.queue <- t:
+	default:
+		std.eventStr(LevelError, t.Key, evHistoryFailed, "queue full, transition dropped")
+	}
+}
@@ -0,0 +1,163 @@
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Inspection is the state of one election as read at Time, with the
+// findings of cross-checking its keys against each other and against the
+// history this process recorded. Keys that are absent are nil.
+type Inspection struct {
+	Key        string       `json:"key"`
+	Time       time.Time    `json:"time"`
+	Leader     *Node        `json:"leader,omitempty"`
+	Broadcast  *Node        `json:"broadcast,omitempty"`
+	Heartbeat  *Node        `json:"heartbeat,omitempty"`
+	Contenders []Node       `json:"contenders,omitempty"`
+	History    []Transition `json:"history,omitempty"`
+	Findings   []Finding    `json:"findings"`
+}
+
+// Failed reports whether any finding of the inspection is an error.
+func (i *Inspection) Failed() bool {
+	for _, f := range i.Findings {
+		if f.Level == FindingError {
+			return true
+		}
+	}
+	return false
+}
+
+// Inspect audits the election of key in layout on demand, for incident
+// triage: it reads the leader, broadcast and heartbeat keys and the fair
+// contenders, and checks that they agree with each other and with the
+// transitions of the election in this process's history (see
+// SetHistoryStore). Unlike the Watchdog it keeps no state between calls, so
+// a finding describes a single moment and may be transient.
+func Inspect(ctx context.Context, client *EtcdClient, key string, layout Layout) *Inspection {
+	report := &Inspection{Key: key, Time: time.Now()}
+	add := func(level, check, message string) {
+		report.Findings = append(report.Findings, Finding{Level: level, Check: check, Election: key, Message: message})
+	}
+	for _, part := range []struct {
+		name string
+		node **Node
+	}{
+		{"leader", &report.Leader},
+		{"broadcast", &report.Broadcast},
+		{"heartbeat", &report.Heartbeat},
+	} {
+		resp, err := client.Get(ctx, layout.key(key, part.name), Option{})
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		} else if err != nil {
+			add(FindingError, "reachability", fmt.Sprintf("cannot read the %s key: %s", part.name, err.Error()))
+			report.Findings = sortFindings(report.Findings)
+			return report
+		}
+		node := resp.Node
+		*part.node = &node
+	}
+	if resp, err := client.list(ctx, layout.key(key, "contenders")); err == nil {
+		report.Contenders = resp.Node.Nodes
+	} else if !errors.Is(err, ErrKeyNotFound) {
+		add(FindingWarning, "contenders", fmt.Sprintf("cannot list the contenders: %s", err.Error()))
+	}
+	for _, entry := range history.snapshot() {
+		if t, ok := entry.(Transition); ok && t.Key == key {
+			report.History = append(report.History, t)
+		}
+	}
+
+	leader, broadcast, heartbeat := report.Leader, report.Broadcast, report.Heartbeat
+	holder := ""
+	if leader == nil {
+		add(FindingWarning, "leader", "the election has no leader")
+	} else {
+		holder = decodeRecord(leader.Value).ID
+		add(FindingInfo, "leader", fmt.Sprintf("%q holds the lock since index %d", holder, leader.CreatedIndex))
+		if leader.Expiration != nil && leader.Expiration.Before(report.Time) {
+			add(FindingWarning, "leader", fmt.Sprintf("the lock expired at %s by the local clock but is still present; check the clock skew", leader.Expiration.Format(time.RFC3339)))
+		}
+	}
+
+	switch {
+	case broadcast == nil && leader != nil:
+		add(FindingWarning, "broadcast", fmt.Sprintf("%q holds the lock but is not announced on the broadcast key", holder))
+	case broadcast == nil:
+	case leader == nil:
+		add(FindingInfo, "broadcast", fmt.Sprintf("the broadcast key still names the last leader %q", decodeRecord(broadcast.Value).ID))
+	case broadcast.Value == leader.Value:
+		add(FindingInfo, "broadcast", "the broadcast key agrees with the lock")
+	case broadcast.ModifiedIndex > leader.CreatedIndex && decodeRecord(broadcast.Value).ID != holder:
+		// only a candidate that believes it acquired the lock announces
+		// itself, so this one did after the holder took it
+		add(FindingError, "broadcast", fmt.Sprintf("%q was announced at index %d, after %q acquired the lock at index %d: two candidates may act as leader", decodeRecord(broadcast.Value).ID, broadcast.ModifiedIndex, holder, leader.CreatedIndex))
+	default:
+		add(FindingWarning, "broadcast", fmt.Sprintf("the broadcast key names %q but %q holds the lock; it is backfilled at the next renewal", decodeRecord(broadcast.Value).ID, holder))
+	}
+
+	if heartbeat != nil {
+		if beating := decodeRecord(heartbeat.Value).ID; leader == nil {
+			add(FindingWarning, "heartbeat", fmt.Sprintf("%q heartbeats without holding the lock", beating))
+		} else if beating != holder {
+			add(FindingError, "heartbeat", fmt.Sprintf("%q heartbeats but %q holds the lock", beating, holder))
+		}
+	}
+
+	for _, node := range report.Contenders {
+		if id := node.Key[strings.LastIndex(node.Key, "/")+1:]; leader != nil && id == holder {
+			add(FindingWarning, "contenders", fmt.Sprintf("leader %q is still enlisted as a contender", holder))
+		}
+	}
+
+	// the candidates of this process that lead by its own history
+	leading := make(map[string]bool)
+	for _, t := range report.History {
+		if t.Leader {
+			leading[t.ID] = true
+		} else {
+			delete(leading, t.ID)
+		}
+	}
+	if len(leading) > 1 {
+		var ids []string
+		for id := range leading {
+			ids = append(ids, fmt.Sprintf("%q", id))
+		}
+		sort.Strings(ids)
+		add(FindingError, "history", fmt.Sprintf("candidates %s of this process all believe they lead", strings.Join(ids, ", ")))
+	}
+	for id := range leading {
+		if id != holder {
+			add(FindingWarning, "history", fmt.Sprintf("%q of this process believes it leads, but the lock says otherwise", id))
+		}
+	}
+	report.Findings = sortFindings(report.Findings)
+	return report
+}
+
+// InspectHandler serves Inspect for the election named by the key query
+// parameter, as JSON. The response is 200 even when the inspection finds
+// errors; it is about the election, not the request.
+func InspectHandler(client *EtcdClient, layout Layout) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := req.URL.Query().Get("key")
+		if key == "" {
+			http.Error(w, "missing key parameter", http.StatusBadRequest)
+			return
+		}
+		report := Inspect(req.Context(), client, key, layout)
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	})
+}
@@ -0,0 +1,126 @@
+package election_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+	"github.com/jeeyoungk/etcd-leader/etcdtest"
+)
+
+// integrationTTL is the lock TTL against real etcd, whose TTLs have a
+// granularity of a second.
+const integrationTTL = 2 * time.Second
+
+// TestIntegration runs elections against every release of the etcd matrix,
+// since TTL, refresh and watch semantics differ between them. Releases that
+// cannot be started are skipped.
+func TestIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts etcd")
+	}
+	etcdtest.RunMatrix(t, func(t *testing.T, etcd *etcdtest.Etcd, target etcdtest.Target) {
+		newClient := func(t *testing.T) *election.EtcdClient {
+			t.Helper()
+			client := election.NewEtcdClient(etcd.URL)
+			backend, err := election.Negotiate(context.Background(), client, election.BackendAuto)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := election.BackendV3
+			if target.Has(etcdtest.FeatureV2) {
+				want = election.BackendV2
+			}
+			if backend != want {
+				t.Fatalf("negotiated %s, want %s", backend, want)
+			}
+			return client
+		}
+		newCandidate := func(t *testing.T, client *election.EtcdClient, key string, id string, config election.ElectionConfig) *election.Elector {
+			t.Helper()
+			elector, err := election.New(client, key, id, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { elector.Stop() })
+			return elector
+		}
+
+		t.Run("hold", func(t *testing.T) {
+			testHold(t, newCandidate(t, newClient(t), "hold", "a", election.ElectionConfig{TTL: integrationTTL}))
+		})
+		t.Run("refresh", func(t *testing.T) {
+			if !target.Has(etcdtest.FeatureRefresh) && target.Has(etcdtest.FeatureV2) {
+				t.Skip("no refresh=true on " + target.Release)
+			}
+			testHold(t, newCandidate(t, newClient(t), "refresh", "a", election.ElectionConfig{TTL: integrationTTL, Refresh: true}))
+		})
+		t.Run("failover", func(t *testing.T) {
+			client := newClient(t)
+			config := election.ElectionConfig{TTL: integrationTTL}
+			first := newCandidate(t, client, "failover", "first", config)
+			second := newCandidate(t, client, "failover", "second", config)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*integrationTTL)
+			defer cancel()
+			lease, err := first.Campaign(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			second.Start()
+			// let second settle into watching the lock
+			time.Sleep(integrationTTL / 2)
+			start := time.Now()
+			if err := first.Resign(ctx); err != nil {
+				t.Fatal(err)
+			}
+			next, err := second.Campaign(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if took := time.Since(start); took > integrationTTL {
+				t.Errorf("second took over after %s, want well within the %s TTL", took, integrationTTL)
+			}
+			if next.Term() <= lease.Term() {
+				t.Errorf("term %d does not follow %d", next.Term(), lease.Term())
+			}
+		})
+		t.Run("observe", func(t *testing.T) {
+			client := newClient(t)
+			config := election.ElectionConfig{TTL: integrationTTL}
+			first := newCandidate(t, client, "observe", "first", config)
+			observer := newCandidate(t, client, "observe", "observer", config)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*integrationTTL)
+			defer cancel()
+			events := observer.Observe(ctx)
+			if _, err := first.Campaign(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := first.Stop(); err != nil {
+				t.Fatal(err)
+			}
+			var seen []string
+			for event := range events {
+				seen = append(seen, event.Leader.ID)
+				if event.Leader.ID == "" && len(seen) > 1 && seen[len(seen)-2] == "first" {
+					return
+				}
+			}
+			t.Fatalf("observed leaders %q, want first followed by none", seen)
+		})
+	})
+}
+
+// testHold campaigns and checks that the lock is still held three TTLs
+// later, across several renewals.
+func testHold(t *testing.T, elector *election.Elector) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*integrationTTL)
+	defer cancel()
+	if _, err := elector.Campaign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * integrationTTL)
+	if !elector.IsLeader() {
+		t.Fatalf("lost the lock within 3 TTLs (phase %s)", elector.Phase())
+	}
+}
@@ -0,0 +1,61 @@
+package election
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// DefaultKeysPath is where etcd serves the v2 keys API.
+const DefaultKeysPath = "/v2/keys"
+
+// SetKeysPath sets the path of the keys API below the base URL, for gateways
+// that mount it somewhere other than /v2/keys. It must be called before the
+// client is shared between goroutines.
+func (c *EtcdClient) SetKeysPath(path string) {
+	c.keysPath = "/" + strings.Trim(path, "/")
+}
+
+// KeyError reports a key the client refuses to send to etcd.
+type KeyError struct {
+	Key    string
+	Reason string
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("invalid key %q: %s", e.Key, e.Reason)
+}
+
+// escapeKey escapes key for use in a URL path. Slashes are kept, so that a
+// key can name an entry in a nested directory; every other character that is
+// not safe in a path segment, such as a space or "?", is escaped.
+func escapeKey(key string) string {
+	segments := strings.Split(strings.TrimPrefix(key, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// validateKey rejects keys that etcd would interpret differently than the
+// caller intended once they are part of a URL path.
+func validateKey(key string) error {
+	trimmed := strings.TrimPrefix(key, "/")
+	if trimmed == "" {
+		return &KeyError{Key: key, Reason: "empty"}
+	}
+	for _, segment := range strings.Split(trimmed, "/") {
+		switch segment {
+		case "":
+			return &KeyError{Key: key, Reason: "empty path segment"}
+		case ".", "..":
+			return &KeyError{Key: key, Reason: "relative path segment"}
+		}
+	}
+	for _, r := range key {
+		if r < 0x20 || r == 0x7f {
+			return &KeyError{Key: key, Reason: "control character"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package election
+
+import (
+	"context"
+	"errors"
+)
+
+// Layout is how the etcd keys of an election are named.
+type Layout string
+
+const (
+	// LayoutFlat stores election k as the sibling keys k-leader,
+	// k-broadcast and k-heartbeat. It is the default.
+	LayoutFlat Layout = "flat"
+	// LayoutDir stores them in the directory k, as k/leader, k/broadcast
+	// and k/heartbeat, leaving room for per-candidate keys beside them.
+	LayoutDir Layout = "dir"
+)
+
+// key returns the etcd key of part name ("leader", "broadcast" or
+// "heartbeat") of election.
+func (l Layout) key(election string, name string) string {
+	if l == LayoutDir {
+		return election + "/" + name
+	}
+	return election + "-" + name
+}
+
+// Key returns the etcd key of part name ("leader", "broadcast" or
+// "heartbeat") of election, for tools and tests that read them directly.
+func (l Layout) Key(election string, name string) string {
+	return l.key(election, name)
+}
+
+func (l Layout) valid() bool {
+	return l == "" || l == LayoutFlat || l == LayoutDir
+}
+
+// A rolling upgrade that changes the layout runs one release with
+// CompatLayout set to the layout of the release before it. A candidate in
+// that release holds the lock in both layouts at once: it can only win
+// against an old candidate by taking the old key, and only against a
+// candidate of the next release by taking the new one, so no two releases
+// that overlap during a rollout can both have a leader. Broadcast and
+// heartbeat keys are written to both layouts. Reads look at the old layout
+// first, since every leader of the last two releases writes it, and fall
+// back to the new one.
+
+// compatKey returns the key of part name in the compat layout, or "" when
+// the election is not being upgraded.
+func (s *State) compatKey(name string) string {
+	if s.compat == "" {
+		return ""
+	}
+	return s.compat.key(s.key, name)
+}
+
+// watchKey is the leader key observers follow.
+func (s *State) watchKey() string {
+	if key := s.compatKey("leader"); key != "" {
+		return key
+	}
+	return s.leaderKey()
+}
+
+// read gets part name of the election, from the compat layout if it is
+// present there and from the election's own layout otherwise.
+func (s *State) read(ctx context.Context, client *EtcdClient, name string) (*EtcdResponse, error) {
+	key := s.layout.key(s.key, name)
+	if compat := s.compatKey(name); compat != "" {
+		resp, err := client.Get(ctx, compat, Option{})
+		if !errors.Is(err, ErrKeyNotFound) {
+			return resp, err
+		}
+	}
+	return client.Get(ctx, key, Option{})
+}
+
+// acquireCompat takes the lock in the compat layout after it was taken in the
+// election's own layout. If an old candidate holds it, the new lock is
+// released again and acquireCompat returns false.
+func acquireCompat(ctx context.Context, state *State, client *EtcdClient) (bool, error) {
+	key := state.compatKey("leader")
+	if key == "" {
+		return true, nil
+	}
+	_, err := client.Put(ctx, key, state.encoded(), Option{ttl: state.ttl, prevExist: -1, origin: "campaign"})
+	if err == nil {
+		return true, nil
+	} else if !answered(err) {
+		return false, err
+	}
+	if _, err := client.Delete(ctx, state.leaderKey(), state.encoded(), Option{origin: "campaign"}); err != nil && !answered(err) {
+		return false, err
+	}
+	return false, nil
+}
+
+// renewCompat renews the lock in the compat layout after it was renewed in
+// the election's own layout; its error decides whether the lock is still
+// held.
+func renewCompat(ctx context.Context, state *State, client *EtcdClient, origin string) error {
+	key := state.compatKey("leader")
+	if key == "" {
+		return nil
+	}
+	option := state.renewal(origin)
+	// the compat key was created at a revision of its own
+	option.prevCreated = 0
+	_, err := client.Put(ctx, key, state.encoded(), option)
+	return err
+}
+
+// releaseCompat deletes the lock in the compat layout, if any, with a
+// compare on the candidate's own value.
+func releaseCompat(ctx context.Context, state *State, client *EtcdClient, origin string) {
+	key := state.compatKey("leader")
+	if key == "" {
+		return
+	}
+	if _, err := client.Delete(ctx, key, state.encoded(), Option{origin: origin}); err != nil && !answered(err) {
+		state.fail(err)
+	}
+}
+
+// mirror writes part name to the compat layout as well, after the write in
+// the election's own layout succeeded. Old observers read only that copy.
+func mirror(ctx context.Context, state *State, client *EtcdClient, name string, option Option) {
+	key := state.compatKey(name)
+	if key == "" {
+		return
+	}
+	if _, err := client.Put(ctx, key, state.encoded(), option); err != nil && !answered(err) {
+		state.fail(err)
+	}
+}
@@ -0,0 +1,1236 @@
+// Package election is experimental leader-election code with ETCD.
+package election
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// State is one candidate's standing in an election. It is driven by the
+// election goroutine; the exported accessors are safe to call from any other
+// goroutine.
+type State struct {
+	key string
+	id  string
+	// tells this process apart from others running as id, with ExclusiveID
+	instance string
+	// the encoded record written to the leader and broadcast keys, a string
+	value atomic.Value
+	// on v3, tells this candidate's acquisition attempts apart; part of
+	// value
+	nonce    string
+	metadata map[string]string
+	signer   Signer
+	current  int32 // the Phase of the candidate, accessed atomically
+	// accessed atomically; 1 once the lock should be given up at the next
+	// renewal
+	resign int32
+	ttl    time.Duration
+	// how long to stay out of the election after losing leadership
+	backoff time.Duration
+	// how to wait out failed iterations of the election loop, the
+	// consecutive failures so far and when the first of them happened
+	retry        RetryPolicy
+	failures     int
+	failingSince time.Time
+	// accessed atomically; 1 once the candidate gave up retrying
+	exhausted int32
+	// TTL of the heartbeat key the leader refreshes on every renewal; zero
+	// disables it
+	heartbeat time.Duration
+	// mean delay between two polls of the lock, or 0 for a quarter of the
+	// TTL
+	renewInterval time.Duration
+	// renew by refreshing the TTL instead of rewriting the value
+	refresh bool
+	// delete the broadcast key along with the lock on resigning
+	clearBroadcast bool
+	// TTL of the broadcast key, or 0 to keep it
+	broadcastTTL time.Duration
+	// report the candidate as leader only within a TTL of confirmed time
+	leaseGuard bool
+	// UnixNano when the last renewal or acquisition request that succeeded
+	// was sent, accessed atomically
+	confirmed int64
+	// defer to longer-waiting contenders when the lock is free
+	fair bool
+	// how long to wait before trying to take a free lock, from the weights
+	// of the candidate's capabilities
+	hesitation time.Duration
+	// the candidate's priority, and whether it preempts leaders of lower
+	// priority and hands the lock to those of higher
+	priority int
+	preempt  bool
+	// the registered gates consulted before every acquisition attempt
+	gates []namedGate
+	// the fleet's budget of acquisition attempts, and until when the token
+	// drawn from it lasts
+	throttle ThrottlePolicy
+	token    time.Time
+	// probability of simulating a stalled leader on each renewal
+	chaos float32
+	// called after every leadership change of this candidate
+	onTransition func(Transition)
+	// called after every phase change, and where those are counted, if set
+	onPhase func(PhaseChange)
+	metrics *Metrics
+	// called with every error of the election loop, if set
+	onError func(error)
+	// receives the log lines of the election, if set
+	logger Logger
+	// the etcd index at which the lock was last acquired; accessed
+	// atomically
+	term int64
+	// called with the events of the election loop, if set
+	onEvent func(ElectionEvent)
+	// the holder of the lock as last read by the election loop, a string
+	seen atomic.Value
+	// the campaign in progress, and the Acquisition ending the last one
+	campaign    campaign
+	acquisition atomic.Value
+	// what to do when the broadcast write after acquiring the lock fails
+	broadcastFailure BroadcastPolicy
+	// how long before the lock expires observers consider it gone
+	observerSkew time.Duration
+	// fraction of the TTL to wait after acquiring the lock before acting as
+	// leader, giving a stalled former leader time to notice it lost the lock
+	takeoverGrace float64
+	// key layout of the election, and of the release before during a
+	// rolling upgrade (see CompatLayout)
+	layout Layout
+	compat Layout
+	// set once the credentials turn out not to allow writes; the candidate
+	// then only tracks the current leader in observed
+	observer bool
+	observed string
+	watch    *watcher
+	// the etcd index at which the lock was last seen held by another
+	// candidate, for pause to watch from; zero to poll instead
+	follow int
+	// verify and decrypt announcements seen while observing, if set
+	verifier Verifier
+	cipher   *MetadataCipher
+	// goroutines, watches and timers held by this election
+	usage usage
+	// closed to cut short the pauses of the election loop, if set
+	stop <-chan struct{}
+	// probes the health endpoint of observed leaders, if set
+	health *healthProbe
+	// the clock skew against etcd, if checked
+	clock *clockGuard
+	// the time the election loop waits by, if not the real one
+	waits  Clock
+	status atomic.Value // LeaderStatus, once observing
+}
+
+// newState returns the state of candidate id in the election of key.
+func newState(key string, id string, config ElectionConfig, signer Signer, verifier Verifier, cipher *MetadataCipher) (*State, error) {
+	metadata := config.Metadata
+	if config.Fingerprint {
+		metadata = Fingerprint()
+		for name, value := range config.Metadata {
+			metadata[name] = value
+		}
+	}
+	gates, err := lookupGates(config.Gates)
+	if err != nil {
+		return nil, err
+	}
+	var instance string
+	if config.ExclusiveID {
+		if instance, err = newNonce(); err != nil {
+			return nil, err
+		}
+	}
+	state := &State{
+		key:              key,
+		id:               id,
+		instance:         instance,
+		metadata:         metadata,
+		signer:           signer,
+		verifier:         verifier,
+		cipher:           cipher,
+		ttl:              config.TTL,
+		backoff:          config.Backoff,
+		retry:            config.Retry,
+		heartbeat:        config.Heartbeat,
+		renewInterval:    config.RenewInterval,
+		refresh:          config.Refresh,
+		clearBroadcast:   config.ClearBroadcast,
+		broadcastTTL:     config.BroadcastTTL,
+		leaseGuard:       config.LeaseGuard,
+		fair:             config.Fair,
+		hesitation:       config.hesitation(),
+		priority:         config.Priority,
+		preempt:          config.Preempt,
+		gates:            gates,
+		throttle:         config.Throttle,
+		chaos:            config.Chaos,
+		broadcastFailure: config.BroadcastFailure,
+		takeoverGrace:    config.TakeoverGrace,
+		observerSkew:     config.ObserverSkew,
+		layout:           config.Layout,
+		compat:           config.CompatLayout,
+	}
+	// a signed value carries a nonce from the start, binding its signature
+	// to this process
+	var nonce string
+	if signer != nil {
+		if nonce, err = newNonce(); err != nil {
+			return nil, err
+		}
+	}
+	if err := state.setNonce(nonce); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// newNonce returns a random token telling a process, or a request, apart
+// from others.
+func newNonce() (string, error) {
+	nonce := make([]byte, 8)
+	if _, err := crand.Read(nonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(nonce), nil
+}
+
+// encoded returns the value the candidate writes to its keys.
+func (s *State) encoded() string {
+	return s.value.Load().(string)
+}
+
+// setNonce encodes the candidate's value anew with nonce. On v3 every
+// acquisition attempt writes a fresh one, so that an attempt whose answer
+// was lost can be recognized as applied by the value it left behind.
+func (s *State) setNonce(nonce string) error {
+	value, err := encodeValue(s.key, s.id, s.instance, nonce, s.priority, s.metadata, s.signer, s.cipher)
+	if err != nil {
+		return err
+	}
+	s.nonce = nonce
+	s.value.Store(value)
+	return nil
+}
+
+func (s *State) isLeader() bool {
+	return s.phase() == PhaseLeader
+}
+
+// IsLeader reports whether the candidate currently holds the lock; see
+// ElectionConfig.LeaseGuard.
+func (s *State) IsLeader() bool {
+	return s.guarded()
+}
+
+// CurrentLeaderID returns the holder of the lock as last read by the election
+// loop, or "" if the lock was free or has not been read yet.
+func (s *State) CurrentLeaderID() string {
+	holder, _ := s.seen.Load().(string)
+	return holder
+}
+
+// LastRenewal returns when the last request that acquired or renewed the lock
+// was sent, or the zero time if the candidate never held it.
+func (s *State) LastRenewal() time.Time {
+	if sent := atomic.LoadInt64(&s.confirmed); sent != 0 {
+		return time.Unix(0, sent)
+	}
+	return time.Time{}
+}
+
+// confirm records that a request sent at sent found the candidate holding the
+// lock, which therefore lasts until at least a TTL after sent.
+func (s *State) confirm(sent time.Time) {
+	atomic.StoreInt64(&s.confirmed, sent.UnixNano())
+}
+
+// guarded reports whether the candidate is leader, and with LeaseGuard still
+// within a TTL of the last confirmation.
+func (s *State) guarded() bool {
+	if !s.isLeader() {
+		return false
+	}
+	return !s.leaseGuard || time.Since(time.Unix(0, atomic.LoadInt64(&s.confirmed))) < s.ttl
+}
+
+// setLeader records a leadership change. previous is the leader this
+// candidate replaced, if known.
+func (s *State) setLeader(leader bool, previous string, reason string) {
+	if leader {
+		s.setPhase(PhaseLeader, reason)
+	} else {
+		s.setPhase(PhaseDemoted, reason)
+	}
+	transition := Transition{Time: time.Now(), Key: s.key, ID: s.id, Leader: leader, Previous: previous, Reason: reason}
+	history.add(transition)
+	persistTransition(transition)
+	if s.onTransition != nil {
+		s.onTransition(transition)
+	}
+}
+
+// fail logs an error of the election loop, which carries on regardless.
+func (s *State) fail(err error) {
+	s.eventStr(LevelError, evError, err.Error())
+	if s.onError != nil {
+		s.onError(err)
+	}
+}
+
+func (s *State) leaderKey() string {
+	return s.layout.key(s.key, "leader")
+}
+
+func (s *State) broadcastKey() string {
+	return s.layout.key(s.key, "broadcast")
+}
+
+func (s *State) heartbeatKey() string {
+	return s.layout.key(s.key, "heartbeat")
+}
+
+// abdicate makes the candidate release the lock instead of renewing it next
+// time round.
+func (s *State) abdicate() {
+	atomic.StoreInt32(&s.resign, 1)
+}
+
+// renewal returns the options of a write renewing the lock held by this
+// candidate. On v3 the write is pinned to the create revision of the lock,
+// so that a lock deleted and recreated by another process running under the
+// same id is not renewed as our own.
+func (s *State) renewal(origin string) Option {
+	return Option{prevValue: s.encoded(), prevCreated: s.currentTerm(), ttl: s.ttl, refresh: s.refresh, origin: origin}
+}
+
+// pollInterval is the jittered delay between two iterations of loop(), unless
+// the candidate is following the lock (see pause).
+func (s *State) pollInterval() time.Duration {
+	interval := s.renewInterval
+	if interval == 0 {
+		interval = s.ttl / 4
+	}
+	return time.Duration(float32(interval) * (0.5 + rand.Float32()))
+}
+
+type EtcdResponse struct {
+	ErrorCode int    `json:"errorCode"`
+	Message   string `json:"message"`
+	Cause     string `json:"cause"`
+	Index     int    `json:"index"`
+	Action    string `json:"action"`
+	Node      Node   `json:"node"`
+	PrevNode  *Node  `json:"prevNode"`
+
+	StatusCode int `json:"-"`
+	// EtcdIndex is the X-Etcd-Index header of the response.
+	EtcdIndex int `json:"-"`
+}
+
+// Unauthorized reports whether etcd rejected the request for lack of
+// permissions.
+func (r *EtcdResponse) Unauthorized() bool {
+	return r.StatusCode == http.StatusUnauthorized || r.StatusCode == http.StatusForbidden || r.ErrorCode == codeUnauthorized
+}
+
+type Node struct {
+	CreatedIndex  int    `json:"CreatedIndex"`
+	Key           string `json:"key"`
+	ModifiedIndex int    `json:"ModifiedIndex"`
+	Value         string `json:"value"`
+	// Expiration is when a key with a TTL expires, in etcd's clock.
+	Expiration *time.Time `json:"expiration,omitempty"`
+	// Dir and Nodes describe a directory and the keys directly under it.
+	Dir   bool   `json:"dir,omitempty"`
+	Nodes []Node `json:"nodes,omitempty"`
+}
+
+type Option struct {
+	ttl       time.Duration
+	wait      bool
+	waitIndex int
+	// compare-and-set fields
+	prevExist int
+	prevIndex int
+	prevValue string
+	// the key's create revision, compared by the v3 backend only: the v2
+	// API has no such condition
+	prevCreated int
+	// reset the TTL without rewriting the value or waking watchers
+	refresh bool
+	// the election operation issuing a write, recorded in the audit log
+	origin string
+	// why the write was issued, recorded in the audit log
+	reason string
+}
+
+type EtcdClient struct {
+	baseUrl  string
+	keysPath string
+	client   *http.Client
+	stats    *connStats
+	// connections per endpoint last opened by Warm, for SetEndpoints to
+	// open to the endpoints it sets; accessed atomically
+	warm      int32
+	responses *ring
+	readOnly  int32 // accessed atomically
+	metrics   *Metrics
+	maxWait   time.Duration
+	prefix    string
+	// prepended to every key by the v3 backend
+	namespace string
+	// bounds every request but watches, if set
+	requestTimeout time.Duration
+	// remembered responses for conditional GETs, if enabled
+	validators *validators
+	flights    *flights
+	audit      AuditSink
+	recorder   *recorder
+	// passes values shown in the wire log, which is off while nil
+	redact Redactor
+	tracer Tracer
+	// set on clusters with auth enabled
+	credentials *credentials
+	// serves requests from the v3 API instead, if set
+	v3 *v3Backend
+	// highest X-Etcd-Index seen, accessed atomically
+	clusterIndex int64
+}
+
+// NewEtcdClient returns a client of the etcd at baseUrl, which may include a
+// path when etcd is mounted below the root of a gateway.
+func NewEtcdClient(baseUrl string) *EtcdClient {
+	return &EtcdClient{
+		baseUrl:   strings.TrimSuffix(baseUrl, "/"),
+		keysPath:  DefaultKeysPath,
+		client:    &http.Client{Transport: newTransport(30 * time.Second)},
+		stats:     &connStats{},
+		responses: newRing(64),
+		metrics:   NewMetrics(100),
+		maxWait:   defaultMaxWait,
+		flights:   &flights{calls: make(map[string]*flight)},
+	}
+}
+
+// MakeURL returns the keys API URL of key. Slashes in key name nested
+// directories; anything else is escaped.
+func (c *EtcdClient) MakeURL(key string) string {
+	return fmt.Sprintf("%s%s/%s", c.baseUrl, c.keysPath, escapeKey(key))
+}
+
+// Get reads key. Like every request of the client, it is abandoned once ctx
+// is done. As with Put and Delete, an error code etcd answers with is
+// returned as an *EtcdError, along with the response carrying it.
+func (c *EtcdClient) Get(ctx context.Context, key string, option Option) (*EtcdResponse, error) {
+	resp, err := c.get(ctx, key, option)
+	c.wire("GET", key, "", option, resp, err)
+	return checked(resp, err)
+}
+
+func (c *EtcdClient) get(ctx context.Context, key string, option Option) (*EtcdResponse, error) {
+	query := make(url.Values)
+	if option.wait {
+		query.Add("wait", "true")
+	}
+	if option.waitIndex != 0 {
+		query.Add("waitIndex", strconv.Itoa(option.waitIndex))
+	}
+	if c.v3 != nil {
+		if option.wait {
+			return c.v3.wait(ctx, key, option.waitIndex)
+		}
+		return c.v3.get(ctx, key)
+	}
+	if req, err := http.NewRequestWithContext(ctx, "GET", c.MakeURL(key)+"?"+query.Encode(), nil); err != nil {
+		return nil, err
+	} else if option.wait {
+		return c.wait(key, req)
+	} else {
+		return c.flights.do(ctx, req.URL.String(), c.stats, func() (*EtcdResponse, error) {
+			return c.request("get", key, req.WithContext(detached{ctx}))
+		})
+	}
+}
+
+func (c *EtcdClient) Put(ctx context.Context, key string, value string, option Option) (*EtcdResponse, error) {
+	if err := c.checkWritable("PUT", key); err != nil {
+		return nil, err
+	}
+	if c.v3 != nil {
+		resp, err := c.v3.put(ctx, key, value, option)
+		c.record("PUT", key, option, resp, err)
+		c.wire("PUT", key, value, option, resp, err)
+		return checked(resp, err)
+	}
+	values := make(url.Values)
+	if option.refresh {
+		values.Add("refresh", "true")
+	} else {
+		values.Add("value", value)
+	}
+	if option.ttl != 0 {
+		values.Add("ttl", strconv.Itoa(int(option.ttl/time.Second)))
+	}
+
+	if option.prevExist == 1 {
+		values.Add("prevExist", "true")
+	} else if option.prevExist == -1 {
+		values.Add("prevExist", "false")
+	}
+
+	if option.prevIndex != 0 {
+		values.Add("prevIndex", strconv.Itoa(option.prevIndex))
+	}
+
+	if option.prevValue != "" {
+		values.Add("prevValue", option.prevValue)
+	}
+
+	body := bytes.NewReader([]byte(values.Encode()))
+	if req, err := http.NewRequestWithContext(ctx, "PUT", c.MakeURL(key), body); err != nil {
+		return nil, err
+	} else {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; param=value")
+		resp, err := c.request("put", key, req)
+		c.record("PUT", key, option, resp, err)
+		c.wire("PUT", key, value, option, resp, err)
+		return checked(resp, err)
+	}
+}
+
+func (c *EtcdClient) Delete(ctx context.Context, key string, value string, option Option) (*EtcdResponse, error) {
+	if err := c.checkWritable("DELETE", key); err != nil {
+		return nil, err
+	}
+	if c.v3 != nil {
+		resp, err := c.v3.delete(ctx, key, value, option)
+		option.prevValue = value
+		c.record("DELETE", key, option, resp, err)
+		c.wire("DELETE", key, "", option, resp, err)
+		return checked(resp, err)
+	}
+	// a non-empty value makes this a compare-and-delete
+	query := make(url.Values)
+	if value != "" {
+		query.Add("prevValue", value)
+	}
+	if option.prevIndex != 0 {
+		query.Add("prevIndex", strconv.Itoa(option.prevIndex))
+	}
+	if req, err := http.NewRequestWithContext(ctx, "DELETE", c.MakeURL(key)+"?"+query.Encode(), nil); err != nil {
+		return nil, err
+	} else {
+		resp, err := c.request("delete", key, req)
+		option.prevValue = value
+		c.record("DELETE", key, option, resp, err)
+		c.wire("DELETE", key, "", option, resp, err)
+		return checked(resp, err)
+	}
+}
+
+// acquire creates the lock at key and writes the broadcast key along with
+// it, in a single transaction on the v3 backend. The v2 keys API has no
+// multi-key transaction, so it is not supported there: the broadcast response
+// is nil, and the caller writes the broadcast key on its own.
+func (c *EtcdClient) acquire(ctx context.Context, key string, broadcastKey string, value string, lock Option, broadcast Option) (*EtcdResponse, *EtcdResponse, error) {
+	if c.v3 == nil {
+		resp, err := c.Put(ctx, key, value, lock)
+		return resp, nil, err
+	}
+	if err := c.checkWritable("PUT", key); err != nil {
+		return nil, nil, err
+	}
+	resp, announced, err := c.v3.acquire(ctx, key, broadcastKey, value, lock, broadcast)
+	c.record("PUT", key, lock, resp, err)
+	c.wire("PUT", key, value, lock, resp, err)
+	if announced != nil {
+		c.record("PUT", broadcastKey, broadcast, announced, nil)
+		c.wire("PUT", broadcastKey, value, broadcast, announced, nil)
+	}
+	resp, err = checked(resp, err)
+	return resp, announced, err
+}
+
+// append creates an in-order key under dir.
+func (c *EtcdClient) append(ctx context.Context, dir string, value string) (*EtcdResponse, error) {
+	if err := c.checkWritable("POST", dir); err != nil {
+		return nil, err
+	}
+	if c.v3 != nil {
+		return checked(c.v3.append(ctx, dir, value))
+	}
+	values := make(url.Values)
+	values.Add("value", value)
+	body := bytes.NewReader([]byte(values.Encode()))
+	if req, err := http.NewRequestWithContext(ctx, "POST", c.MakeURL(dir), body); err != nil {
+		return nil, err
+	} else {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; param=value")
+		return checked(c.request("post", dir, req))
+	}
+}
+
+// list reads the keys directly under dir.
+func (c *EtcdClient) list(ctx context.Context, dir string) (*EtcdResponse, error) {
+	if c.v3 != nil {
+		resp, err := c.v3.list(ctx, dir)
+		c.wire("GET", dir, "", Option{}, resp, err)
+		return checked(resp, err)
+	}
+	return c.Get(ctx, dir, Option{})
+}
+
+func (c *EtcdClient) request(op string, key string, req *http.Request) (*EtcdResponse, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	if err := c.checkPrefix(key); err != nil {
+		return nil, err
+	}
+	req, cancel := c.bound(req, op)
+	defer cancel()
+	req, span := c.traceRequest(req, op, key)
+	start := time.Now()
+	resp, err := c.do(req)
+	result := outcome(resp, err)
+	c.metrics.observe(electionKey(key), op, result, time.Since(start))
+	if resp != nil {
+		span.SetFields(Field{"status", resp.StatusCode}, Field{"errorCode", resp.ErrorCode})
+	}
+	span.SetFields(Field{"outcome", result})
+	span.End(err)
+	return resp, err
+}
+
+func (c *EtcdClient) do(req *http.Request) (*EtcdResponse, error) {
+	// watches always answer with a new event, so only plain reads are made
+	// conditional
+	conditional := c.validators != nil && req.Method == "GET" && req.URL.Query().Get("wait") == ""
+	if conditional {
+		c.validators.prepare(req)
+	}
+	if c.credentials != nil {
+		req.SetBasicAuth(c.credentials.username, c.credentials.password)
+	}
+	if resp, err := c.client.Do(c.stats.trace(req)); err != nil {
+		return nil, err
+	} else {
+		defer resp.Body.Close()
+		c.stats.record(resp)
+		if body, err := ioutil.ReadAll(resp.Body); err != nil {
+			return nil, err
+		} else {
+			c.responses.add(newExchange(req, resp, body))
+			status := resp.StatusCode
+			if conditional {
+				status, body = c.validators.resolve(req, resp, body)
+			}
+			if c.recorder != nil {
+				c.recorder.add(c.baseUrl, req, status, resp.Header, body)
+			}
+			response := &EtcdResponse{StatusCode: status}
+			if index, err := strconv.Atoi(resp.Header.Get("X-Etcd-Index")); err == nil {
+				response.EtcdIndex = index
+				c.observeIndex(index)
+			}
+			if status == http.StatusTooManyRequests {
+				return nil, ErrRateLimited
+			}
+			failed := status < 200 || status > 299
+			if err := json.Unmarshal(body, response); err != nil {
+				if failed {
+					text := strings.TrimSpace(string(body))
+					if len(text) > 128 {
+						text = text[:128] + "..."
+					}
+					return nil, &StatusError{StatusCode: status, Body: text}
+				}
+				return nil, err
+			}
+			if failed && response.ErrorCode == 0 {
+				// an error code of 0 is success, which this is not
+				if status == http.StatusUnauthorized || status == http.StatusForbidden {
+					response.ErrorCode = codeUnauthorized
+				} else {
+					return nil, &StatusError{StatusCode: status, Body: response.Message}
+				}
+			}
+			return response, nil
+		}
+	}
+}
+
+var leaderCount int32 = 0
+
+var std = newLogger(os.Stdout, LevelInfo)
+
+// loop runs one iteration of the election. ctx cancels its requests, and the
+// watch of an observing candidate.
+func loop(ctx context.Context, state *State, client *EtcdClient) bool {
+	leaderKey := state.leaderKey()
+	if state.observer {
+		if state.watch == nil {
+			state.watch = newWatcher(ctx, client, state.watchKey())
+			atomic.AddInt32(&state.usage.watches, 1)
+		}
+		state.setPhase(PhaseIdle, "observing")
+		resp, err := state.watch.Next()
+		if err != nil && !answered(err) {
+			if ctx.Err() != nil {
+				// cut short by Stop or Close
+				return false
+			}
+			state.fail(err)
+			return false
+		}
+		var node *Node
+		if err == nil {
+			node = &resp.Node
+		}
+		observe(state, node)
+		return true
+	}
+	state.follow = 0
+	if phase := state.phase(); phase == PhaseIdle || phase == PhaseDemoted {
+		state.campaign = campaign{start: time.Now()}
+		state.setPhase(PhaseCampaigning, "campaigning")
+	}
+	if state.fair && state.phase() == PhaseCampaigning {
+		if err := enlist(ctx, state, client); err != nil && ctx.Err() == nil {
+			state.fail(err)
+		}
+	}
+	resp, err := state.read(ctx, client, "leader")
+	if err != nil && !answered(err) {
+		if ctx.Err() != nil {
+			return false
+		}
+		state.fail(err)
+		return false
+	}
+	// any other error etcd answered with leaves the lock neither free nor
+	// held, and the candidate waits for the next iteration
+	free, held := errors.Is(err, ErrKeyNotFound), err == nil
+	if free {
+		state.see("")
+	} else if held {
+		holder := decodeRecord(resp.Node.Value).ID
+		state.see(holder)
+		if holder != state.id && state.phase() == PhaseCampaigning {
+			state.campaign.contend(holder)
+			state.campaign.deferred = time.Time{}
+		}
+	}
+	if state.isLeader() {
+		// a leader that stalled past its TTL finds the lock gone, or taken
+		if free {
+			demote(state, "lock expired")
+			state.sleep(state.backoff)
+			return true
+		} else if holder := decodeRecord(resp.Node.Value).ID; held && holder != state.id {
+			demote(state, "lock taken over by "+holder)
+			state.sleep(state.backoff)
+			return true
+		} else if held && !state.owns(resp.Node.Value) {
+			demote(state, "lock taken over by another process running as "+holder)
+			state.fail(ErrDuplicateID)
+			state.sleep(state.backoff)
+			return true
+		} else if held && client.v3 != nil && resp.Node.CreatedIndex != state.lastTerm() {
+			// same id, but not our lock: etcd v3 revisions identify it
+			demote(state, fmt.Sprintf("lock recreated at revision %d", resp.Node.CreatedIndex))
+			state.sleep(state.backoff)
+			return true
+		}
+	}
+	if free {
+		if state.clock != nil && state.clock.refuses(state.ttl) {
+			state.event(LevelWarn, evClockRefused)
+			return true
+		}
+		if err := state.gated(ctx); err != nil {
+			state.eventStr(LevelDebug, evGated, err.Error())
+			// a fair contender that may not lead gives up its place
+			withdraw(ctx, state, client)
+			return true
+		}
+		if state.fair && yields(ctx, state, client) {
+			return true
+		}
+		if throttled(ctx, state, client) {
+			return true
+		}
+		if state.hesitation > 0 && !state.sleepUntil(state.hesitation, state.stop) {
+			return true
+		}
+		state.event(LevelDebug, evNoLock)
+		state.campaign.attempts++
+		if state.metrics != nil {
+			state.metrics.campaigned(state.key)
+		}
+		ctx, trace := state.traceCampaign(ctx, client)
+		defer trace.end()
+		failed := func(reason AttemptFailure, err error) {
+			trace.outcome, trace.err = string(reason), err
+			detail := ""
+			if err != nil {
+				detail = err.Error()
+			}
+			state.attemptFailed(reason, detail)
+		}
+		if client.v3 != nil {
+			nonce, err := newNonce()
+			if err == nil {
+				err = state.setNonce(nonce)
+			}
+			if err != nil {
+				failed(attemptFailure(err), err)
+				state.fail(err)
+				return false
+			}
+		}
+		sent := time.Now()
+		option := Option{ttl: state.ttl, prevExist: -1, origin: "campaign"}
+		var resp, announced *EtcdResponse
+		var err error
+		if state.compat == "" {
+			resp, announced, err = client.acquire(ctx, leaderKey, state.broadcastKey(), state.encoded(), option, Option{ttl: state.broadcastTTL, origin: "broadcast"})
+		} else {
+			// during a layout upgrade the lock is taken in both layouts
+			// first, so the broadcast cannot go along with it
+			resp, err = client.Put(ctx, leaderKey, state.encoded(), option)
+		}
+		if err == ErrReadOnly || errors.Is(err, ErrUnauthorized) {
+			failed(FailureAuth, err)
+			state.event(LevelWarn, evObserveOnly)
+			state.observer = true
+			return true
+		}
+		if err != nil && !answered(err) {
+			if ctx.Err() != nil {
+				return false
+			}
+			failed(attemptFailure(err), err)
+			state.fail(err)
+			return false
+		}
+		if err != nil {
+			failed(attemptFailure(err), err)
+		} else {
+			acquired := resp.Node.ModifiedIndex
+			if announced != nil {
+				// the broadcast went along with the lock, so no other
+				// candidate can have announced itself since
+				settle(state, announced.PrevNode)
+			} else {
+				if ok, err := acquireCompat(ctx, state, client); err != nil {
+					if ctx.Err() != nil {
+						return false
+					}
+					failed(attemptFailure(err), err)
+					state.fail(err)
+					return false
+				} else if !ok {
+					failed(FailureKeyExists, nil)
+					return true
+				}
+				if ok, err := takeover(ctx, state, client, acquired); err != nil {
+					if ctx.Err() != nil {
+						return false
+					}
+					failed(attemptFailure(err), err)
+					state.fail(err)
+					return false
+				} else if !ok {
+					failed(FailureTakeoverConflict, nil)
+					return true
+				}
+				var ok bool
+				if announced, ok = announce(ctx, state, client); !ok {
+					failed(FailureBroadcast, nil)
+					return true
+				}
+			}
+			trace.outcome = "acquired"
+			trace.span.SetFields(Field{"term", acquired})
+			state.confirm(sent)
+			state.acquire(acquired)
+			count := atomic.AddInt32(&leaderCount, 1)
+			state.eventInt(LevelInfo, evGain, int64(count))
+			previous, reason := "", "acquired"
+			if announced == nil {
+				reason = "acquired without broadcast"
+			} else if announced.PrevNode != nil {
+				previous = decodeRecord(announced.PrevNode.Value).ID
+			}
+			withdraw(ctx, state, client)
+			// EventElected reports the change of leader
+			state.seen.Store(state.id)
+			state.setLeader(true, previous, reason)
+			beat(ctx, state, client)
+		}
+	} else if held && state.handedOver(resp.Node.Value) {
+		return adopt(ctx, state, client, resp.Node)
+	} else if held {
+		// an acquisition PUT that timed out but was applied is recognized
+		// here by our id, and on v3 by the nonce of the attempt; the
+		// renewal below then takes it up as won, as it does our lock from
+		// before a restart
+		if state.owns(resp.Node.Value) {
+			if nonce := decodeRecord(resp.Node.Value).Nonce; nonce != state.nonce {
+				// our lock from before a restart: take on its nonce, so
+				// that the renewal's compare on the value holds
+				if err := state.setNonce(nonce); err != nil {
+					state.fail(err)
+					return false
+				}
+			}
+			state.event(LevelDebug, evIsLeader)
+			if atomic.CompareAndSwapInt32(&state.resign, 1, 0) {
+				if err := resign(ctx, state, client, state.clearBroadcast); err != nil {
+					if ctx.Err() != nil {
+						return false
+					}
+					state.fail(err)
+					return false
+				}
+				state.sleep(state.backoff)
+				return true
+			}
+			if rand.Float32() < state.chaos {
+				// simulate high latency - sleep
+				state.eventInt(LevelInfo, evLosing, int64(atomic.LoadInt32(&leaderCount)))
+				state.sleep(state.ttl * 2)
+			}
+			ctx, trace := state.trace(ctx, client, "renew", Field{"term", state.currentTerm()})
+			defer trace.end()
+			sent := time.Now()
+			_, err := client.Put(
+				ctx,
+				leaderKey,
+				state.encoded(),
+				// compare on the value rather than the index, since the lease
+				// may also be renewed from the application via KeepAliveOnce
+				state.renewal("renew"),
+			)
+			if err == nil {
+				err = renewCompat(ctx, state, client, "renew")
+			}
+			if err != nil && !answered(err) {
+				if ctx.Err() != nil {
+					return false
+				}
+				trace.outcome, trace.err = "error", err
+				state.renewFailed(err)
+				state.fail(err)
+				return false
+			}
+			if err == nil {
+				trace.outcome = "renewed"
+				state.confirm(sent)
+				state.event(LevelDebug, evRenewed)
+				if state.metrics != nil {
+					state.metrics.renewed(state.key, true)
+				}
+				if !state.isLeader() {
+					reclaim(ctx, state, client, resp.Node)
+				}
+				beat(ctx, state, client)
+				if err := backfill(ctx, state, client); err != nil {
+					if ctx.Err() != nil {
+						return false
+					}
+					state.fail(err)
+					return false
+				}
+				handOff(ctx, state, client)
+			} else {
+				reason := err.Error()
+				trace.outcome = "lost"
+				trace.span.SetFields(Field{"reason", reason})
+				state.eventStr(LevelDebug, evRenewFailed, reason)
+				state.renewFailed(err)
+				if state.isLeader() {
+					demote(state, reason)
+				}
+				if errors.Is(err, ErrUnauthorized) {
+					state.event(LevelWarn, evObserveOnly)
+					state.observer = true
+					return true
+				}
+				state.sleep(state.backoff)
+			}
+		} else {
+			state.event(LevelDebug, evNotLeader)
+			if decodeRecord(resp.Node.Value).ID == state.id {
+				state.fail(ErrDuplicateID)
+			}
+			preempting := preempt(ctx, state, client, decodeRecord(resp.Node.Value))
+			if state.compat == "" && !state.fair && !preempting {
+				// during an upgrade the lock may be in either layout, so
+				// only the poll catches its release; fair candidates poll
+				// to keep their contender key alive, and preempting ones
+				// their request
+				state.follow = resp.EtcdIndex
+			}
+		}
+	}
+	return true
+}
+
+// owns reports whether the lock value names this candidate: its id, and with
+// ExclusiveID this very process. A value carrying the nonce of the
+// candidate's last acquisition attempt is ours whatever the rest says; a
+// value written without an instance by an older release counts as ours too.
+func (s *State) owns(value string) bool {
+	r := decodeRecord(value)
+	if r.ID != s.id {
+		return false
+	}
+	if r.Nonce != "" && r.Nonce == s.nonce {
+		// written by an acquisition attempt of this candidate
+		return true
+	}
+	return s.instance == "" || r.Instance == "" || r.Instance == s.instance
+}
+
+// reclaim promotes a candidate that found the lock holding its own value
+// while not leader, and just renewed it. The lock keeps the term it was
+// created with.
+func reclaim(ctx context.Context, state *State, client *EtcdClient, node Node) {
+	state.acquire(node.CreatedIndex)
+	count := atomic.AddInt32(&leaderCount, 1)
+	state.eventInt(LevelInfo, evGain, int64(count))
+	withdraw(ctx, state, client)
+	state.seen.Store(state.id)
+	state.setLeader(true, "", "reclaimed")
+}
+
+// demote records that the candidate lost the lock it held.
+func demote(state *State, reason string) {
+	count := atomic.AddInt32(&leaderCount, -1)
+	state.eventInt(LevelInfo, evLost, int64(count))
+	state.setLeader(false, state.id, reason)
+}
+
+// reasonResigned is the Transition reason of a candidate giving up its lock.
+const reasonResigned = "resigned"
+
+// resign releases the lock held by state with a compare-and-delete on its own
+// value, and with clearBroadcast its broadcast key too. The candidate counts
+// as resigned even if the delete fails, since its lock then expires on its
+// own.
+func resign(ctx context.Context, state *State, client *EtcdClient, clearBroadcast bool) error {
+	pinned := state.currentTerm()
+	ctx, trace := state.trace(ctx, client, "resign", Field{"term", pinned})
+	defer trace.end()
+	state.setPhase(PhaseResigning, "resigning")
+	_, err := client.Delete(ctx, state.leaderKey(), state.encoded(), Option{prevCreated: pinned, origin: "resign"})
+	releaseCompat(ctx, state, client, "resign")
+	if clearBroadcast {
+		for _, key := range []string{state.broadcastKey(), state.compatKey("broadcast")} {
+			if key == "" {
+				continue
+			}
+			// a successor may have announced itself already
+			if _, err := client.Delete(ctx, key, state.encoded(), Option{origin: "resign"}); err != nil && !answered(err) {
+				state.fail(err)
+			}
+		}
+	}
+	count := atomic.AddInt32(&leaderCount, -1)
+	state.eventInt(LevelInfo, evLost, int64(count))
+	state.setLeader(false, state.id, reasonResigned)
+	trace.outcome, trace.err = reasonResigned, err
+	return err
+}
+
+// takeover runs between acquiring the lock at index acquired and activating
+// as leader. If another candidate has announced itself on the broadcast key
+// since then, the lock is released again and takeover returns false.
+// Otherwise the broadcast key is either absent or names a stale leader, and
+// takeover waits out the grace period before returning true.
+func takeover(ctx context.Context, state *State, client *EtcdClient, acquired int) (bool, error) {
+	resp, err := state.read(ctx, client, "broadcast")
+	if err != nil && !answered(err) {
+		return false, err
+	}
+	var broadcast *Node
+	if err == nil {
+		broadcast = &resp.Node
+	}
+	if announced := decodeRecord(resp.Node.Value).ID; broadcast != nil && announced != state.id && broadcast.ModifiedIndex > acquired {
+		state.eventStr(LevelWarn, evTakeoverConflict, announced)
+		_, err := client.Delete(ctx, state.leaderKey(), state.encoded(), Option{prevIndex: acquired, origin: "takeover"})
+		releaseCompat(ctx, state, client, "takeover")
+		if answered(err) {
+			// the lock is no longer the one acquired
+			err = nil
+		}
+		return false, err
+	}
+	settle(state, broadcast)
+	return true, nil
+}
+
+// settle waits out the takeover grace after the lock was acquired, unless the
+// broadcast key already named the candidate, as when it takes back the lock
+// it held before a restart.
+func settle(state *State, broadcast *Node) {
+	if state.takeoverGrace > 0 && (broadcast == nil || decodeRecord(broadcast.Value).ID != state.id) {
+		state.sleep(time.Duration(float64(state.ttl) * state.takeoverGrace))
+	}
+}
+
+// BroadcastPolicy decides what a candidate that just acquired the lock does
+// when it cannot write the broadcast key.
+type BroadcastPolicy string
+
+const (
+	// BroadcastRetry retries the write with backoff for up to about half
+	// the TTL, then resigns.
+	BroadcastRetry BroadcastPolicy = "retry"
+	// BroadcastResign releases the lock right away.
+	BroadcastResign BroadcastPolicy = "resign"
+	// BroadcastDegraded becomes leader anyway, leaving the broadcast key
+	// to be backfilled on the next renewal.
+	BroadcastDegraded BroadcastPolicy = "degraded"
+)
+
+// broadcastRetries is the number of retries under BroadcastRetry. Starting at
+// a sixteenth of the TTL and doubling, they take just under half of it.
+const broadcastRetries = 3
+
+// announce writes the broadcast key after the lock was acquired, handling a
+// failure according to the candidate's BroadcastPolicy. It returns the
+// response of the write, or nil if the candidate became leader without one,
+// and false if the lock was released instead.
+//
+// On etcd v2, and during a layout upgrade, the lock and the broadcast key are
+// written by two separate requests, so observers can briefly see them
+// disagree: the v2 keys API has no multi-key transaction to close that
+// window. The v3 backend writes both in one transaction (see acquire), and
+// only announces this way when adopting a lock handed over by TransferTo.
+func announce(ctx context.Context, state *State, client *EtcdClient) (*EtcdResponse, bool) {
+	retries := 0
+	if state.broadcastFailure == BroadcastRetry {
+		retries = broadcastRetries
+	}
+	delay := state.ttl / 16
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Put(ctx, state.broadcastKey(), state.encoded(), Option{ttl: state.broadcastTTL, origin: "broadcast"})
+		if err == nil {
+			mirror(ctx, state, client, "broadcast", Option{ttl: state.broadcastTTL, origin: "broadcast"})
+			return resp, true
+		}
+		state.eventStr(LevelWarn, evBroadcastFailed, err.Error())
+		if attempt == retries {
+			break
+		}
+		state.sleep(delay)
+		delay *= 2
+	}
+	if state.broadcastFailure == BroadcastDegraded {
+		state.event(LevelWarn, evBroadcastDegraded)
+		return nil, true
+	}
+	state.event(LevelWarn, evResigned)
+	state.setPhase(PhaseResigning, "broadcast failed")
+	if _, err := client.Delete(ctx, state.leaderKey(), state.encoded(), Option{origin: "resign"}); err != nil && !answered(err) {
+		state.fail(err)
+	}
+	releaseCompat(ctx, state, client, "resign")
+	state.setPhase(PhaseDemoted, "broadcast failed")
+	state.sleep(state.backoff)
+	return nil, false
+}
+
+// backfill rewrites the broadcast key of a renewing leader if it is missing
+// or names someone else, e.g. after it was deleted by hand or the broadcast
+// after acquisition failed.
+func backfill(ctx context.Context, state *State, client *EtcdClient) error {
+	resp, err := client.Get(ctx, state.broadcastKey(), Option{})
+	if err != nil && !answered(err) {
+		return err
+	}
+	option := Option{ttl: state.broadcastTTL, origin: "backfill"}
+	if err == nil && resp.Node.Value == state.encoded() {
+		if state.broadcastTTL == 0 {
+			return nil
+		}
+		// keep it from expiring without waking those following it
+		if _, err := client.Put(ctx, state.broadcastKey(), state.encoded(), Option{ttl: state.broadcastTTL, refresh: true, prevValue: state.encoded(), origin: "broadcast"}); err != nil {
+			return err
+		}
+		mirror(ctx, state, client, "broadcast", option)
+		return nil
+	}
+	state.event(LevelInfo, evBackfill)
+	if _, err := client.Put(ctx, state.broadcastKey(), state.encoded(), option); err != nil {
+		return err
+	}
+	mirror(ctx, state, client, "broadcast", option)
+	return nil
+}
+
+// beat refreshes the leader's heartbeat key. The heartbeat has a shorter TTL
+// than the lock, so a leader that stalls shows up as a present lock without a
+// heartbeat well before the lock expires.
+func beat(ctx context.Context, state *State, client *EtcdClient) {
+	if state.heartbeat == 0 {
+		return
+	}
+	if _, err := client.Put(ctx, state.heartbeatKey(), state.encoded(), Option{ttl: state.heartbeat, origin: "heartbeat"}); err != nil && !answered(err) {
+		state.fail(err)
+		return
+	}
+	mirror(ctx, state, client, "heartbeat", Option{ttl: state.heartbeat, origin: "heartbeat"})
+}
+
+// observe tracks the current leader for a candidate that cannot campaign.
+func observe(state *State, node *Node) {
+	leader := ""
+	var metadata map[string]string
+	if node != nil && node.Value != "" {
+		announcement, err := DecodeAnnouncement(state.key, node.Value, state.verifier, state.cipher)
+		if err != nil && err != ErrSealedMetadata {
+			state.eventStr(LevelWarn, evUnverified, err.Error())
+		} else {
+			leader = announcement.ID
+			metadata = announcement.Metadata
+		}
+	}
+	if leader != state.observed {
+		state.observed = leader
+		state.eventStr(LevelInfo, evObserved, leader)
+	}
+
+	status := LeaderStatus{Leader: leader, Exists: leader != "", Checked: time.Now()}
+	if status.Exists && node.Expiration != nil {
+		status.Expires = *node.Expiration
+	}
+	status.Responsive = status.Exists
+	if status.Exists && state.health != nil {
+		if err := state.health.check(metadata); err != nil {
+			status.Responsive = false
+			status.Error = err.Error()
+		}
+	}
+	state.setStatus(status)
+}
@@ -0,0 +1,74 @@
+package election
+
+import (
+	"context"
+	"time"
+)
+
+// Lease is held leadership of one election. The election loop renews it in
+// the background; KeepAliveOnce lets the application renew it as well.
+type Lease struct {
+	state  *State
+	client *EtcdClient
+	// how the leadership the lease was handed out for was won
+	acquisition Acquisition
+}
+
+func (l *Lease) Key() string {
+	return l.state.key
+}
+
+// Term returns the term of the leadership the lease was handed out for, for
+// fencing writes; see Elector.Term. It stays the same after the lease is
+// lost, even if the candidate is elected again.
+func (l *Lease) Term() int {
+	return l.acquisition.Term
+}
+
+// Acquisition returns how the leadership the lease was handed out for was
+// won.
+func (l *Lease) Acquisition() Acquisition {
+	return l.acquisition
+}
+
+// KeepAliveOnce renews the lease immediately, confirming the leadership as
+// the election loop's renewals do. Processes that are too busy to let the
+// election goroutine run on time can call it from their own work loop. It
+// fails with ErrNotLeader once the leadership the lease was handed out for
+// is over, even if the candidate was elected again since.
+func (l *Lease) KeepAliveOnce(ctx context.Context) error {
+	if !l.state.isLeader() || l.state.currentTerm() != l.acquisition.Term {
+		return ErrNotLeader
+	}
+	sent := time.Now()
+	_, err := l.client.Put(
+		ctx,
+		l.state.leaderKey(),
+		l.state.encoded(),
+		l.state.renewal("keepalive"),
+	)
+	if err == nil {
+		err = renewCompat(ctx, l.state, l.client, "keepalive")
+	}
+	if err != nil {
+		return err
+	}
+	l.state.confirm(sent)
+	return nil
+}
+
+// Heartbeat refreshes the leader's heartbeat key from the application, so
+// that the heartbeat tracks the application's progress rather than only the
+// election goroutine's.
+func (l *Lease) Heartbeat(ctx context.Context) error {
+	if l.state.heartbeat == 0 {
+		return nil
+	}
+	_, err := l.client.Put(
+		ctx,
+		l.state.heartbeatKey(),
+		l.state.encoded(),
+		Option{ttl: l.state.heartbeat, origin: "heartbeat"},
+	)
+	return err
+}
@@ -0,0 +1,53 @@
+package election_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+	"github.com/jeeyoungk/etcd-leader/leadertest"
+)
+
+// TestLeaseKeepAliveOnce renews a lease from the application while the
+// election loop is asleep on a fake clock: the renewal must confirm the
+// leadership for LeaseGuard, and fail once the leadership is over.
+func TestLeaseKeepAliveOnce(t *testing.T) {
+	server := leadertest.NewServer(t)
+	clock := server.FakeTime()
+	config := election.ElectionConfig{TTL: time.Second, LeaseGuard: true}
+	elector, err := election.New(election.NewEtcdClient(server.URL), "keepalive", "a", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { elector.Stop() })
+	elector.SetClock(clock)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lease, err := elector.Campaign(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if elector.IsLeader() {
+		t.Fatal("leader a TTL after its last renewal")
+	}
+	if err := lease.KeepAliveOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !elector.IsLeader() {
+		t.Fatal("not leader after renewing the lease")
+	}
+
+	if err := elector.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := lease.KeepAliveOnce(ctx); !errors.Is(err, election.ErrNotLeader) {
+		t.Fatalf("renewal after stopping: %v, want ErrNotLeader", err)
+	}
+	if leader := server.Leader("keepalive"); leader != "" {
+		t.Fatalf("renewal after stopping left %q holding the lock", leader)
+	}
+}
@@ -0,0 +1,329 @@
+package election
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// event is a preformatted log line; the election loop logs events instead of
+// format strings so that a disabled level costs a single atomic load.
+type event uint8
+
+const (
+	evNoLock event = iota
+	evGain
+	evIsLeader
+	evLosing
+	evRenewed
+	evRenewFailed
+	evLost
+	evNotLeader
+	evError
+	evAlertFailed
+	evAlert
+	evAnnotateFailed
+	evObserveOnly
+	evObserved
+	evTakeoverConflict
+	evUnverified
+	evSkipped
+	evAuditFailed
+	evWatchStale
+	evDemoted
+	evBroadcastFailed
+	evBroadcastDegraded
+	evResigned
+	evBackfill
+	evWatchReissued
+	evLocalLock
+	evHookPanic
+	evShutdown
+	evShutdownFailed
+	evHistoryFailed
+	evTransferred
+	evClockSkew
+	evClockRefused
+	evPhase
+	evRecordFailed
+	evAcquired
+	evFailover
+	evDeferred
+	evRetrying
+	evGaveUp
+	evGated
+	evWire
+	evSuccessor
+	evHandshakeMissed
+	evAttemptFailed
+	evPreempting
+	evPreempted
+	evThrottled
+	evRemedied
+	evRemedyFailed
+)
+
+var eventText = [...]string{
+	evNoLock:            "no lock - attempt to PUT",
+	evGain:              "-> gain",
+	evIsLeader:          "lock present - is leader",
+	evLosing:            "-- losing",
+	evRenewed:           "renewed",
+	evRenewFailed:       "failed to renew",
+	evLost:              "<- lost",
+	evNotLeader:         "lock present - not leader",
+	evError:             "error",
+	evAlertFailed:       "alert delivery failed",
+	evAlert:             "alert",
+	evAnnotateFailed:    "annotation failed",
+	evObserveOnly:       "credentials are read-only - observing only",
+	evObserved:          "leader",
+	evTakeoverConflict:  "broadcast claimed after acquisition by",
+	evUnverified:        "ignoring unverified leader",
+	evSkipped:           "not campaigning",
+	evAuditFailed:       "audit record failed",
+	evWatchStale:        "watch is lagging by",
+	evDemoted:           "forced demotion of",
+	evBroadcastFailed:   "broadcast failed",
+	evBroadcastDegraded: "leading without broadcast",
+	evResigned:          "<- resigned after failed broadcast",
+	evBackfill:          "rewriting missing or stale broadcast",
+	evWatchReissued:     "watch timed out - reissuing",
+	evLocalLock:         "waiting for another local process to release",
+	evHookPanic:         "transition hook failed",
+	evShutdown:          "shut down",
+	evShutdownFailed:    "shutdown failed",
+	evHistoryFailed:     "persisting history failed",
+	evTransferred:       "<- transferred leadership to",
+	evClockSkew:         "local clock is skewed against etcd by",
+	evClockRefused:      "clock skewed - not campaigning",
+	evPhase:             "phase",
+	evRecordFailed:      "recording failed",
+	evAcquired:          "acquired",
+	evFailover:          "endpoint failed",
+	evDeferred:          "lock free - deferring to longer-waiting",
+	evRetrying:          "etcd failed - retrying in",
+	evGaveUp:            "giving up on etcd after failures",
+	evGated:             "lock free - gate closed",
+	evWire:              "etcd",
+	evSuccessor:         "designated successor",
+	evHandshakeMissed:   "standby missed handshake -",
+	evAttemptFailed:     "campaign attempt failed -",
+	evPreempting:        "lock held by lower priority - asking to hand over",
+	evPreempted:         "preempted by higher priority",
+	evThrottled:         "lock free - no campaign token left in",
+	evRemedied:          "ran recovery action for",
+	evRemedyFailed:      "recovery action failed for",
+}
+
+// Logger receives the package's log lines as structured records, to route
+// them into the application's logging. msg is the fixed text of the kind of
+// line; fields carry the candidate id, election key and the term of the
+// candidate's latest leadership where known, then the line's value.
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+// Field is a named value of a log record.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// scope is where a log line comes from: the logger of the elector or
+// manager, if it has its own, and the candidate and election.
+type scope struct {
+	logger Logger
+	id     string
+	key    string
+	term   int64
+}
+
+// lineValue is the value a log line carries after its text: none, an int64
+// or a string. Unlike an interface{} it holds either without allocating, so
+// a line written as text is garbage-free; only a line sent to a Logger boxes
+// it in a Field.
+type lineValue struct {
+	kind byte
+	n    int64
+	s    string
+}
+
+const (
+	noValue byte = iota
+	intValue
+	strValue
+)
+
+type logger struct {
+	level int32
+	mu    sync.Mutex
+	out   io.Writer
+	buf   []byte
+	// the Logger set with SetLogger, as a loggerSink; written as text if
+	// unset
+	sink atomic.Value
+}
+
+type loggerSink struct{ Logger }
+
+func newLogger(out io.Writer, level Level) *logger {
+	return &logger{level: int32(level), out: out, buf: make([]byte, 0, 256)}
+}
+
+func (l *logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.level, int32(level))
+}
+
+func (l *logger) enabled(level Level) bool {
+	return int32(level) >= atomic.LoadInt32(&l.level)
+}
+
+// event logs "[id] [time] text".
+func (l *logger) event(level Level, id string, ev event) {
+	if l.enabled(level) {
+		l.record(level, scope{id: id}, ev, lineValue{})
+	}
+}
+
+// eventInt logs "[id] [time] text: n".
+func (l *logger) eventInt(level Level, id string, ev event, n int64) {
+	if l.enabled(level) {
+		l.record(level, scope{id: id}, ev, lineValue{kind: intValue, n: n})
+	}
+}
+
+// eventStr logs "[id] [time] text: s".
+func (l *logger) eventStr(level Level, id string, ev event, s string) {
+	if l.enabled(level) {
+		l.record(level, scope{id: id}, ev, lineValue{kind: strValue, s: s})
+	}
+}
+
+// record sends an enabled line to the Logger of its scope or the package,
+// or else writes it as text, with extra fields following its value. Callers
+// check the level first, so that a disabled line costs nothing.
+func (l *logger) record(level Level, sc scope, ev event, value lineValue, extra ...Field) {
+	to := sc.logger
+	if to == nil {
+		if sink, ok := l.sink.Load().(loggerSink); ok {
+			to = sink.Logger
+		}
+	}
+	if to == nil {
+		l.mu.Lock()
+		buf := l.header(sc.id, ev)
+		switch value.kind {
+		case intValue:
+			buf = strconv.AppendInt(append(buf, ": "...), value.n, 10)
+		case strValue:
+			buf = append(append(buf, ": "...), value.s...)
+		}
+		for i, f := range extra {
+			if i == 0 && sc.key != "" {
+				buf = append(append(append(buf, ": "...), sc.key...), ' ')
+			} else if i == 0 {
+				buf = append(buf, ": "...)
+			} else {
+				buf = append(buf, ' ')
+			}
+			buf = append(append(buf, f.Key...), '=')
+			// JSON, such as a response body in the wire log, is left as
+			// it is
+			if s, ok := f.Value.(string); ok && (s == "" || strings.ContainsAny(s, " \t\n\"") && s[0] != '{') {
+				buf = strconv.AppendQuote(buf, s)
+			} else {
+				buf = append(buf, fmt.Sprint(f.Value)...)
+			}
+		}
+		l.write(buf)
+		l.mu.Unlock()
+		return
+	}
+	fields := make([]Field, 0, 4+len(extra))
+	if sc.id != "" {
+		fields = append(fields, Field{"id", sc.id})
+	}
+	if sc.key != "" {
+		fields = append(fields, Field{"key", sc.key})
+	}
+	if sc.term != 0 {
+		fields = append(fields, Field{"term", sc.term})
+	}
+	switch value.kind {
+	case intValue:
+		fields = append(fields, Field{"value", value.n})
+	case strValue:
+		fields = append(fields, Field{"value", value.s})
+	}
+	fields = append(fields, extra...)
+	to.Log(level, eventText[ev], fields...)
+}
+
+func (l *logger) error(id string, err error) {
+	l.eventStr(LevelError, id, evError, err.Error())
+}
+
+func (l *logger) header(id string, ev event) []byte {
+	buf := append(l.buf[:0], '[')
+	buf = append(buf, id...)
+	buf = append(buf, "] ["...)
+	buf = time.Now().AppendFormat(buf, "Jan 2 15:04:05")
+	buf = append(buf, "] "...)
+	return append(buf, eventText[ev]...)
+}
+
+func (l *logger) write(buf []byte) {
+	buf = append(buf, '\n')
+	l.out.Write(buf)
+	l.buf = buf
+}
+
+// SetLogLevel sets the level below which the package's log lines are dropped,
+// whichever Logger they go to.
+func SetLogLevel(level Level) {
+	std.SetLevel(level)
+}
+
+// SetLogger sends the package's log lines to l instead of writing them to
+// stdout, except for those of electors and managers given a Logger of their
+// own. nil restores the text output.
+func SetLogger(l Logger) {
+	std.sink.Store(loggerSink{l})
+}
+
+// event logs a line of the election loop of s.
+func (s *State) event(level Level, ev event) {
+	if std.enabled(level) {
+		std.record(level, s.scope(), ev, lineValue{})
+	}
+}
+
+func (s *State) eventInt(level Level, ev event, n int64) {
+	if std.enabled(level) {
+		std.record(level, s.scope(), ev, lineValue{kind: intValue, n: n})
+	}
+}
+
+func (s *State) eventStr(level Level, ev event, str string) {
+	if std.enabled(level) {
+		std.record(level, s.scope(), ev, lineValue{kind: strValue, s: str})
+	}
+}
+
+func (s *State) scope() scope {
+	return scope{logger: s.logger, id: s.id, key: s.key, term: int64(s.lastTerm())}
+}
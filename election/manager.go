@@ -0,0 +1,475 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Manager campaigns for many shards on behalf of a single node.
+type Manager struct {
+	client *EtcdClient
+	id     string
+
+	// mu guards everything below
+	mu       sync.Mutex
+	config   ManagerConfig
+	hooks    []func(Transition)
+	phased   []func(PhaseChange)
+	signer   Signer
+	verifier Verifier
+	cipher   *MetadataCipher
+	health   *healthProbe
+	lockDir  string
+	panics   PanicPolicy
+	clock    *clockGuard
+	states   map[string]*State
+	// the Logger of the manager and its shards, as a loggerSink; read
+	// without mu since the manager logs while holding it
+	logger atomic.Value
+
+	// cancelled by Close, abandoning the requests in flight
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+// PanicPolicy decides what a shard does after an OnTransition hook panics.
+type PanicPolicy string
+
+const (
+	// PanicContinue logs the panic and carries on.
+	PanicContinue PanicPolicy = "continue"
+	// PanicResign also gives up the leadership the hook was told about,
+	// since the application may not have finished taking over.
+	PanicResign PanicPolicy = "resign"
+)
+
+func NewManager(client *EtcdClient, id string, ttl time.Duration, concurrency int) *Manager {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if id == "" {
+		id = client.Identity()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		client: client,
+		id:     id,
+		config: ManagerConfig{Concurrency: concurrency, Defaults: ElectionConfig{TTL: ttl}},
+		states: make(map[string]*State),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// NewManagerWithConfig returns a manager configured by config, which must be
+// valid.
+func NewManagerWithConfig(client *EtcdClient, id string, config ManagerConfig) (*Manager, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.strict(client); err != nil {
+		return nil, err
+	}
+	if id == "" {
+		if id = client.Identity(); id == "" {
+			return nil, errors.New("no node id, and no client certificate to take it from")
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		client: client,
+		id:     id,
+		config: config,
+		states: make(map[string]*State),
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+// ID returns the node id the manager campaigns with.
+func (m *Manager) ID() string {
+	return m.id
+}
+
+// SetChaos makes held leaderships stall past their TTL with probability p on
+// each renewal, to exercise failover. It applies to shards started afterwards.
+func (m *Manager) SetChaos(p float32) {
+	m.mu.Lock()
+	m.config.Defaults.Chaos = p
+	m.mu.Unlock()
+}
+
+// SetTakeoverGrace delays activation after acquiring a lock by fraction of
+// the TTL, unless this node was already the announced leader. It applies to
+// shards started afterwards.
+func (m *Manager) SetTakeoverGrace(fraction float64) {
+	m.mu.Lock()
+	m.config.Defaults.TakeoverGrace = fraction
+	m.mu.Unlock()
+}
+
+// SetSigner signs this node's leader announcements. It applies to shards
+// started afterwards.
+func (m *Manager) SetSigner(signer Signer) {
+	m.mu.Lock()
+	m.signer = signer
+	m.mu.Unlock()
+}
+
+// SetVerifier makes shards that fall back to observing ignore leader values
+// whose signature does not verify. It applies to shards started afterwards.
+func (m *Manager) SetVerifier(verifier Verifier) {
+	m.mu.Lock()
+	m.verifier = verifier
+	m.mu.Unlock()
+}
+
+// SetMetadataCipher encrypts the metadata announced by this node, and
+// decrypts it for shards that fall back to observing. It applies to shards
+// started afterwards.
+func (m *Manager) SetMetadataCipher(cipher *MetadataCipher) {
+	m.mu.Lock()
+	m.cipher = cipher
+	m.mu.Unlock()
+}
+
+// SetHealthProbe makes shards that fall back to observing request the health
+// URL the leader advertises under metadata entry key, so that LeaderStatus
+// tells a wedged leader from a responsive one. It applies to shards started
+// afterwards.
+func (m *Manager) SetHealthProbe(key string, timeout time.Duration) {
+	m.mu.Lock()
+	m.health = newHealthProbe(key, timeout)
+	m.mu.Unlock()
+}
+
+// SetLogger sends the log lines of the manager, and of shards started
+// afterwards, to l instead of the package's logger.
+func (m *Manager) SetLogger(l Logger) {
+	m.logger.Store(loggerSink{l})
+}
+
+// eventStr logs a line of the manager itself.
+func (m *Manager) eventStr(level Level, ev event, s string) {
+	if std.enabled(level) {
+		std.record(level, scope{logger: m.ownLogger(), id: m.id}, ev, lineValue{kind: strValue, s: s})
+	}
+}
+
+func (m *Manager) ownLogger() Logger {
+	sink, _ := m.logger.Load().(loggerSink)
+	return sink.Logger
+}
+
+// SetLockDir makes every shard take an exclusive file lock under dir before
+// campaigning, so that only one process per host campaigns with this node's
+// id. It applies to shards started afterwards.
+func (m *Manager) SetLockDir(dir string) {
+	m.mu.Lock()
+	m.lockDir = dir
+	m.mu.Unlock()
+}
+
+// OnTransition registers fn to be called from the election goroutine after
+// every leadership change of this node. A panic in fn is recovered and
+// handled according to the PanicPolicy.
+func (m *Manager) OnTransition(fn func(Transition)) {
+	m.mu.Lock()
+	m.hooks = append(m.hooks, fn)
+	m.mu.Unlock()
+}
+
+// OnPhase registers fn to be called from the election goroutine after every
+// phase change of this node's candidates. A panic in fn is recovered and
+// logged.
+func (m *Manager) OnPhase(fn func(PhaseChange)) {
+	m.mu.Lock()
+	m.phased = append(m.phased, fn)
+	m.mu.Unlock()
+}
+
+// SetPanicPolicy decides what happens after an OnTransition hook panics. The
+// default is PanicContinue.
+func (m *Manager) SetPanicPolicy(policy PanicPolicy) {
+	m.mu.Lock()
+	m.panics = policy
+	m.mu.Unlock()
+}
+
+func (m *Manager) transition(t Transition) {
+	m.mu.Lock()
+	hooks := m.hooks
+	policy := m.panics
+	state := m.states[t.Key]
+	m.mu.Unlock()
+	for _, hook := range hooks {
+		if err := callHook(func() { hook(t) }); err != nil {
+			m.eventStr(LevelError, evHookPanic, t.Key+": "+err.Error())
+			if policy == PanicResign && t.Leader && state != nil {
+				state.abdicate()
+			}
+		}
+	}
+}
+
+func (m *Manager) phase(change PhaseChange) {
+	m.mu.Lock()
+	hooks := m.phased
+	m.mu.Unlock()
+	for _, hook := range hooks {
+		if err := callHook(func() { hook(change) }); err != nil {
+			m.eventStr(LevelError, evHookPanic, change.Key+": "+err.Error())
+		}
+	}
+}
+
+// callHook calls hook, turning a panic into an error so that a bug in
+// application code cannot take down every election of the manager.
+func callHook(hook func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("hook panicked: %v", r)
+		}
+	}()
+	hook()
+	return nil
+}
+
+// IsLeader reports whether this node currently holds the lock for shard; see
+// ElectionConfig.LeaseGuard.
+func (m *Manager) IsLeader(shard string) bool {
+	m.mu.Lock()
+	state, ok := m.states[shard]
+	m.mu.Unlock()
+	return ok && state.guarded()
+}
+
+// State returns this node's standing in the election of shard, or nil for
+// shards it does not campaign for.
+func (m *Manager) State(shard string) *State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.states[shard]
+}
+
+// Phase returns where this node stands in the election of shard, which is
+// PhaseIdle for shards it does not campaign for.
+func (m *Manager) Phase(shard string) Phase {
+	m.mu.Lock()
+	state, ok := m.states[shard]
+	m.mu.Unlock()
+	if !ok {
+		return PhaseIdle
+	}
+	return state.phase()
+}
+
+// Term returns the term of this node's leadership of shard, or 0 if it is not
+// leader of shard. See Elector.Term.
+func (m *Manager) Term(shard string) int {
+	m.mu.Lock()
+	state, ok := m.states[shard]
+	m.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	return state.currentTerm()
+}
+
+// LeaderStatus returns what this node knows about the leader of shard while
+// it is only observing that shard, and false otherwise.
+func (m *Manager) LeaderStatus(shard string) (LeaderStatus, bool) {
+	m.mu.Lock()
+	state, ok := m.states[shard]
+	m.mu.Unlock()
+	if !ok {
+		return LeaderStatus{}, false
+	}
+	return state.leaderStatus()
+}
+
+// Resources returns what the manager's elections hold in total.
+func (m *Manager) Resources() Resources {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var total Resources
+	for _, state := range m.states {
+		total = total.add(state.usage.snapshot())
+	}
+	return total
+}
+
+// Lease returns the lease for shard while this node is its leader, or nil.
+func (m *Manager) Lease(shard string) *Lease {
+	m.mu.Lock()
+	state, ok := m.states[shard]
+	m.mu.Unlock()
+	if !ok || !state.isLeader() {
+		return nil
+	}
+	return &Lease{state: state, client: m.client, acquisition: state.lastAcquisition()}
+}
+
+// Start campaigns for every shard in the background. At most Concurrency
+// first campaigns are in flight at once. Critical shards go first, and the
+// others wait until every critical shard has completed its first campaign;
+// within each group, shards that currently have no leader are campaigned for
+// before shards that do.
+func (m *Manager) Start(shards []string) {
+	states := make([]*State, 0, len(shards))
+	critical := make(map[*State]bool)
+	m.mu.Lock()
+	select {
+	case <-m.ctx.Done():
+		m.mu.Unlock()
+		m.eventStr(LevelError, evSkipped, ErrClosed.Error())
+		return
+	default:
+	}
+	for _, shard := range shards {
+		if _, ok := m.states[shard]; ok {
+			continue
+		}
+		if limit := m.config.MaxElections; limit > 0 && len(m.states) >= limit {
+			m.eventStr(LevelError, evSkipped, fmt.Sprintf("%s: limit of %d elections reached", shard, limit))
+			continue
+		}
+		config := m.config.Election(shard)
+		state, err := newState(shard, m.id, config, m.signer, m.verifier, m.cipher)
+		if err != nil {
+			m.eventStr(LevelError, evSkipped, shard+": "+err.Error())
+			continue
+		}
+		state.health = m.health
+		state.clock = m.clock
+		state.onTransition = m.transition
+		state.onPhase = m.phase
+		state.metrics = m.client.metrics
+		state.logger = m.ownLogger()
+		state.stop = m.ctx.Done()
+		m.states[shard] = state
+		states = append(states, state)
+		critical[state] = config.Critical
+	}
+	concurrency := m.config.Concurrency
+	lockDir := m.lockDir
+	clock := m.clock
+	m.running.Add(len(states))
+	m.mu.Unlock()
+	if clock != nil {
+		m.watchClock(clock)
+	}
+
+	go func() {
+		leaderless := m.probe(states, concurrency)
+		sort.SliceStable(states, func(i, j int) bool {
+			a, b := states[i], states[j]
+			if critical[a] != critical[b] {
+				return critical[a]
+			}
+			return leaderless[a] && !leaderless[b]
+		})
+		var settled sync.WaitGroup
+		slots := make(chan struct{}, concurrency)
+		for i, state := range states {
+			if !critical[state] {
+				settled.Wait()
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-m.ctx.Done():
+				for _, state := range states[i:] {
+					m.forget(state)
+					m.running.Done()
+				}
+				return
+			}
+			if critical[state] {
+				settled.Add(1)
+				go m.run(state, lockDir, func() { <-slots; settled.Done() })
+			} else {
+				go m.run(state, lockDir, func() { <-slots })
+			}
+		}
+	}()
+}
+
+// probe reports which shards have no leader key, with the same concurrency
+// bound as the campaigns themselves.
+func (m *Manager) probe(states []*State, concurrency int) map[*State]bool {
+	var (
+		mu         sync.Mutex
+		wg         sync.WaitGroup
+		leaderless = make(map[*State]bool, len(states))
+		slots      = make(chan struct{}, concurrency)
+	)
+	for _, state := range states {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(state *State) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if _, err := state.read(m.ctx, m.client, "leader"); errors.Is(err, ErrKeyNotFound) {
+				mu.Lock()
+				leaderless[state] = true
+				mu.Unlock()
+			}
+		}(state)
+	}
+	wg.Wait()
+	return leaderless
+}
+
+// run executes the election loop for one shard, under the shard's local lock
+// if lockDir is set. started is called once the first campaign attempt has
+// completed, or right away while waiting for the local lock.
+func (m *Manager) run(state *State, lockDir string, started func()) {
+	defer m.running.Done()
+	defer state.usage.hold(false)()
+	defer m.forget(state)
+	defer func() {
+		// a leader stays one until Close releases its lock
+		if !state.isLeader() {
+			ctx, cancel := context.WithTimeout(context.Background(), state.ttl)
+			withdraw(ctx, state, m.client)
+			cancel()
+			state.setPhase(PhaseIdle, "stopped")
+		}
+	}()
+	if lockDir != "" {
+		var once sync.Once
+		release := started
+		started = func() { once.Do(release) }
+		lock, err := lockLocal(lockDir, state.key)
+		if err == errLocked {
+			started()
+			lock, err = waitLocal(state, lockDir, m.ctx.Done())
+		}
+		if err != nil {
+			if err != ErrClosed {
+				state.fail(err)
+			}
+			started()
+			return
+		}
+		defer lock.release()
+	}
+	success := loop(m.ctx, state, m.client)
+	started()
+	for state.next(m.ctx, m.client, success, m.ctx.Done()) {
+		success = loop(m.ctx, state, m.client)
+	}
+}
+
+// forget removes a shard whose election loop has ended.
+func (m *Manager) forget(state *State) {
+	m.mu.Lock()
+	delete(m.states, state.key)
+	m.mu.Unlock()
+}
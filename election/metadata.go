@@ -0,0 +1,83 @@
+package election
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+)
+
+var ErrSealedMetadata = errors.New("leader metadata is encrypted and no key is configured")
+
+// MetadataCipher encrypts the metadata of leader values with AES-GCM under a
+// key shared by the candidates and the observers allowed to read it. The
+// election key and leader id are bound in as additional data, so sealed
+// metadata cannot be moved to another announcement.
+type MetadataCipher struct {
+	aead cipher.AEAD
+}
+
+// NewMetadataCipher takes a 16, 24 or 32 byte AES key.
+func NewMetadataCipher(key []byte) (*MetadataCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &MetadataCipher{aead: aead}, nil
+}
+
+func (c *MetadataCipher) seal(key string, id string, metadata map[string]string) ([]byte, error) {
+	plain, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plain, binding(key, id)), nil
+}
+
+func (c *MetadataCipher) open(key string, id string, sealed []byte) (map[string]string, error) {
+	size := c.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, errors.New("sealed leader metadata is truncated")
+	}
+	plain, err := c.aead.Open(nil, sealed[:size], sealed[size:], binding(key, id))
+	if err != nil {
+		return nil, err
+	}
+	var metadata map[string]string
+	return metadata, json.Unmarshal(plain, &metadata)
+}
+
+// Announcement is a decoded leader value.
+type Announcement struct {
+	ID       string
+	Metadata map[string]string
+}
+
+// DecodeAnnouncement decodes the value of the leader or broadcast key of the
+// election key, verifying it and decrypting its metadata. verifier and cipher
+// may be nil.
+func DecodeAnnouncement(key string, value string, verifier Verifier, cipher *MetadataCipher) (Announcement, error) {
+	r, err := verifyValue(key, value, verifier)
+	if err != nil {
+		return Announcement{ID: r.ID}, err
+	}
+	announcement := Announcement{ID: r.ID, Metadata: r.Meta}
+	if r.Sealed != nil {
+		if cipher == nil {
+			return announcement, ErrSealedMetadata
+		}
+		if announcement.Metadata, err = cipher.open(key, r.ID, r.Sealed); err != nil {
+			return announcement, err
+		}
+	}
+	return announcement, nil
+}
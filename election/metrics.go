@@ -0,0 +1,191 @@
+package election
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// otherKey is the label used for election keys past the cardinality cap.
+const otherKey = "_other"
+
+type opLabels struct {
+	key     string
+	op      string
+	outcome string
+}
+
+type opStat struct {
+	count   int64
+	latency time.Duration
+}
+
+// OpSample is the accumulated count and latency for one label combination.
+type OpSample struct {
+	Key     string        `json:"key"`
+	Op      string        `json:"op"`
+	Outcome string        `json:"outcome"`
+	Count   int64         `json:"count"`
+	Latency time.Duration `json:"latency"`
+}
+
+// Metrics counts etcd operations by election key, operation and outcome.
+// Only the first maxKeys distinct election keys (plus any allowlisted key)
+// get their own label; the rest are folded into "_other" so that a manager
+// with many dynamic shards keeps a bounded number of series.
+type Metrics struct {
+	maxKeys int
+	allow   map[string]bool
+
+	mu          sync.Mutex
+	keys        map[string]bool
+	ops         map[opLabels]*opStat
+	watchLag    map[string]int64
+	transitions map[transitionLabels]int64
+	// acquisition stats and campaign and renewal counts by key label
+	acquisitions map[string]*acquisitionStat
+	activity     map[string]*activityStat
+	// request latencies by operation, which unlike keys are few
+	latency map[string]*histogram
+}
+
+func NewMetrics(maxKeys int, allow ...string) *Metrics {
+	m := &Metrics{
+		maxKeys:      maxKeys,
+		allow:        make(map[string]bool, len(allow)),
+		keys:         make(map[string]bool),
+		ops:          make(map[opLabels]*opStat),
+		watchLag:     make(map[string]int64),
+		transitions:  make(map[transitionLabels]int64),
+		acquisitions: make(map[string]*acquisitionStat),
+		activity:     make(map[string]*activityStat),
+		latency:      make(map[string]*histogram),
+	}
+	for _, key := range allow {
+		m.allow[key] = true
+	}
+	return m
+}
+
+func (m *Metrics) keyLabel(key string) string {
+	if m.allow[key] || m.keys[key] {
+		return key
+	}
+	if len(m.keys) < m.maxKeys {
+		m.keys[key] = true
+		return key
+	}
+	return otherKey
+}
+
+func (m *Metrics) observe(key, op, outcome string, latency time.Duration) {
+	m.mu.Lock()
+	labels := opLabels{key: m.keyLabel(key), op: op, outcome: outcome}
+	stat, ok := m.ops[labels]
+	if !ok {
+		stat = &opStat{}
+		m.ops[labels] = stat
+	}
+	stat.count++
+	stat.latency += latency
+	h, ok := m.latency[op]
+	if !ok {
+		h = &histogram{}
+		m.latency[op] = h
+	}
+	h.add(latency)
+	m.mu.Unlock()
+}
+
+func (m *Metrics) setWatchLag(key string, lag int64) {
+	m.mu.Lock()
+	label := m.keyLabel(key)
+	if label == otherKey && lag < m.watchLag[label] {
+		// keep the worst lag among the folded keys
+		lag = m.watchLag[label]
+	}
+	m.watchLag[label] = lag
+	m.mu.Unlock()
+}
+
+// WatchLag returns the last reported lag of the watchers of each election
+// key, in etcd indexes.
+func (m *Metrics) WatchLag() map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lags := make(map[string]int64, len(m.watchLag))
+	for key, lag := range m.watchLag {
+		lags[key] = lag
+	}
+	return lags
+}
+
+// Snapshot returns all samples ordered by key, operation and outcome.
+func (m *Metrics) Snapshot() []OpSample {
+	m.mu.Lock()
+	samples := make([]OpSample, 0, len(m.ops))
+	for labels, stat := range m.ops {
+		samples = append(samples, OpSample{
+			Key:     labels.key,
+			Op:      labels.op,
+			Outcome: labels.outcome,
+			Count:   stat.count,
+			Latency: stat.latency,
+		})
+	}
+	m.mu.Unlock()
+	sort.Slice(samples, func(i, j int) bool {
+		a, b := samples[i], samples[j]
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		if a.Op != b.Op {
+			return a.Op < b.Op
+		}
+		return a.Outcome < b.Outcome
+	})
+	return samples
+}
+
+// electionKey maps an etcd key back to the election it belongs to.
+func electionKey(key string) string {
+	for _, name := range []string{"leader", "broadcast", "heartbeat"} {
+		for _, layout := range []Layout{LayoutFlat, LayoutDir} {
+			if suffix := layout.key("", name); strings.HasSuffix(key, suffix) {
+				return strings.TrimSuffix(key, suffix)
+			}
+		}
+	}
+	return key
+}
+
+var outcomes = map[int]string{
+	0:   "ok",
+	100: "key_not_found",
+	101: "compare_failed",
+	105: "node_exist",
+}
+
+func outcome(resp *EtcdResponse, err error) string {
+	if err == ErrRateLimited {
+		return "rate_limited"
+	}
+	if err != nil {
+		return "transport_error"
+	}
+	if name, ok := outcomes[resp.ErrorCode]; ok {
+		return name
+	}
+	return "etcd_error"
+}
+
+// SetMetrics replaces the metrics the client records into. It must be called
+// before the client is shared between goroutines.
+func (c *EtcdClient) SetMetrics(metrics *Metrics) {
+	c.metrics = metrics
+}
+
+func (c *EtcdClient) Metrics() *Metrics {
+	return c.metrics
+}
@@ -0,0 +1,160 @@
+package election_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+	"github.com/jeeyoungk/etcd-leader/leadertest"
+)
+
+// TestRegistry campaigns two electors sharing a client, lets the leader
+// renew and scrapes the registry: each election must be exported once, and
+// the client's counts not twice for its two electors.
+func TestRegistry(t *testing.T) {
+	server := leadertest.NewServer(t)
+	client := election.NewEtcdClient(server.URL)
+	metrics := election.NewMetrics(10)
+	client.SetMetrics(metrics)
+	var registry election.Registry
+	var electors []*election.Elector
+	for _, key := range []string{"a", "b"} {
+		elector, err := election.New(client, key, "node", election.ElectionConfig{TTL: time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { elector.Stop() })
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if _, err := elector.Campaign(ctx); err != nil {
+			t.Fatal(err)
+		}
+		registry.Register(elector, elector)
+		electors = append(electors, elector)
+	}
+	// the lock is renewed at a fraction of its TTL
+	time.Sleep(1200 * time.Millisecond)
+
+	stats := registry.Gather()
+	if len(stats.Elections) != 2 || stats.Elections[0].Key != "a" || stats.Elections[1].Key != "b" {
+		t.Fatalf("gathered elections %+v, want a and b once", stats.Elections)
+	}
+	if len(stats.Activity) != 2 {
+		t.Fatalf("gathered activity %+v, want a sample per election", stats.Activity)
+	}
+	for _, a := range stats.Activity {
+		if a.Campaigns != 1 || a.Renewals == 0 {
+			t.Errorf("activity %+v, want one campaign and renewals", a)
+		}
+	}
+	var requests int64
+	for _, l := range stats.Latencies {
+		requests += l.Count
+	}
+	// counted after gathering, so never fewer
+	var operations int64
+	for _, op := range metrics.Snapshot() {
+		operations += op.Count
+	}
+	if requests == 0 || requests > operations {
+		t.Errorf("gathered %d requests in the latency histograms, want the %d counted", requests, operations)
+	}
+
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if typ := w.Header().Get("Content-Type"); !strings.HasPrefix(typ, "application/openmetrics-text") {
+		t.Errorf("served %s, want OpenMetrics", typ)
+	}
+	body, _ := io.ReadAll(w.Body)
+	for _, line := range []string{
+		`etcd_leader_is_leader{key="a",id="node"} 1`,
+		`etcd_leader_is_leader{key="b",id="node"} 1`,
+		`etcd_leader_campaigns_total{key="a"} 1`,
+		`etcd_leader_request_duration_seconds_bucket{op="put",le="+Inf"}`,
+		"# EOF",
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Errorf("metrics lack %s:\n%s", line, body)
+		}
+	}
+
+	registry.Unregister(electors[0])
+	if elections := registry.Gather().Elections; len(elections) != 1 || elections[0].Key != "b" {
+		t.Fatalf("gathered elections %+v after unregistering a, want b", elections)
+	}
+}
+
+// TestCampaignFailures loses a race for the lock, and campaigns with a
+// read-only client: both failed attempts must be counted by reason, in the
+// acquisition that follows and in the metrics.
+func TestCampaignFailures(t *testing.T) {
+	server := leadertest.NewServer(t)
+	var raced atomic.Bool
+	lost := make(chan struct{})
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// another candidate takes the lock just before the first create
+		if r.Method == http.MethodPut && r.FormValue("prevExist") == "false" && !raced.Swap(true) {
+			server.ForceLeader("race", "b")
+			defer close(lost)
+		}
+		server.ServeHTTP(w, r)
+	}))
+	t.Cleanup(proxy.Close)
+	metrics := election.NewMetrics(10)
+	client := election.NewEtcdClient(proxy.URL)
+	client.SetMetrics(metrics)
+	elector, err := election.New(client, "race", "a", election.ElectionConfig{TTL: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { elector.Stop() })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	elector.Start()
+	select {
+	case <-lost:
+	case <-ctx.Done():
+		t.Fatal("the candidate did not try to create the lock")
+	}
+	server.Expire("race")
+	lease, err := elector.Campaign(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acquisition := lease.Acquisition(); acquisition.Attempts != 2 || acquisition.Failures[election.FailureLostRace] != 1 {
+		t.Errorf("acquired %s, want a race lost on the first of two attempts", acquisition)
+	}
+
+	readOnly := election.NewEtcdClient(server.URL)
+	readOnly.SetMetrics(metrics)
+	readOnly.SetReadOnly(true)
+	observer, err := election.New(readOnly, "readonly", "a", election.ElectionConfig{TTL: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { observer.Stop() })
+	observer.Start()
+	failures := func() map[string]map[election.AttemptFailure]int64 {
+		failures := make(map[string]map[election.AttemptFailure]int64)
+		for _, a := range metrics.Activity() {
+			failures[a.Key] = a.Failures
+		}
+		return failures
+	}
+	for failures()["readonly"] == nil && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	got := failures()
+	if got["race"][election.FailureLostRace] != 1 || len(got["race"]) != 1 {
+		t.Errorf("counted failures %v for the race, want one lost race", got["race"])
+	}
+	if got["readonly"][election.FailureAuth] != 1 || len(got["readonly"]) != 1 {
+		t.Errorf("counted failures %v for the read-only client, want one refused write", got["readonly"])
+	}
+}
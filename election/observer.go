@@ -0,0 +1,144 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// LeaderInfo is the leader of an election as seen by an Observer. ID is empty
+// while the election has no leader.
+type LeaderInfo struct {
+	Key string
+	Leader
+	// Expires is when the lock expires unless renewed, in etcd's clock, if
+	// known.
+	Expires time.Time
+}
+
+// Observer follows the leader of an election without campaigning, for
+// processes that only need to know where to route: it never writes, so it
+// works with read-only credentials and clients.
+type Observer struct {
+	client *EtcdClient
+	state  *State
+}
+
+// defaultObserverTTL stands in for the TTL of an observer's config, where it
+// only paces retries.
+const defaultObserverTTL = 10 * time.Second
+
+// NewObserver returns an observer of the election of key. Of config, the
+// settings naming the election's keys and deciding when its lock counts as
+// gone are used: Layout, CompatLayout, ObserverSkew and Refresh, and TTL,
+// which may be left zero, for the pace of retries. Zero fields take the same
+// defaults as in a ManagerConfig.
+func NewObserver(client *EtcdClient, key string, config ElectionConfig) (*Observer, error) {
+	if config.TTL == 0 {
+		config.TTL = defaultObserverTTL
+	}
+	manager := ManagerConfig{Concurrency: 1, Defaults: config}
+	if err := manager.Validate(); err != nil {
+		return nil, err
+	}
+	state, err := newState(key, "", manager.Election(key), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	state.observer = true
+	return &Observer{client: client, state: state}, nil
+}
+
+// SetVerifier makes the observer reject leader values whose signature does
+// not verify. It must be called before the observer is used.
+func (o *Observer) SetVerifier(verifier Verifier) {
+	o.state.verifier = verifier
+}
+
+// SetMetadataCipher lets the observer read encrypted leader metadata. It must
+// be called before the observer is used.
+func (o *Observer) SetMetadataCipher(cipher *MetadataCipher) {
+	o.state.cipher = cipher
+}
+
+// Leader returns the current leader of the election, or ErrNoLeader while it
+// has none.
+func (o *Observer) Leader(ctx context.Context) (LeaderInfo, error) {
+	return currentLeader(ctx, o.state, o.client)
+}
+
+// LeaderChanges streams the leader of the election until ctx is done,
+// starting with the current one, and then every time it changes. A
+// LeaderInfo with an empty ID reports that the election lost its leader. The
+// stream follows the lock with a watch rather than polling it; failed
+// requests are retried at the poll interval, and values that do not verify
+// are skipped. A receiver that falls behind holds up the watch, and then
+// sees the leader of when it catches up.
+func (o *Observer) LeaderChanges(ctx context.Context) <-chan LeaderInfo {
+	changes := make(chan LeaderInfo, 1)
+	go func() {
+		defer close(changes)
+		defer o.state.usage.hold(true)()
+		watch := newWatcher(ctx, o.client, o.state.watchKey())
+		last, first := "", true
+		for {
+			resp, err := watch.Next()
+			if err != nil && !answered(err) {
+				if ctx.Err() != nil {
+					return
+				}
+				o.state.eventStr(LevelError, evError, err.Error())
+				if !o.state.sleepUntil(o.state.pollInterval(), ctx.Done()) {
+					return
+				}
+				continue
+			}
+			info := LeaderInfo{Key: o.state.key}
+			if err == nil && resp.Node.Value != "" {
+				if info, err = o.state.leaderInfo(resp.Node); err != nil {
+					o.state.eventStr(LevelWarn, evUnverified, err.Error())
+					continue
+				}
+			}
+			if info.ID == last && !first {
+				continue
+			}
+			last, first = info.ID, false
+			select {
+			case changes <- info:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changes
+}
+
+// currentLeader reads the leader of the election of state, treating a lock
+// that expires within the ObserverSkew as gone.
+func currentLeader(ctx context.Context, state *State, client *EtcdClient) (LeaderInfo, error) {
+	resp, err := state.read(ctx, client, "leader")
+	if errors.Is(err, ErrKeyNotFound) {
+		return LeaderInfo{}, ErrNoLeader
+	} else if err != nil {
+		return LeaderInfo{}, err
+	}
+	if resp.Node.Expiration != nil && state.expiring(*resp.Node.Expiration) {
+		return LeaderInfo{}, ErrNoLeader
+	}
+	return state.leaderInfo(resp.Node)
+}
+
+// leaderInfo decodes the lock node of the election, verifying it and
+// decrypting its metadata as configured.
+func (s *State) leaderInfo(node Node) (LeaderInfo, error) {
+	announcement, err := DecodeAnnouncement(s.key, node.Value, s.verifier, s.cipher)
+	if err != nil && err != ErrSealedMetadata {
+		return LeaderInfo{}, err
+	}
+	info := LeaderInfo{Key: s.key, Leader: Leader{ID: announcement.ID, Metadata: announcement.Metadata, Index: node.CreatedIndex}}
+	if node.Expiration != nil {
+		info.Expires = *node.Expiration
+	}
+	return info, nil
+}
@@ -0,0 +1,49 @@
+package election_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+	"github.com/jeeyoungk/etcd-leader/leadertest"
+)
+
+func next(t *testing.T, changes <-chan election.LeaderInfo) string {
+	t.Helper()
+	select {
+	case info := <-changes:
+		return info.ID
+	case <-time.After(2 * time.Second):
+		t.Fatal("no leader change within 2s")
+		return ""
+	}
+}
+
+// TestObserverSeesRecreatedLock deletes and recreates the lock while the
+// observer is held up by its receiver, so that its next wait is served from
+// etcd's event history: the recreation must not be skipped.
+func TestObserverSeesRecreatedLock(t *testing.T) {
+	server := leadertest.NewServer(t)
+	server.ForceLeader("recreate", "x")
+	observer, err := election.NewObserver(election.NewEtcdClient(server.URL), "recreate", election.ElectionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	changes := observer.LeaderChanges(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// x fills the buffer, so the observer blocks sending y
+	server.ForceLeader("recreate", "y")
+	time.Sleep(100 * time.Millisecond)
+	server.Expire("recreate")
+	server.ForceLeader("recreate", "z")
+
+	for _, want := range []string{"x", "y", "", "z"} {
+		if got := next(t, changes); got != want {
+			t.Fatalf("leader %q, want %q", got, want)
+		}
+	}
+}
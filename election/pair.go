@@ -0,0 +1,374 @@
+package election
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Pair runs back-to-back elections for an active and a standby key. Every
+// member campaigns for the active key, and those that are not active also for
+// the standby key. The active leader registers the standby leader as its
+// designated successor, which other members defer to when the active lock is
+// free for as long as the successor still holds the standby lock, so that a
+// failover lands on the standby the active leader last checked.
+//
+// The active leader checks its successor with a no-op handshake every
+// handshake period: it writes a nonce addressed to the standby, which the
+// standby echoes back. A missed handshake tells before any failover that
+// promotion would hit a standby that cannot reach etcd or is stuck.
+//
+// The keys are those of the elections key+"/active" and key+"/standby",
+// along with key+"/successor", key+"/handshake" and key+"/ack".
+type Pair struct {
+	client    *EtcdClient
+	key       string
+	id        string
+	ttl       time.Duration
+	handshake time.Duration
+
+	active  *Elector
+	standby *Elector
+	wake    chan struct{}
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// mu guards everything below
+	mu     sync.Mutex
+	status PairStatus
+	// the nonce of the last handshake sent, as active, when and to whom,
+	// or of the last one acked, as standby
+	nonce   string
+	sent    time.Time
+	shookTo string
+}
+
+// PairConfig holds the settings of a Pair.
+type PairConfig struct {
+	// Election configures both elections of the pair.
+	Election ElectionConfig
+	// Handshake is the period of the handshake with the standby; it
+	// defaults to a third of the TTL.
+	Handshake time.Duration
+}
+
+// PairRole is what a member of a Pair currently is.
+type PairRole string
+
+const (
+	RoleActive  PairRole = "active"
+	RoleStandby PairRole = "standby"
+	// RoleSpare is a member holding neither lock.
+	RoleSpare PairRole = "spare"
+)
+
+// PairStatus is what a member of a Pair knows about the pair.
+type PairStatus struct {
+	Role PairRole
+	// Successor is the designated successor, as last registered or read
+	// by this member.
+	Successor string
+	// Handshake is when the active leader last heard back from the
+	// standby, and Missed counts the handshakes it has missed since.
+	Handshake time.Time
+	Missed    int
+}
+
+// NewPair returns a member of the pair of elections under key, campaigning
+// as id.
+func NewPair(client *EtcdClient, key string, id string, config PairConfig) (*Pair, error) {
+	active, err := New(client, key+"/active", id, config.Election)
+	if err != nil {
+		return nil, err
+	}
+	standby, err := New(client, key+"/standby", active.state.id, config.Election)
+	if err != nil {
+		return nil, err
+	}
+	p := &Pair{
+		client:    client,
+		key:       key,
+		id:        active.state.id,
+		ttl:       active.state.ttl,
+		handshake: config.Handshake,
+		active:    active,
+		standby:   standby,
+		wake:      make(chan struct{}, 1),
+		status:    PairStatus{Role: RoleSpare},
+	}
+	if p.handshake <= 0 {
+		p.handshake = p.ttl / 3
+	}
+	active.state.gates = append(active.state.gates, namedGate{"pair", p.gate})
+	active.OnElected(func(int) { p.poke() })
+	active.OnDemoted(func(error) { p.poke() })
+	return p, nil
+}
+
+// Active and Standby return the electors of the two keys, to observe them or
+// register callbacks before Start.
+func (p *Pair) Active() *Elector  { return p.active }
+func (p *Pair) Standby() *Elector { return p.standby }
+
+// Status returns what the member currently knows about the pair.
+func (p *Pair) Status() PairStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+// Start campaigns in the background until Stop.
+func (p *Pair) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cancel != nil {
+		return
+	}
+	var ctx context.Context
+	ctx, p.cancel = context.WithCancel(context.Background())
+	p.done = make(chan struct{})
+	p.active.Start()
+	p.standby.Start()
+	go p.run(ctx, p.done)
+}
+
+// Stop stops campaigning and resigns whichever lock the member holds. A
+// designated successor stays registered, and takes over from the active
+// leader that stops; a member that stops as standby withdraws as successor.
+func (p *Pair) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	cancel, done := p.cancel, p.done
+	p.cancel, p.done = nil, nil
+	p.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	err := p.standby.Resign(ctx)
+	if activeErr := p.active.Resign(ctx); activeErr != nil {
+		err = activeErr
+	}
+	p.mu.Lock()
+	role, nonce, shookTo := p.status.Role, p.nonce, p.shookTo
+	p.status = PairStatus{Role: RoleSpare}
+	p.nonce, p.sent, p.shookTo = "", time.Time{}, ""
+	p.mu.Unlock()
+	if cleanErr := p.clean(ctx, role, nonce, shookTo); cleanErr != nil && err == nil {
+		err = cleanErr
+	}
+	return err
+}
+
+// clean deletes the pair keys the member wrote in role, each only while it
+// still holds what the member wrote: as standby, its registration as
+// successor and its ack, and as active, its last handshake.
+func (p *Pair) clean(ctx context.Context, role PairRole, nonce string, shookTo string) error {
+	type written struct{ key, value string }
+	var keys []written
+	switch role {
+	case RoleStandby:
+		keys = append(keys, written{p.key + "/successor", p.id})
+		if nonce != "" {
+			keys = append(keys, written{p.key + "/ack", nonce})
+		}
+	case RoleActive:
+		if nonce != "" {
+			keys = append(keys, written{p.key + "/handshake", shookTo + " " + nonce})
+		}
+	}
+	var err error
+	for _, w := range keys {
+		if _, deleteErr := discard(ctx, p.client, w.key, w.value, "pair"); deleteErr != nil {
+			err = deleteErr
+		}
+	}
+	return err
+}
+
+func (p *Pair) poke() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Pair) run(ctx context.Context, done chan<- struct{}) {
+	defer close(done)
+	// twice per handshake, so that the standby echoes each one in time
+	ticker := time.NewTicker(p.handshake / 2)
+	defer ticker.Stop()
+	for {
+		if err := p.step(ctx); err != nil && ctx.Err() == nil {
+			p.active.state.eventStr(LevelWarn, evError, err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.wake:
+		}
+	}
+}
+
+// step plays the member's part in the pair once.
+func (p *Pair) step(ctx context.Context) error {
+	switch {
+	case p.active.IsLeader():
+		p.setRole(RoleActive)
+		// the standby lock goes to another member
+		if err := p.standby.Resign(ctx); err != nil {
+			return err
+		}
+		return p.lead(ctx)
+	case p.standby.IsLeader():
+		p.setRole(RoleStandby)
+		return p.echo(ctx)
+	}
+	p.setRole(RoleSpare)
+	p.standby.Start()
+	return nil
+}
+
+func (p *Pair) setRole(role PairRole) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.status.Role == role {
+		return
+	}
+	p.status.Handshake, p.status.Missed = time.Time{}, 0
+	p.nonce, p.sent, p.shookTo = "", time.Time{}, ""
+	p.status.Role = role
+}
+
+// lead registers the standby leader as successor, and checks the ack of the
+// previous handshake before sending the next.
+func (p *Pair) lead(ctx context.Context) error {
+	leader, err := p.standby.Leader(ctx)
+	if err == ErrNoLeader {
+		leader.ID = ""
+	} else if err != nil {
+		return err
+	}
+	successor := leader.ID
+	if successor == "" {
+		p.setSuccessor(successor)
+		return nil
+	}
+	// the successor outlives the active lock by a TTL, so that only it
+	// campaigns once the lock expires
+	if _, err := p.client.Put(ctx, p.key+"/successor", successor, Option{ttl: 2 * p.ttl, origin: "pair"}); err != nil {
+		return err
+	}
+	p.setSuccessor(successor)
+	p.mu.Lock()
+	nonce, sent, shookTo := p.nonce, p.sent, p.shookTo
+	p.mu.Unlock()
+	if time.Since(sent) < p.handshake {
+		return nil
+	}
+	if nonce != "" && shookTo == successor {
+		if err := p.checkAck(ctx, successor, nonce); err != nil {
+			return err
+		}
+	}
+	nonce = strconv.FormatInt(time.Now().UnixNano(), 36)
+	if _, err := p.client.Put(ctx, p.key+"/handshake", successor+" "+nonce, Option{ttl: 2 * p.ttl, origin: "pair"}); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.nonce, p.sent, p.shookTo = nonce, time.Now(), successor
+	p.mu.Unlock()
+	return nil
+}
+
+// setSuccessor records the designated successor, logging a change.
+func (p *Pair) setSuccessor(successor string) {
+	p.mu.Lock()
+	changed := p.status.Successor != successor
+	p.status.Successor = successor
+	if changed {
+		p.status.Handshake, p.status.Missed = time.Time{}, 0
+		p.nonce, p.sent, p.shookTo = "", time.Time{}, ""
+	}
+	p.mu.Unlock()
+	if changed {
+		p.active.state.eventStr(LevelInfo, evSuccessor, successor)
+	}
+}
+
+// checkAck reads the standby's echo of handshake nonce.
+func (p *Pair) checkAck(ctx context.Context, successor string, nonce string) error {
+	resp, err := p.client.Get(ctx, p.key+"/ack", Option{})
+	if err != nil && !answered(err) {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil && resp.Node.Value == nonce {
+		p.status.Handshake, p.status.Missed = time.Now(), 0
+		return nil
+	}
+	p.status.Missed++
+	p.active.state.eventStr(LevelWarn, evHandshakeMissed, successor)
+	return nil
+}
+
+// echo acks a handshake addressed to this member as standby.
+func (p *Pair) echo(ctx context.Context) error {
+	resp, err := p.client.Get(ctx, p.key+"/handshake", Option{})
+	if answered(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	fields := strings.Fields(resp.Node.Value)
+	if len(fields) != 2 || fields[0] != p.id {
+		return nil
+	}
+	nonce := fields[1]
+	p.mu.Lock()
+	p.status.Successor = p.id
+	acked := p.nonce
+	p.mu.Unlock()
+	if nonce == acked {
+		return nil
+	}
+	if _, err := p.client.Put(ctx, p.key+"/ack", nonce, Option{ttl: 2 * p.ttl, origin: "pair"}); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.nonce, p.status.Handshake = nonce, time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+// gate keeps the member from taking the free active lock while another
+// member is the designated successor and still holds the standby lock.
+func (p *Pair) gate(ctx context.Context, key string, id string) error {
+	resp, err := p.client.Get(ctx, p.key+"/successor", Option{})
+	if answered(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	successor := resp.Node.Value
+	if successor == id {
+		return nil
+	}
+	standby, err := p.standby.Leader(ctx)
+	if err == ErrNoLeader || (err == nil && standby.ID != successor) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return fmt.Errorf("standby %s is the designated successor", successor)
+}
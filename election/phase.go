@@ -0,0 +1,120 @@
+package election
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Phase is where a candidate stands in its election. A candidate moves
+// Idle → Campaigning → Leader → Resigning → Demoted, and back to Campaigning
+// once its backoff is over; a leader that fails to renew goes straight to
+// Demoted.
+type Phase int32
+
+const (
+	// PhaseIdle is a candidate that is not campaigning: not started yet,
+	// stopped, or only observing.
+	PhaseIdle Phase = iota
+	// PhaseCampaigning is a candidate trying to acquire the lock.
+	PhaseCampaigning
+	// PhaseLeader is a candidate holding the lock.
+	PhaseLeader
+	// PhaseResigning is a candidate releasing the lock. It no longer acts
+	// as leader, but others cannot acquire the lock yet.
+	PhaseResigning
+	// PhaseDemoted is a candidate that lost or gave up the lock and sits
+	// out its backoff.
+	PhaseDemoted
+)
+
+// Phases lists every phase in order.
+var Phases = []Phase{PhaseIdle, PhaseCampaigning, PhaseLeader, PhaseResigning, PhaseDemoted}
+
+var phaseNames = map[Phase]string{
+	PhaseIdle:        "idle",
+	PhaseCampaigning: "campaigning",
+	PhaseLeader:      "leader",
+	PhaseResigning:   "resigning",
+	PhaseDemoted:     "demoted",
+}
+
+func (p Phase) String() string {
+	return phaseNames[p]
+}
+
+func (p Phase) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// PhaseChange is a transition of a candidate from one phase to another.
+type PhaseChange struct {
+	Time   time.Time `json:"time"`
+	Key    string    `json:"key"`
+	ID     string    `json:"id"`
+	From   Phase     `json:"from"`
+	To     Phase     `json:"to"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+func (s *State) phase() Phase {
+	return Phase(atomic.LoadInt32(&s.current))
+}
+
+// setPhase moves the candidate to phase to, counting the transition and
+// calling the phase hook. It does nothing if the candidate is already there.
+func (s *State) setPhase(to Phase, reason string) {
+	from := Phase(atomic.SwapInt32(&s.current, int32(to)))
+	if from == to {
+		return
+	}
+	s.eventStr(LevelDebug, evPhase, from.String()+" -> "+to.String())
+	if s.metrics != nil {
+		s.metrics.transition(s.key, from, to)
+	}
+	if s.onPhase != nil {
+		s.onPhase(PhaseChange{Time: time.Now(), Key: s.key, ID: s.id, From: from, To: to, Reason: reason})
+	}
+}
+
+type transitionLabels struct {
+	key      string
+	from, to Phase
+}
+
+// TransitionSample is the number of transitions between two phases in one
+// election.
+type TransitionSample struct {
+	Key   string `json:"key"`
+	From  Phase  `json:"from"`
+	To    Phase  `json:"to"`
+	Count int64  `json:"count"`
+}
+
+func (m *Metrics) transition(key string, from, to Phase) {
+	m.mu.Lock()
+	m.transitions[transitionLabels{key: m.keyLabel(key), from: from, to: to}]++
+	m.mu.Unlock()
+}
+
+// Transitions returns the phase transitions counted so far, ordered by key
+// and phases.
+func (m *Metrics) Transitions() []TransitionSample {
+	m.mu.Lock()
+	samples := make([]TransitionSample, 0, len(m.transitions))
+	for labels, count := range m.transitions {
+		samples = append(samples, TransitionSample{Key: labels.key, From: labels.from, To: labels.to, Count: count})
+	}
+	m.mu.Unlock()
+	sort.Slice(samples, func(i, j int) bool {
+		a, b := samples[i], samples[j]
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	return samples
+}
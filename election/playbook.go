@@ -0,0 +1,175 @@
+package election
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Conditions of the watchdog that a Remedy can be bound to.
+const (
+	// ConditionLeaderless holds while an election has no leader.
+	ConditionLeaderless = "leaderless"
+	// ConditionDoubleLeader holds while the broadcast key names a
+	// candidate that announced itself after another acquired the lock,
+	// so that both may act as leader.
+	ConditionDoubleLeader = "double leader"
+	// ConditionUnreachable holds while etcd cannot be reached for any of
+	// the watched elections. Its Election is empty.
+	ConditionUnreachable = "etcd unreachable"
+)
+
+// Remedy is a recovery action the watchdog runs once a condition has held
+// for a while, turning a detection into automated remediation. Either
+// Command or URL is set. The action is guarded: it runs only after the
+// condition held for After, at most once per Cooldown for each election, and
+// never while its previous run for that election is still going. Every run
+// is logged with its outcome.
+type Remedy struct {
+	// Condition is one of the Condition constants.
+	Condition string
+	// After is how long the condition must hold before the action runs.
+	After time.Duration
+	// Command is run with the environment of the watchdog plus
+	// ETCD_LEADER_CONDITION, ETCD_LEADER_ELECTION and ETCD_LEADER_DETAIL.
+	Command []string
+	// URL is posted an Alert describing the condition as JSON.
+	URL string
+	// Cooldown is the least time between two runs for one election.
+	// Defaults to a minute.
+	Cooldown time.Duration
+	// Timeout bounds each run. Defaults to 30s.
+	Timeout time.Duration
+}
+
+func (r Remedy) validate() error {
+	switch r.Condition {
+	case ConditionLeaderless, ConditionDoubleLeader, ConditionUnreachable:
+	default:
+		return fmt.Errorf("unknown remedy condition %q", r.Condition)
+	}
+	if (len(r.Command) == 0) == (r.URL == "") {
+		return fmt.Errorf("remedy for %s needs either a command or a URL", r.Condition)
+	}
+	if r.After < 0 || r.Cooldown < 0 || r.Timeout < 0 {
+		return fmt.Errorf("remedy for %s has a negative duration", r.Condition)
+	}
+	return nil
+}
+
+// playbook is the remedies of a watchdog, and what it remembers about the
+// conditions they are bound to.
+type playbook struct {
+	remedies []Remedy
+	// when each condition of an election was first detected, keyed by
+	// condition and election
+	since map[string]time.Time
+
+	mu      sync.Mutex
+	lastRun map[string]time.Time // keyed by remedy and election
+	running map[string]bool
+}
+
+// AddRemedy binds a recovery action to a condition of the watchdog. It must
+// be called before Run.
+func (w *Watchdog) AddRemedy(remedy Remedy) error {
+	if err := remedy.validate(); err != nil {
+		return err
+	}
+	if remedy.Cooldown == 0 {
+		remedy.Cooldown = time.Minute
+	}
+	if remedy.Timeout == 0 {
+		remedy.Timeout = 30 * time.Second
+	}
+	if w.playbook == nil {
+		w.playbook = &playbook{since: make(map[string]time.Time), lastRun: make(map[string]time.Time), running: make(map[string]bool)}
+	}
+	w.playbook.remedies = append(w.playbook.remedies, remedy)
+	return nil
+}
+
+// detected records that condition holds for election at now, and runs the
+// remedies whose guards allow it.
+func (w *Watchdog) detected(condition string, election string, detail string, now time.Time) {
+	p := w.playbook
+	if p == nil {
+		return
+	}
+	key := condition + "/" + election
+	since, ok := p.since[key]
+	if !ok {
+		since = now
+		p.since[key] = now
+	}
+	for i, remedy := range p.remedies {
+		if remedy.Condition != condition || now.Sub(since) < remedy.After {
+			continue
+		}
+		run := fmt.Sprintf("%d/%s", i, election)
+		p.mu.Lock()
+		if p.running[run] || (!p.lastRun[run].IsZero() && now.Sub(p.lastRun[run]) < remedy.Cooldown) {
+			p.mu.Unlock()
+			continue
+		}
+		p.running[run], p.lastRun[run] = true, now
+		p.mu.Unlock()
+		alert := Alert{Key: key, Election: election, Condition: condition, Detail: detail, Time: now}
+		go func(remedy Remedy) {
+			err := remedy.run(alert)
+			p.mu.Lock()
+			delete(p.running, run)
+			p.mu.Unlock()
+			if err != nil {
+				std.eventStr(LevelError, election, evRemedyFailed, condition+": "+err.Error())
+			} else {
+				std.eventStr(LevelWarn, election, evRemedied, condition)
+			}
+		}(remedy)
+	}
+}
+
+// cleared records that condition no longer holds for election.
+func (w *Watchdog) cleared(condition string, election string) {
+	if w.playbook != nil {
+		delete(w.playbook.since, condition+"/"+election)
+	}
+}
+
+// run carries out the action of the remedy for alert.
+func (r Remedy) run(alert Alert) error {
+	if r.URL != "" {
+		body, err := json.Marshal(alert)
+		if err != nil {
+			return err
+		}
+		client := &http.Client{Timeout: r.Timeout}
+		resp, err := client.Post(r.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("%s: %s", r.URL, resp.Status)
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, r.Command[0], r.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"ETCD_LEADER_CONDITION="+alert.Condition,
+		"ETCD_LEADER_ELECTION="+alert.Election,
+		"ETCD_LEADER_DETAIL="+alert.Detail,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s: %s", r.Command[0], err.Error(), output)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package election_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+	"github.com/jeeyoungk/etcd-leader/leadertest"
+)
+
+func TestRemedyValidation(t *testing.T) {
+	dog := election.NewWatchdog(election.NewEtcdClient("http://127.0.0.1:0"), nil, time.Second, 0, nil)
+	for _, remedy := range []election.Remedy{
+		{Condition: "on fire", URL: "http://remedy"},
+		{Condition: election.ConditionLeaderless},
+		{Condition: election.ConditionLeaderless, URL: "http://remedy", Command: []string{"true"}},
+		{Condition: election.ConditionLeaderless, URL: "http://remedy", After: -time.Second},
+	} {
+		if err := dog.AddRemedy(remedy); err == nil {
+			t.Errorf("accepted %+v", remedy)
+		}
+	}
+}
+
+// TestPlaybook runs remedies on a leaderless election: those whose condition
+// held long enough run once within their cooldown, with the condition's
+// details, and the others not at all.
+func TestPlaybook(t *testing.T) {
+	server := leadertest.NewServer(t)
+	var mu sync.Mutex
+	var posted []election.Alert
+	remedy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert election.Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		posted = append(posted, alert)
+		mu.Unlock()
+	}))
+	t.Cleanup(remedy.Close)
+	out := filepath.Join(t.TempDir(), "remedied")
+
+	alerts, err := election.NewAlerter("", &alertLog{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dog := election.NewWatchdog(election.NewEtcdClient(server.URL), []string{"free"}, 10*time.Millisecond, 0, alerts)
+	for _, r := range []election.Remedy{
+		{Condition: election.ConditionLeaderless, After: 30 * time.Millisecond, URL: remedy.URL},
+		{Condition: election.ConditionLeaderless, Command: []string{"sh", "-c", `echo "$ETCD_LEADER_CONDITION $ETCD_LEADER_ELECTION" >> ` + out}},
+		{Condition: election.ConditionLeaderless, After: time.Hour, URL: remedy.URL + "/later"},
+		{Condition: election.ConditionDoubleLeader, URL: remedy.URL + "/double"},
+	} {
+		if err := dog.AddRemedy(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runWatchdog(dog)
+	// remedies run in the background
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(posted) != 1 {
+		t.Fatalf("posted %d remedies, want 1", len(posted))
+	}
+	if alert := posted[0]; alert.Condition != election.ConditionLeaderless || alert.Election != "free" {
+		t.Errorf("posted %+v, want the leaderless election", alert)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 || lines[0] != "leaderless free" {
+		t.Errorf("command ran with %q, want it once for the leaderless election", lines)
+	}
+}
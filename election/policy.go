@@ -0,0 +1,125 @@
+package election
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DemotionPolicy lets the watchdog delete the leader key of an election whose
+// leader is wedged or unreachable, so that another candidate can take over.
+// It is never applied unless set with SetDemotionPolicy, and every key it
+// deletes is recorded to the client's audit sink along with the reason.
+type DemotionPolicy struct {
+	// TTL is the lock TTL of the watched elections.
+	TTL time.Duration
+	// HeartbeatAbsent demotes a leader whose heartbeat key has been missing
+	// for more than this many TTLs. Zero disables the condition.
+	HeartbeatAbsent float64
+	// HealthKey names the leader metadata entry holding its health URL.
+	// Empty disables the condition.
+	HealthKey string
+	// HealthFailures is the number of consecutive failed health probes
+	// that demotes a leader. Defaults to 3.
+	HealthFailures int
+	// HealthTimeout bounds each health probe.
+	HealthTimeout time.Duration
+}
+
+// policyState is what the watchdog remembers about one election between
+// checks while a policy is set.
+type policyState struct {
+	// the leader value the rest of the state refers to
+	value          string
+	heartbeatSince time.Time
+	healthFailures int
+}
+
+// SetDemotionPolicy enables forced demotion under policy. The watchdog's
+// client must be writable and should have an audit sink.
+func (w *Watchdog) SetDemotionPolicy(policy *DemotionPolicy) {
+	w.policy = policy
+	w.policyStates = make(map[string]*policyState)
+	if policy.HeartbeatAbsent > 0 {
+		w.heartbeat = true
+	}
+	if policy.HealthTimeout == 0 {
+		policy.HealthTimeout = time.Second
+	}
+	if policy.HealthFailures <= 0 {
+		// a single failed probe is no reason to demote anyone
+		policy.HealthFailures = 3
+	}
+	w.health = newHealthProbe(policy.HealthKey, policy.HealthTimeout)
+}
+
+// enforce demotes the leader of key if a condition of the policy holds, and
+// reports whether it did. heartbeat reports whether the leader's heartbeat key
+// is present.
+func (w *Watchdog) enforce(ctx context.Context, key string, leader *EtcdResponse, heartbeat bool, now time.Time) bool {
+	value := leader.Node.Value
+	state, ok := w.policyStates[key]
+	if !ok || state.value != value {
+		state = &policyState{value: value}
+		w.policyStates[key] = state
+	}
+
+	var reason string
+	if w.policy.HeartbeatAbsent > 0 {
+		if heartbeat {
+			state.heartbeatSince = time.Time{}
+		} else if state.heartbeatSince.IsZero() {
+			state.heartbeatSince = now
+		} else if gap := now.Sub(state.heartbeatSince); gap > time.Duration(w.policy.HeartbeatAbsent*float64(w.policy.TTL)) {
+			reason = fmt.Sprintf("heartbeat absent for %s", gap)
+		}
+	}
+	if reason == "" && w.policy.HealthKey != "" {
+		if err := w.probeHealth(key, value); err != nil {
+			state.healthFailures++
+			if state.healthFailures >= w.policy.HealthFailures {
+				reason = fmt.Sprintf("%d failed health probes: %s", state.healthFailures, err.Error())
+			}
+		} else {
+			state.healthFailures = 0
+		}
+	}
+
+	demoted := "demoted/" + key
+	if reason == "" {
+		w.alerts.Resolve(demoted)
+		return false
+	}
+	probe := &State{key: key, layout: w.layout}
+	_, err := w.client.Delete(ctx, probe.leaderKey(), value, Option{
+		prevIndex: leader.Node.ModifiedIndex,
+		origin:    "demote",
+		reason:    reason,
+	})
+	if err != nil {
+		std.error(key, err)
+		return false
+	}
+	delete(w.policyStates, key)
+	id := decodeRecord(value).ID
+	std.eventStr(LevelWarn, key, evDemoted, id+": "+reason)
+	w.alerts.Fire(Alert{
+		Key:       demoted,
+		Election:  key,
+		Condition: "forced demotion",
+		Detail:    fmt.Sprintf("deleted the lock of %q: %s", id, reason),
+		Severity:  "warning",
+	})
+	return true
+}
+
+// probeHealth requests the health URL the leader advertises in its metadata.
+func (w *Watchdog) probeHealth(key string, value string) error {
+	announcement, err := DecodeAnnouncement(key, value, w.verifier, nil)
+	if err != nil {
+		// unverified leaders are reported by check, sealed metadata
+		// cannot be probed
+		return nil
+	}
+	return w.health.check(announcement.Metadata)
+}
@@ -0,0 +1,54 @@
+package election
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrOutsidePrefix is returned for requests on keys outside the client's
+// allowed prefix.
+var ErrOutsidePrefix = errors.New("key is outside the allowed prefix")
+
+// SetAllowedPrefix confines the client to keys below the directory prefix,
+// e.g. "myapp/", rejecting every other request before it is sent. This keeps
+// a misconfigured election from reading or writing unrelated parts of a
+// shared keyspace. The prefix matches whole path segments: "myapp" allows
+// "myapp/orders" but not "myapp2/orders". An empty prefix allows every key.
+// It must be called before the client is shared between goroutines.
+func (c *EtcdClient) SetAllowedPrefix(prefix string) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	c.prefix = prefix
+}
+
+// checkPrefix guards every request. In dev builds a request outside the
+// prefix is a programming error and panics.
+func (c *EtcdClient) checkPrefix(key string) error {
+	// the prefix ends in a slash, and so allows its directory too
+	if strings.HasPrefix(strings.TrimPrefix(key, "/")+"/", c.prefix) {
+		return nil
+	}
+	if devBuild {
+		panic(key + ": " + ErrOutsidePrefix.Error())
+	}
+	return ErrOutsidePrefix
+}
+
+// SetNamespace places every key of the client below namespace, e.g.
+// "tenant-a/", the way clientv3.Namespace does: the namespace is prepended to
+// the keys sent to etcd and stripped from those it returns, so that the same
+// election names map onto a separate part of a shared cluster, such as the
+// key range a v3 role is granted. The allowed prefix, metrics and logs see
+// keys without the namespace. Only the v3 backend applies it; on the v2 keys
+// API, mount the election below a directory with SetKeysPath instead. It
+// must be called before the client is shared between goroutines.
+func (c *EtcdClient) SetNamespace(namespace string) {
+	c.namespace = namespace
+}
+
+// Namespace returns the namespace set with SetNamespace.
+func (c *EtcdClient) Namespace() string {
+	return c.namespace
+}
@@ -0,0 +1,36 @@
+package election_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+	"github.com/jeeyoungk/etcd-leader/leadertest"
+)
+
+func TestAllowedPrefix(t *testing.T) {
+	server := leadertest.NewServer(t)
+	for _, test := range []struct {
+		prefix  string
+		key     string
+		allowed bool
+	}{
+		{"", "anything", true},
+		{"myapp/", "myapp/orders", true},
+		{"myapp", "myapp/orders", true},
+		{"/myapp", "/myapp/orders", true},
+		{"myapp", "myapp", true},
+		{"myapp", "myapp2/orders", false},
+		{"myapp/", "myapp2/orders", false},
+		{"myapp/orders", "myapp/orders-eu", false},
+		{"myapp", "other/orders", false},
+	} {
+		client := election.NewEtcdClient(server.URL)
+		client.SetAllowedPrefix(test.prefix)
+		_, err := client.Get(context.Background(), test.key, election.Option{})
+		if outside := errors.Is(err, election.ErrOutsidePrefix); outside == test.allowed {
+			t.Errorf("prefix %q, key %q: %v, want allowed %t", test.prefix, test.key, err, test.allowed)
+		}
+	}
+}
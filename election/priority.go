@@ -0,0 +1,78 @@
+package election
+
+import "context"
+
+// MaxPriority is the highest ElectionConfig.Priority of a candidate.
+const MaxPriority = 10
+
+// With ElectionConfig.Preempt, a follower that finds the lock held by a leader
+// of lower priority writes a preempt request naming itself and its priority,
+// with the TTL of the lock, and polls the lock rather than watch it so that
+// the request stays alive. The leader reads the request on every renewal and,
+// if it comes from a candidate of higher priority, deletes it and hands the
+// lock over as TransferTo does. Only the highest-priority request is kept, so
+// the lock goes to the best candidate asking for it.
+
+// preemptKey is the key of the election's preempt request.
+func (s *State) preemptKey() string {
+	return s.layout.key(s.key, "preempt")
+}
+
+// preemptValue is the value of the candidate's preempt request.
+func (s *State) preemptValue() string {
+	return record{ID: s.id, Priority: s.priority}.encode()
+}
+
+// preempt asks the leader holding the lock to hand it over, if it ranks below
+// the candidate, and reports whether it did. A pending request of a
+// candidate ranking at least as high is left alone, and also counts.
+func preempt(ctx context.Context, state *State, client *EtcdClient, holder record) bool {
+	if !state.preempt || holder.Priority >= state.priority {
+		return false
+	}
+	value := state.preemptValue()
+	option := Option{ttl: state.ttl, prevExist: -1, origin: "preempt"}
+	resp, err := client.Get(ctx, state.preemptKey(), Option{})
+	if err != nil && !answered(err) {
+		state.fail(err)
+		return true
+	} else if err == nil {
+		pending := decodeRecord(resp.Node.Value)
+		if pending.ID != state.id && pending.Priority >= state.priority {
+			return true
+		}
+		option.prevExist, option.prevValue = 0, resp.Node.Value
+	}
+	state.eventStr(LevelDebug, evPreempting, holder.ID)
+	// a failed compare means another candidate just asked first
+	if _, err := client.Put(ctx, state.preemptKey(), value, option); err != nil && !answered(err) {
+		state.fail(err)
+	}
+	return true
+}
+
+// handOff transfers the lock held by the candidate to the one that asked for
+// it with a higher priority, if any.
+func handOff(ctx context.Context, state *State, client *EtcdClient) {
+	if !state.preempt {
+		return
+	}
+	resp, err := client.Get(ctx, state.preemptKey(), Option{})
+	if err != nil {
+		if !answered(err) {
+			state.fail(err)
+		}
+		return
+	}
+	successor := decodeRecord(resp.Node.Value)
+	if successor.ID == state.id || successor.Priority <= state.priority {
+		return
+	}
+	state.eventStr(LevelInfo, evPreempted, successor.ID)
+	if _, err := client.Delete(ctx, state.preemptKey(), resp.Node.Value, Option{origin: "preempt"}); err != nil && !answered(err) {
+		state.fail(err)
+	}
+	if err := transfer(ctx, state, client, successor.ID); err != nil {
+		state.fail(err)
+	}
+}
@@ -0,0 +1,258 @@
+package election
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyBounds are the upper bounds, in seconds, of the buckets of the etcd
+// request latency histogram.
+var latencyBounds = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	// requests per bucket, the last one past every bound
+	buckets []int64
+	sum     time.Duration
+}
+
+func (h *histogram) add(d time.Duration) {
+	if h.buckets == nil {
+		h.buckets = make([]int64, len(latencyBounds)+1)
+	}
+	i := sort.SearchFloat64s(latencyBounds, d.Seconds())
+	h.buckets[i]++
+	h.sum += d
+}
+
+// LatencySample is the latency histogram of the etcd requests of one
+// operation, across elections.
+type LatencySample struct {
+	Op string `json:"op"`
+	// Bounds are the upper bounds of the buckets in seconds, and Buckets
+	// the requests taking at most as long as each, cumulatively; Count
+	// includes those past the last bound too.
+	Bounds  []float64     `json:"bounds"`
+	Buckets []int64       `json:"buckets"`
+	Count   int64         `json:"count"`
+	Sum     time.Duration `json:"sum"`
+}
+
+type activityStat struct {
+	campaigns       int64
+	failures        map[AttemptFailure]int64
+	renewals        int64
+	renewalFailures int64
+}
+
+// ActivitySample counts what the candidates of one election did.
+type ActivitySample struct {
+	Key string `json:"key"`
+	// Campaigns counts the writes trying to create the lock, successful
+	// or not, and Failures the attempts that did not win it by reason.
+	Campaigns       int64                    `json:"campaigns"`
+	Failures        map[AttemptFailure]int64 `json:"failures,omitempty"`
+	Renewals        int64                    `json:"renewals"`
+	RenewalFailures int64                    `json:"renewal_failures"`
+}
+
+// active returns the activity of key. m.mu must be held.
+func (m *Metrics) active(key string) *activityStat {
+	label := m.keyLabel(key)
+	stat, ok := m.activity[label]
+	if !ok {
+		stat = &activityStat{}
+		m.activity[label] = stat
+	}
+	return stat
+}
+
+func (m *Metrics) campaigned(key string) {
+	m.mu.Lock()
+	m.active(key).campaigns++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) attemptFailed(key string, reason AttemptFailure) {
+	m.mu.Lock()
+	stat := m.active(key)
+	if stat.failures == nil {
+		stat.failures = make(map[AttemptFailure]int64)
+	}
+	stat.failures[reason]++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) renewed(key string, ok bool) {
+	m.mu.Lock()
+	if ok {
+		m.active(key).renewals++
+	} else {
+		m.active(key).renewalFailures++
+	}
+	m.mu.Unlock()
+}
+
+// Activity returns the campaigns and renewals counted so far, ordered by key.
+func (m *Metrics) Activity() []ActivitySample {
+	m.mu.Lock()
+	samples := make([]ActivitySample, 0, len(m.activity))
+	for key, stat := range m.activity {
+		samples = append(samples, ActivitySample{
+			Key:             key,
+			Campaigns:       stat.campaigns,
+			Failures:        copyFailures(stat.failures),
+			Renewals:        stat.renewals,
+			RenewalFailures: stat.renewalFailures,
+		})
+	}
+	m.mu.Unlock()
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Key < samples[j].Key })
+	return samples
+}
+
+func copyFailures(failures map[AttemptFailure]int64) map[AttemptFailure]int64 {
+	if len(failures) == 0 {
+		return nil
+	}
+	copy := make(map[AttemptFailure]int64, len(failures))
+	for reason, n := range failures {
+		copy[reason] = n
+	}
+	return copy
+}
+
+// Latencies returns the latency histograms of etcd requests, ordered by
+// operation.
+func (m *Metrics) Latencies() []LatencySample {
+	m.mu.Lock()
+	samples := make([]LatencySample, 0, len(m.latency))
+	for op, h := range m.latency {
+		samples = append(samples, h.sample(op))
+	}
+	m.mu.Unlock()
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Op < samples[j].Op })
+	return samples
+}
+
+func (h *histogram) sample(op string) LatencySample {
+	sample := LatencySample{Op: op, Bounds: latencyBounds, Buckets: make([]int64, len(latencyBounds)), Sum: h.sum}
+	for i, n := range h.buckets {
+		sample.Count += n
+		if i < len(latencyBounds) {
+			sample.Buckets[i] = sample.Count
+		}
+	}
+	return sample
+}
+
+// StatsSource is an Elector or Manager whose Stats a Registry exports.
+type StatsSource interface {
+	Stats() Stats
+}
+
+// Registry gathers the Stats of the electors and managers of a process for a
+// single metrics endpoint. As an http.Handler, it serves them in the
+// OpenMetrics text format that Prometheus scrapes. The zero Registry is
+// ready to use.
+//
+// Client-wide metrics are counted once per client, however many of the
+// registered sources share it.
+type Registry struct {
+	mu      sync.Mutex
+	sources []StatsSource
+}
+
+// Register adds sources to the registry, ignoring those already in it.
+func (r *Registry) Register(sources ...StatsSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, source := range sources {
+		if r.index(source) < 0 {
+			r.sources = append(r.sources, source)
+		}
+	}
+}
+
+// Unregister removes source from the registry, such as an elector that
+// stopped for good.
+func (r *Registry) Unregister(source StatsSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.index(source); i >= 0 {
+		r.sources = append(r.sources[:i], r.sources[i+1:]...)
+	}
+}
+
+func (r *Registry) index(source StatsSource) int {
+	for i, s := range r.sources {
+		if s == source {
+			return i
+		}
+	}
+	return -1
+}
+
+// Gather returns the Stats of every registered source, merged.
+func (r *Registry) Gather() Stats {
+	r.mu.Lock()
+	sources := append([]StatsSource(nil), r.sources...)
+	r.mu.Unlock()
+	merged := Stats{Time: time.Now(), WatchLag: make(map[string]int64), Connections: ConnStats{Protocols: make(map[string]int64)}}
+	latencies := make(map[string]*LatencySample)
+	seenMetrics := make(map[*Metrics]bool)
+	seenClients := make(map[*EtcdClient]bool)
+	for _, source := range sources {
+		s := source.Stats()
+		merged.Elections = append(merged.Elections, s.Elections...)
+		if s.metrics == nil || !seenMetrics[s.metrics] {
+			seenMetrics[s.metrics] = true
+			merged.Operations = append(merged.Operations, s.Operations...)
+			merged.Transitions = append(merged.Transitions, s.Transitions...)
+			merged.Acquisitions = append(merged.Acquisitions, s.Acquisitions...)
+			merged.Activity = append(merged.Activity, s.Activity...)
+			for key, lag := range s.WatchLag {
+				if lag > merged.WatchLag[key] {
+					merged.WatchLag[key] = lag
+				}
+			}
+			for _, l := range s.Latencies {
+				total, ok := latencies[l.Op]
+				if !ok {
+					l.Buckets = append([]int64(nil), l.Buckets...)
+					latencies[l.Op] = &l
+					continue
+				}
+				for i := range total.Buckets {
+					total.Buckets[i] += l.Buckets[i]
+				}
+				total.Count += l.Count
+				total.Sum += l.Sum
+			}
+		}
+		if s.client == nil || !seenClients[s.client] {
+			seenClients[s.client] = true
+			c := &merged.Connections
+			c.Requests += s.Connections.Requests
+			c.Reused += s.Connections.Reused
+			c.Created += s.Connections.Created
+			c.Shared += s.Connections.Shared
+			for protocol, n := range s.Connections.Protocols {
+				c.Protocols[protocol] += n
+			}
+		}
+	}
+	for _, l := range latencies {
+		merged.Latencies = append(merged.Latencies, *l)
+	}
+	sort.Slice(merged.Latencies, func(i, j int) bool { return merged.Latencies[i].Op < merged.Latencies[j].Op })
+	sort.SliceStable(merged.Elections, func(i, j int) bool { return merged.Elections[i].Key < merged.Elections[j].Key })
+	return merged
+}
+
+// ServeHTTP writes the gathered Stats, as the /metrics handler of the process.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	r.Gather().WriteOpenMetrics(w)
+}
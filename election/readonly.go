@@ -0,0 +1,36 @@
+package election
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrReadOnly is returned by mutating client calls on a read-only client.
+var ErrReadOnly = errors.New("etcd client is read-only")
+
+// SetReadOnly makes Put and Delete fail with ErrReadOnly, for observers that
+// must never change election state. Binaries built with the readonly tag are
+// always read-only.
+func (c *EtcdClient) SetReadOnly(readOnly bool) {
+	if readOnly {
+		atomic.StoreInt32(&c.readOnly, 1)
+	} else {
+		atomic.StoreInt32(&c.readOnly, 0)
+	}
+}
+
+func (c *EtcdClient) ReadOnly() bool {
+	return atomic.LoadInt32(&c.readOnly) == 1 || readOnlyBuild
+}
+
+// checkWritable guards every mutating request. In dev builds a write from a
+// read-only client is a programming error and panics.
+func (c *EtcdClient) checkWritable(method string, key string) error {
+	if !c.ReadOnly() {
+		return nil
+	}
+	if devBuild {
+		panic(method + " " + key + ": " + ErrReadOnly.Error())
+	}
+	return ErrReadOnly
+}
@@ -0,0 +1,5 @@
+//go:build readonly
+
+package election
+
+const readOnlyBuild = true
@@ -0,0 +1,171 @@
+package election
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interaction is one recorded request to the v2 keys API and its response.
+type Interaction struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	// Path is the path and query of the request below the base URL.
+	Path    string `json:"path"`
+	Request string `json:"request,omitempty"`
+	Status  int    `json:"status"`
+	// Index is the X-Etcd-Index header of the response.
+	Index string `json:"index,omitempty"`
+	Body  string `json:"body"`
+}
+
+// recorder writes interactions as JSON lines.
+type recorder struct {
+	mu     sync.Mutex
+	w      io.Writer
+	failed bool
+}
+
+// Record appends every keys API request of the client and its response to w,
+// one JSON Interaction per line, for NewReplayer to play back. Unlike the
+// exchanges kept for WriteBundle, bodies are not truncated. It must be called
+// before the client is shared between goroutines.
+func (c *EtcdClient) Record(w io.Writer) {
+	c.recorder = &recorder{w: w}
+}
+
+func (r *recorder) add(baseUrl string, req *http.Request, status int, header http.Header, body []byte) {
+	interaction := Interaction{
+		Time:   time.Now(),
+		Method: req.Method,
+		Path:   strings.TrimPrefix(req.URL.String(), baseUrl),
+		Status: status,
+		Index:  header.Get("X-Etcd-Index"),
+		Body:   string(body),
+	}
+	if req.GetBody != nil {
+		if reader, err := req.GetBody(); err == nil {
+			sent, _ := ioutil.ReadAll(reader)
+			interaction.Request = string(sent)
+		}
+	}
+	line, _ := json.Marshal(interaction)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.w.Write(append(line, '\n')); err != nil && !r.failed {
+		// logged once; a full disk should not flood the log
+		r.failed = true
+		std.eventStr(LevelWarn, "", evRecordFailed, err.Error())
+	}
+}
+
+// ReadRecording reads the interactions written by Record.
+func ReadRecording(r io.Reader) ([]Interaction, error) {
+	var interactions []Interaction
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var interaction Interaction
+		if err := json.Unmarshal(scanner.Bytes(), &interaction); err != nil {
+			return nil, fmt.Errorf("recording line %d: %s", line, err.Error())
+		}
+		interactions = append(interactions, interaction)
+	}
+	return interactions, scanner.Err()
+}
+
+// replayBase is the base URL of clients returned by Replayer.Client.
+const replayBase = "http://replay"
+
+// Replayer plays a recording back to the election code in place of etcd, so
+// that a recording taken during an incident can become a regression test.
+// Each request is answered with the earliest unused interaction of the same
+// method and path, whatever its position in the recording, so that requests
+// issued concurrently by several elections need not arrive in the recorded
+// order. Replay does not wait out the recorded gaps between interactions.
+type Replayer struct {
+	mu      sync.Mutex
+	pending []Interaction
+	used    []bool
+	// requests that matched no interaction
+	unmatched []string
+}
+
+func NewReplayer(recording []Interaction) *Replayer {
+	return &Replayer{pending: recording, used: make([]bool, len(recording))}
+}
+
+// Client returns a client whose requests are answered from the recording.
+func (r *Replayer) Client() *EtcdClient {
+	client := NewEtcdClient(replayBase)
+	client.client = &http.Client{Transport: r}
+	return client
+}
+
+// RoundTrip answers req from the recording, or fails it once no interaction
+// is left for it.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	path := strings.TrimPrefix(req.URL.String(), replayBase)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, interaction := range r.pending {
+		if r.used[i] || interaction.Method != req.Method || interaction.Path != path {
+			continue
+		}
+		r.used[i] = true
+		header := make(http.Header)
+		header.Set("Content-Type", "application/json")
+		if interaction.Index != "" {
+			header.Set("X-Etcd-Index", interaction.Index)
+		}
+		return &http.Response{
+			Status:        strconv.Itoa(interaction.Status) + " " + http.StatusText(interaction.Status),
+			StatusCode:    interaction.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(strings.NewReader(interaction.Body)),
+			ContentLength: int64(len(interaction.Body)),
+			Request:       req,
+		}, nil
+	}
+	r.unmatched = append(r.unmatched, req.Method+" "+path)
+	return nil, fmt.Errorf("replay: no recorded interaction left for %s %s", req.Method, path)
+}
+
+// Remaining returns how many interactions of the recording were not played
+// back yet.
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	remaining := 0
+	for _, used := range r.used {
+		if !used {
+			remaining++
+		}
+	}
+	return remaining
+}
+
+// Unmatched returns the requests, as "METHOD path", that found no
+// interaction to replay.
+func (r *Replayer) Unmatched() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.unmatched...)
+}
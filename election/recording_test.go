@@ -0,0 +1,59 @@
+package election_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+	"github.com/jeeyoungk/etcd-leader/leadertest"
+)
+
+// TestReplayCampaign records a campaign against a server and plays it back
+// to a candidate with nothing but the recording to talk to.
+func TestReplayCampaign(t *testing.T) {
+	server := leadertest.NewServer(t)
+	var recording bytes.Buffer
+	client := election.NewEtcdClient(server.URL)
+	client.Record(&recording)
+	recorded, err := election.New(client, "replay", "a", election.ElectionConfig{TTL: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := recorded.Campaign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorded.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	interactions, err := election.ReadRecording(&recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayer := election.NewReplayer(interactions)
+	replay := replayer.Client()
+	// settings of the HTTP transport do not apply to a replay
+	replay.SetTLS(&tls.Config{})
+	replay.SetCompression(false)
+	replay.SetKeepAlive(time.Second)
+	if err := replay.SetEndpoints([]string{"http://a", "http://b"}); err == nil {
+		t.Error("endpoints set on a replaying client")
+	}
+
+	replayed, err := election.New(replay, "replay", "a", election.ElectionConfig{TTL: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayed.Stop()
+	if _, err := replayed.Campaign(ctx); err != nil {
+		t.Fatalf("replayed campaign failed: %s (unmatched %q)", err, replayer.Unmatched())
+	}
+	if replayer.Remaining() == len(interactions) {
+		t.Fatal("nothing of the recording was played back")
+	}
+}
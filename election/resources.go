@@ -0,0 +1,82 @@
+package election
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Resources counts what elections hold at one point in time: goroutines
+// running their loops and subscriptions, open watches, and pending timers.
+type Resources struct {
+	Goroutines int `json:"goroutines"`
+	Watches    int `json:"watches"`
+	Timers     int `json:"timers"`
+}
+
+func (r Resources) add(other Resources) Resources {
+	return Resources{
+		Goroutines: r.Goroutines + other.Goroutines,
+		Watches:    r.Watches + other.Watches,
+		Timers:     r.Timers + other.Timers,
+	}
+}
+
+// usage holds the counters behind Resources; all fields are accessed
+// atomically.
+type usage struct {
+	goroutines int32
+	watches    int32
+	timers     int32
+}
+
+func (u *usage) snapshot() Resources {
+	return Resources{
+		Goroutines: int(atomic.LoadInt32(&u.goroutines)),
+		Watches:    int(atomic.LoadInt32(&u.watches)),
+		Timers:     int(atomic.LoadInt32(&u.timers)),
+	}
+}
+
+// hold counts a goroutine, and a watch if watch is set, until the returned
+// function is called.
+func (u *usage) hold(watch bool) func() {
+	atomic.AddInt32(&u.goroutines, 1)
+	if watch {
+		atomic.AddInt32(&u.watches, 1)
+	}
+	return func() {
+		atomic.AddInt32(&u.goroutines, -1)
+		if watch {
+			atomic.AddInt32(&u.watches, -1)
+		}
+	}
+}
+
+// sleep pauses the election for d, or until the election is stopped,
+// counting the timer.
+func (s *State) sleep(d time.Duration) {
+	s.sleepUntil(d, s.stop)
+}
+
+// sleepUntil pauses the election for d or until done is closed, and reports
+// whether the full d passed.
+func (s *State) sleepUntil(d time.Duration, done <-chan struct{}) bool {
+	atomic.AddInt32(&s.usage.timers, 1)
+	defer atomic.AddInt32(&s.usage.timers, -1)
+	if s.waits != nil {
+		select {
+		case <-s.waits.After(d):
+			return true
+		case <-done:
+			return false
+		}
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
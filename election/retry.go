@@ -0,0 +1,103 @@
+package election
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy decides how a candidate waits out failed requests to etcd, so
+// that an outage of the cluster does not remove it from the election. After
+// each failed iteration of the election loop the candidate waits Initial,
+// doubled with every further consecutive failure up to Max, then tries again.
+type RetryPolicy struct {
+	// Initial defaults to a quarter of the TTL, the interval of a poll.
+	Initial time.Duration
+	// Max defaults to twice the TTL.
+	Max time.Duration
+	// MaxElapsed is how long a candidate keeps failing before it gives up
+	// and leaves the election with ErrRetriesExhausted. Zero retries
+	// forever.
+	MaxElapsed time.Duration
+	// Jitter spreads each wait by up to this fraction either way, so that
+	// candidates that failed together do not retry together. Defaults to
+	// 0.5.
+	Jitter float64
+}
+
+// merge returns p with its zero fields taken from defaults.
+func (p RetryPolicy) merge(defaults RetryPolicy) RetryPolicy {
+	if p.Initial == 0 {
+		p.Initial = defaults.Initial
+	}
+	if p.Max == 0 {
+		p.Max = defaults.Max
+	}
+	if p.MaxElapsed == 0 {
+		p.MaxElapsed = defaults.MaxElapsed
+	}
+	if p.Jitter == 0 {
+		p.Jitter = defaults.Jitter
+	}
+	return p
+}
+
+func (p RetryPolicy) validate() []string {
+	var problems []string
+	if p.Initial < 0 || p.Max < 0 || p.MaxElapsed < 0 {
+		problems = append(problems, fmt.Sprintf("retry durations %s, %s and %s must not be negative", p.Initial, p.Max, p.MaxElapsed))
+	} else if p.Max < p.Initial {
+		problems = append(problems, fmt.Sprintf("retry max %s is shorter than its initial %s", p.Max, p.Initial))
+	}
+	if p.Jitter < 0 || p.Jitter >= 1 {
+		problems = append(problems, fmt.Sprintf("retry jitter %g is outside [0, 1)", p.Jitter))
+	}
+	return problems
+}
+
+// delay is the wait after the given number of consecutive failures.
+func (p RetryPolicy) delay(failures int) time.Duration {
+	d := p.Initial
+	for i := 1; i < failures && d < p.Max; i++ {
+		d *= 2
+	}
+	if d > p.Max {
+		d = p.Max
+	}
+	return time.Duration(float64(d) * (1 + p.Jitter*(2*rand.Float64()-1)))
+}
+
+// next waits for the next iteration of the election loop after one that
+// succeeded or failed: the poll or watch of pause after a success, the
+// backoff of the retry policy after a failure. It reports false once done is
+// closed, or once the candidate gives up.
+func (s *State) next(ctx context.Context, client *EtcdClient, success bool, done <-chan struct{}) bool {
+	if success {
+		s.failures = 0
+		return s.pause(ctx, client, done)
+	}
+	select {
+	case <-done:
+		// failed because the candidate is stopping
+		return false
+	default:
+	}
+	if s.failures == 0 {
+		s.failingSince = time.Now()
+	}
+	s.failures++
+	if s.retry.MaxElapsed > 0 && time.Since(s.failingSince) >= s.retry.MaxElapsed {
+		s.eventInt(LevelError, evGaveUp, int64(s.failures))
+		if s.isLeader() {
+			demote(s, "gave up retrying")
+		}
+		atomic.StoreInt32(&s.exhausted, 1)
+		s.fail(ErrRetriesExhausted)
+		return false
+	}
+	delay := s.retry.delay(s.failures)
+	s.eventStr(LevelWarn, evRetrying, delay.Round(time.Millisecond).String())
+	return s.sleepUntil(delay, done)
+}
@@ -0,0 +1,145 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrClosed is returned for work refused because the manager was closed.
+var ErrClosed = errors.New("manager is closed")
+
+// ShutdownReport describes how a manager left its elections, so that deploy
+// tooling can check that a node went away cleanly.
+type ShutdownReport struct {
+	ID       string            `json:"id"`
+	Time     time.Time         `json:"time"`
+	Duration time.Duration     `json:"duration"`
+	Resigned []string          `json:"resigned"`
+	Cleaned  []string          `json:"cleaned"`
+	Failures []ShutdownFailure `json:"failures,omitempty"`
+}
+
+// ShutdownFailure is one election or key the manager could not leave
+// cleanly.
+type ShutdownFailure struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+func (r *ShutdownReport) fail(key string, err error) {
+	r.Failures = append(r.Failures, ShutdownFailure{Key: key, Error: err.Error()})
+}
+
+// Close stops campaigning for every shard, waits for election iterations in
+// progress to finish, and releases the locks this node holds. The keys its
+// candidates keep for themselves, heartbeat, contender and preempt request,
+// are deleted too rather than left to expire, so that other candidates and
+// dashboards see the node gone at once. If ctx is done first, locks are
+// released anyway and the shards still running are reported as failures.
+// The returned error is non-nil if anything failed.
+func (m *Manager) Close(ctx context.Context) (ShutdownReport, error) {
+	start := time.Now()
+	report := ShutdownReport{ID: m.id, Time: start, Resigned: []string{}, Cleaned: []string{}}
+
+	m.mu.Lock()
+	select {
+	case <-m.ctx.Done():
+		m.mu.Unlock()
+		return report, ErrClosed
+	default:
+	}
+	m.cancel()
+	states := make([]*State, 0, len(m.states))
+	for _, state := range m.states {
+		states = append(states, state)
+	}
+	m.mu.Unlock()
+	sort.Slice(states, func(i, j int) bool { return states[i].key < states[j].key })
+
+	stopped := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		m.mu.Lock()
+		for _, state := range states {
+			if _, ok := m.states[state.key]; ok {
+				report.fail(state.key, fmt.Errorf("election loop still running: %s", ctx.Err()))
+			}
+		}
+		m.mu.Unlock()
+	}
+
+	// the releases must not be cut short by ctx; a lock left behind holds
+	// up every other candidate for a full TTL
+	release := context.Background()
+	for _, state := range states {
+		if state.isLeader() {
+			if err := resign(release, state, m.client, state.clearBroadcast); err != nil {
+				report.fail(state.leaderKey(), err)
+			} else {
+				report.Resigned = append(report.Resigned, state.key)
+			}
+			state.setPhase(PhaseIdle, "closed")
+		}
+		cleanUp(release, state, m.client, &report)
+	}
+	report.Duration = time.Since(start)
+
+	m.eventStr(LevelInfo, evShutdown, fmt.Sprintf("resigned %d, cleaned %d, failed %d", len(report.Resigned), len(report.Cleaned), len(report.Failures)))
+	if len(report.Failures) == 0 {
+		return report, nil
+	}
+	failures := make([]string, len(report.Failures))
+	for i, failure := range report.Failures {
+		m.eventStr(LevelError, evShutdownFailed, failure.Key+": "+failure.Error)
+		failures[i] = failure.Key + ": " + failure.Error
+	}
+	return report, fmt.Errorf("unclean shutdown: %s", strings.Join(failures, "; "))
+}
+
+// cleanUp deletes the keys the candidate keeps for itself, each only while it
+// still holds the value this incarnation wrote, and records them in report.
+// A key that is gone or was since taken over is left alone.
+func cleanUp(ctx context.Context, state *State, client *EtcdClient, report *ShutdownReport) {
+	if state.observer {
+		return
+	}
+	if state.campaign.enlisted != 0 {
+		state.campaign.enlisted = 0
+		cleanKey(ctx, client, state.contenderKey(), state.contenderValue(), report)
+	}
+	if state.heartbeat != 0 && state.encoded() != "" {
+		cleanKey(ctx, client, state.heartbeatKey(), state.encoded(), report)
+	}
+	if state.preempt {
+		cleanKey(ctx, client, state.preemptKey(), state.preemptValue(), report)
+	}
+}
+
+func cleanKey(ctx context.Context, client *EtcdClient, key string, value string, report *ShutdownReport) {
+	if deleted, err := discard(ctx, client, key, value, "shutdown"); err != nil {
+		report.fail(key, err)
+	} else if deleted {
+		report.Cleaned = append(report.Cleaned, key)
+	}
+}
+
+// discard deletes key if it holds value, and reports whether it did.
+func discard(ctx context.Context, client *EtcdClient, key string, value string, origin string) (bool, error) {
+	_, err := client.Delete(ctx, key, value, Option{origin: origin})
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrKeyNotFound), errors.Is(err, ErrTestFailed):
+		return false, nil
+	}
+	return false, err
+}
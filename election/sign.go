@@ -0,0 +1,162 @@
+package election
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Signer signs the leader announcements of a candidate, so that observers can
+// tell them apart from values forged by other etcd writers. The message
+// covers the election key and the whole announcement but its signature.
+type Signer interface {
+	Sign(message []byte) ([]byte, error)
+}
+
+// Verifier checks the signature on a leader announcement of node id.
+type Verifier interface {
+	Verify(id string, message []byte, sig []byte) error
+}
+
+var (
+	ErrUnsigned     = errors.New("leader value is not signed")
+	ErrBadSignature = errors.New("leader value has an invalid signature")
+	ErrUnknownNode  = errors.New("leader value is signed by an unknown node")
+)
+
+// binding ties sealed metadata to the election key and the id announced
+// with it, so that it cannot be moved into another announcement.
+func binding(key string, id string) []byte {
+	return []byte(key + "\x00" + id)
+}
+
+// message is what the signature of r covers: the election key, so that an
+// announcement cannot be replayed into another election, and every field of
+// r but the signature. The instance or nonce among them ties the signature
+// to one process or acquisition, so that it cannot vouch for an
+// announcement with other metadata or priority either.
+func message(key string, r record) []byte {
+	r.Sig = nil
+	data, _ := json.Marshal(r)
+	return append([]byte(key+"\x00"), data...)
+}
+
+// HMACKey signs and verifies with a key shared between signer and observers.
+type HMACKey []byte
+
+func (k HMACKey) Sign(message []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, k)
+	mac.Write(message)
+	return mac.Sum(nil), nil
+}
+
+func (k HMACKey) Verify(id string, message []byte, sig []byte) error {
+	expected, _ := k.Sign(message)
+	if !hmac.Equal(expected, sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// HMACKeyring verifies announcements with a separate HMAC key per node id.
+type HMACKeyring map[string]HMACKey
+
+func (r HMACKeyring) Verify(id string, message []byte, sig []byte) error {
+	k, ok := r[id]
+	if !ok {
+		return ErrUnknownNode
+	}
+	return k.Verify(id, message, sig)
+}
+
+// Ed25519Signer signs with a node's private key.
+type Ed25519Signer ed25519.PrivateKey
+
+func (s Ed25519Signer) Sign(message []byte) ([]byte, error) {
+	return ed25519.Sign(ed25519.PrivateKey(s), message), nil
+}
+
+// Ed25519Keyring verifies announcements with the public key of each node id.
+type Ed25519Keyring map[string]ed25519.PublicKey
+
+func (r Ed25519Keyring) Verify(id string, message []byte, sig []byte) error {
+	public, ok := r[id]
+	if !ok {
+		return ErrUnknownNode
+	}
+	if !ed25519.Verify(public, message, sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// record is the payload of the leader and broadcast keys. Records with only
+// an id are written as the bare id, as before signing and metadata existed.
+// Instance tells apart processes running under the same id, with
+// ElectionConfig.ExclusiveID; Nonce tells apart the acquisition attempts of
+// a candidate on v3, and the processes of a signing one.
+type record struct {
+	ID       string            `json:"id"`
+	Instance string            `json:"instance,omitempty"`
+	Nonce    string            `json:"nonce,omitempty"`
+	Priority int               `json:"priority,omitempty"`
+	Meta     map[string]string `json:"meta,omitempty"`
+	Sealed   []byte            `json:"sealed,omitempty"`
+	Sig      []byte            `json:"sig,omitempty"`
+}
+
+func (r record) encode() string {
+	if r.Sig == nil && r.Meta == nil && r.Sealed == nil && r.Instance == "" && r.Nonce == "" && r.Priority == 0 {
+		return r.ID
+	}
+	data, _ := json.Marshal(r)
+	return string(data)
+}
+
+func decodeRecord(value string) record {
+	var r record
+	if strings.HasPrefix(value, "{") && json.Unmarshal([]byte(value), &r) == nil {
+		return r
+	}
+	return record{ID: value}
+}
+
+// encodeValue returns the value announcing id as the leader of key. The
+// metadata is encrypted when cipher is set; signer and cipher may be nil,
+// instance and nonce empty and priority zero.
+func encodeValue(key string, id string, instance string, nonce string, priority int, metadata map[string]string, signer Signer, cipher *MetadataCipher) (string, error) {
+	r := record{ID: id, Instance: instance, Nonce: nonce, Priority: priority}
+	if len(metadata) > 0 {
+		if cipher == nil {
+			r.Meta = metadata
+		} else if sealed, err := cipher.seal(key, id, metadata); err != nil {
+			return "", err
+		} else {
+			r.Sealed = sealed
+		}
+	}
+	if signer != nil {
+		sig, err := signer.Sign(message(key, r))
+		if err != nil {
+			return "", err
+		}
+		r.Sig = sig
+	}
+	return r.encode(), nil
+}
+
+// verifyValue decodes a leader value of key, checking its signature when
+// verifier is set.
+func verifyValue(key string, value string, verifier Verifier) (record, error) {
+	r := decodeRecord(value)
+	if verifier == nil {
+		return r, nil
+	}
+	if r.Sig == nil {
+		return r, ErrUnsigned
+	}
+	return r, verifier.Verify(r.ID, message(key, r), r.Sig)
+}
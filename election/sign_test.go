@@ -0,0 +1,61 @@
+package election_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+	"github.com/jeeyoungk/etcd-leader/leadertest"
+)
+
+// TestSignedAnnouncement checks that the signature covers the whole
+// announcement and the election it was written to.
+func TestSignedAnnouncement(t *testing.T) {
+	server := leadertest.NewServer(t)
+	client := election.NewEtcdClient(server.URL)
+	key := election.HMACKey("shared")
+	manager := election.NewManager(client, "node", time.Second, 1)
+	manager.SetSigner(key)
+	manager.Start([]string{"signed"})
+	t.Cleanup(func() { manager.Close(context.Background()) })
+	server.WaitForLeader(t, "signed", 5*time.Second)
+
+	resp, err := client.Get(context.Background(), "signed-leader", election.Option{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := resp.Node.Value
+	if announcement, err := election.DecodeAnnouncement("signed", value, key, nil); err != nil {
+		t.Fatalf("announcement of the leader does not verify: %s", err)
+	} else if announcement.ID != "node" {
+		t.Fatalf("announcement names %q, want node", announcement.ID)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		t.Fatalf("signed value %q is not a record: %s", value, err)
+	}
+	if fields["nonce"] == nil {
+		t.Fatalf("signed value %q carries no nonce", value)
+	}
+	fields["priority"] = 100
+	tampered, _ := json.Marshal(fields)
+
+	for _, c := range []struct {
+		name     string
+		key      string
+		value    string
+		verifier election.Verifier
+	}{
+		{"another election", "other", value, key},
+		{"tampered record", "signed", string(tampered), key},
+		{"another key", "signed", value, election.HMACKey("other")},
+	} {
+		if _, err := election.DecodeAnnouncement(c.key, c.value, c.verifier, nil); !errors.Is(err, election.ErrBadSignature) {
+			t.Errorf("%s: got %v, want ErrBadSignature", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package election
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stats is a point-in-time summary of a manager's elections and of the etcd
+// client they share.
+type Stats struct {
+	Time        time.Time
+	Elections   []ElectionStats
+	Operations  []OpSample
+	Transitions []TransitionSample
+	// Acquisitions are the lock acquisitions by election.
+	Acquisitions []AcquisitionSample
+	Activity     []ActivitySample
+	Latencies    []LatencySample
+	WatchLag     map[string]int64
+	Connections  ConnStats
+
+	// where the client-wide parts come from, for Registry to count them
+	// once
+	metrics *Metrics
+	client  *EtcdClient
+}
+
+// ElectionStats is the standing of one election of a manager or elector.
+type ElectionStats struct {
+	Key       string
+	ID        string
+	Leader    bool
+	Phase     Phase
+	Observing bool
+	Resources Resources
+}
+
+// Stats summarizes the manager's elections, ordered by key.
+func (m *Manager) Stats() Stats {
+	m.mu.Lock()
+	elections := make([]ElectionStats, 0, len(m.states))
+	for key, state := range m.states {
+		_, observing := state.leaderStatus()
+		elections = append(elections, ElectionStats{
+			Key:       key,
+			ID:        m.id,
+			Leader:    state.isLeader(),
+			Phase:     state.phase(),
+			Observing: observing,
+			Resources: state.usage.snapshot(),
+		})
+	}
+	m.mu.Unlock()
+	sort.Slice(elections, func(i, j int) bool { return elections[i].Key < elections[j].Key })
+	return m.client.summary(elections)
+}
+
+// Stats summarizes the candidate's election, along with the client it runs
+// on like Manager.Stats.
+func (c *Elector) Stats() Stats {
+	_, observing := c.state.leaderStatus()
+	return c.client.summary([]ElectionStats{{
+		Key:       c.state.key,
+		ID:        c.state.id,
+		Leader:    c.state.isLeader(),
+		Phase:     c.state.phase(),
+		Observing: observing,
+		Resources: c.state.usage.snapshot(),
+	}})
+}
+
+// summary completes elections with the client-wide metrics.
+func (c *EtcdClient) summary(elections []ElectionStats) Stats {
+	metrics := c.Metrics()
+	return Stats{
+		Time:         time.Now(),
+		Elections:    elections,
+		Operations:   metrics.Snapshot(),
+		Transitions:  metrics.Transitions(),
+		Acquisitions: metrics.Acquisitions(),
+		Activity:     metrics.Activity(),
+		Latencies:    metrics.Latencies(),
+		WatchLag:     metrics.WatchLag(),
+		Connections:  c.ConnStats(),
+		metrics:      metrics,
+		client:       c,
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteOpenMetrics writes s in the OpenMetrics text format, for applications
+// that want election metrics in their own diagnostics without running a
+// metrics handler.
+func (s Stats) WriteOpenMetrics(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	family := func(name, kind, help string) {
+		fmt.Fprintf(b, "# TYPE %s %s\n# HELP %s %s\n", name, kind, name, help)
+	}
+	sample := func(name string, value interface{}, labels ...string) {
+		b.WriteString(name)
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i == 0 {
+				b.WriteByte('{')
+			} else {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+			if i+2 >= len(labels) {
+				b.WriteByte('}')
+			}
+		}
+		fmt.Fprintf(b, " %v\n", value)
+	}
+	gauge := func(v bool) int {
+		if v {
+			return 1
+		}
+		return 0
+	}
+
+	family("etcd_leader_is_leader", "gauge", "Whether this node holds the lock of the election.")
+	for _, e := range s.Elections {
+		sample("etcd_leader_is_leader", gauge(e.Leader), "key", e.Key, "id", e.ID)
+	}
+	family("etcd_leader_observing", "gauge", "Whether this node only observes the election.")
+	for _, e := range s.Elections {
+		sample("etcd_leader_observing", gauge(e.Observing), "key", e.Key, "id", e.ID)
+	}
+	family("etcd_leader_phase", "gauge", "The phase of this node in the election, one series per phase.")
+	for _, e := range s.Elections {
+		for _, phase := range Phases {
+			sample("etcd_leader_phase", gauge(e.Phase == phase), "key", e.Key, "id", e.ID, "phase", phase.String())
+		}
+	}
+	family("etcd_leader_phase_transitions", "counter", "Phase transitions by election and phases.")
+	for _, t := range s.Transitions {
+		sample("etcd_leader_phase_transitions_total", t.Count, "key", t.Key, "from", t.From.String(), "to", t.To.String())
+	}
+	family("etcd_leader_acquisitions", "counter", "Lock acquisitions by election.")
+	for _, a := range s.Acquisitions {
+		sample("etcd_leader_acquisitions_total", a.Count, "key", a.Key)
+	}
+	family("etcd_leader_contended_acquisitions", "counter", "Lock acquisitions whose campaign saw the lock held by another candidate.")
+	for _, a := range s.Acquisitions {
+		sample("etcd_leader_contended_acquisitions_total", a.Contended, "key", a.Key)
+	}
+	family("etcd_leader_acquisition_attempts", "counter", "Writes trying to create the lock, by election, of campaigns that won it.")
+	for _, a := range s.Acquisitions {
+		sample("etcd_leader_acquisition_attempts_total", a.Attempts, "key", a.Key)
+	}
+	family("etcd_leader_acquisition_seconds", "counter", "Total time from the start of a campaign to winning the lock.")
+	for _, a := range s.Acquisitions {
+		sample("etcd_leader_acquisition_seconds_total", a.Latency.Seconds(), "key", a.Key)
+	}
+	family("etcd_leader_campaigns", "counter", "Writes trying to create the lock, by election.")
+	for _, a := range s.Activity {
+		sample("etcd_leader_campaigns_total", a.Campaigns, "key", a.Key)
+	}
+	family("etcd_leader_campaign_failures", "counter", "Attempts to take a free lock that did not win it, by election and reason.")
+	for _, a := range s.Activity {
+		reasons := make([]string, 0, len(a.Failures))
+		for reason := range a.Failures {
+			reasons = append(reasons, string(reason))
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			sample("etcd_leader_campaign_failures_total", a.Failures[AttemptFailure(reason)], "key", a.Key, "reason", reason)
+		}
+	}
+	family("etcd_leader_renewals", "counter", "Successful renewals of the lock, by election.")
+	for _, a := range s.Activity {
+		sample("etcd_leader_renewals_total", a.Renewals, "key", a.Key)
+	}
+	family("etcd_leader_renewal_failures", "counter", "Failed renewals of the lock, by election.")
+	for _, a := range s.Activity {
+		sample("etcd_leader_renewal_failures_total", a.RenewalFailures, "key", a.Key)
+	}
+	family("etcd_leader_request_duration_seconds", "histogram", "Latency of etcd requests by operation.")
+	for _, l := range s.Latencies {
+		for i, bound := range l.Bounds {
+			sample("etcd_leader_request_duration_seconds_bucket", l.Buckets[i], "op", l.Op, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		sample("etcd_leader_request_duration_seconds_bucket", l.Count, "op", l.Op, "le", "+Inf")
+		sample("etcd_leader_request_duration_seconds_sum", l.Sum.Seconds(), "op", l.Op)
+		sample("etcd_leader_request_duration_seconds_count", l.Count, "op", l.Op)
+	}
+	family("etcd_leader_operations", "counter", "etcd requests by election, operation and outcome.")
+	for _, op := range s.Operations {
+		sample("etcd_leader_operations_total", op.Count, "key", op.Key, "op", op.Op, "outcome", op.Outcome)
+	}
+	family("etcd_leader_operation_seconds", "counter", "Total latency of etcd requests.")
+	for _, op := range s.Operations {
+		sample("etcd_leader_operation_seconds_total", op.Latency.Seconds(), "key", op.Key, "op", op.Op, "outcome", op.Outcome)
+	}
+	var total Resources
+	for _, e := range s.Elections {
+		total = total.add(e.Resources)
+	}
+	family("etcd_leader_goroutines", "gauge", "Goroutines held by the elections.")
+	sample("etcd_leader_goroutines", total.Goroutines)
+	family("etcd_leader_watches", "gauge", "Open watches held by the elections.")
+	sample("etcd_leader_watches", total.Watches)
+	family("etcd_leader_timers", "gauge", "Pending timers held by the elections.")
+	sample("etcd_leader_timers", total.Timers)
+	keys := make([]string, 0, len(s.WatchLag))
+	for key := range s.WatchLag {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	family("etcd_leader_watch_lag", "gauge", "etcd indexes the election's watcher trails the cluster index.")
+	for _, key := range keys {
+		sample("etcd_leader_watch_lag", s.WatchLag[key], "key", key)
+	}
+	family("etcd_leader_connections", "counter", "HTTP connections to etcd by whether they were reused.")
+	sample("etcd_leader_connections_total", s.Connections.Reused, "state", "reused")
+	sample("etcd_leader_connections_total", s.Connections.Created, "state", "created")
+	b.WriteString("# EOF\n")
+	return b.Flush()
+}
@@ -0,0 +1,78 @@
+package election
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SetRequestTimeout bounds every request of the client other than watches,
+// which SetMaxWait bounds instead; zero leaves them to the caller's context.
+// A slow request of the election loop otherwise holds up the renewals
+// queued behind it for as long as etcd takes. It must be called before the
+// client is shared between goroutines.
+func (c *EtcdClient) SetRequestTimeout(timeout time.Duration) {
+	c.requestTimeout = timeout
+}
+
+// bound applies the client's request timeout to req, unless it is a watch.
+func (c *EtcdClient) bound(req *http.Request, op string) (*http.Request, context.CancelFunc) {
+	if c.requestTimeout == 0 || op == "watch" {
+		return req, func() {}
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), c.requestTimeout)
+	return req.WithContext(ctx), cancel
+}
+
+// Unsafe lists what makes the election config, as run through client, known
+// to be unsafe, which Strict refuses rather than warn about:
+//   - a TTL shorter than twice the client's request timeout, or no timeout,
+//     so that a renewal can hang until the lock has expired;
+//   - a renew interval over half the TTL, which leaves a single failed
+//     renewal no time for another;
+//   - no LeaseGuard, so that a leader cut off from etcd goes on acting as
+//     one after its lock expires;
+//   - no BroadcastTTL, so that the broadcast key names a crashed leader
+//     until a successor takes over.
+func (c ElectionConfig) Unsafe(client *EtcdClient) []string {
+	var problems []string
+	if timeout := client.requestTimeout; timeout == 0 {
+		problems = append(problems, "requests have no timeout")
+	} else if c.TTL < 2*timeout {
+		problems = append(problems, fmt.Sprintf("ttl %s is shorter than twice the request timeout %s", c.TTL, timeout))
+	}
+	if c.RenewInterval > c.TTL/2 {
+		problems = append(problems, fmt.Sprintf("renew interval %s is over half the ttl", c.RenewInterval))
+	}
+	if !c.LeaseGuard {
+		problems = append(problems, "no lease guard")
+	}
+	if c.BroadcastTTL == 0 {
+		problems = append(problems, "broadcast key has no ttl")
+	}
+	return problems
+}
+
+// strict refuses the elections of config that are Strict and unsafe.
+func (c *ManagerConfig) strict(client *EtcdClient) error {
+	var problems []string
+	for _, shard := range append([]string{""}, c.shards()...) {
+		election := c.Election(shard)
+		if !election.Strict {
+			continue
+		}
+		name := shard
+		if name == "" {
+			name = "defaults"
+		}
+		for _, problem := range election.Unsafe(client) {
+			problems = append(problems, name+": "+problem)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("unsafe config refused in strict mode: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// ThrottlePolicy limits how many acquisition attempts hit etcd at once across
+// a fleet, such as when the leases of many elections expire together after
+// an etcd outage ends. Before trying to take a free lock, a candidate draws a
+// token from the budget under Key, shared by every election and candidate
+// configured with it: one of Budget slot keys, created with a TTL of Window.
+// The token lets the candidate attempt for the rest of the window; without
+// one it is throttled, and tries again at its next poll. So at most Budget
+// candidates start attempting per window, whatever the size of the fleet.
+//
+// Drawing a token is a single write to a random slot, so a candidate may be
+// throttled while other slots are free; the budget is a ceiling rather than
+// a quota. Errors drawing a token let the candidate attempt anyway, since
+// etcd then fails the attempt too.
+type ThrottlePolicy struct {
+	// Key is the directory of the slot keys; empty disables throttling.
+	Key string
+	// Budget is the number of slots.
+	Budget int
+	// Window is the TTL of a token. Defaults to 1s.
+	Window time.Duration
+}
+
+// merge returns p with its zero fields taken from defaults.
+func (p ThrottlePolicy) merge(defaults ThrottlePolicy) ThrottlePolicy {
+	if p.Key == "" {
+		p.Key = defaults.Key
+	}
+	if p.Budget == 0 {
+		p.Budget = defaults.Budget
+	}
+	if p.Window == 0 {
+		p.Window = defaults.Window
+	}
+	return p
+}
+
+func (p ThrottlePolicy) validate() []string {
+	if p.Key == "" {
+		return nil
+	}
+	var problems []string
+	if p.Budget < 1 {
+		problems = append(problems, fmt.Sprintf("throttle budget %d is less than 1", p.Budget))
+	}
+	if p.Window < time.Second || p.Window%time.Second != 0 {
+		problems = append(problems, fmt.Sprintf("throttle window %s is not a whole number of seconds", p.Window))
+	}
+	return problems
+}
+
+// throttled reports whether the fleet's budget keeps the candidate from
+// attempting to take a free lock this time round.
+func throttled(ctx context.Context, state *State, client *EtcdClient) bool {
+	policy := state.throttle
+	if policy.Key == "" || time.Now().Before(state.token) {
+		return false
+	}
+	slot := fmt.Sprintf("%s/%d", policy.Key, rand.Intn(policy.Budget))
+	drawn := time.Now()
+	_, err := client.Put(ctx, slot, state.id, Option{ttl: policy.Window, prevExist: -1, origin: "throttle"})
+	if errors.Is(err, ErrNodeExist) {
+		state.eventStr(LevelDebug, evThrottled, policy.Key)
+		return true
+	} else if err != nil {
+		state.fail(err)
+		return false
+	}
+	state.token = drawn.Add(policy.Window)
+	return false
+}
@@ -0,0 +1,100 @@
+package election
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+// TLSFiles names the PEM files securing the connection to an etcd cluster
+// that serves https:// endpoints.
+type TLSFiles struct {
+	// CA is the bundle verifying etcd's certificate, in place of the
+	// system roots.
+	CA string
+	// Cert and Key are the client certificate and its key, for clusters
+	// that authenticate clients by certificate.
+	Cert string
+	Key  string
+	// InsecureSkipVerify accepts any certificate etcd presents. It is meant
+	// for test clusters only.
+	InsecureSkipVerify bool
+}
+
+// Config loads the files into a TLS configuration.
+func (f TLSFiles) Config() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: f.InsecureSkipVerify}
+	if f.CA != "" {
+		pem, err := ioutil.ReadFile(f.CA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New(f.CA + ": no certificates found")
+		}
+	}
+	if f.Cert != "" || f.Key != "" {
+		if f.Cert == "" || f.Key == "" {
+			return nil, errors.New("a client certificate needs both a cert and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(f.Cert, f.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// SetTLS sets the TLS configuration of connections to https:// endpoints. It
+// must be called before the client is shared between goroutines, and has no
+// effect on a client without an HTTP transport, such as a Replayer's.
+func (c *EtcdClient) SetTLS(config *tls.Config) {
+	if transport := c.transport(); transport != nil {
+		transport.TLSClientConfig = config
+	}
+}
+
+// CertificateIdentity returns the identity a certificate names: its common
+// name, or else its first DNS, URI or email subject alternative name.
+func CertificateIdentity(cert tls.Certificate) (string, error) {
+	leaf := cert.Leaf
+	if leaf == nil {
+		if len(cert.Certificate) == 0 {
+			return "", errors.New("empty certificate")
+		}
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return "", err
+		}
+	}
+	switch {
+	case leaf.Subject.CommonName != "":
+		return leaf.Subject.CommonName, nil
+	case len(leaf.DNSNames) > 0:
+		return leaf.DNSNames[0], nil
+	case len(leaf.URIs) > 0:
+		return leaf.URIs[0].String(), nil
+	case len(leaf.EmailAddresses) > 0:
+		return leaf.EmailAddresses[0], nil
+	}
+	return "", errors.New("certificate names no identity")
+}
+
+// Identity returns the identity of the client certificate the client
+// presents to etcd, or "" if it presents none. Electors and managers created
+// with an empty id campaign under it.
+func (c *EtcdClient) Identity() string {
+	transport := c.transport()
+	if transport == nil || transport.TLSClientConfig == nil {
+		return ""
+	}
+	config := transport.TLSClientConfig
+	if len(config.Certificates) == 0 {
+		return ""
+	}
+	id, _ := CertificateIdentity(config.Certificates[0])
+	return id
+}
@@ -0,0 +1,92 @@
+package election
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Tracer starts the spans tracing a client's etcd requests and the campaign,
+// renewal and resignation of its elections, so that a slow change of leader
+// can be followed from the application's own spans down to the requests that
+// held it up. Spans are children of the span in ctx. An OpenTelemetry adapter
+// starts a span of a trace.Tracer with the fields as attributes, and ends it
+// after recording a non-nil error and setting the span's status to Error.
+type Tracer interface {
+	Start(ctx context.Context, name string, fields ...Field) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SetFields(fields ...Field)
+	// End ends the span, failed if err is non-nil.
+	End(err error)
+}
+
+// SetTracer traces the client's requests, and the elections campaigning
+// through it, with tracer; nil turns tracing off. It must be called before
+// the client is shared between goroutines.
+func (c *EtcdClient) SetTracer(tracer Tracer) {
+	c.tracer = tracer
+}
+
+type noSpan struct{}
+
+func (noSpan) SetFields(...Field) {}
+func (noSpan) End(error)          {}
+
+// span starts a span of the client's tracer, or a no-op one without tracer.
+func (c *EtcdClient) span(ctx context.Context, name string, fields ...Field) (context.Context, Span) {
+	if c.tracer == nil {
+		return ctx, noSpan{}
+	}
+	return c.tracer.Start(ctx, name, fields...)
+}
+
+// traceRequest starts the span of an etcd request, returning req in its
+// context.
+func (c *EtcdClient) traceRequest(req *http.Request, op string, key string) (*http.Request, Span) {
+	if c.tracer == nil {
+		return req, noSpan{}
+	}
+	ctx, span := c.tracer.Start(req.Context(), "etcd."+op,
+		Field{"key", key}, Field{"method", req.Method}, Field{"endpoint", c.baseUrl})
+	return req.WithContext(ctx), span
+}
+
+// detached keeps the values of a context, such as the span it carries,
+// without its deadline and cancellation, for a request shared by callers that
+// may give up on it (see flights).
+type detached struct{ context.Context }
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// trace is a span of an election step, ended with the outcome and error last
+// set.
+type trace struct {
+	span    Span
+	outcome string
+	err     error
+}
+
+func (t *trace) end() {
+	t.span.SetFields(Field{"outcome", t.outcome})
+	t.span.End(t.err)
+}
+
+// trace starts the span of an election step of state.
+func (s *State) trace(ctx context.Context, client *EtcdClient, name string, fields ...Field) (context.Context, *trace) {
+	fields = append([]Field{{"key", s.key}, {"id", s.id}}, fields...)
+	ctx, span := client.span(ctx, "election."+name, fields...)
+	return ctx, &trace{span: span}
+}
+
+// traceCampaign starts the span of one attempt to take the lock, which tells
+// how long the campaign has run so far.
+func (s *State) traceCampaign(ctx context.Context, client *EtcdClient) (context.Context, *trace) {
+	return s.trace(ctx, client, "campaign",
+		Field{"attempt", s.campaign.attempts},
+		Field{"campaigning", time.Since(s.campaign.start)})
+}
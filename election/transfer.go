@@ -0,0 +1,113 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNotLeader is returned by TransferTo when the candidate does not hold the
+// lock, and by Lease.KeepAliveOnce when it no longer holds the lease.
+var ErrNotLeader = errors.New("candidate is not the leader")
+
+// TransferTo hands the leadership over to the candidate id of the same
+// election, for planned maintenance: rather than releasing the lock for the
+// followers to race for, it rewrites the lock to name id, on the condition
+// that it still holds this candidate's value, and announces id on the
+// broadcast key. id takes the lock over on its next iteration, so the
+// election never goes without a leader. This candidate is demoted, and goes
+// on campaigning as a follower if it was started.
+//
+// id must be campaigning for the election: until it rewrites the lock with
+// its own value, which observers that verify signatures reject, nobody renews
+// it, and it expires a TTL after the transfer. On etcd v2 the new leader's
+// term is the index at which it took the lock over; on v3 it keeps the term
+// of this candidate, the lock's create revision. Leadership cannot be
+// transferred during a layout upgrade.
+func (c *Elector) TransferTo(ctx context.Context, id string) error {
+	if id == c.state.id {
+		return nil
+	}
+	// pause the election loop, so that it does not renew the lock under
+	// the transfer
+	release, err := c.halt(ctx, nil)
+	if err != nil {
+		// campaign again once the loop exits
+		go c.Start()
+		return err
+	}
+	if release != nil {
+		defer c.Start()
+		defer release()
+	}
+	if !c.state.isLeader() {
+		return ErrNotLeader
+	}
+	return transfer(ctx, c.state, c.client, id)
+}
+
+func transfer(ctx context.Context, state *State, client *EtcdClient, id string) error {
+	if state.compat != "" {
+		return errors.New("leadership cannot be transferred during a layout upgrade")
+	}
+	ctx, trace := state.trace(ctx, client, "transfer", Field{"term", state.currentTerm()}, Field{"to", id})
+	defer trace.end()
+	value := record{ID: id}.encode()
+	option := state.renewal("transfer")
+	option.refresh = false
+	if _, err := client.Put(ctx, state.leaderKey(), value, option); err != nil {
+		trace.outcome, trace.err = "error", err
+		return err
+	}
+	// the successor announces itself too once it takes over; this tells
+	// those following the broadcast without waiting for it
+	if _, err := client.Put(ctx, state.broadcastKey(), value, Option{ttl: state.broadcastTTL, origin: "transfer"}); err != nil {
+		state.fail(err)
+	}
+	state.eventStr(LevelInfo, evTransferred, id)
+	demote(state, "transferred to "+id)
+	trace.outcome = "transferred"
+	return nil
+}
+
+// handedOver reports whether the lock value names the candidate the way
+// TransferTo does, by id alone, while the candidate is not leader.
+func (s *State) handedOver(value string) bool {
+	return !s.isLeader() && !s.observer && value == (record{ID: s.id}).encode()
+}
+
+// adopt takes over a lock handed to the candidate by TransferTo, rewriting it
+// with the candidate's own value, and returns true unless that failed.
+func adopt(ctx context.Context, state *State, client *EtcdClient, node Node) bool {
+	ctx, trace := state.trace(ctx, client, "adopt")
+	defer trace.end()
+	sent := time.Now()
+	resp, err := client.Put(ctx, state.leaderKey(), state.encoded(),
+		Option{prevValue: node.Value, prevCreated: node.CreatedIndex, ttl: state.ttl, origin: "adopt"})
+	if err != nil {
+		trace.outcome, trace.err = "error", err
+		state.fail(err)
+		return false
+	}
+	term := resp.Node.ModifiedIndex
+	if client.v3 != nil {
+		// v3 renewals compare the lock's create revision with the term
+		term = resp.Node.CreatedIndex
+	}
+	if _, ok := announce(ctx, state, client); !ok {
+		trace.outcome = "broadcast failed"
+		return true
+	}
+	trace.outcome = "adopted"
+	trace.span.SetFields(Field{"term", term})
+	state.confirm(sent)
+	state.acquire(term)
+	count := atomic.AddInt32(&leaderCount, 1)
+	state.eventInt(LevelInfo, evGain, int64(count))
+	withdraw(ctx, state, client)
+	state.seen.Store(state.id)
+	state.setLeader(true, "", "transferred")
+	beat(ctx, state, client)
+	return true
+}
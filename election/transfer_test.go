@@ -0,0 +1,56 @@
+package election_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+	"github.com/jeeyoungk/etcd-leader/leadertest"
+)
+
+// TestTransferToWatchingFollower hands the lock to a follower that watches
+// it: the follower must take it over at once rather than once it expires.
+func TestTransferToWatchingFollower(t *testing.T) {
+	server := leadertest.NewServer(t)
+	config := election.ElectionConfig{TTL: 2 * time.Second}
+	first, err := election.New(election.NewEtcdClient(server.URL), "transfer", "first", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { first.Stop() })
+	second, err := election.New(election.NewEtcdClient(server.URL), "transfer", "second", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { second.Stop() })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := first.Campaign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	second.Start()
+	// let the follower find the lock held and start watching it
+	time.Sleep(300 * time.Millisecond)
+
+	start := time.Now()
+	if err := first.TransferTo(ctx, "second"); err != nil {
+		t.Fatal(err)
+	}
+	adopted, cancelAdopted := context.WithTimeout(ctx, config.TTL/2)
+	defer cancelAdopted()
+	if _, err := second.Campaign(adopted); err != nil {
+		t.Fatalf("second did not take the handed-over lock within %s: %s", config.TTL/2, err)
+	}
+	t.Logf("adopted after %s", time.Since(start))
+
+	// the lock is renewed by its new holder, not left to expire
+	time.Sleep(config.TTL + config.TTL/2)
+	if leader := server.Leader("transfer"); leader != "second" {
+		t.Fatalf("leader is %q a TTL after the transfer, want second", leader)
+	}
+	if first.IsLeader() {
+		t.Fatal("first still leads after the transfer")
+	}
+}
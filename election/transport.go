@@ -0,0 +1,142 @@
+package election
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// newTransport returns a transport that keeps connections to etcd alive and
+// negotiates HTTP/2 when the server offers it over TLS. keepAlive is the
+// TCP keep-alive period of new connections.
+func newTransport(keepAlive time.Duration) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: keepAlive,
+		}).DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 5 * time.Second,
+	}
+}
+
+// connStats counts how requests were served, so that a renewal paying for a
+// fresh TCP/TLS handshake shows up as a new connection rather than silence.
+type connStats struct {
+	requests int64
+	reused   int64
+	created  int64
+	// GETs answered by a concurrent identical request
+	shared int64
+
+	mu     sync.Mutex
+	protos map[string]int64
+}
+
+type ConnStats struct {
+	Requests  int64
+	Reused    int64
+	Created   int64
+	Shared    int64
+	Protocols map[string]int64
+}
+
+func (s ConnStats) String() string {
+	return fmt.Sprintf("requests=%d reused=%d new=%d shared=%d protocols=%v", s.Requests, s.Reused, s.Created, s.Shared, s.Protocols)
+}
+
+func (s *connStats) trace(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				atomic.AddInt64(&s.reused, 1)
+			} else {
+				atomic.AddInt64(&s.created, 1)
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+func (s *connStats) record(resp *http.Response) {
+	atomic.AddInt64(&s.requests, 1)
+	s.mu.Lock()
+	if s.protos == nil {
+		s.protos = make(map[string]int64)
+	}
+	s.protos[resp.Proto]++
+	s.mu.Unlock()
+}
+
+func (s *connStats) snapshot() ConnStats {
+	stats := ConnStats{
+		Requests:  atomic.LoadInt64(&s.requests),
+		Reused:    atomic.LoadInt64(&s.reused),
+		Created:   atomic.LoadInt64(&s.created),
+		Shared:    atomic.LoadInt64(&s.shared),
+		Protocols: make(map[string]int64),
+	}
+	s.mu.Lock()
+	for proto, count := range s.protos {
+		stats.Protocols[proto] = count
+	}
+	s.mu.Unlock()
+	return stats
+}
+
+// Warm opens conns connections to each etcd endpoint ahead of the first
+// election request, so the handshake cost is not paid out of the first lease,
+// nor out of the first request failing over to another endpoint. Endpoints
+// set later with SetEndpoints are warmed as well.
+func (c *EtcdClient) Warm(ctx context.Context, conns int) error {
+	atomic.StoreInt32(&c.warm, int32(conns))
+	// past failover, which would send every request to the same endpoint
+	client := c.client
+	if transport := c.transport(); transport != nil {
+		client = &http.Client{Transport: transport}
+	}
+	endpoints := c.endpoints()
+	errs := make(chan error, conns*len(endpoints))
+	for _, endpoint := range endpoints {
+		for i := 0; i < conns; i++ {
+			go func(endpoint string) {
+				req, err := http.NewRequestWithContext(ctx, "GET", endpoint+"/version", nil)
+				if err != nil {
+					errs <- err
+					return
+				}
+				resp, err := client.Do(c.stats.trace(req))
+				if err != nil {
+					errs <- err
+					return
+				}
+				c.stats.record(resp)
+				io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+				errs <- nil
+			}(endpoint)
+		}
+	}
+	var first error
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (c *EtcdClient) ConnStats() ConnStats {
+	return c.stats.snapshot()
+}
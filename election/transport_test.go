@@ -0,0 +1,50 @@
+package election_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// TestWarmEndpoints checks that Warm opens connections to every endpoint,
+// including those set after it.
+func TestWarmEndpoints(t *testing.T) {
+	var hits [3]int32
+	var urls [3]string
+	for i := range urls {
+		i := i
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits[i], 1)
+		}))
+		t.Cleanup(server.Close)
+		urls[i] = server.URL
+	}
+	client := election.NewEtcdClient(urls[0])
+	if err := client.SetEndpoints(urls[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Warm(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if n := atomic.LoadInt32(&hits[i]); n != 2 {
+			t.Errorf("endpoint %d got %d warming requests, want 2", i, n)
+		}
+	}
+
+	if err := client.SetEndpoints(urls[1:]); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&hits[2]) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("endpoint set after Warm was not warmed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,658 @@
+package election
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// v3Backend serves the client's keys API operations from etcd's v3 API, for
+// clusters that have the v2 API disabled. It uses the JSON gateway etcd
+// serves next to gRPC, which exposes the same KV, Lease and Watch services;
+// a native gRPC transport would need the etcd and gRPC modules, which this
+// tree does not depend on.
+//
+// Responses are translated into their v2 form so that the election loop is
+// unchanged: revisions stand in for indexes, a key's TTL is a lease granted
+// for the write, and refreshing a key keeps its lease alive. Unlike v2, an
+// expiry is reported to watchers as a plain delete.
+type v3Backend struct {
+	client *EtcdClient
+	// path of the gateway below the base URL, e.g. "/v3"
+	path string
+}
+
+// DefaultV3Path is where etcd 3.4 and later serve the v3 JSON gateway.
+const DefaultV3Path = "/v3"
+
+// v3 wire types. The gateway encodes bytes as base64 and 64-bit integers as
+// strings.
+type v3Header struct {
+	Revision int64 `json:"revision,string"`
+}
+
+type v3KV struct {
+	Key            []byte `json:"key"`
+	Value          []byte `json:"value"`
+	CreateRevision int64  `json:"create_revision,string"`
+	ModRevision    int64  `json:"mod_revision,string"`
+	Lease          int64  `json:"lease,string"`
+}
+
+type v3Compare struct {
+	Target         string `json:"target"`
+	Result         string `json:"result"`
+	Key            []byte `json:"key"`
+	Value          []byte `json:"value,omitempty"`
+	CreateRevision *int64 `json:"create_revision,omitempty,string"`
+	ModRevision    int64  `json:"mod_revision,omitempty,string"`
+}
+
+type v3Op struct {
+	Put    *v3PutRequest    `json:"request_put,omitempty"`
+	Delete *v3DeleteRequest `json:"request_delete_range,omitempty"`
+	Range  *v3RangeRequest  `json:"request_range,omitempty"`
+}
+
+type v3PutRequest struct {
+	Key    []byte `json:"key"`
+	Value  []byte `json:"value"`
+	Lease  int64  `json:"lease,omitempty,string"`
+	PrevKV bool   `json:"prev_kv"`
+}
+
+type v3DeleteRequest struct {
+	Key    []byte `json:"key"`
+	PrevKV bool   `json:"prev_kv"`
+}
+
+type v3RangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+}
+
+type v3RangeResponse struct {
+	Header v3Header `json:"header"`
+	KVs    []v3KV   `json:"kvs"`
+}
+
+type v3TxnResponse struct {
+	Header    v3Header `json:"header"`
+	Succeeded bool     `json:"succeeded"`
+	Responses []struct {
+		Put *struct {
+			PrevKV *v3KV `json:"prev_kv"`
+		} `json:"response_put"`
+		Delete *struct {
+			PrevKVs []v3KV `json:"prev_kvs"`
+		} `json:"response_delete_range"`
+		Range *v3RangeResponse `json:"response_range"`
+	} `json:"responses"`
+}
+
+type v3WatchResponse struct {
+	Result struct {
+		Header          v3Header `json:"header"`
+		Created         bool     `json:"created"`
+		CompactRevision int64    `json:"compact_revision,string"`
+		Events          []struct {
+			Type   string `json:"type"`
+			KV     v3KV   `json:"kv"`
+			PrevKV *v3KV  `json:"prev_kv"`
+		} `json:"events"`
+	} `json:"result"`
+	Error *v3Error `json:"error"`
+}
+
+type v3Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// call posts body to a gateway endpoint and decodes the response into out.
+func (b *v3Backend) call(ctx context.Context, op string, key string, endpoint string, body interface{}, out interface{}) (int, error) {
+	if err := validateKey(key); err != nil {
+		return 0, err
+	}
+	if err := b.client.checkPrefix(key); err != nil {
+		return 0, err
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", b.client.baseUrl+b.path+endpoint, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req, cancel := b.client.bound(req, op)
+	defer cancel()
+	req, span := b.client.traceRequest(req, op, key)
+	start := time.Now()
+	status, err := b.do(req, out)
+	result := v3Outcome(status, err)
+	b.client.metrics.observe(electionKey(key), op, result, time.Since(start))
+	span.SetFields(Field{"status", status}, Field{"outcome", result})
+	span.End(err)
+	return status, err
+}
+
+func (b *v3Backend) do(req *http.Request, out interface{}) (int, error) {
+	resp, err := b.send(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	b.client.stats.record(resp)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	b.client.responses.add(newExchange(req, resp, body))
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return resp.StatusCode, nil
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return resp.StatusCode, ErrRateLimited
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e v3Error
+		json.Unmarshal(body, &e)
+		return resp.StatusCode, fmt.Errorf("etcd v3 %s: %s %s", req.URL.Path, resp.Status, e.Message)
+	}
+	return resp.StatusCode, json.Unmarshal(body, out)
+}
+
+func v3Outcome(status int, err error) string {
+	switch {
+	case err == ErrRateLimited:
+		return "rate_limited"
+	case err != nil:
+		return "transport_error"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "unauthorized"
+	}
+	return "ok"
+}
+
+// wireKey returns key as sent to etcd, below the client's namespace.
+func (b *v3Backend) wireKey(key string) []byte {
+	return []byte(b.client.namespace + key)
+}
+
+// node translates a v3 key-value into a v2 node, named without the client's
+// namespace.
+func (b *v3Backend) node(kv *v3KV) Node {
+	return Node{
+		Key:           strings.TrimPrefix(string(kv.Key), b.client.namespace),
+		Value:         string(kv.Value),
+		CreatedIndex:  int(kv.CreateRevision),
+		ModifiedIndex: int(kv.ModRevision),
+	}
+}
+
+func (b *v3Backend) respond(header v3Header) *EtcdResponse {
+	b.client.observeIndex(int(header.Revision))
+	return &EtcdResponse{StatusCode: http.StatusOK, EtcdIndex: int(header.Revision), Index: int(header.Revision)}
+}
+
+// unauthorized returns the v2 form of a response refused for lack of
+// permissions.
+func unauthorized(status int) *EtcdResponse {
+	return &EtcdResponse{StatusCode: status, ErrorCode: codeUnauthorized, Message: "The request requires user authentication"}
+}
+
+func notFound(resp *EtcdResponse, key string) *EtcdResponse {
+	resp.ErrorCode, resp.Message, resp.Cause = codeKeyNotFound, "Key not found", key
+	return resp
+}
+
+func (b *v3Backend) get(ctx context.Context, key string) (*EtcdResponse, error) {
+	var out v3RangeResponse
+	status, err := b.call(ctx, "get", key, "/kv/range", v3RangeRequest{Key: b.wireKey(key)}, &out)
+	if err != nil {
+		return nil, err
+	} else if status != http.StatusOK {
+		return unauthorized(status), nil
+	}
+	resp := b.respond(out.Header)
+	if len(out.KVs) == 0 {
+		return notFound(resp, key), nil
+	}
+	resp.Action = "get"
+	resp.Node = b.node(&out.KVs[0])
+	return resp, nil
+}
+
+// compares returns the v3 comparisons equivalent to the preconditions of a
+// v2 write.
+func compares(key []byte, value string, option Option) []v3Compare {
+	var compares []v3Compare
+	zero := int64(0)
+	switch option.prevExist {
+	case -1:
+		compares = append(compares, v3Compare{Target: "CREATE", Result: "EQUAL", Key: key, CreateRevision: &zero})
+	case 1:
+		compares = append(compares, v3Compare{Target: "CREATE", Result: "GREATER", Key: key, CreateRevision: &zero})
+	}
+	if value != "" {
+		compares = append(compares, v3Compare{Target: "VALUE", Result: "EQUAL", Key: key, Value: []byte(value)})
+	}
+	if option.prevIndex != 0 {
+		compares = append(compares, v3Compare{Target: "MOD", Result: "EQUAL", Key: key, ModRevision: int64(option.prevIndex)})
+	}
+	if option.prevCreated != 0 {
+		created := int64(option.prevCreated)
+		compares = append(compares, v3Compare{Target: "CREATE", Result: "EQUAL", Key: key, CreateRevision: &created})
+	}
+	return compares
+}
+
+// failed returns the v2 error of a transaction whose comparisons failed,
+// given the key's current value as read by the failure branch.
+func failed(resp *EtcdResponse, key string, option Option, current *v3RangeResponse) *EtcdResponse {
+	if current == nil || len(current.KVs) == 0 {
+		if option.prevExist == -1 {
+			// a missing key passes the create comparison, so another one
+			// failed
+			resp.ErrorCode, resp.Message, resp.Cause = codeTestFailed, "Compare failed", key
+			return resp
+		}
+		return notFound(resp, key)
+	}
+	if option.prevExist == -1 {
+		resp.ErrorCode, resp.Message, resp.Cause = codeNodeExist, "Key already exists", key
+		return resp
+	}
+	resp.ErrorCode, resp.Message = codeTestFailed, "Compare failed"
+	resp.Cause = fmt.Sprintf("[%s != %s]", option.prevValue, string(current.KVs[0].Value))
+	if created := current.KVs[0].CreateRevision; option.prevCreated != 0 && created != int64(option.prevCreated) {
+		resp.Cause = fmt.Sprintf("[created %d != %d]", option.prevCreated, created)
+	}
+	return resp
+}
+
+func (b *v3Backend) txn(ctx context.Context, op string, key string, compare []v3Compare, success ...v3Op) (*v3TxnResponse, int, error) {
+	body := map[string]interface{}{
+		"compare": compare,
+		"success": success,
+		"failure": []v3Op{{Range: &v3RangeRequest{Key: b.wireKey(key)}}},
+	}
+	var out v3TxnResponse
+	status, err := b.call(ctx, op, key, "/kv/txn", body, &out)
+	return &out, status, err
+}
+
+func (b *v3Backend) put(ctx context.Context, key string, value string, option Option) (*EtcdResponse, error) {
+	if option.refresh {
+		return b.refresh(ctx, key, option)
+	}
+	var lease int64
+	var status int
+	var err error
+	if option.prevExist != -1 {
+		// a rewrite, such as a renewal, keeps the key's lease
+		if lease, status, err = b.reuse(ctx, key, option.ttl); err != nil {
+			return nil, err
+		} else if status != http.StatusOK {
+			return unauthorized(status), nil
+		}
+	}
+	granted := int64(0)
+	if lease == 0 {
+		if lease, status, err = b.grant(ctx, key, option.ttl); err != nil {
+			return nil, err
+		} else if status != http.StatusOK {
+			return unauthorized(status), nil
+		}
+		granted = lease
+	}
+	out, status, err := b.txn(ctx, "put", key, compares(b.wireKey(key), option.prevValue, option), v3Op{
+		Put: &v3PutRequest{Key: b.wireKey(key), Value: []byte(value), Lease: lease, PrevKV: true},
+	})
+	if err != nil {
+		return nil, err
+	} else if status != http.StatusOK {
+		return unauthorized(status), nil
+	}
+	resp := b.respond(out.Header)
+	if !out.Succeeded {
+		b.revoke(ctx, key, granted)
+		return failed(resp, key, option, out.Responses[0].Range), nil
+	}
+	resp.Action = "set"
+	resp.Node = Node{Key: key, Value: value, CreatedIndex: int(out.Header.Revision), ModifiedIndex: int(out.Header.Revision)}
+	if put := out.Responses[0].Put; put != nil && put.PrevKV != nil {
+		prev := b.node(put.PrevKV)
+		resp.PrevNode = &prev
+		resp.Node.CreatedIndex = prev.CreatedIndex
+	}
+	return resp, nil
+}
+
+// grant returns a new lease of ttl for the key of a write, or 0 without a
+// TTL.
+func (b *v3Backend) grant(ctx context.Context, key string, ttl time.Duration) (int64, int, error) {
+	if ttl == 0 {
+		return 0, http.StatusOK, nil
+	}
+	var out struct {
+		ID int64 `json:"ID,string"`
+	}
+	seconds := strconv.Itoa(int(ttl / time.Second))
+	status, err := b.call(ctx, "lease", key, "/lease/grant", map[string]string{"TTL": seconds}, &out)
+	return out.ID, status, err
+}
+
+// reuse returns the lease of key's current value, kept alive, if it was
+// granted for ttl, and otherwise 0: a write with a new lease would leave the
+// old one to expire, and renewals would leak a lease each.
+func (b *v3Backend) reuse(ctx context.Context, key string, ttl time.Duration) (int64, int, error) {
+	if ttl == 0 {
+		return 0, http.StatusOK, nil
+	}
+	var kv v3RangeResponse
+	status, err := b.call(ctx, "get", key, "/kv/range", v3RangeRequest{Key: b.wireKey(key)}, &kv)
+	if err != nil || status != http.StatusOK || len(kv.KVs) == 0 || kv.KVs[0].Lease == 0 {
+		return 0, status, err
+	}
+	lease := kv.KVs[0].Lease
+	left, status, err := b.keepAlive(ctx, "lease", key, lease)
+	if err != nil || status != http.StatusOK || left != int64(ttl/time.Second) {
+		return 0, status, err
+	}
+	return lease, status, nil
+}
+
+// keepAlive renews lease and returns its TTL in seconds, which is 0 once it
+// expired.
+func (b *v3Backend) keepAlive(ctx context.Context, op string, key string, lease int64) (int64, int, error) {
+	var out struct {
+		Result struct {
+			TTL int64 `json:"TTL,string"`
+		} `json:"result"`
+	}
+	status, err := b.call(ctx, op, key, "/lease/keepalive", map[string]string{"ID": strconv.FormatInt(lease, 10)}, &out)
+	return out.Result.TTL, status, err
+}
+
+// revoke gives up a lease granted for a write that did not happen, rather
+// than leaving it to expire. Failures are ignored for that reason.
+func (b *v3Backend) revoke(ctx context.Context, key string, lease int64) {
+	if lease == 0 {
+		return
+	}
+	b.call(ctx, "lease", key, "/lease/revoke", map[string]string{"ID": strconv.FormatInt(lease, 10)}, &struct{}{})
+}
+
+// acquire creates the lock at key and writes the broadcast key in the same
+// transaction, so that no observer sees one without the other. It returns
+// the responses of the two writes in their v2 form; the broadcast response
+// is nil unless the lock was created.
+func (b *v3Backend) acquire(ctx context.Context, key string, broadcastKey string, value string, lock Option, broadcast Option) (*EtcdResponse, *EtcdResponse, error) {
+	lockLease, status, err := b.grant(ctx, key, lock.ttl)
+	if err != nil {
+		return nil, nil, err
+	} else if status != http.StatusOK {
+		return unauthorized(status), nil, nil
+	}
+	broadcastLease, status, err := b.grant(ctx, broadcastKey, broadcast.ttl)
+	if err != nil {
+		return nil, nil, err
+	} else if status != http.StatusOK {
+		return unauthorized(status), nil, nil
+	}
+	lock.prevExist = -1
+	out, status, err := b.txn(ctx, "put", key, compares(b.wireKey(key), "", lock),
+		v3Op{Put: &v3PutRequest{Key: b.wireKey(key), Value: []byte(value), Lease: lockLease, PrevKV: true}},
+		v3Op{Put: &v3PutRequest{Key: b.wireKey(broadcastKey), Value: []byte(value), Lease: broadcastLease, PrevKV: true}},
+	)
+	if err != nil {
+		return b.reclaim(ctx, key, broadcastKey, value, err)
+	} else if status != http.StatusOK {
+		return unauthorized(status), nil, nil
+	}
+	resp := b.respond(out.Header)
+	if !out.Succeeded {
+		b.revoke(ctx, key, lockLease)
+		b.revoke(ctx, broadcastKey, broadcastLease)
+		return failed(resp, key, lock, out.Responses[0].Range), nil, nil
+	}
+	revision := int(out.Header.Revision)
+	resp.Action = "create"
+	resp.Node = Node{Key: key, Value: value, CreatedIndex: revision, ModifiedIndex: revision}
+	announced := b.respond(out.Header)
+	announced.Action = "set"
+	announced.Node = Node{Key: broadcastKey, Value: value, CreatedIndex: revision, ModifiedIndex: revision}
+	if put := out.Responses[1].Put; put != nil && put.PrevKV != nil {
+		prev := b.node(put.PrevKV)
+		announced.PrevNode = &prev
+		announced.Node.CreatedIndex = prev.CreatedIndex
+	}
+	return resp, announced, nil
+}
+
+// reclaim looks for the lock an acquire transaction that failed with err may
+// have created nonetheless, its answer lost on the way back. The value
+// carries the nonce of the attempt, so a lock holding exactly that value was
+// created by this request; reclaim then returns it as acquire would, except
+// that the broadcast key's previous value is unknown. Otherwise it returns
+// err.
+func (b *v3Backend) reclaim(ctx context.Context, key string, broadcastKey string, value string, err error) (*EtcdResponse, *EtcdResponse, error) {
+	if ctx.Err() != nil || decodeRecord(value).Nonce == "" {
+		return nil, nil, err
+	}
+	resp, getErr := b.get(ctx, key)
+	if getErr != nil || resp.ErrorCode != 0 || resp.Node.Value != value {
+		return nil, nil, err
+	}
+	announced, getErr := b.get(ctx, broadcastKey)
+	if getErr != nil || announced.ErrorCode != 0 || announced.Node.Value != value {
+		return nil, nil, err
+	}
+	resp.Action, announced.Action = "create", "set"
+	return resp, announced, nil
+}
+
+// refresh keeps the lease of key alive, after checking the preconditions
+// against its current value. The lease kept alive is the one of the value
+// that was checked, read along with it, so that a key recreated in between
+// does not have its new lease renewed on the strength of the old value.
+func (b *v3Backend) refresh(ctx context.Context, key string, option Option) (*EtcdResponse, error) {
+	var kv v3RangeResponse
+	status, err := b.call(ctx, "get", key, "/kv/range", v3RangeRequest{Key: b.wireKey(key)}, &kv)
+	if err != nil {
+		return nil, err
+	} else if status != http.StatusOK {
+		return unauthorized(status), nil
+	}
+	current := b.respond(kv.Header)
+	if len(kv.KVs) == 0 {
+		return notFound(current, key), nil
+	}
+	current.Action = "get"
+	current.Node = b.node(&kv.KVs[0])
+	if (option.prevValue != "" && current.Node.Value != option.prevValue) || (option.prevIndex != 0 && current.Node.ModifiedIndex != option.prevIndex) {
+		current.ErrorCode, current.Message = codeTestFailed, "Compare failed"
+		current.Cause = fmt.Sprintf("[%s != %s]", option.prevValue, current.Node.Value)
+		return current, nil
+	}
+	if option.prevCreated != 0 && current.Node.CreatedIndex != option.prevCreated {
+		current.ErrorCode, current.Message = codeTestFailed, "Compare failed"
+		current.Cause = fmt.Sprintf("[created %d != %d]", option.prevCreated, current.Node.CreatedIndex)
+		return current, nil
+	}
+	if kv.KVs[0].Lease == 0 {
+		// nothing to keep alive
+		return current, nil
+	}
+	left, status, err := b.keepAlive(ctx, "refresh", key, kv.KVs[0].Lease)
+	if err != nil {
+		return nil, err
+	} else if status != http.StatusOK {
+		return unauthorized(status), nil
+	}
+	if left <= 0 {
+		// the lease expired between the read and the keep-alive
+		return notFound(current, key), nil
+	}
+	current.Action = "update"
+	return current, nil
+}
+
+func (b *v3Backend) delete(ctx context.Context, key string, value string, option Option) (*EtcdResponse, error) {
+	out, status, err := b.txn(ctx, "delete", key, compares(b.wireKey(key), value, option), v3Op{
+		Delete: &v3DeleteRequest{Key: b.wireKey(key), PrevKV: true},
+	})
+	if err != nil {
+		return nil, err
+	} else if status != http.StatusOK {
+		return unauthorized(status), nil
+	}
+	resp := b.respond(out.Header)
+	if !out.Succeeded {
+		option.prevValue = value
+		return failed(resp, key, option, out.Responses[0].Range), nil
+	}
+	deleted := out.Responses[0].Delete
+	if deleted == nil || len(deleted.PrevKVs) == 0 {
+		return notFound(resp, key), nil
+	}
+	prev := b.node(&deleted.PrevKVs[0])
+	resp.Action = "delete"
+	resp.PrevNode = &prev
+	resp.Node = Node{Key: key, CreatedIndex: prev.CreatedIndex, ModifiedIndex: int(out.Header.Revision)}
+	return resp, nil
+}
+
+// wait returns the first change to key at or after revision index. A
+// revision that was compacted away is reported as the v2 error 401.
+func (b *v3Backend) wait(ctx context.Context, key string, index int) (*EtcdResponse, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	if err := b.client.checkPrefix(key); err != nil {
+		return nil, err
+	}
+	parent := ctx
+	if b.client.maxWait != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, b.client.maxWait)
+		defer cancel()
+	}
+	request := map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            b.wireKey(key),
+			"start_revision": strconv.Itoa(index),
+			"prev_kv":        true,
+		},
+	}
+	data, _ := json.Marshal(request)
+	req, err := http.NewRequestWithContext(ctx, "POST", b.client.baseUrl+b.path+"/watch", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	start := time.Now()
+	resp, err := b.watch(req, key)
+	if err != nil && ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+		err = ErrWaitTimeout
+	}
+	b.client.metrics.observe(electionKey(key), "watch", outcome(resp, err), time.Since(start))
+	return resp, err
+}
+
+func (b *v3Backend) watch(req *http.Request, key string) (*EtcdResponse, error) {
+	resp, err := b.send(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b.client.stats.record(resp)
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return unauthorized(resp.StatusCode), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("etcd v3 %s: %s", req.URL.Path, resp.Status)
+	}
+	// the gateway streams one JSON object per watch response
+	decoder := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var message v3WatchResponse
+		if err := decoder.Decode(&message); err != nil {
+			return nil, err
+		}
+		if message.Error != nil {
+			return nil, fmt.Errorf("etcd v3 watch: %s", message.Error.Message)
+		}
+		result := message.Result
+		if result.CompactRevision != 0 {
+			out := b.respond(result.Header)
+			out.ErrorCode, out.Message, out.Cause = codeIndexCleared, "The event in requested index is outdated and cleared", key
+			return out, nil
+		}
+		if len(result.Events) == 0 {
+			continue
+		}
+		// one event per wait, as on v2: the caller resumes right after its
+		// revision, so that the next watch delivers the rest of the batch
+		event := result.Events[0]
+		out := b.respond(result.Header)
+		out.Action = "set"
+		if event.Type == "DELETE" {
+			out.Action = "delete"
+		}
+		out.Node = b.node(&event.KV)
+		if event.PrevKV != nil {
+			prev := b.node(event.PrevKV)
+			out.PrevNode = &prev
+		}
+		return out, nil
+	}
+}
+
+// list reads the keys below dir/, as the v2 listing of directory dir. Unlike
+// v2 it also returns keys nested deeper.
+func (b *v3Backend) list(ctx context.Context, dir string) (*EtcdResponse, error) {
+	prefix := b.client.namespace + dir + "/"
+	end := []byte(prefix)
+	end[len(end)-1]++
+	var out v3RangeResponse
+	status, err := b.call(ctx, "get", dir, "/kv/range", v3RangeRequest{Key: []byte(prefix), RangeEnd: end}, &out)
+	if err != nil {
+		return nil, err
+	} else if status != http.StatusOK {
+		return unauthorized(status), nil
+	}
+	resp := b.respond(out.Header)
+	if len(out.KVs) == 0 {
+		return notFound(resp, dir), nil
+	}
+	resp.Action = "get"
+	resp.Node = Node{Key: dir, Dir: true}
+	for _, kv := range out.KVs {
+		resp.Node.Nodes = append(resp.Node.Nodes, b.node(&kv))
+	}
+	return resp, nil
+}
+
+// append emulates an in-order key under dir, which v3 does not have, with a
+// key whose name sorts by creation time. The key is only created if it does
+// not exist, and the next name is tried if it does, so that appends within
+// the same nanosecond keep both values.
+func (b *v3Backend) append(ctx context.Context, dir string, value string) (*EtcdResponse, error) {
+	for name := time.Now().UnixNano(); ; name++ {
+		resp, err := b.put(ctx, fmt.Sprintf("%s/%020d", dir, name), value, Option{prevExist: -1})
+		if err != nil || resp.ErrorCode != codeNodeExist {
+			return resp, err
+		}
+	}
+}
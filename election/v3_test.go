@@ -0,0 +1,513 @@
+package election_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// gateway is an in-memory fake of the subset of etcd's v3 JSON gateway the
+// v3 backend uses: range, txn, leases, watch and authentication.
+type gateway struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	revision int64
+	kvs      map[string]*gatewayKV
+	leases   map[int64]*gatewayLease
+	lease    int64
+	events   []gatewayEvent
+	changed  chan struct{}
+	// with a password, requests need the token last handed out
+	password string
+	token    string
+	// counters of requests the tests check
+	granted, authenticated int
+	// the comparisons of the next failTxns transactions fail
+	failTxns int
+	// the next create of a key below race finds it just created by
+	// another client
+	race string
+}
+
+type gatewayKV struct {
+	Key            []byte `json:"key"`
+	Value          []byte `json:"value,omitempty"`
+	CreateRevision int64  `json:"create_revision,string,omitempty"`
+	ModRevision    int64  `json:"mod_revision,string"`
+	Lease          int64  `json:"lease,string,omitempty"`
+}
+
+type gatewayLease struct {
+	ttl     int64
+	expires time.Time
+}
+
+type gatewayEvent struct {
+	Type   string     `json:"type,omitempty"`
+	KV     gatewayKV  `json:"kv"`
+	PrevKV *gatewayKV `json:"prev_kv,omitempty"`
+}
+
+type gatewayHeader struct {
+	Revision int64 `json:"revision,string"`
+}
+
+func newGateway(t *testing.T) *gateway {
+	g := &gateway{
+		kvs:     make(map[string]*gatewayKV),
+		leases:  make(map[int64]*gatewayLease),
+		changed: make(chan struct{}),
+	}
+	g.Server = httptest.NewServer(g)
+	t.Cleanup(g.Close)
+	return g
+}
+
+// client returns a client of the gateway's v3 API.
+func (g *gateway) client() *election.EtcdClient {
+	client := election.NewEtcdClient(g.URL)
+	client.SetBackend(election.BackendV3)
+	return client
+}
+
+func (g *gateway) get(key string) *gatewayKV {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.expire()
+	return g.kvs[key]
+}
+
+// put and remove change a key at the current revision, recording the
+// event: callers hold g.mu, and move to the next revision first, once per
+// transaction.
+func (g *gateway) put(key string, value []byte, lease int64) (prev *gatewayKV) {
+	prev = g.kvs[key]
+	kv := &gatewayKV{Key: []byte(key), Value: value, CreateRevision: g.revision, ModRevision: g.revision, Lease: lease}
+	if prev != nil {
+		kv.CreateRevision = prev.CreateRevision
+	}
+	g.kvs[key] = kv
+	g.publish(gatewayEvent{KV: *kv, PrevKV: prev})
+	return prev
+}
+
+func (g *gateway) remove(key string) *gatewayKV {
+	prev := g.kvs[key]
+	if prev == nil {
+		return nil
+	}
+	delete(g.kvs, key)
+	g.publish(gatewayEvent{Type: "DELETE", KV: gatewayKV{Key: []byte(key), ModRevision: g.revision}, PrevKV: prev})
+	return prev
+}
+
+func (g *gateway) publish(event gatewayEvent) {
+	g.events = append(g.events, event)
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+// expire deletes the keys of expired leases. Callers hold g.mu.
+func (g *gateway) expire() {
+	for id, lease := range g.leases {
+		if time.Now().After(lease.expires) {
+			g.revoke(id)
+		}
+	}
+}
+
+func (g *gateway) revoke(id int64) {
+	delete(g.leases, id)
+	g.revision++
+	for key, kv := range g.kvs {
+		if kv.Lease == id {
+			g.remove(key)
+		}
+	}
+}
+
+// expireToken makes the gateway reject the token it handed out last.
+func (g *gateway) expireToken() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.token = ""
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]json.RawMessage
+	json.NewDecoder(r.Body).Decode(&body)
+	endpoint := strings.TrimPrefix(r.URL.Path, "/v3")
+	g.mu.Lock()
+	if endpoint == "/auth/authenticate" {
+		defer g.mu.Unlock()
+		var password string
+		json.Unmarshal(body["password"], &password)
+		if password != g.password {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		g.authenticated++
+		g.token = "token-" + strconv.Itoa(g.authenticated)
+		json.NewEncoder(w).Encode(map[string]string{"token": g.token})
+		return
+	}
+	if g.password != "" && (g.token == "" || r.Header.Get("Authorization") != g.token) {
+		g.mu.Unlock()
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	g.expire()
+	if endpoint == "/watch" {
+		g.watch(w, r, body) // unlocks
+		return
+	}
+	defer g.mu.Unlock()
+	var out interface{}
+	switch endpoint {
+	case "/kv/range":
+		out = g.rangeKeys(body)
+	case "/kv/txn":
+		out = g.txn(body)
+	case "/lease/grant":
+		var ttl int64
+		json.Unmarshal(body["TTL"], &ttl)
+		if ttl == 0 {
+			var s string
+			json.Unmarshal(body["TTL"], &s)
+			ttl, _ = strconv.ParseInt(s, 10, 64)
+		}
+		g.lease++
+		g.granted++
+		g.leases[g.lease] = &gatewayLease{ttl: ttl, expires: time.Now().Add(time.Duration(ttl) * time.Second)}
+		out = map[string]string{"ID": strconv.FormatInt(g.lease, 10), "TTL": strconv.FormatInt(ttl, 10)}
+	case "/lease/keepalive":
+		ttl := int64(0)
+		if lease := g.leases[leaseID(body)]; lease != nil {
+			lease.expires = time.Now().Add(time.Duration(lease.ttl) * time.Second)
+			ttl = lease.ttl
+		}
+		out = map[string]interface{}{"result": map[string]string{"TTL": strconv.FormatInt(ttl, 10)}}
+	case "/lease/revoke":
+		g.revoke(leaseID(body))
+		out = map[string]interface{}{}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+func leaseID(body map[string]json.RawMessage) int64 {
+	var s string
+	json.Unmarshal(body["ID"], &s)
+	id, _ := strconv.ParseInt(s, 10, 64)
+	return id
+}
+
+type gatewayRange struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end"`
+}
+
+func (g *gateway) rangeOf(request gatewayRange) map[string]interface{} {
+	kvs := []gatewayKV{}
+	for key, kv := range g.kvs {
+		if key == string(request.Key) || (request.RangeEnd != nil && key >= string(request.Key) && key < string(request.RangeEnd)) {
+			kvs = append(kvs, *kv)
+		}
+	}
+	return map[string]interface{}{"header": gatewayHeader{g.revision}, "kvs": kvs}
+}
+
+func (g *gateway) rangeKeys(body map[string]json.RawMessage) interface{} {
+	var request gatewayRange
+	json.Unmarshal(body["key"], &request.Key)
+	json.Unmarshal(body["range_end"], &request.RangeEnd)
+	return g.rangeOf(request)
+}
+
+type gatewayCompare struct {
+	Target         string `json:"target"`
+	Result         string `json:"result"`
+	Key            []byte `json:"key"`
+	Value          []byte `json:"value"`
+	CreateRevision int64  `json:"create_revision,string"`
+	ModRevision    int64  `json:"mod_revision,string"`
+}
+
+type gatewayOp struct {
+	Put *struct {
+		Key   []byte `json:"key"`
+		Value []byte `json:"value"`
+		Lease int64  `json:"lease,string"`
+	} `json:"request_put"`
+	Delete *struct {
+		Key []byte `json:"key"`
+	} `json:"request_delete_range"`
+	Range *gatewayRange `json:"request_range"`
+}
+
+func (g *gateway) txn(body map[string]json.RawMessage) interface{} {
+	var compares []gatewayCompare
+	var success, failure []gatewayOp
+	json.Unmarshal(body["compare"], &compares)
+	json.Unmarshal(body["success"], &success)
+	json.Unmarshal(body["failure"], &failure)
+	succeeded := g.failTxns == 0
+	if !succeeded {
+		g.failTxns--
+	}
+	for _, c := range compares {
+		key := string(c.Key)
+		if g.race != "" && strings.HasPrefix(key, g.race) && c.Target == "CREATE" && c.CreateRevision == 0 && c.Result == "EQUAL" {
+			g.race = ""
+			g.revision++
+			g.put(key, []byte("raced"), 0)
+		}
+		kv := g.kvs[key]
+		var held int64
+		switch c.Target {
+		case "CREATE":
+			if kv != nil {
+				held = kv.CreateRevision
+			}
+			if (c.Result == "EQUAL" && held != c.CreateRevision) || (c.Result == "GREATER" && held <= c.CreateRevision) {
+				succeeded = false
+			}
+		case "MOD":
+			if kv == nil || kv.ModRevision != c.ModRevision {
+				succeeded = false
+			}
+		case "VALUE":
+			if kv == nil || !bytes.Equal(kv.Value, c.Value) {
+				succeeded = false
+			}
+		}
+	}
+	ops := failure
+	if succeeded {
+		ops = success
+	}
+	for _, op := range ops {
+		if op.Put != nil || (op.Delete != nil && g.kvs[string(op.Delete.Key)] != nil) {
+			g.revision++
+			break
+		}
+	}
+	var responses []map[string]interface{}
+	for _, op := range ops {
+		switch {
+		case op.Put != nil:
+			prev := g.put(string(op.Put.Key), op.Put.Value, op.Put.Lease)
+			responses = append(responses, map[string]interface{}{"response_put": map[string]interface{}{"prev_kv": prev}})
+		case op.Delete != nil:
+			deleted := []gatewayKV{}
+			if prev := g.remove(string(op.Delete.Key)); prev != nil {
+				deleted = append(deleted, *prev)
+			}
+			responses = append(responses, map[string]interface{}{"response_delete_range": map[string]interface{}{"prev_kvs": deleted}})
+		case op.Range != nil:
+			responses = append(responses, map[string]interface{}{"response_range": g.rangeOf(*op.Range)})
+		}
+	}
+	return map[string]interface{}{"header": gatewayHeader{g.revision}, "succeeded": succeeded, "responses": responses}
+}
+
+// watch streams the events of one key from a start revision: all of those
+// already past in one message, as etcd sends a batch, or else the next one.
+// It is called with g.mu held, and unlocks it.
+func (g *gateway) watch(w http.ResponseWriter, r *http.Request, body map[string]json.RawMessage) {
+	var request struct {
+		Key           []byte `json:"key"`
+		StartRevision int64  `json:"start_revision,string"`
+	}
+	json.Unmarshal(body["create_request"], &request)
+	encoder := json.NewEncoder(w)
+	encoder.Encode(map[string]interface{}{"result": map[string]interface{}{"header": gatewayHeader{g.revision}, "created": true}})
+	w.(http.Flusher).Flush()
+	for {
+		var events []gatewayEvent
+		for _, event := range g.events {
+			if string(event.KV.Key) == string(request.Key) && event.KV.ModRevision >= request.StartRevision {
+				events = append(events, event)
+			}
+		}
+		if len(events) > 0 {
+			encoder.Encode(map[string]interface{}{"result": map[string]interface{}{"header": gatewayHeader{g.revision}, "events": events}})
+			g.mu.Unlock()
+			return
+		}
+		changed := g.changed
+		g.mu.Unlock()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+		g.mu.Lock()
+	}
+}
+
+// TestV3CompareFailure checks the errors of writes whose comparisons fail.
+func TestV3CompareFailure(t *testing.T) {
+	g := newGateway(t)
+	client := g.client()
+	ctx := context.Background()
+
+	if _, err := client.Get(ctx, "compare", election.Option{}); !errors.Is(err, election.ErrKeyNotFound) {
+		t.Fatalf("get of a missing key: %v, want ErrKeyNotFound", err)
+	}
+	if _, err := client.Delete(ctx, "compare", "a", election.Option{}); !errors.Is(err, election.ErrKeyNotFound) {
+		t.Fatalf("delete of a missing key: %v, want ErrKeyNotFound", err)
+	}
+	if _, err := client.Put(ctx, "compare", "a", election.Option{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Delete(ctx, "compare", "b", election.Option{}); !errors.Is(err, election.ErrTestFailed) {
+		t.Fatalf("delete comparing another value: %v, want ErrTestFailed", err)
+	}
+	resp, err := client.Delete(ctx, "compare", "a", election.Option{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.PrevNode == nil || resp.PrevNode.Value != "a" {
+		t.Fatalf("delete returned previous node %+v, want value a", resp.PrevNode)
+	}
+}
+
+// TestV3Campaign campaigns against the gateway, retrying an acquisition
+// whose comparison failed on the missing lock, and renews the lock by
+// rewriting it: the renewals must keep the lock's lease rather than grant
+// one each.
+func TestV3Campaign(t *testing.T) {
+	g := newGateway(t)
+	g.failTxns = 1
+	elector, err := election.New(g.client(), "campaign", "a", election.ElectionConfig{TTL: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { elector.Stop() })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lease, err := elector.Campaign(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := g.get("campaign-leader")
+	if lock == nil {
+		t.Fatal("leader without a lock")
+	}
+	if int64(lease.Term()) != lock.CreateRevision {
+		t.Fatalf("term %d, want the lock's create revision %d", lease.Term(), lock.CreateRevision)
+	}
+	g.mu.Lock()
+	granted := g.granted
+	g.mu.Unlock()
+
+	time.Sleep(1500 * time.Millisecond)
+	if !elector.IsLeader() {
+		t.Fatal("lost the lock while renewing it")
+	}
+	if renewed := g.get("campaign-leader"); renewed == nil || renewed.Lease != lock.Lease {
+		t.Fatalf("renewed lock %+v, want it on lease %d", renewed, lock.Lease)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.granted != granted {
+		t.Fatalf("renewals granted %d leases", g.granted-granted)
+	}
+}
+
+// TestV3Watch follows the lock while it is deleted and recreated within one
+// batch of watch events: the recreation must not be lost.
+func TestV3Watch(t *testing.T) {
+	g := newGateway(t)
+	client := g.client()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Put(ctx, "watch-leader", "x", election.Option{}); err != nil {
+		t.Fatal(err)
+	}
+	observer, err := election.NewObserver(client, "watch", election.ElectionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := observer.LeaderChanges(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// x fills the buffer, so the observer blocks sending y
+	if _, err := client.Put(ctx, "watch-leader", "y", election.Option{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := client.Delete(ctx, "watch-leader", "y", election.Option{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Put(ctx, "watch-leader", "z", election.Option{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"x", "y", "", "z"} {
+		if got := next(t, changes); got != want {
+			t.Fatalf("leader %q, want %q", got, want)
+		}
+	}
+}
+
+// TestV3AuthRetry expires the client's token: the next request must
+// authenticate again and succeed.
+func TestV3AuthRetry(t *testing.T) {
+	g := newGateway(t)
+	g.password = "secret"
+	client := g.client()
+	client.SetCredentials("root", "secret")
+	ctx := context.Background()
+
+	if _, err := client.Put(ctx, "auth", "a", election.Option{}); err != nil {
+		t.Fatal(err)
+	}
+	g.expireToken()
+	resp, err := client.Get(ctx, "auth", election.Option{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Node.Value != "a" {
+		t.Fatalf("read %q, want a", resp.Node.Value)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.authenticated != 2 {
+		t.Fatalf("authenticated %d times, want 2", g.authenticated)
+	}
+}
+
+// TestV3Append appends a transition whose key another client creates at the
+// same time: the append must move on to the next key rather than fail.
+func TestV3Append(t *testing.T) {
+	g := newGateway(t)
+	g.race = "history/"
+	store := election.NewEtcdHistoryStore(g.client(), "history", election.HistoryRetention{})
+	want := election.Transition{Key: "append", ID: "a", Leader: true}
+	if err := store.Append(want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != want.ID || got[0].Key != want.Key {
+		t.Fatalf("loaded %+v, want the appended transition", got)
+	}
+}
@@ -0,0 +1,116 @@
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Backends that can serve elections.
+const (
+	BackendAuto = "auto"
+	BackendV2   = "v2"
+	BackendV3   = "v3"
+)
+
+// Version is the response of etcd's /version endpoint.
+type Version struct {
+	Server  string `json:"etcdserver"`
+	Cluster string `json:"etcdcluster"`
+}
+
+// Major returns the major version of the server, or 0 if it cannot be parsed.
+func (v Version) Major() int {
+	major, _ := strconv.Atoi(strings.SplitN(v.Server, ".", 2)[0])
+	return major
+}
+
+func (c *EtcdClient) Version(ctx context.Context) (Version, error) {
+	var version Version
+	raw, err := c.raw(ctx, "/version")
+	if err != nil {
+		return version, err
+	}
+	return version, json.Unmarshal(raw, &version)
+}
+
+// ServesV2 reports whether the endpoint answers the v2 keys API, which etcd
+// 3.4 and later disable by default.
+func (c *EtcdClient) ServesV2(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.MakeURL(""), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return false, err
+	}
+	var probe EtcdResponse
+	return json.Unmarshal(body, &probe) == nil && (probe.Action != "" || probe.ErrorCode != 0), nil
+}
+
+// SetBackend selects the API the client sends its requests to: BackendV3
+// serves them from the v3 API at DefaultV3Path, anything else from the v2
+// keys API. It must be called before the client is shared between
+// goroutines.
+func (c *EtcdClient) SetBackend(backend string) {
+	if backend != BackendV3 {
+		c.v3 = nil
+	} else if c.v3 == nil {
+		c.v3 = &v3Backend{client: c, path: DefaultV3Path}
+	}
+}
+
+// SetV3Path sets the path of the v3 gateway below the base URL, for etcd
+// releases that serve it as /v3beta or /v3alpha, and selects BackendV3.
+func (c *EtcdClient) SetV3Path(path string) {
+	c.SetBackend(BackendV3)
+	c.v3.path = "/" + strings.Trim(path, "/")
+}
+
+// Backend returns the API the client sends its requests to.
+func (c *EtcdClient) Backend() string {
+	if c.v3 != nil {
+		return BackendV3
+	}
+	return BackendV2
+}
+
+// Negotiate picks the backend to run elections with and sets it on c.
+// BackendAuto probes the endpoint's version, and prefers the v2 keys API
+// when a v3 server still serves it; any other value is taken as an explicit
+// override.
+func Negotiate(ctx context.Context, c *EtcdClient, backend string) (string, error) {
+	switch backend {
+	case BackendV2, BackendV3:
+		c.SetBackend(backend)
+		return backend, nil
+	case BackendAuto, "":
+	default:
+		return "", fmt.Errorf("unknown backend %q", backend)
+	}
+	version, err := c.Version(ctx)
+	if err != nil {
+		return "", err
+	}
+	backend = BackendV2
+	if version.Major() >= 3 {
+		if ok, err := c.ServesV2(ctx); err != nil {
+			return "", err
+		} else if !ok {
+			backend = BackendV3
+		}
+	}
+	c.SetBackend(backend)
+	return backend, nil
+}
@@ -0,0 +1,193 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ErrWaitTimeout is returned by a wait=true GET that got no answer within the
+// client's maximum wait.
+var ErrWaitTimeout = errors.New("watch timed out")
+
+// defaultMaxWait bounds wait=true GETs, so that a connection silently dropped
+// by a proxy cannot stall a watcher forever.
+const defaultMaxWait = 5 * time.Minute
+
+// maxWatchLag is the lag in etcd indexes past which a watcher is reported as
+// stale. The v2 API keeps only the last 1000 events of the whole cluster, so a
+// watcher lagging further than that can no longer resume where it left off.
+const maxWatchLag = 500
+
+// watcher follows one key with wait=true GETs and tracks how far the index it
+// has processed trails the latest index the client has seen.
+type watcher struct {
+	ctx       context.Context
+	client    *EtcdClient
+	key       string
+	next      int
+	processed int64 // accessed atomically
+	stale     bool
+}
+
+// newWatcher returns a watcher of key whose requests are abandoned once ctx
+// is done.
+func newWatcher(ctx context.Context, client *EtcdClient, key string) *watcher {
+	return &watcher{ctx: ctx, client: client, key: key}
+}
+
+// Next returns the next change to the key. The first call, and the first
+// call after the watcher fell out of etcd's event history, returns the
+// current value instead. An error etcd answered with, such as
+// ErrKeyNotFound, comes with its response.
+func (w *watcher) Next() (*EtcdResponse, error) {
+	var resp *EtcdResponse
+	var err error
+	waited := w.next != 0
+	if !waited {
+		resp, err = w.client.Get(w.ctx, w.key, Option{})
+	} else {
+		resp, err = w.client.Get(w.ctx, w.key, Option{wait: true, waitIndex: w.next})
+	}
+	if err == ErrWaitTimeout {
+		// nothing happened, or the connection died; ask again
+		std.event(LevelDebug, w.key, evWatchReissued)
+		return w.Next()
+	}
+	if errors.Is(err, ErrEventIndexCleared) {
+		// the event at w.next was cleared from history; start over
+		w.next = 0
+		return w.Next()
+	}
+	if err != nil && !answered(err) {
+		return nil, err
+	}
+	// a wait served from etcd's event history carries the cluster's current
+	// index, which may be past later events of the key: resume right after
+	// the event itself, and after the index of the read only for a plain GET
+	index := resp.EtcdIndex
+	if waited {
+		index = resp.Node.ModifiedIndex
+	}
+	w.next = index + 1
+	atomic.StoreInt64(&w.processed, int64(index))
+	w.check()
+	return resp, err
+}
+
+// Lag returns how many etcd indexes the watcher's processed index trails the
+// cluster index last seen by the client.
+func (w *watcher) Lag() int64 {
+	lag := w.client.ClusterIndex() - atomic.LoadInt64(&w.processed)
+	if lag < 0 {
+		return 0
+	}
+	return lag
+}
+
+func (w *watcher) check() {
+	lag := w.Lag()
+	w.client.metrics.setWatchLag(electionKey(w.key), lag)
+	if lag > maxWatchLag && !w.stale {
+		std.eventStr(LevelWarn, w.key, evWatchStale, strconv.FormatInt(lag, 10))
+	}
+	w.stale = lag > maxWatchLag
+}
+
+// SetMaxWait bounds how long a wait=true GET may go unanswered before it is
+// abandoned with ErrWaitTimeout; watchers then re-issue it. Zero waits
+// forever. It must be called before the client is shared between goroutines.
+func (c *EtcdClient) SetMaxWait(maxWait time.Duration) {
+	c.maxWait = maxWait
+}
+
+// SetKeepAlive sets the TCP keep-alive period of new connections, which
+// bounds how long the kernel takes to notice a dead peer under an idle watch.
+// It must be called before the client is shared between goroutines, and has
+// no effect on a client without an HTTP transport.
+func (c *EtcdClient) SetKeepAlive(period time.Duration) {
+	current := c.transport()
+	if current == nil {
+		return
+	}
+	transport := newTransport(period)
+	transport.DisableCompression = current.DisableCompression
+	transport.TLSClientConfig = current.TLSClientConfig
+	c.setTransport(transport)
+}
+
+// wait issues a wait=true GET, giving up after the client's maximum wait.
+func (c *EtcdClient) wait(key string, req *http.Request) (*EtcdResponse, error) {
+	if c.maxWait == 0 {
+		return c.request("watch", key, req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), c.maxWait)
+	defer cancel()
+	resp, err := c.request("watch", key, req.WithContext(ctx))
+	if err != nil && ctx.Err() == context.DeadlineExceeded && req.Context().Err() == nil {
+		return nil, ErrWaitTimeout
+	}
+	return resp, err
+}
+
+// pause waits between two iterations of the election loop, and reports false
+// once done is closed. A candidate that last saw the lock held by someone
+// else watches it and returns as soon as it is released or changes hands,
+// rather than noticing on a later poll; any other candidate sleeps for the
+// poll interval.
+func (s *State) pause(ctx context.Context, client *EtcdClient, done <-chan struct{}) bool {
+	index := s.follow
+	s.follow = 0
+	if index == 0 {
+		return s.sleepUntil(s.pollInterval(), done)
+	}
+	atomic.AddInt32(&s.usage.watches, 1)
+	defer atomic.AddInt32(&s.usage.watches, -1)
+	for {
+		resp, err := client.Get(ctx, s.leaderKey(), Option{wait: true, waitIndex: index + 1})
+		select {
+		case <-done:
+			return false
+		default:
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				// cut short by Stop or Close
+				return false
+			}
+			// timed out, or fell out of etcd's event history: poll
+			if err != ErrWaitTimeout && !answered(err) {
+				s.fail(err)
+			}
+			return s.sleepUntil(s.pollInterval(), done)
+		}
+		switch resp.Action {
+		case "delete", "compareAndDelete", "expire":
+			return true
+		}
+		// a new value, such as the lock handed over to this candidate by
+		// TransferTo, is for the next iteration to act on
+		if s.handedOver(resp.Node.Value) || resp.PrevNode == nil || resp.PrevNode.Value != resp.Node.Value {
+			return true
+		}
+		// a renewal that rewrote the value
+		index = resp.Node.ModifiedIndex
+	}
+}
+
+// ClusterIndex returns the highest X-Etcd-Index the client has seen.
+func (c *EtcdClient) ClusterIndex() int64 {
+	return atomic.LoadInt64(&c.clusterIndex)
+}
+
+func (c *EtcdClient) observeIndex(index int) {
+	for {
+		current := atomic.LoadInt64(&c.clusterIndex)
+		if int64(index) <= current || atomic.CompareAndSwapInt64(&c.clusterIndex, current, int64(index)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Watchdog observes elections without taking part in them and raises alerts
+// when an election has been leaderless for too long, its broadcast key
+// disagrees with the leader key, or its leader has stopped heartbeating.
+// Remedies added with AddRemedy act on some of those conditions.
+type Watchdog struct {
+	client          *EtcdClient
+	keys            []string
+	layout          Layout
+	interval        time.Duration
+	leaderlessAfter time.Duration
+	alerts          *Alerter
+	verifier        Verifier
+	heartbeat       bool
+	policy          *DemotionPolicy
+	policyStates    map[string]*policyState
+	health          *healthProbe
+	playbook        *playbook
+
+	leaderlessSince map[string]time.Time
+}
+
+func NewWatchdog(client *EtcdClient, keys []string, interval, leaderlessAfter time.Duration, alerts *Alerter) *Watchdog {
+	return &Watchdog{
+		client:          client,
+		keys:            keys,
+		interval:        interval,
+		leaderlessAfter: leaderlessAfter,
+		alerts:          alerts,
+		leaderlessSince: make(map[string]time.Time),
+	}
+}
+
+// SetVerifier makes the watchdog alert on leader values whose signature does
+// not verify.
+func (w *Watchdog) SetVerifier(verifier Verifier) {
+	w.verifier = verifier
+}
+
+// SetLayout names the keys of the watched elections as layout does. The
+// default is LayoutFlat.
+func (w *Watchdog) SetLayout(layout Layout) {
+	w.layout = layout
+}
+
+// SetHeartbeat makes the watchdog treat a leader key without a heartbeat key
+// as a wedged leader: one that still holds the lock but has stopped making
+// progress.
+func (w *Watchdog) SetHeartbeat(expect bool) {
+	w.heartbeat = expect
+}
+
+// Run checks every election once per interval until ctx is done.
+func (w *Watchdog) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		now, unreachable := time.Now(), 0
+		for _, key := range w.keys {
+			if !w.check(ctx, key, now) {
+				unreachable++
+			}
+		}
+		if len(w.keys) > 0 && unreachable == len(w.keys) {
+			w.detected(ConditionUnreachable, "", fmt.Sprintf("none of the %d watched elections could be read", len(w.keys)), now)
+		} else {
+			w.cleared(ConditionUnreachable, "")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check checks the election of key, and reports false if etcd could not be
+// reached for it.
+func (w *Watchdog) check(ctx context.Context, key string, now time.Time) bool {
+	probe := &State{key: key, layout: w.layout}
+	unreachable := "unreachable/" + key
+	leader, err := w.client.Get(ctx, probe.leaderKey(), Option{})
+	if err != nil && !answered(err) && ctx.Err() != nil {
+		// the watchdog is stopping, and cut the request short
+		return true
+	} else if err != nil && !answered(err) {
+		w.alerts.Fire(Alert{Key: unreachable, Election: key, Condition: "etcd unreachable", Detail: err.Error()})
+		return false
+	}
+	w.alerts.Resolve(unreachable)
+
+	leaderless := "leaderless/" + key
+	if errors.Is(err, ErrKeyNotFound) {
+		since, ok := w.leaderlessSince[key]
+		if !ok {
+			w.leaderlessSince[key], since = now, now
+		} else if gap := now.Sub(since); gap >= w.leaderlessAfter {
+			w.alerts.Fire(Alert{Key: leaderless, Election: key, Condition: "leaderless", Detail: fmt.Sprintf("no leader for %s", gap)})
+		}
+		w.detected(ConditionLeaderless, key, fmt.Sprintf("no leader for %s", now.Sub(since)), now)
+		return true
+	}
+	delete(w.leaderlessSince, key)
+	w.alerts.Resolve(leaderless)
+	w.cleared(ConditionLeaderless, key)
+
+	forged := "unverified/" + key
+	if _, err := verifyValue(key, leader.Node.Value, w.verifier); err != nil {
+		w.alerts.Fire(Alert{Key: forged, Election: key, Condition: "unverified leader", Detail: err.Error()})
+	} else {
+		w.alerts.Resolve(forged)
+	}
+
+	heartbeat := true
+	if w.heartbeat {
+		wedged := "wedged/" + key
+		_, err := w.client.Get(ctx, probe.heartbeatKey(), Option{})
+		if errors.Is(err, ErrKeyNotFound) {
+			heartbeat = false
+			w.alerts.Fire(Alert{
+				Key:       wedged,
+				Election:  key,
+				Condition: "wedged leader",
+				Detail:    fmt.Sprintf("leader %q holds the lock but its heartbeat expired", decodeRecord(leader.Node.Value).ID),
+			})
+		} else if err == nil {
+			w.alerts.Resolve(wedged)
+		}
+	}
+	if w.policy != nil && w.enforce(ctx, key, leader, heartbeat, now) {
+		return true
+	}
+
+	mismatch := "broadcast/" + key
+	double := "double-leader/" + key
+	broadcast, err := w.client.Get(ctx, probe.broadcastKey(), Option{})
+	if err != nil && !answered(err) {
+		return true
+	}
+	holder, announced := decodeRecord(leader.Node.Value).ID, decodeRecord(broadcast.Node.Value).ID
+	if err == nil && announced != holder && broadcast.Node.ModifiedIndex > leader.Node.CreatedIndex {
+		// only a candidate that believes it acquired the lock announces
+		// itself, and this one did after the holder took it
+		detail := fmt.Sprintf("%q announced itself at index %d, after %q acquired the lock at index %d", announced, broadcast.Node.ModifiedIndex, holder, leader.Node.CreatedIndex)
+		w.alerts.Fire(Alert{Key: double, Election: key, Condition: "double leader suspected", Detail: detail})
+		w.detected(ConditionDoubleLeader, key, detail, now)
+	} else {
+		w.alerts.Resolve(double)
+		w.cleared(ConditionDoubleLeader, key)
+	}
+	if broadcast.Node.Value != leader.Node.Value {
+		w.alerts.Fire(Alert{
+			Key:       mismatch,
+			Election:  key,
+			Condition: "broadcast mismatch",
+			Detail:    fmt.Sprintf("leader is %q but broadcast says %q", leader.Node.Value, broadcast.Node.Value),
+			Severity:  "warning",
+		})
+	} else {
+		w.alerts.Resolve(mismatch)
+	}
+	return true
+}
+
+// LogSink writes alerts to the process log.
+type LogSink struct{}
+
+func (LogSink) Send(alert Alert) error {
+	std.eventStr(LevelWarn, alert.Election, evAlert, alert.Message)
+	return nil
+}
@@ -0,0 +1,277 @@
+package election_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+	"github.com/jeeyoungk/etcd-leader/leadertest"
+)
+
+// alertLog is an AlertSink recording the alerts it is sent.
+type alertLog struct {
+	mu     sync.Mutex
+	alerts []election.Alert
+}
+
+func (l *alertLog) Send(alert election.Alert) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.alerts = append(l.alerts, alert)
+	return nil
+}
+
+// fired returns the keys of the alerts fired and not resolved since.
+func (l *alertLog) fired() map[string]bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	active := make(map[string]bool)
+	for _, alert := range l.alerts {
+		active[alert.Key] = !alert.Resolved
+	}
+	return active
+}
+
+// runWatchdog runs dog for a few intervals of 10ms.
+func runWatchdog(dog *election.Watchdog) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	dog.Run(ctx)
+}
+
+// TestWatchdogLayout watches elections in the dir layout: the one with a
+// leader must not look leaderless.
+func TestWatchdogLayout(t *testing.T) {
+	server := leadertest.NewServer(t)
+	server.SetLayout(election.LayoutDir)
+	server.ForceLeader("held", "a")
+	sink := &alertLog{}
+	alerts, err := election.NewAlerter("", sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dog := election.NewWatchdog(election.NewEtcdClient(server.URL), []string{"held", "free"}, 10*time.Millisecond, 0, alerts)
+	dog.SetLayout(election.LayoutDir)
+	runWatchdog(dog)
+
+	fired := sink.fired()
+	if fired["leaderless/held"] {
+		t.Error("the election with a leader was reported leaderless")
+	}
+	if !fired["leaderless/free"] {
+		t.Error("the election without a leader was not reported leaderless")
+	}
+}
+
+// TestWatchdogAlerts watches an election whose broadcast key names another
+// candidate than its lock, and one etcd that cannot be reached.
+func TestWatchdogAlerts(t *testing.T) {
+	server := leadertest.NewServer(t)
+	server.ForceLeader("agreed", "a")
+	server.ForceLeader("split", "a")
+	client := election.NewEtcdClient(server.URL)
+	// b announced itself after a took the lock
+	if _, err := client.Put(context.Background(), "split-broadcast", `{"id":"b"}`, election.Option{}); err != nil {
+		t.Fatal(err)
+	}
+	sink := &alertLog{}
+	alerts, err := election.NewAlerter("", sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runWatchdog(election.NewWatchdog(client, []string{"agreed", "split"}, 10*time.Millisecond, 0, alerts))
+
+	fired := sink.fired()
+	for _, key := range []string{"broadcast/split", "double-leader/split"} {
+		if !fired[key] {
+			t.Errorf("%s was not fired", key)
+		}
+	}
+	for _, key := range []string{"broadcast/agreed", "double-leader/agreed", "leaderless/agreed", "unreachable/agreed"} {
+		if fired[key] {
+			t.Errorf("%s was fired", key)
+		}
+	}
+
+	gone := leadertest.NewServer(t)
+	gone.Close()
+	sink = &alertLog{}
+	if alerts, err = election.NewAlerter("", sink); err != nil {
+		t.Fatal(err)
+	}
+	runWatchdog(election.NewWatchdog(election.NewEtcdClient(gone.URL), []string{"agreed"}, 10*time.Millisecond, 0, alerts))
+	if fired := sink.fired(); !fired["unreachable/agreed"] {
+		t.Errorf("unreachable etcd was not reported: %v", fired)
+	}
+}
+
+// TestWatchdogHeartbeat watches a leader that heartbeats and one that holds
+// its lock without: only the second is wedged, until it heartbeats again.
+func TestWatchdogHeartbeat(t *testing.T) {
+	server := leadertest.NewServer(t)
+	client := election.NewEtcdClient(server.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	leader, err := election.New(client, "beating", "a", election.ElectionConfig{TTL: 2 * time.Second, Heartbeat: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { leader.Stop() })
+	if _, err := leader.Campaign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	beat, err := client.Get(ctx, "beating-heartbeat", election.Option{})
+	if err != nil {
+		t.Fatalf("leader without a heartbeat key: %v", err)
+	}
+	if beat.Node.Expiration == nil {
+		t.Fatal("heartbeat key without a TTL")
+	}
+	server.ForceLeader("stalled", "b")
+
+	sink := &alertLog{}
+	alerts, err := election.NewAlerter("", sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dog := election.NewWatchdog(client, []string{"beating", "stalled"}, 10*time.Millisecond, 0, alerts)
+	dog.SetHeartbeat(true)
+	runWatchdog(dog)
+	fired := sink.fired()
+	if fired["wedged/beating"] {
+		t.Error("the heartbeating leader was reported wedged")
+	}
+	if !fired["wedged/stalled"] {
+		t.Fatal("the leader without a heartbeat was not reported wedged")
+	}
+
+	if _, err := client.Put(ctx, "stalled-heartbeat", "b", election.Option{}); err != nil {
+		t.Fatal(err)
+	}
+	runWatchdog(dog)
+	if fired := sink.fired(); fired["wedged/stalled"] {
+		t.Error("wedged leader not resolved once it heartbeats")
+	}
+}
+
+// auditLog is an AuditSink recording the entries it is sent.
+type auditLog struct {
+	mu      sync.Mutex
+	entries []election.AuditEntry
+}
+
+func (l *auditLog) Record(entry election.AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+// TestWatchdogHeartbeatAbsent demotes the leader whose heartbeat has been
+// missing for longer than the policy allows, and spares the one that
+// heartbeats; the demotion is audited and alerted on.
+func TestWatchdogHeartbeatAbsent(t *testing.T) {
+	server := leadertest.NewServer(t)
+	server.ForceLeader("stalled", "a")
+	server.ForceLeader("beating", "b")
+	client := election.NewEtcdClient(server.URL)
+	if _, err := client.Put(context.Background(), "beating-heartbeat", "b", election.Option{}); err != nil {
+		t.Fatal(err)
+	}
+	audit := &auditLog{}
+	client.SetAuditSink(audit)
+	sink := &alertLog{}
+	alerts, err := election.NewAlerter("", sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dog := election.NewWatchdog(client, []string{"stalled", "beating"}, 10*time.Millisecond, time.Hour, alerts)
+	dog.SetDemotionPolicy(&election.DemotionPolicy{TTL: time.Second, HeartbeatAbsent: 0.03})
+	runWatchdog(dog)
+
+	if leader := server.Leader("stalled"); leader != "" {
+		t.Errorf("%q still leads without a heartbeat", leader)
+	}
+	if leader := server.Leader("beating"); leader != "b" {
+		t.Errorf("the heartbeating leader was demoted, leader is %q", leader)
+	}
+	if !sink.fired()["demoted/stalled"] {
+		t.Error("the demotion was not alerted on")
+	}
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	if len(audit.entries) != 1 {
+		t.Fatalf("audited %+v, want the one demotion", audit.entries)
+	}
+	if entry := audit.entries[0]; entry.Key != "stalled-leader" || entry.Origin != "demote" || !strings.HasPrefix(entry.Reason, "heartbeat absent") {
+		t.Errorf("audited %+v, want the demotion of stalled with its reason", entry)
+	}
+}
+
+// TestWatchdogHealthFailures demotes a leader whose health probes fail a
+// number of times in a row before they succeed: only as many failures as
+// the policy allows, 3 by default, demote it.
+func TestWatchdogHealthFailures(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		allowed int
+		failing int32
+		demoted bool
+	}{
+		{"default, below", 0, 2, false},
+		{"default, reached", 0, 3, true},
+		{"one", 1, 1, true},
+		{"five, below", 5, 4, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var probes int32
+			health := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&probes, 1) <= test.failing {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			t.Cleanup(health.Close)
+			server := leadertest.NewServer(t)
+			client := election.NewEtcdClient(server.URL)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			// the lock of a leader advertising its health URL, without a
+			// TTL so that it cannot expire under the test
+			config := election.ElectionConfig{TTL: time.Second, Metadata: map[string]string{"health": health.URL}}
+			leader, err := election.New(client, "probed", "a", config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := leader.Campaign(ctx); err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Get(ctx, "probed-leader", election.Option{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			leader.Stop()
+			if _, err := client.Put(ctx, "probed-leader", resp.Node.Value, election.Option{}); err != nil {
+				t.Fatal(err)
+			}
+
+			alerts, err := election.NewAlerter("", &alertLog{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			dog := election.NewWatchdog(client, []string{"probed"}, 10*time.Millisecond, 0, alerts)
+			dog.SetDemotionPolicy(&election.DemotionPolicy{TTL: time.Second, HealthKey: "health", HealthFailures: test.allowed})
+			runWatchdog(dog)
+
+			if demoted := server.Leader("probed") == ""; demoted != test.demoted {
+				t.Fatalf("demoted %t after %d probes, want %t", demoted, atomic.LoadInt32(&probes), test.demoted)
+			}
+		})
+	}
+}
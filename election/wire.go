@@ -0,0 +1,123 @@
+package election
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Redactor rewrites a value stored under key before the wire log shows it.
+type Redactor func(key string, value string) string
+
+// RedactValues hides a value behind its length and a short hash, which still
+// tell whether the value a compare-and-swap expected is the one etcd holds.
+func RedactValues(key string, value string) string {
+	if value == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(value))
+	return fmt.Sprintf("<%d bytes %x>", len(value), sum[:4])
+}
+
+// ShowValues leaves values as they are, for clusters whose values are not
+// sensitive.
+func ShowValues(key string, value string) string {
+	return value
+}
+
+// SetWireLog logs every request of the client and the response it got, as
+// LevelDebug lines: the method, key and conditions of the request, then the
+// status and body of the response, or the error. It is meant for finding out
+// why a compare-and-swap failed in production. Values in requests and
+// responses alike, including those quoted in the cause of a failed compare,
+// are passed through redact; nil turns the wire log off. The lines only show
+// once SetLogLevel lets debug lines through. It must be called before the
+// client is shared between goroutines.
+func (c *EtcdClient) SetWireLog(redact Redactor) {
+	c.redact = redact
+}
+
+// wire logs a request and its response, if the wire log is on. value is the
+// value written, empty but for a PUT.
+func (c *EtcdClient) wire(method string, key string, value string, option Option, resp *EtcdResponse, err error) {
+	if c.redact == nil || !std.enabled(LevelDebug) {
+		return
+	}
+	redact := func(value string) string {
+		return c.redact(key, value)
+	}
+	fields := []Field{{"method", method}}
+	if method == "PUT" && !option.refresh {
+		fields = append(fields, Field{"value", redact(value)})
+	}
+	if option.ttl != 0 {
+		fields = append(fields, Field{"ttl", option.ttl.String()})
+	}
+	if option.refresh {
+		fields = append(fields, Field{"refresh", true})
+	}
+	if option.prevExist != 0 {
+		fields = append(fields, Field{"prevExist", option.prevExist == 1})
+	}
+	if option.prevIndex != 0 {
+		fields = append(fields, Field{"prevIndex", option.prevIndex})
+	}
+	if option.prevValue != "" {
+		fields = append(fields, Field{"prevValue", redact(option.prevValue)})
+	}
+	if option.prevCreated != 0 {
+		fields = append(fields, Field{"prevCreated", option.prevCreated})
+	}
+	if option.wait {
+		fields = append(fields, Field{"waitIndex", option.waitIndex})
+	}
+	if err != nil {
+		fields = append(fields, Field{"error", err.Error()})
+	} else {
+		shown := *resp
+		shown.Node = redactNode(shown.Node, c.redact)
+		if shown.PrevNode != nil {
+			prev := redactNode(*shown.PrevNode, c.redact)
+			shown.PrevNode = &prev
+		}
+		shown.Cause = redactCause(shown.Cause, option.prevValue, redact)
+		var body strings.Builder
+		encoder := json.NewEncoder(&body)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(shown)
+		fields = append(fields, Field{"status", resp.StatusCode}, Field{"body", strings.TrimSuffix(body.String(), "\n")})
+	}
+	std.record(LevelDebug, scope{key: key}, evWire, lineValue{}, fields...)
+}
+
+func redactNode(node Node, redact Redactor) Node {
+	node.Value = redact(node.Key, node.Value)
+	if node.Nodes != nil {
+		nodes := make([]Node, len(node.Nodes))
+		for i, child := range node.Nodes {
+			nodes[i] = redactNode(child, redact)
+		}
+		node.Nodes = nodes
+	}
+	return node
+}
+
+// indexCompare ends the cause of a compare that failed on the index too.
+var indexCompare = regexp.MustCompile(`\] \[\d+ != \d+\]$`)
+
+// redactCause redacts the values quoted in the cause of a compare on
+// prevValue that failed, "[prevValue != value]" and possibly the comparison
+// of indexes after it.
+func redactCause(cause string, prevValue string, redact func(string) string) string {
+	prefix := "[" + prevValue + " != "
+	if prevValue == "" || !strings.HasPrefix(cause, prefix) {
+		return cause
+	}
+	value, tail := strings.TrimSuffix(cause[len(prefix):], "]"), ""
+	if m := indexCompare.FindStringIndex(cause); m != nil && m[0] >= len(prefix) {
+		value, tail = cause[len(prefix):m[0]], cause[m[0]+1:]
+	}
+	return "[" + redact(prevValue) + " != " + redact(value) + "]" + tail
+}
@@ -0,0 +1,198 @@
+// Package etcdtest starts real etcd servers for integration tests, either as
+// Docker containers or as local etcd processes.
+package etcdtest
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// DefaultImage is the etcd image started by StartContainer.
+const DefaultImage = "quay.io/coreos/etcd:v3.5.17"
+
+var (
+	ErrNoDocker = errors.New("etcdtest: docker is not available")
+	ErrNoBinary = errors.New("etcdtest: no etcd binary in PATH")
+)
+
+type Options struct {
+	// Image is the container image; defaults to DefaultImage.
+	Image string
+	// Binary is the etcd executable for StartProcess; defaults to etcd in PATH.
+	Binary string
+	// DisableV2 leaves the v2 keys API off on etcd 3.x, which serves it only
+	// when asked to.
+	DisableV2 bool
+	// Ready bounds how long to wait for the server to become healthy;
+	// defaults to 30 seconds.
+	Ready time.Duration
+}
+
+func (o Options) ready() time.Duration {
+	if o.Ready == 0 {
+		return 30 * time.Second
+	}
+	return o.Ready
+}
+
+// Etcd is a running single-node etcd server.
+type Etcd struct {
+	// URL is the client URL of the server.
+	URL  string
+	stop func() error
+}
+
+// Start starts etcd for the duration of a test, preferring a container and
+// falling back to a local etcd process. The test is skipped if neither is
+// available.
+func Start(t testing.TB, opts Options) *Etcd {
+	t.Helper()
+	etcd, err := StartContainer(opts)
+	if err == ErrNoDocker {
+		etcd, err = StartProcess(opts)
+	}
+	if err == ErrNoBinary {
+		t.Skip("etcdtest: neither docker nor an etcd binary is available")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { etcd.Close() })
+	return etcd
+}
+
+// StartContainer runs etcd in a Docker container published on a random local
+// port and waits for it to become healthy.
+func StartContainer(opts Options) (*Etcd, error) {
+	docker, err := exec.LookPath("docker")
+	if err != nil {
+		return nil, ErrNoDocker
+	}
+	image := opts.Image
+	if image == "" {
+		image = DefaultImage
+	}
+	args := []string{
+		"run", "-d", "--rm", "-p", "127.0.0.1::2379", image,
+		"etcd",
+		"--listen-client-urls", "http://0.0.0.0:2379",
+		"--advertise-client-urls", "http://0.0.0.0:2379",
+	}
+	if !opts.DisableV2 && !strings.Contains(image, ":v2.") {
+		args = append(args, "--enable-v2")
+	}
+	id, err := exec.Command(docker, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("etcdtest: docker run: %s", describe(err))
+	}
+	container := strings.TrimSpace(string(id))
+	etcd := &Etcd{stop: func() error {
+		return exec.Command(docker, "rm", "-f", container).Run()
+	}}
+	port, err := exec.Command(docker, "port", container, "2379/tcp").Output()
+	if err != nil {
+		etcd.Close()
+		return nil, fmt.Errorf("etcdtest: docker port: %s", describe(err))
+	}
+	etcd.URL = "http://" + strings.TrimSpace(strings.SplitN(string(port), "\n", 2)[0])
+	if err := etcd.WaitHealthy(opts.ready()); err != nil {
+		etcd.Close()
+		return nil, err
+	}
+	return etcd, nil
+}
+
+var etcd3 = regexp.MustCompile(`(?m)^etcd Version: 3\.`)
+
+// StartProcess runs a local etcd process with a temporary data directory and
+// waits for it to become healthy.
+func StartProcess(opts Options) (*Etcd, error) {
+	path := opts.Binary
+	if path == "" {
+		var err error
+		if path, err = exec.LookPath("etcd"); err != nil {
+			return nil, ErrNoBinary
+		}
+	}
+	dir, err := ioutil.TempDir("", "etcdtest")
+	if err != nil {
+		return nil, err
+	}
+	clientURL, peerURL := "http://"+freeAddr(), "http://"+freeAddr()
+	args := []string{
+		"--name", "etcdtest",
+		"--data-dir", dir,
+		"--listen-client-urls", clientURL,
+		"--advertise-client-urls", clientURL,
+		"--listen-peer-urls", peerURL,
+		"--initial-advertise-peer-urls", peerURL,
+		"--initial-cluster", "etcdtest=" + peerURL,
+	}
+	if version, err := exec.Command(path, "--version").Output(); err == nil && etcd3.Match(version) && !opts.DisableV2 {
+		args = append(args, "--enable-v2")
+	}
+	cmd := exec.Command(path, args...)
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	etcd := &Etcd{URL: clientURL, stop: func() error {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return os.RemoveAll(dir)
+	}}
+	if err := etcd.WaitHealthy(opts.ready()); err != nil {
+		etcd.Close()
+		return nil, err
+	}
+	return etcd, nil
+}
+
+// WaitHealthy polls the server's /version endpoint until it answers.
+func (e *Etcd) WaitHealthy(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := http.Get(e.URL + "/version")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("etcdtest: %s not healthy after %s", e.URL, timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Close stops the server and removes its data.
+func (e *Etcd) Close() error {
+	return e.stop()
+}
+
+// freeAddr returns a local address with a port that was free a moment ago.
+func freeAddr() string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "127.0.0.1:0"
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func describe(err error) string {
+	if exit, ok := err.(*exec.ExitError); ok && len(exit.Stderr) > 0 {
+		return strings.TrimSpace(string(exit.Stderr))
+	}
+	return err.Error()
+}
@@ -0,0 +1,116 @@
+package etcdtest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Feature is a behaviour of etcd that differs between the releases elections
+// run against.
+type Feature string
+
+const (
+	// FeatureV2 is the v2 keys API, native on 2.x and emulated on 3.x
+	// when started with --enable-v2.
+	FeatureV2 Feature = "v2"
+	// FeatureRefresh is PUT refresh=true, which resets a TTL without
+	// notifying watchers. It appeared in 2.3.
+	FeatureRefresh Feature = "refresh"
+	// FeatureV3Gateway is the v3 JSON gateway at /v3, served from 3.4 on.
+	FeatureV3Gateway Feature = "v3-gateway"
+	// FeatureExpireEvent reports a TTL expiry to v2 watchers as an
+	// "expire" action rather than a "delete".
+	FeatureExpireEvent Feature = "expire-event"
+)
+
+// Target is one etcd release of the compatibility matrix.
+type Target struct {
+	// Name identifies the target in test names and ETCDTEST_TARGETS.
+	Name string
+	// Release is the image tag, and the directory of the target's binary
+	// under ETCDTEST_RELEASES.
+	Release   string
+	DisableV2 bool
+	Features  []Feature
+}
+
+// Matrix lists the releases elections are tested against: the last 2.x, a
+// 3.x serving the v2 API through emulation, and a current 3.x with the v2
+// API off, as etcd ships it by default.
+var Matrix = []Target{
+	{Name: "v2.3", Release: "v2.3.8", Features: []Feature{FeatureV2, FeatureRefresh, FeatureExpireEvent}},
+	{Name: "v3.4", Release: "v3.4.34", Features: []Feature{FeatureV2, FeatureRefresh, FeatureExpireEvent, FeatureV3Gateway}},
+	{Name: "v3.5-v3only", Release: "v3.5.17", DisableV2: true, Features: []Feature{FeatureV3Gateway}},
+}
+
+// Has reports whether the target's release has feature.
+func (t Target) Has(feature Feature) bool {
+	for _, f := range t.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Options returns the options starting the target: its release's image, and
+// its binary at $ETCDTEST_RELEASES/<release>/etcd when that variable is set.
+func (t Target) Options() Options {
+	opts := Options{Image: "quay.io/coreos/etcd:" + t.Release, DisableV2: t.DisableV2}
+	if dir := os.Getenv("ETCDTEST_RELEASES"); dir != "" {
+		opts.Binary = filepath.Join(dir, t.Release, "etcd")
+	}
+	return opts
+}
+
+// Targets returns the matrix, narrowed to the comma-separated names in
+// $ETCDTEST_TARGETS if it is set.
+func Targets() []Target {
+	names := os.Getenv("ETCDTEST_TARGETS")
+	if names == "" {
+		return Matrix
+	}
+	var targets []Target
+	for _, target := range Matrix {
+		for _, name := range strings.Split(names, ",") {
+			if strings.TrimSpace(name) == target.Name {
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets
+}
+
+// StartTarget starts etcd of target: its binary if one is configured and
+// present, and a container of its release otherwise.
+func StartTarget(target Target) (*Etcd, error) {
+	opts := target.Options()
+	if opts.Binary != "" {
+		if _, err := os.Stat(opts.Binary); err == nil {
+			return StartProcess(opts)
+		}
+	}
+	return StartContainer(opts)
+}
+
+// RunMatrix runs fn as a subtest against each target of Targets. A target
+// that cannot be started is skipped; fn can skip checks of behaviours the
+// target lacks with Target.Has.
+func RunMatrix(t *testing.T, fn func(t *testing.T, etcd *Etcd, target Target)) {
+	for _, target := range Targets() {
+		target := target
+		t.Run(target.Name, func(t *testing.T) {
+			etcd, err := StartTarget(target)
+			if err == ErrNoDocker {
+				t.Skipf("etcdtest: no binary for %s and docker is not available", target.Release)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { etcd.Close() })
+			fn(t, etcd, target)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// basic runs two candidates of one election: the first to campaign leads,
+// both agree on who that is, and the second takes over once the first stops.
+func basic(ctx context.Context, client *election.EtcdClient) error {
+	key := electionKey("basic")
+	config := election.ElectionConfig{TTL: time.Second}
+	first, err := election.New(client, key, "first", config)
+	if err != nil {
+		return err
+	}
+	defer first.Stop()
+	second, err := election.New(client, key, "second", config)
+	if err != nil {
+		return err
+	}
+	defer second.Stop()
+
+	lease, err := first.Campaign(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("basic: first won %s\n", lease.Acquisition())
+	term := lease.Term()
+	second.Start()
+	leader, err := second.Leader(ctx)
+	if err != nil {
+		return err
+	}
+	if leader.ID != "first" || second.IsLeader() {
+		return fmt.Errorf("second sees %q as leader, and leads itself: %t", leader.ID, second.IsLeader())
+	}
+
+	if err := first.Stop(); err != nil {
+		return err
+	}
+	lease, err = second.Campaign(ctx)
+	if err != nil {
+		return fmt.Errorf("second did not take over: %s", err.Error())
+	}
+	if lease.Term() <= term {
+		return fmt.Errorf("term %d of the new leader does not follow the old one", lease.Term())
+	}
+	fmt.Printf("basic: second took over, %s\n", lease.Acquisition())
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// chaos runs thirty managers for one shard whose leaders stall past their TTL
+// a quarter of the time, and checks that the leadership keeps changing hands
+// and that the shard ends up with a leader.
+func chaos(ctx context.Context, client *election.EtcdClient) error {
+	shard := electionKey("chaos")
+	var mu sync.Mutex
+	gained := make(map[string]int)
+	managers := make([]*election.Manager, 30)
+	for i := range managers {
+		managers[i] = election.NewManager(client, fmt.Sprintf("%d", i), time.Second, 8)
+		managers[i].SetChaos(0.25)
+		managers[i].OnTransition(func(t election.Transition) {
+			if t.Leader {
+				mu.Lock()
+				gained[t.ID]++
+				mu.Unlock()
+			}
+		})
+		managers[i].Start([]string{shard})
+		defer managers[i].Close(context.Background())
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(10 * time.Second):
+	}
+	mu.Lock()
+	leaders := len(gained)
+	mu.Unlock()
+	fmt.Printf("chaos: %d managers led, connections: %s\n", leaders, client.ConnStats())
+	if leaders < 2 {
+		return fmt.Errorf("leadership never changed hands")
+	}
+	return settle(ctx, "chaos", managers, []string{shard})
+}
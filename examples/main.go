@@ -0,0 +1,98 @@
+// Command examples runs scenarios exercising the public API of the election
+// package, as living documentation and as an acceptance test of the library
+// surface. Each scenario checks what it demonstrates and fails otherwise:
+//
+//	go run ./examples -scenario basic
+//	go run ./examples -endpoint http://127.0.0.1:2379 -scenario all
+//
+// Without -endpoint, the scenarios run against an in-memory keys API.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+	"github.com/jeeyoungk/etcd-leader/leadertest"
+)
+
+// scenario is one example. run returns an error if the library did not
+// behave as the scenario shows it does.
+type scenario struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, client *election.EtcdClient) error
+}
+
+func scenarios() []scenario {
+	return []scenario{
+		{"basic", "two candidates of one election, and failover when the leader stops", basic},
+		{"multi-shard", "managers spreading the leadership of many shards", multiShard},
+		{"chaos", "many managers whose leaders stall past their TTL at random", chaos},
+		{"transfer", "a leader handing its leadership over to a standby", transfer},
+		{"pair", "paired active and standby keys, and promotion of the standby", pair},
+	}
+}
+
+func main() {
+	rand.Seed(time.Now().UnixNano())
+	endpoint := flag.String("endpoint", "", "etcd endpoint; an in-memory keys API if empty")
+	name := flag.String("scenario", "all", "scenario to run, or all")
+	timeout := flag.Duration("timeout", time.Minute, "time limit of each scenario")
+	list := flag.Bool("list", false, "list the scenarios and exit")
+	flag.Parse()
+	if *list {
+		for _, s := range scenarios() {
+			fmt.Printf("%-12s %s\n", s.name, s.summary)
+		}
+		return
+	}
+	if *endpoint == "" {
+		server := leadertest.Start()
+		defer server.Close()
+		*endpoint = server.URL
+	}
+	client := election.NewEtcdClient(*endpoint)
+	if _, err := election.Negotiate(context.Background(), client, election.BackendAuto); err != nil {
+		fmt.Fprintf(os.Stderr, "examples: negotiating the backend: %s\n", err.Error())
+	}
+	election.SetLogLevel(election.LevelWarn)
+	failed, ran := false, false
+	for _, s := range scenarios() {
+		if *name != "all" && *name != s.name {
+			continue
+		}
+		ran = true
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		start := time.Now()
+		err := s.run(ctx, client)
+		cancel()
+		if err != nil {
+			failed = true
+			fmt.Printf("FAIL %s (%s): %s\n", s.name, time.Since(start).Round(time.Millisecond), err.Error())
+		} else {
+			fmt.Printf("ok   %s (%s)\n", s.name, time.Since(start).Round(time.Millisecond))
+		}
+	}
+	if !ran {
+		var names []string
+		for _, s := range scenarios() {
+			names = append(names, s.name)
+		}
+		fmt.Fprintf(os.Stderr, "examples: unknown scenario %q, want all or one of %s\n", *name, strings.Join(names, ", "))
+		os.Exit(2)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// electionKey returns a key no other run of the scenario shares.
+func electionKey(scenario string) string {
+	return fmt.Sprintf("examples-%s-%d", scenario, rand.Int31())
+}
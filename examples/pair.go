@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// pair runs three members of a pair of active and standby elections. Once
+// the active member has handshaken with the standby, it stops, and the
+// standby, its designated successor, is promoted ahead of the spare member.
+func pair(ctx context.Context, client *election.EtcdClient) error {
+	key := electionKey("pair")
+	config := election.PairConfig{Election: election.ElectionConfig{TTL: 2 * time.Second}}
+	var members []*election.Pair
+	for _, id := range []string{"a", "b", "c"} {
+		member, err := election.NewPair(client, key, id, config)
+		if err != nil {
+			return err
+		}
+		defer member.Stop(context.Background())
+		member.Start()
+		members = append(members, member)
+	}
+
+	var active, standby *election.Pair
+	for active == nil || standby == nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+		active, standby = nil, nil
+		for _, member := range members {
+			switch status := member.Status(); status.Role {
+			case election.RoleActive:
+				if !status.Handshake.IsZero() {
+					active = member
+				}
+			case election.RoleStandby:
+				standby = member
+			}
+		}
+	}
+	successor := active.Status().Successor
+	if err := active.Stop(ctx); err != nil {
+		return err
+	}
+	start := time.Now()
+	for !standby.Active().IsLeader() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	fmt.Printf("pair: standby %s promoted in %s\n", successor, time.Since(start).Round(time.Millisecond))
+	return nil
+}
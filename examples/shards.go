@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// multiShard runs three managers over the same shards until every shard has
+// exactly one leader among them, then closes one manager and waits for the
+// others to pick up its shards.
+func multiShard(ctx context.Context, client *election.EtcdClient) error {
+	prefix := electionKey("shard")
+	var shards []string
+	for i := 0; i < 12; i++ {
+		shards = append(shards, fmt.Sprintf("%s-%d", prefix, i))
+	}
+	managers := make([]*election.Manager, 3)
+	for i := range managers {
+		managers[i] = election.NewManager(client, fmt.Sprintf("node-%d", i), time.Second, 4)
+		managers[i].Start(shards)
+		defer managers[i].Close(context.Background())
+	}
+	if err := settle(ctx, "multi-shard", managers, shards); err != nil {
+		return err
+	}
+	report, err := managers[0].Close(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("multi-shard: node-0 resigned %d shards\n", len(report.Resigned))
+	return settle(ctx, "multi-shard", managers[1:], shards)
+}
+
+// settle waits until each shard has exactly one leader among managers, and
+// prints how the shards are spread for the scenario.
+func settle(ctx context.Context, scenario string, managers []*election.Manager, shards []string) error {
+	for {
+		counts := make(map[string]int)
+		settled := true
+		for _, shard := range shards {
+			leaders := 0
+			for _, m := range managers {
+				if m.IsLeader(shard) {
+					leaders++
+					counts[m.ID()]++
+				}
+			}
+			settled = settled && leaders == 1
+		}
+		if settled {
+			fmt.Printf("%s: shards per node %v\n", scenario, counts)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("shards never settled on one leader each: %v", counts)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// transfer hands the leadership of an election from its leader over to a
+// standby: the leader rewrites the lock to name the standby, rather than
+// releasing it for the followers to race for, and the standby takes it over
+// on its next iteration.
+func transfer(ctx context.Context, client *election.EtcdClient) error {
+	key := electionKey("transfer")
+	config := election.ElectionConfig{TTL: 5 * time.Second}
+	leader, err := election.New(client, key, "leader", config)
+	if err != nil {
+		return err
+	}
+	defer leader.Stop()
+	standby, err := election.New(client, key, "standby", config)
+	if err != nil {
+		return err
+	}
+	defer standby.Stop()
+	demoted := make(chan error, 1)
+	leader.OnDemoted(func(reason error) { demoted <- reason })
+
+	if _, err := leader.Campaign(ctx); err != nil {
+		return err
+	}
+	events := standby.Observe(ctx)
+	standby.Start()
+	start := time.Now()
+	if err := leader.TransferTo(ctx, "standby"); err != nil {
+		return err
+	}
+	var lost *election.LostError
+	if reason := <-demoted; !errors.As(reason, &lost) || lost.Reason != "transferred to standby" {
+		return fmt.Errorf("leader was demoted with %v rather than transferring", reason)
+	}
+	for event := range events {
+		if event.Leader.ID != "standby" {
+			continue
+		}
+		handoff := time.Since(start)
+		fmt.Printf("transfer: standby took over in %s\n", handoff.Round(time.Millisecond))
+		if handoff >= config.TTL {
+			return fmt.Errorf("handoff took %s, as long as the lock takes to expire", handoff)
+		}
+		return nil
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,3 @@
+module github.com/jeeyoungk/etcd-leader
+
+go 1.21
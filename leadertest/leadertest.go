@@ -0,0 +1,152 @@
+package leadertest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+// Clock is fake time for a Server. Keys created with a TTL only expire when
+// the clock is advanced past their expiration. It is an election.Clock: an
+// Elector given it with SetClock polls, backs off and renews only as the
+// clock is advanced.
+type Clock struct {
+	server *Server
+	now    time.Time
+	timers []timer
+}
+
+// timer is a pending After.
+type timer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// FakeTime switches the server to fake time, starting at the current time.
+func (s *Server) FakeTime() *Clock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clock == nil {
+		s.clock = &Clock{server: s, now: time.Now()}
+	}
+	return s.clock
+}
+
+// Advance moves the clock forward, expiring keys and waking their watchers
+// and the Afters that are due.
+func (c *Clock) Advance(d time.Duration) {
+	c.server.mu.Lock()
+	c.now = c.now.Add(d)
+	c.server.expire()
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if c.now.Before(t.at) {
+			pending = append(pending, t)
+			continue
+		}
+		t.ch <- c.now
+	}
+	c.timers = pending
+	c.server.mu.Unlock()
+}
+
+// After returns a channel that receives once the clock has been advanced by
+// d.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, timer{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Waiting returns how many Afters have not fired yet, letting a test advance
+// the clock once an elector is asleep.
+func (c *Clock) Waiting() int {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	return len(c.timers)
+}
+
+func (c *Clock) Now() time.Time {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	return c.now
+}
+
+// SetLayout names the keys the helpers below look at as layout does. The
+// default is election.LayoutFlat.
+func (s *Server) SetLayout(layout election.Layout) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.layout = layout
+}
+
+// key returns the etcd key of part name of key. Callers hold s.mu.
+func (s *Server) key(key string, name string) string {
+	return "/" + s.layout.Key(key, name)
+}
+
+// Leader returns the ID of the current leader of the election key, or "" if
+// it has none.
+func (s *Server) Leader(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	if n := s.nodes[s.key(key, "leader")]; n != nil {
+		announcement, _ := election.DecodeAnnouncement(key, n.Value, nil, nil)
+		return announcement.ID
+	}
+	return ""
+}
+
+// LeaderFor returns the current leader of election, failing the test if the
+// election has no leader.
+func (s *Server) LeaderFor(t testing.TB, election string) string {
+	t.Helper()
+	leader := s.Leader(election)
+	if leader == "" {
+		t.Fatalf("leadertest: election %q has no leader", election)
+	}
+	return leader
+}
+
+// WaitForLeader polls until election has a leader other than "", failing the
+// test after timeout.
+func (s *Server) WaitForLeader(t testing.TB, election string, timeout time.Duration) string {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		if leader := s.Leader(election); leader != "" {
+			return leader
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("leadertest: election %q has no leader after %s", election, timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// ForceLeader makes id the leader of election, replacing any current leader
+// as if it had lost its lease. The lock has no TTL.
+func (s *Server) ForceLeader(election string, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(s.key(election, "leader"), id, 0)
+	s.set(s.key(election, "broadcast"), id, 0)
+}
+
+// Expire removes the leader's lock on election as if its TTL had passed.
+func (s *Server) Expire(election string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := s.key(election, "leader")
+	if s.nodes[key] != nil {
+		s.remove(key, "expire")
+	}
+}
@@ -0,0 +1,439 @@
+// Package leadertest provides an in-memory etcd v2 keys API for testing code
+// that embeds etcd-leader elections, without running etcd.
+package leadertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeeyoungk/etcd-leader/election"
+)
+
+type node struct {
+	Key           string     `json:"key"`
+	Value         string     `json:"value"`
+	Dir           bool       `json:"dir,omitempty"`
+	Nodes         []*node    `json:"nodes,omitempty"`
+	CreatedIndex  int        `json:"createdIndex"`
+	ModifiedIndex int        `json:"modifiedIndex"`
+	Expiration    *time.Time `json:"expiration,omitempty"`
+	TTL           int64      `json:"ttl,omitempty"`
+}
+
+type response struct {
+	Action   string `json:"action"`
+	Node     *node  `json:"node"`
+	PrevNode *node  `json:"prevNode,omitempty"`
+}
+
+type etcdError struct {
+	ErrorCode int    `json:"errorCode"`
+	Message   string `json:"message"`
+	Cause     string `json:"cause"`
+	Index     int    `json:"index"`
+}
+
+type event struct {
+	index int
+	resp  response
+}
+
+type waiter struct {
+	key   string
+	index int
+	ch    chan response
+}
+
+// Server is an in-memory implementation of the subset of the etcd v2 keys API
+// used by elections: GET (with wait and waitIndex), PUT and DELETE with TTLs
+// and prevExist/prevIndex/prevValue conditions, and POSTs creating in-order
+// keys. Directories exist only as the
+// parents of keys; a GET of one lists the keys directly under it.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	clock   *Clock
+	layout  election.Layout
+	index   int
+	nodes   map[string]*node
+	events  []event
+	waiters []*waiter
+	done    chan struct{}
+}
+
+// maxEvents is the length of the event history, as in etcd.
+const maxEvents = 1000
+
+// NewServer starts a server that is closed when the test ends. Keys expire in
+// real time until FakeTime is called.
+func NewServer(t testing.TB) *Server {
+	s := Start()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Start starts a server outside of a test; the caller must Close it.
+func Start() *Server {
+	s := &Server{nodes: make(map[string]*node), layout: election.LayoutFlat, done: make(chan struct{})}
+	s.Server = httptest.NewServer(s)
+	go s.sweep()
+	return s
+}
+
+func (s *Server) Close() {
+	s.mu.Lock()
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	s.mu.Unlock()
+	s.Server.Close()
+}
+
+// sweep expires keys in real time so that watchers see expirations.
+func (s *Server) sweep() {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			s.expire()
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *Server) now() time.Time {
+	if s.clock != nil {
+		return s.clock.now
+	}
+	return time.Now()
+}
+
+// expire removes every key whose TTL has passed. Callers hold s.mu.
+func (s *Server) expire() {
+	now := s.now()
+	for key, n := range s.nodes {
+		if n.Expiration != nil && !now.Before(*n.Expiration) {
+			s.remove(key, "expire")
+		}
+	}
+}
+
+// remove deletes key and records the event. Callers hold s.mu.
+func (s *Server) remove(key string, action string) response {
+	prev := s.nodes[key]
+	delete(s.nodes, key)
+	s.index++
+	resp := response{
+		Action:   action,
+		Node:     &node{Key: key, CreatedIndex: prev.CreatedIndex, ModifiedIndex: s.index},
+		PrevNode: s.view(prev),
+	}
+	s.publish(resp)
+	return resp
+}
+
+// set writes key and records the event. Callers hold s.mu.
+func (s *Server) set(key string, value string, ttl time.Duration) response {
+	prev := s.nodes[key]
+	s.index++
+	n := &node{Key: key, Value: value, CreatedIndex: s.index, ModifiedIndex: s.index}
+	action := "create"
+	if prev != nil {
+		n.CreatedIndex = prev.CreatedIndex
+		action = "set"
+	}
+	if ttl > 0 {
+		expiration := s.now().Add(ttl)
+		n.Expiration = &expiration
+	}
+	s.nodes[key] = n
+	resp := response{Action: action, Node: s.view(n), PrevNode: s.view(prev)}
+	s.publish(resp)
+	return resp
+}
+
+// dir returns the directory key, listing the keys directly under it, or nil
+// if there are none. Callers hold s.mu.
+func (s *Server) dir(key string) *node {
+	var children []string
+	for child := range s.nodes {
+		if strings.HasPrefix(child, key+"/") && !strings.Contains(child[len(key)+1:], "/") {
+			children = append(children, child)
+		}
+	}
+	if len(children) == 0 {
+		return nil
+	}
+	sort.Strings(children)
+	dir := &node{Key: key, Dir: true}
+	for _, child := range children {
+		dir.Nodes = append(dir.Nodes, s.view(s.nodes[child]))
+	}
+	return dir
+}
+
+// view returns a copy of n with its remaining TTL filled in.
+func (s *Server) view(n *node) *node {
+	if n == nil {
+		return nil
+	}
+	copy := *n
+	if n.Expiration != nil {
+		copy.TTL = int64(n.Expiration.Sub(s.now())/time.Second) + 1
+	}
+	return &copy
+}
+
+func (s *Server) publish(resp response) {
+	s.events = append(s.events, event{index: s.index, resp: resp})
+	if len(s.events) > maxEvents {
+		s.events = s.events[len(s.events)-maxEvents:]
+	}
+	waiters := s.waiters[:0]
+	for _, w := range s.waiters {
+		if w.key == resp.Node.Key && s.index >= w.index {
+			w.ch <- resp
+		} else {
+			waiters = append(waiters, w)
+		}
+	}
+	s.waiters = waiters
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/version" {
+		w.Write([]byte(`{"etcdserver":"2.3.8","etcdcluster":"2.3.0"}`))
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/v2/keys/") {
+		http.NotFound(w, r)
+		return
+	}
+	key := "/" + strings.Trim(strings.TrimPrefix(r.URL.Path, "/v2/keys/"), "/")
+	r.ParseForm()
+
+	s.mu.Lock()
+	s.expire()
+	switch r.Method {
+	case "GET":
+		s.get(w, r, key)
+		return // get unlocks
+	case "PUT":
+		s.put(w, r, key)
+	case "DELETE":
+		s.delete(w, r, key)
+	case "POST":
+		s.post(w, r, key)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+	s.mu.Unlock()
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, key string) {
+	if r.Form.Get("wait") != "true" {
+		n := s.nodes[key]
+		if n == nil {
+			n = s.dir(key)
+		}
+		if n == nil {
+			s.fail(w, 100, "Key not found", key)
+		} else {
+			s.reply(w, http.StatusOK, response{Action: "get", Node: s.view(n)})
+		}
+		s.mu.Unlock()
+		return
+	}
+
+	index := s.index + 1
+	if raw := r.Form.Get("waitIndex"); raw != "" {
+		index, _ = strconv.Atoi(raw)
+		if len(s.events) > 0 && index < s.events[0].index {
+			s.fail(w, 401, "The event in requested index is outdated and cleared", key)
+			s.mu.Unlock()
+			return
+		}
+		for _, e := range s.events {
+			if e.index >= index && e.resp.Node.Key == key {
+				s.reply(w, http.StatusOK, e.resp)
+				s.mu.Unlock()
+				return
+			}
+		}
+	}
+	watch := &waiter{key: key, index: index, ch: make(chan response, 1)}
+	s.waiters = append(s.waiters, watch)
+	s.mu.Unlock()
+
+	select {
+	case resp := <-watch.ch:
+		s.mu.Lock()
+		s.reply(w, http.StatusOK, resp)
+		s.mu.Unlock()
+	case <-r.Context().Done():
+		s.mu.Lock()
+		for i, other := range s.waiters {
+			if other == watch {
+				s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+				break
+			}
+		}
+		s.mu.Unlock()
+	case <-s.done:
+	}
+}
+
+// compare checks the prevExist/prevIndex/prevValue conditions of r against
+// the current node, writing the error response if they fail.
+func (s *Server) compare(w http.ResponseWriter, r *http.Request, key string, n *node) bool {
+	switch r.Form.Get("prevExist") {
+	case "false":
+		if n != nil {
+			s.fail(w, 105, "Key already exists", key)
+			return false
+		}
+	case "true":
+		if n == nil {
+			s.fail(w, 100, "Key not found", key)
+			return false
+		}
+	}
+	prevIndex, prevValue := r.Form.Get("prevIndex"), r.Form.Get("prevValue")
+	if prevIndex == "" && prevValue == "" {
+		return true
+	}
+	if n == nil {
+		s.fail(w, 100, "Key not found", key)
+		return false
+	}
+	if prevIndex != "" && prevIndex != strconv.Itoa(n.ModifiedIndex) {
+		s.fail(w, 101, "Compare failed", "["+prevIndex+" != "+strconv.Itoa(n.ModifiedIndex)+"]")
+		return false
+	}
+	if prevValue != "" && prevValue != n.Value {
+		s.fail(w, 101, "Compare failed", "["+prevValue+" != "+n.Value+"]")
+		return false
+	}
+	return true
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, key string) {
+	if !s.compare(w, r, key, s.nodes[key]) {
+		return
+	}
+	var ttl time.Duration
+	if raw := r.Form.Get("ttl"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil {
+			s.fail(w, 202, "The given TTL in POST form is not a number", "Update")
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+	if r.Form.Get("refresh") == "true" {
+		s.refresh(w, r, key, ttl)
+		return
+	}
+	resp := s.set(key, r.Form.Get("value"), ttl)
+	status := http.StatusOK
+	if resp.Action == "create" {
+		status = http.StatusCreated
+	}
+	s.reply(w, status, resp)
+}
+
+// post creates an in-order key under the directory key, named after its
+// index like etcd's.
+func (s *Server) post(w http.ResponseWriter, r *http.Request, key string) {
+	var ttl time.Duration
+	if raw := r.Form.Get("ttl"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil {
+			s.fail(w, 202, "The given TTL in POST form is not a number", "Create")
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+	name := fmt.Sprintf("%020d", s.index+1)
+	s.reply(w, http.StatusCreated, s.set(key+"/"+name, r.Form.Get("value"), ttl))
+}
+
+// refresh resets the TTL of key without changing its value. Like etcd, it
+// does not wake watchers.
+func (s *Server) refresh(w http.ResponseWriter, r *http.Request, key string, ttl time.Duration) {
+	prev := s.nodes[key]
+	if prev == nil {
+		s.fail(w, 100, "Key not found", key)
+		return
+	}
+	if r.Form.Get("value") != "" {
+		s.fail(w, 211, "Value provided on refresh", key)
+		return
+	}
+	if ttl == 0 {
+		s.fail(w, 212, "A TTL must be provided on refresh", key)
+		return
+	}
+	s.index++
+	n := *prev
+	n.ModifiedIndex = s.index
+	expiration := s.now().Add(ttl)
+	n.Expiration = &expiration
+	s.nodes[key] = &n
+	s.reply(w, http.StatusOK, response{Action: "update", Node: s.view(&n), PrevNode: s.view(prev)})
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request, key string) {
+	n := s.nodes[key]
+	if n == nil {
+		s.fail(w, 100, "Key not found", key)
+		return
+	}
+	if !s.compare(w, r, key, n) {
+		return
+	}
+	action := "delete"
+	if r.Form.Get("prevValue") != "" || r.Form.Get("prevIndex") != "" {
+		action = "compareAndDelete"
+	}
+	s.reply(w, http.StatusOK, s.remove(key, action))
+}
+
+func (s *Server) reply(w http.ResponseWriter, status int, resp response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Etcd-Index", strconv.Itoa(s.index))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+var errorStatus = map[int]int{
+	100: http.StatusNotFound,
+	101: http.StatusPreconditionFailed,
+	105: http.StatusPreconditionFailed,
+	202: http.StatusBadRequest,
+	211: http.StatusBadRequest,
+	212: http.StatusBadRequest,
+	401: http.StatusBadRequest,
+}
+
+func (s *Server) fail(w http.ResponseWriter, code int, message string, cause string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Etcd-Index", strconv.Itoa(s.index))
+	w.WriteHeader(errorStatus[code])
+	json.NewEncoder(w).Encode(etcdError{ErrorCode: code, Message: message, Cause: cause, Index: s.index})
+}